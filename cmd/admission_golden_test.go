@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gomodules.xyz/jsonpatch/v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// updateGolden rewrites golden files from what the webhook currently answers: go test ./cmd/ -update
+var updateGolden = flag.Bool("update", false, "Rewrite golden files of recorded admission reviews")
+
+// goldenResponse is what golden files hold of an admission response
+type goldenResponse struct {
+	Allowed  bool                           `json:"allowed"`
+	Code     int32                          `json:"code,omitempty"`
+	Reason   metav1.StatusReason            `json:"reason,omitempty"`
+	Message  string                         `json:"message,omitempty"`
+	Warnings []string                       `json:"warnings,omitempty"`
+	Patches  []jsonpatch.JsonPatchOperation `json:"patches,omitempty"`
+}
+
+// Every directory of testdata/admission holds a recorded AdmissionReview, request.json, and what the webhook answers
+// to it, response.golden.json. They are replayed against a cluster holding a single node, node-a.
+var _ = Describe("Replaying recorded admission reviews", Label("Golden"), func() {
+	scheme := runtime.NewScheme()
+	Expect(corev1.AddToScheme(scheme)).To(Succeed())
+
+	node := fixtures.NodeWithCapacity("4", "8Gi")
+	node.Name = "node-a"
+	handler := &podSizingHandler{
+		sizer:   sizing.New(fake.NewClientBuilder().WithObjects(node).Build(), sizing.Options{}),
+		decoder: admission.NewDecoder(scheme),
+	}
+
+	cases, err := os.ReadDir(filepath.Join("testdata", "admission"))
+	Expect(err).NotTo(HaveOccurred())
+	for _, c := range cases {
+		dir := filepath.Join("testdata", "admission", c.Name())
+		It("answers "+c.Name()+" as recorded", func(ctx SpecContext) {
+			raw, err := os.ReadFile(filepath.Join(dir, "request.json"))
+			Expect(err).NotTo(HaveOccurred())
+			var review admissionv1.AdmissionReview
+			Expect(json.Unmarshal(raw, &review)).To(Succeed())
+
+			response := handler.Handle(ctx, admission.Request{AdmissionRequest: *review.Request})
+			golden := goldenResponse{Allowed: response.Allowed, Warnings: response.Warnings, Patches: response.Patches}
+			if response.Result != nil {
+				golden.Code, golden.Reason, golden.Message = response.Result.Code, response.Result.Reason, response.Result.Message
+			}
+			actual, err := json.MarshalIndent(golden, "", "  ")
+			Expect(err).NotTo(HaveOccurred())
+			actual = append(actual, '\n')
+
+			goldenFile := filepath.Join(dir, "response.golden.json")
+			if *updateGolden {
+				Expect(os.WriteFile(goldenFile, actual, 0o644)).To(Succeed())
+			}
+			expected, err := os.ReadFile(goldenFile)
+			Expect(err).NotTo(HaveOccurred(), "run go test ./cmd/ -update to record it")
+			Expect(string(actual)).To(Equal(string(expected)))
+		})
+	}
+})
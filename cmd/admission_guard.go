@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	mapset "github.com/deckarep/golang-set/v2"
+	corev1 "k8s.io/api/core/v1"
+	"strings"
+)
+
+// admissionGuard keeps pods the webhook was not meant to see from being sized, should the webhook configuration be
+// wrong, e.g. missing its objectSelector. Unlike excluded namespaces, it is not reloaded with the config file.
+type admissionGuard struct {
+	// allowed namespaces are the only ones whose pods are sized, any when empty
+	allowed mapset.Set[string]
+	// denied namespaces never have their pods sized
+	denied mapset.Set[string]
+	// requireLabel leaves pods without sizing.EnabledLabel alone
+	requireLabel bool
+}
+
+func newAdmissionGuard(allowlist, denylist string, requireLabel bool) *admissionGuard {
+	return &admissionGuard{
+		allowed:      mapset.NewThreadUnsafeSet(splitList(allowlist)...),
+		denied:       mapset.NewThreadUnsafeSet(splitList(denylist)...),
+		requireLabel: requireLabel,
+	}
+}
+
+// refusal tells why a pod of namespace must be left alone, or returns an empty string when it may be sized
+func (g *admissionGuard) refusal(namespace string, pod *corev1.Pod) string {
+	if g.denied.Contains(namespace) {
+		return fmt.Sprintf("namespace %s is protected from sizing", namespace)
+	}
+	if g.allowed.Cardinality() > 0 && !g.allowed.Contains(namespace) {
+		return fmt.Sprintf("namespace %s is not allowed to be sized", namespace)
+	}
+	if g.requireLabel && pod.Labels[sizing.EnabledLabel] != "true" {
+		return fmt.Sprintf("pod is not labelled %s=true", sizing.EnabledLabel)
+	}
+	return ""
+}
+
+// splitList parses a comma-separated list, leaving out empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Guarding against misconfigured webhooks", Label("Guard"), func() {
+	labelled := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{sizing.EnabledLabel: "true"}}}
+
+	It("never sizes denied namespaces", func() {
+		guard := newAdmissionGuard("", "kube-system, critical", true)
+		Expect(guard.refusal("kube-system", labelled)).To(ContainSubstring("protected"))
+		Expect(guard.refusal("critical", labelled)).To(ContainSubstring("protected"))
+		Expect(guard.refusal("team", labelled)).To(BeEmpty())
+	})
+
+	It("only sizes allowed namespaces when given", func() {
+		guard := newAdmissionGuard("team", "", true)
+		Expect(guard.refusal("team", labelled)).To(BeEmpty())
+		Expect(guard.refusal("other", labelled)).To(ContainSubstring("not allowed"))
+	})
+
+	It("requires the enabled label unless told otherwise", func() {
+		Expect(newAdmissionGuard("", "", true).refusal("team", &corev1.Pod{})).To(ContainSubstring(sizing.EnabledLabel))
+		Expect(newAdmissionGuard("", "", false).refusal("team", &corev1.Pod{})).To(BeEmpty())
+	})
+})
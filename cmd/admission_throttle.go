@@ -0,0 +1,51 @@
+package main
+
+import (
+	"golang.org/x/time/rate"
+	"net/http"
+)
+
+// admissionThrottle bounds how many admission requests are handled at once, and how many start per second, so that a
+// storm of rollouts degrades into refused requests, answered according to the failurePolicy of the webhook, rather
+// than into memory exhaustion. Refused requests are answered 429 right away.
+type admissionThrottle struct {
+	// inFlight holds a token per request being handled, nil for no limit
+	inFlight chan struct{}
+	// limiter is nil for no limit
+	limiter *rate.Limiter
+}
+
+// newAdmissionThrottle allows maxInFlight concurrent requests, and ratePerSecond requests per second with bursts of
+// burst requests. Limits that are not positive are disabled.
+func newAdmissionThrottle(maxInFlight int, ratePerSecond float64, burst int) *admissionThrottle {
+	throttle := &admissionThrottle{}
+	if maxInFlight > 0 {
+		throttle.inFlight = make(chan struct{}, maxInFlight)
+	}
+	if ratePerSecond > 0 {
+		throttle.limiter = rate.NewLimiter(rate.Limit(ratePerSecond), max(burst, 1))
+	}
+	return throttle
+}
+
+// wrap applies the throttle to the requests handler serves
+func (t *admissionThrottle) wrap(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t.limiter != nil && !t.limiter.Allow() {
+			throttledRequestsTotal.WithLabelValues("rate").Inc()
+			http.Error(w, "too many admission requests per second", http.StatusTooManyRequests)
+			return
+		}
+		if t.inFlight != nil {
+			select {
+			case t.inFlight <- struct{}{}:
+				defer func() { <-t.inFlight }()
+			default:
+				throttledRequestsTotal.WithLabelValues("concurrency").Inc()
+				http.Error(w, "too many admission requests in flight", http.StatusTooManyRequests)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
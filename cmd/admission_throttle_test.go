@@ -0,0 +1,47 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("Throttling admission requests", Label("AdmissionThrottle"), func() {
+	serve := func(handler http.Handler) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/mutate", nil))
+		return recorder.Code
+	}
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+	It("refuses requests above the concurrency limit", func() {
+		throttle := newAdmissionThrottle(1, 0, 0)
+		before := testutil.ToFloat64(throttledRequestsTotal.WithLabelValues("concurrency"))
+		var nested int
+		Expect(serve(throttle.wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			nested = serve(throttle.wrap(ok))
+		})))).To(Equal(http.StatusOK))
+		Expect(nested).To(Equal(http.StatusTooManyRequests))
+		Expect(testutil.ToFloat64(throttledRequestsTotal.WithLabelValues("concurrency"))).To(Equal(before + 1))
+
+		Expect(serve(throttle.wrap(ok))).To(Equal(http.StatusOK), "the slot is released once done")
+	})
+
+	It("refuses requests above the rate, once the burst is spent", func() {
+		handler := newAdmissionThrottle(0, 0.001, 2).wrap(ok)
+		before := testutil.ToFloat64(throttledRequestsTotal.WithLabelValues("rate"))
+		Expect(serve(handler)).To(Equal(http.StatusOK))
+		Expect(serve(handler)).To(Equal(http.StatusOK))
+		Expect(serve(handler)).To(Equal(http.StatusTooManyRequests))
+		Expect(testutil.ToFloat64(throttledRequestsTotal.WithLabelValues("rate"))).To(Equal(before + 1))
+	})
+
+	It("lets everything through without limits", func() {
+		handler := newAdmissionThrottle(0, 0, 0).wrap(ok)
+		for range 100 {
+			Expect(serve(handler)).To(Equal(http.StatusOK))
+		}
+	})
+})
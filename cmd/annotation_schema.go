@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	"io"
+	"net/http"
+)
+
+// annotationSchemaPath serves the JSON Schema of sizing annotations next to metrics, for policy engines to fetch it
+// from the running webhook, custom annotations registered on start included
+const annotationSchemaPath = "/annotation-schema"
+
+// writeAnnotationSchema writes the JSON Schema of sizing annotations, indented
+func writeAnnotationSchema(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(sizing.NewAnnotationSchema())
+}
+
+// serveAnnotationSchema answers GET requests with the JSON Schema of sizing annotations
+func serveAnnotationSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	_ = writeAnnotationSchema(w)
+}
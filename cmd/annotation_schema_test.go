@@ -0,0 +1,27 @@
+package main
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("Serving the annotation schema", Label("AnnotationSchema"), func() {
+	It("answers GET requests with the JSON Schema", func() {
+		recorder := httptest.NewRecorder()
+		serveAnnotationSchema(recorder, httptest.NewRequest(http.MethodGet, annotationSchemaPath, nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/schema+json"))
+		var schema map[string]any
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &schema)).To(Succeed())
+		Expect(schema).To(HaveKeyWithValue("properties", HaveKey("node-specific-sizing.manomano.tech/request-cpu-fraction")))
+	})
+
+	It("refuses other methods", func() {
+		recorder := httptest.NewRecorder()
+		serveAnnotationSchema(recorder, httptest.NewRequest(http.MethodPost, annotationSchemaPath, nil))
+		Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gomodules.xyz/jsonpatch/v2"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditEntry is a line of the audit log, telling how a pod was mutated
+type auditEntry struct {
+	Time time.Time `json:"time"`
+	// RequestUID ties the entry to the API server audit log, pods being given a UID only once created
+	RequestUID string                         `json:"requestUID"`
+	UID        string                         `json:"uid,omitempty"`
+	Namespace  string                         `json:"namespace"`
+	Name       string                         `json:"name"`
+	Node       string                         `json:"node"`
+	DryRun     bool                           `json:"dryRun"`
+	Patch      []jsonpatch.JsonPatchOperation `json:"patch"`
+	// Previous is the SHA-256 of the line before, so that removed or edited lines show
+	Previous string `json:"previous"`
+}
+
+// auditLog appends one JSON line per mutation to a file, rotated by size, or to stdout. It is safe for concurrent use.
+type auditLog struct {
+	lock sync.Mutex
+	out  io.Writer
+	// file is nil when writing to stdout, which is not rotated
+	file       *os.File
+	path       string
+	size       int64
+	maxBytes   int64
+	maxBackups int
+	// previous is the hash of the last line written, chaining lines across rotations
+	previous string
+}
+
+// openAuditLog appends to path, or to stdout when path is "-". The file is rotated once above maxBytes, keeping
+// maxBackups of them as path.1, path.2 and so on, the chain of hashes going on from its last line.
+func openAuditLog(path string, maxBytes int64, maxBackups int) (*auditLog, error) {
+	if path == "-" {
+		return &auditLog{out: os.Stdout}, nil
+	}
+	l := &auditLog{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	previous, err := lastLineHash(path)
+	if err != nil {
+		return nil, err
+	}
+	l.previous = previous
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *auditLog) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("could not open audit log: %w", err)
+	}
+	l.file, l.out, l.size = file, file, info.Size()
+	return nil
+}
+
+// record appends an entry, chained to the one before
+func (l *auditLog) record(entry auditEntry) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	entry.Previous = l.previous
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if l.file != nil && l.maxBytes > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := l.out.Write(line); err != nil {
+		return fmt.Errorf("could not write audit log: %w", err)
+	}
+	l.size += int64(len(line))
+	l.previous = hashLine(line)
+	return nil
+}
+
+// rotate shifts backups by one, dropping the oldest, and starts a new file
+func (l *auditLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("could not rotate audit log: %w", err)
+	}
+	if err := os.Remove(fmt.Sprintf("%s.%d", l.path, l.maxBackups)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("could not rotate audit log: %w", err)
+	}
+	for i := l.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("could not rotate audit log: %w", err)
+		}
+	}
+	if l.maxBackups > 0 {
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return fmt.Errorf("could not rotate audit log: %w", err)
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return fmt.Errorf("could not rotate audit log: %w", err)
+	}
+	return l.open()
+}
+
+// Close closes the file of the audit log, if any
+func (l *auditLog) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// lastLineHash returns the hash of the last line of path, or an empty string when there is none
+func lastLineHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not read audit log: %w", err)
+	}
+	defer file.Close()
+
+	var last []byte
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			last = line
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("could not read audit log: %w", err)
+		}
+	}
+	if last == nil {
+		return "", nil
+	}
+	return hashLine(last), nil
+}
+
+func hashLine(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gomodules.xyz/jsonpatch/v2"
+	"os"
+	"path/filepath"
+)
+
+var _ = Describe("Audit log", Label("AuditLog"), func() {
+	var path string
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "audit.log")
+	})
+
+	entry := auditEntry{
+		RequestUID: "req-1",
+		Namespace:  "default",
+		Name:       "agent-",
+		Node:       "node-a",
+		Patch:      []jsonpatch.JsonPatchOperation{jsonpatch.NewOperation("add", "/spec/containers/0/resources/requests/cpu", "400m")},
+	}
+
+	lines := func(path string) [][]byte {
+		content, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		return bytes.Split(bytes.TrimSuffix(content, []byte("\n")), []byte("\n"))
+	}
+	previousOf := func(line []byte) string {
+		var parsed auditEntry
+		Expect(json.Unmarshal(line, &parsed)).To(Succeed())
+		return parsed.Previous
+	}
+
+	It("chains every line to the one before, across restarts", func() {
+		audit, err := openAuditLog(path, 0, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(audit.record(entry)).To(Succeed())
+		Expect(audit.record(entry)).To(Succeed())
+		Expect(audit.Close()).To(Succeed())
+
+		audit, err = openAuditLog(path, 0, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(audit.record(entry)).To(Succeed())
+		Expect(audit.Close()).To(Succeed())
+
+		written := lines(path)
+		Expect(written).To(HaveLen(3))
+		Expect(previousOf(written[0])).To(BeEmpty())
+		Expect(previousOf(written[1])).To(Equal(hashLine(append(written[0], '\n'))))
+		Expect(previousOf(written[2])).To(Equal(hashLine(append(written[1], '\n'))))
+	})
+
+	It("rotates files above the maximum size, keeping backups", func() {
+		audit, err := openAuditLog(path, 1, 2)
+		Expect(err).NotTo(HaveOccurred())
+		for range 4 {
+			Expect(audit.record(entry)).To(Succeed())
+		}
+		Expect(audit.Close()).To(Succeed())
+
+		Expect(lines(path)).To(HaveLen(1))
+		Expect(lines(path + ".1")).To(HaveLen(1))
+		Expect(lines(path + ".2")).To(HaveLen(1))
+		Expect(path + ".3").NotTo(BeAnExistingFile())
+		Expect(previousOf(lines(path)[0])).To(Equal(hashLine(append(lines(path + ".1")[0], '\n'))))
+	})
+})
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// bindingHandler sizes pods pending sizing as the scheduler binds them, so that their containers start with their
+// sizes rather than being resized once running. Bindings are always allowed: pods it could not size are left to
+// boundPodSizer, which sizes them once their node shows up on the pod.
+type bindingHandler struct {
+	decoder admission.Decoder
+	// reader reads the pods being bound, bindings only carrying their name
+	reader client.Reader
+	sizer  *boundPodSizer
+}
+
+var _ admission.Handler = &bindingHandler{}
+
+func (h *bindingHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.SubResource != "binding" || req.Operation != admissionv1.Create || (req.DryRun != nil && *req.DryRun) {
+		return admission.Allowed("")
+	}
+	var binding corev1.Binding
+	if err := h.decoder.Decode(req, &binding); err != nil {
+		zap.L().Warn("Could not decode raw object", zap.Any("kind", req.Kind), zap.Error(err))
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if binding.Target.Kind != "" && binding.Target.Kind != "Node" {
+		return admission.Allowed("")
+	}
+
+	logger := zap.L().With(zap.String("uid", string(req.UID)), zap.String("namespace", req.Namespace),
+		zap.String("name", req.Name), zap.String("node", binding.Target.Name))
+	ctx = sizing.ContextWithLogger(ctx, logger)
+
+	var pod corev1.Pod
+	if err := h.reader.Get(ctx, client.ObjectKey{Namespace: req.Namespace, Name: req.Name}, &pod); err != nil {
+		logger.Debug("Leaving bound pod to be sized once bound", zap.Error(err))
+		return admission.Allowed("")
+	}
+	if pod.Annotations[sizing.PendingSizingAnnotation] != "true" {
+		return admission.Allowed("")
+	}
+	pod.Spec.NodeName = binding.Target.Name
+	if err := h.sizer.resize(ctx, &pod); err != nil {
+		logger.Debug("Leaving bound pod to be sized once bound", zap.Error(err))
+		return admission.Allowed("")
+	}
+	boundSizingsTotal.WithLabelValues("resized_on_binding").Inc()
+	return admission.Allowed("pod sized for node " + binding.Target.Name)
+}
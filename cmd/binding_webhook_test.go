@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("Sizing pods on binding", Label("BindingWebhook"), func() {
+	scheme := runtime.NewScheme()
+	Expect(corev1.AddToScheme(scheme)).To(Succeed())
+
+	var (
+		c       client.Client
+		handler *bindingHandler
+		pod     *corev1.Pod
+	)
+
+	BeforeEach(func() {
+		node := fixtures.NodeWithCapacity("4", "8G")
+		node.Name = "node-a"
+		pod = fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil))
+		pod.Name, pod.Namespace = "pending", "default"
+		pod.Annotations = map[string]string{
+			"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.1",
+			sizing.PendingSizingAnnotation:                            "true",
+		}
+		c = fake.NewClientBuilder().WithObjects(node, pod).Build()
+		sizer := sizing.New(c, sizing.Options{})
+		handler = &bindingHandler{
+			decoder: admission.NewDecoder(scheme),
+			reader:  c,
+			sizer:   &boundPodSizer{client: c, sizer: func() *sizing.Sizer { return sizer }, recorder: record.NewFakeRecorder(10)},
+		}
+	})
+
+	bindingTo := func(nodeName string) admission.Request {
+		req := admissionRequestFor("Binding", &corev1.Binding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Binding"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: pod.Name},
+			Target:     corev1.ObjectReference{Kind: "Node", Name: nodeName},
+		})
+		req.Namespace, req.Name, req.SubResource = pod.Namespace, pod.Name, "binding"
+		return req
+	}
+
+	It("sizes pods pending sizing for the node they are bound to", func(ctx SpecContext) {
+		before := testutil.ToFloat64(boundSizingsTotal.WithLabelValues("resized_on_binding"))
+		Expect(handler.Handle(ctx, bindingTo("node-a")).Allowed).To(BeTrue())
+
+		var stored corev1.Pod
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(pod), &stored)).To(Succeed())
+		Expect(stored.Annotations).NotTo(HaveKey(sizing.PendingSizingAnnotation))
+		Expect(stored.Spec.Containers[0].Resources.Requests.Cpu().String()).To(Equal("400m"))
+		Expect(testutil.ToFloat64(boundSizingsTotal.WithLabelValues("resized_on_binding"))).To(Equal(before + 1))
+	})
+
+	It("leaves pods it cannot size pending, and allows their binding", func(ctx SpecContext) {
+		Expect(handler.Handle(ctx, bindingTo("unknown")).Allowed).To(BeTrue())
+
+		var stored corev1.Pod
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(pod), &stored)).To(Succeed())
+		Expect(stored.Annotations).To(HaveKey(sizing.PendingSizingAnnotation))
+	})
+
+	It("leaves other pods alone", func(ctx SpecContext) {
+		delete(pod.Annotations, sizing.PendingSizingAnnotation)
+		Expect(c.Update(ctx, pod)).To(Succeed())
+		Expect(handler.Handle(ctx, bindingTo("node-a")).Allowed).To(BeTrue())
+
+		var stored corev1.Pod
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(pod), &stored)).To(Succeed())
+		Expect(stored.Spec.Containers[0].Resources.Requests.Cpu().String()).To(Equal("100m"))
+	})
+})
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	"go.uber.org/zap"
+	"gomodules.xyz/jsonpatch/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"time"
+)
+
+// boundSizingTimeout bounds sizing a single bound pod, node lookups and API calls included
+const boundSizingTimeout = 10 * time.Second
+
+// pendingSizingPath points to sizing.PendingSizingAnnotation within a JSONPatch
+var pendingSizingPath = sizing.AnnotationJsonPath(sizing.PendingSizingAnnotation)
+
+// boundPodSizer sizes pods admitted before their node was known, once the scheduler bound them to one, through the
+// resize subresource. Pods are not recreated, their controller would create them again without a node.
+type boundPodSizer struct {
+	client   client.Client
+	sizer    func() *sizing.Sizer
+	recorder record.EventRecorder
+}
+
+var _ toolscache.ResourceEventHandler = &boundPodSizer{}
+
+func (bs *boundPodSizer) OnAdd(obj interface{}, _ bool) {
+	if pod, ok := obj.(*corev1.Pod); ok {
+		bs.size(pod)
+	}
+}
+
+func (bs *boundPodSizer) OnUpdate(_, newObj interface{}) {
+	if pod, ok := newObj.(*corev1.Pod); ok {
+		bs.size(pod)
+	}
+}
+
+func (bs *boundPodSizer) OnDelete(interface{}) {}
+
+func (bs *boundPodSizer) size(pod *corev1.Pod) {
+	if pod.Annotations[sizing.PendingSizingAnnotation] != "true" || pod.Spec.NodeName == "" ||
+		pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return
+	}
+	// Patching updates the object given, which must not be the one of the cache
+	pod = pod.DeepCopy()
+	ctx, cancel := context.WithTimeout(context.Background(), boundSizingTimeout)
+	defer cancel()
+	logger := zap.L().With(zap.String("namespace", pod.Namespace), zap.String("name", pod.Name), zap.String("node", pod.Spec.NodeName))
+	ctx = sizing.ContextWithLogger(ctx, logger)
+
+	if err := bs.resize(ctx, pod); err != nil {
+		boundSizingsTotal.WithLabelValues("failed").Inc()
+		logger.Warn("Could not size bound pod", zap.Error(err))
+		bs.recorder.Eventf(pod, corev1.EventTypeWarning, reasonSizingFailed, "Pod could not be sized once bound to %s: %s", pod.Spec.NodeName, err)
+		return
+	}
+	boundSizingsTotal.WithLabelValues("resized").Inc()
+}
+
+// resize applies resource patches through the resize subresource, then annotations, the pending one removed
+func (bs *boundPodSizer) resize(ctx context.Context, pod *corev1.Pod) error {
+	_, patch, err := bs.sizer().CreatePatch(ctx, pod, false)
+	if err != nil {
+		return err
+	}
+	return resizeInPlace(ctx, bs.client, pod, append(patch, jsonpatch.NewOperation("remove", pendingSizingPath, nil)))
+}
+
+// resizeInPlace applies the operations of patch on the pod spec through the resize subresource, then the others
+func resizeInPlace(ctx context.Context, c client.Client, pod *corev1.Pod, patch []jsonpatch.JsonPatchOperation) error {
+	var resources, metadata []jsonpatch.JsonPatchOperation
+	for _, op := range patch {
+		if strings.HasPrefix(op.Path, "/spec/") {
+			resources = append(resources, op)
+		} else {
+			metadata = append(metadata, op)
+		}
+	}
+
+	if len(resources) > 0 {
+		raw, err := json.Marshal(resources)
+		if err != nil {
+			return err
+		}
+		if err := c.SubResource("resize").Patch(ctx, pod, client.RawPatch(types.JSONPatchType, raw), client.FieldOwner(fieldManager)); err != nil {
+			return err
+		}
+	}
+	if len(metadata) == 0 {
+		return nil
+	}
+	raw, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return c.Patch(ctx, pod, client.RawPatch(types.JSONPatchType, raw), client.FieldOwner(fieldManager))
+}
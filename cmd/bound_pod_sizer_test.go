@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+var _ = Describe("Sizing pods once bound", Label("BoundPodSizer"), func() {
+	var (
+		recorder *record.FakeRecorder
+		c        client.Client
+		bs       *boundPodSizer
+		pod      *corev1.Pod
+	)
+
+	BeforeEach(func() {
+		node := fixtures.NodeWithCapacity("4", "8G")
+		node.Name = "node-a"
+		pod = fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil))
+		pod.Name, pod.Namespace = "pending", "default"
+		pod.Annotations = map[string]string{
+			"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.1",
+			sizing.PendingSizingAnnotation:                            "true",
+		}
+		c = fake.NewClientBuilder().WithObjects(node, pod).Build()
+		recorder = record.NewFakeRecorder(10)
+		sizer := sizing.New(c, sizing.Options{})
+		bs = &boundPodSizer{client: c, sizer: func() *sizing.Sizer { return sizer }, recorder: recorder}
+	})
+
+	It("waits for pods to be bound", func(ctx SpecContext) {
+		bs.OnAdd(pod, false)
+		var stored corev1.Pod
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(pod), &stored)).To(Succeed())
+		Expect(stored.Annotations).To(HaveKey(sizing.PendingSizingAnnotation))
+	})
+
+	It("resizes bound pods and clears the pending annotation", func(ctx SpecContext) {
+		before := testutil.ToFloat64(boundSizingsTotal.WithLabelValues("resized"))
+		pod.Spec.NodeName = "node-a"
+		bs.OnUpdate(nil, pod)
+
+		var stored corev1.Pod
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(pod), &stored)).To(Succeed())
+		Expect(stored.Annotations).NotTo(HaveKey(sizing.PendingSizingAnnotation))
+		Expect(stored.Annotations).To(HaveKey(sizing.AppliedResourcesAnnotation))
+		Expect(stored.Spec.Containers[0].Resources.Requests.Cpu().String()).To(Equal("400m"))
+		Expect(testutil.ToFloat64(boundSizingsTotal.WithLabelValues("resized"))).To(Equal(before + 1))
+	})
+
+	It("writes as our field manager", func() {
+		funcs, managers := recordFieldManagers()
+		bs.client = interceptor.NewClient(c.(client.WithWatch), funcs)
+		pod.Spec.NodeName = "node-a"
+		bs.OnUpdate(nil, pod)
+		Expect(*managers).To(Equal([]string{fieldManager, fieldManager}))
+	})
+
+	It("reports pods bound to nodes it cannot read", func() {
+		before := testutil.ToFloat64(boundSizingsTotal.WithLabelValues("failed"))
+		pod.Spec.NodeName = "unknown"
+		bs.OnUpdate(nil, pod)
+		Expect(testutil.ToFloat64(boundSizingsTotal.WithLabelValues("failed"))).To(Equal(before + 1))
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning NodeSpecificSizingFailed Pod could not be sized once bound to unknown")))
+	})
+})
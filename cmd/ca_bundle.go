@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+	"os"
+)
+
+// caBundlePath serves the CA bundle of the serving certificate next to metrics, for infrastructure as code managing the
+// webhook configurations itself to fetch their caBundle, e.g. with the http data source of Terraform
+const caBundlePath = "/ca-bundle"
+
+// caBundleServer answers GET requests with the PEM bundle of file, read on every request to follow renewals. It is
+// read-only and unauthenticated, the bundle is public anyway: API servers are given it to verify the webhook.
+type caBundleServer struct {
+	file string
+}
+
+func (cbs caBundleServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	bundle, err := os.ReadFile(cbs.file)
+	if err != nil || !x509.NewCertPool().AppendCertsFromPEM(bundle) {
+		// The certificate may not be issued yet
+		http.Error(w, "no CA bundle is available", http.StatusServiceUnavailable)
+		return
+	}
+	switch r.URL.Query().Get("encoding") {
+	case "", "pem":
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		_, _ = w.Write(bundle)
+	case "base64":
+		// As found in the caBundle field of webhook configurations
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(bundle)))
+	default:
+		http.Error(w, "unknown encoding, expected pem or base64", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/base64"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+)
+
+var _ = Describe("Serving the CA bundle", Label("CABundle"), func() {
+	var server caBundleServer
+	var bundle []byte
+
+	BeforeEach(func() {
+		data, err := newSelfSignedCerts(nil, "kube-system", "node-specific-sizing-cert", "node-specific-sizing", "node-specific-sizing").generate(nil)
+		Expect(err).NotTo(HaveOccurred())
+		bundle = data["ca.crt"]
+		server = caBundleServer{file: filepath.Join(GinkgoT().TempDir(), "ca.crt")}
+		Expect(os.WriteFile(server.file, bundle, 0o600)).To(Succeed())
+	})
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+		return recorder
+	}
+
+	It("answers with the PEM bundle", func() {
+		recorder := serve(http.MethodGet, caBundlePath)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/x-pem-file"))
+		Expect(recorder.Body.Bytes()).To(Equal(bundle))
+	})
+
+	It("encodes the bundle as in webhook configurations when asked to", func() {
+		recorder := serve(http.MethodGet, caBundlePath+"?encoding=base64")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(base64.StdEncoding.DecodeString(recorder.Body.String())).To(Equal(bundle))
+		Expect(serve(http.MethodGet, caBundlePath+"?encoding=der").Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("is unavailable until a certificate is issued", func() {
+		Expect(os.WriteFile(server.file, nil, 0o600)).To(Succeed())
+		Expect(serve(http.MethodGet, caBundlePath).Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(os.Remove(server.file)).To(Succeed())
+		Expect(serve(http.MethodGet, caBundlePath).Code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("refuses other methods", func() {
+		Expect(serve(http.MethodPut, caBundlePath).Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// loadCertPool reads a bundle of PEM certificates
+func loadCertPool(path string) (*x509.CertPool, error) {
+	bundle, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+	return pool, nil
+}
+
+// clientAuthTLS returns how the webhook server verifies the client certificates the API server presents, as configured
+// by its AdmissionConfiguration. Certificates are verified against the bundle of caFile when given, and required when
+// require is set. It returns nil when client certificates are not verified.
+func clientAuthTLS(caFile string, require bool) (func(*tls.Config), error) {
+	if caFile == "" {
+		if require {
+			return nil, errors.New("requiring client certificates needs a client CA bundle")
+		}
+		return nil, nil
+	}
+	pool, err := loadCertPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+	clientAuth := tls.VerifyClientCertIfGiven
+	if require {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	return func(tlsConfig *tls.Config) {
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = clientAuth
+	}, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("Verifying client certificates", Label("ClientAuth"), func() {
+	// issue returns a certificate signed by parent, self-signed when parent is nil
+	issue := func(name string, parent *tls.Certificate) tls.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(time.Now().UnixNano()),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             time.Now().Add(-time.Minute),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  parent == nil,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		issuer, signer := template, any(key)
+		if parent != nil {
+			issuer, signer = parent.Leaf, parent.PrivateKey
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+		Expect(err).NotTo(HaveOccurred())
+		leaf, err := x509.ParseCertificate(der)
+		Expect(err).NotTo(HaveOccurred())
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+	}
+
+	var ca tls.Certificate
+	var caFile string
+	BeforeEach(func() {
+		ca = issue("ca", nil)
+		caFile = filepath.Join(GinkgoT().TempDir(), "client-ca.crt")
+		Expect(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0o600)).To(Succeed())
+	})
+
+	// get calls a server verifying client certificates as configured, presenting the given ones
+	get := func(require bool, certificates ...tls.Certificate) error {
+		clientAuth, err := clientAuthTLS(caFile, require)
+		Expect(err).NotTo(HaveOccurred())
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		server.TLS = &tls.Config{}
+		clientAuth(server.TLS)
+		server.StartTLS()
+		defer server.Close()
+
+		client := server.Client()
+		client.Transport.(*http.Transport).TLSClientConfig.Certificates = certificates
+		response, err := client.Get(server.URL)
+		if err == nil {
+			_ = response.Body.Close()
+		}
+		return err
+	}
+
+	It("accepts certificates issued by the client CA", func() {
+		Expect(get(true, issue("kube-apiserver", &ca))).To(Succeed())
+	})
+
+	It("refuses other certificates", func() {
+		Expect(get(true, issue("intruder", nil))).NotTo(Succeed())
+	})
+
+	It("refuses missing certificates only when required", func() {
+		Expect(get(false)).To(Succeed())
+		Expect(get(true)).NotTo(Succeed())
+	})
+
+	It("needs a CA bundle to require certificates", func() {
+		_, err := clientAuthTLS("", true)
+		Expect(err).To(HaveOccurred())
+		Expect(clientAuthTLS("", false)).To(BeNil())
+	})
+})
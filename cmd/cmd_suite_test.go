@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestCmd(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cmd Suite")
+}
+
+// recordFieldManagers intercepts writes, patches of subresources included, recording the field manager of every one
+func recordFieldManagers() (interceptor.Funcs, *[]string) {
+	var managers []string
+	return interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			managers = append(managers, (&client.CreateOptions{}).ApplyOptions(opts).FieldManager)
+			return c.Create(ctx, obj, opts...)
+		},
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			managers = append(managers, (&client.UpdateOptions{}).ApplyOptions(opts).FieldManager)
+			return c.Update(ctx, obj, opts...)
+		},
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			managers = append(managers, (&client.PatchOptions{}).ApplyOptions(opts).FieldManager)
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+		SubResourcePatch: func(ctx context.Context, c client.Client, subResource string, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+			managers = append(managers, (&client.SubResourcePatchOptions{}).ApplyOptions(opts).FieldManager)
+			return c.SubResource(subResource).Patch(ctx, obj, patch, opts...)
+		},
+	}, &managers
+}
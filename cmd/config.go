@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/yaml"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// failureMode tells how pods that cannot be sized are answered
+type failureMode string
+
+const (
+	// failureModeError answers with an error, the failurePolicy of the webhook configuration then decides
+	failureModeError failureMode = "error"
+	// failureModeAllow admits pods as they are, with a warning
+	failureModeAllow failureMode = "allow"
+	// failureModeDeny refuses pods
+	failureModeDeny failureMode = "deny"
+)
+
+func parseFailureMode(value string) (failureMode, error) {
+	mode := failureMode(value)
+	if !slices.Contains([]failureMode{failureModeError, failureModeAllow, failureModeDeny}, mode) {
+		return "", fmt.Errorf("unknown failure mode %q, expected one of error, allow or deny", value)
+	}
+	return mode, nil
+}
+
+// parseResourceList parses comma-separated resource=quantity pairs, e.g. cpu=100m,memory=128Mi
+func parseResourceList(value string) (corev1.ResourceList, error) {
+	result := corev1.ResourceList{}
+	for _, pair := range splitList(value) {
+		name, raw, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("%q is not a resource=quantity pair", pair)
+		}
+		quantity, err := resource.ParseQuantity(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("quantity of %s: %w", strings.TrimSpace(name), err)
+		}
+		result[corev1.ResourceName(strings.TrimSpace(name))] = quantity
+	}
+	return result, nil
+}
+
+// parseWebhookPaths parses the comma-separated paths each handler is served on, by flag name. Paths must be absolute
+// and may only be served once, shardedMutatePath being reserved.
+func parseWebhookPaths(values map[string]string) (map[string][]string, error) {
+	taken := mapset.NewThreadUnsafeSet(shardedMutatePath)
+	result := make(map[string][]string, len(values))
+	for name, value := range values {
+		paths := splitList(value)
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("--%s lists no path", name)
+		}
+		for _, path := range paths {
+			if !strings.HasPrefix(path, "/") {
+				return nil, fmt.Errorf("--%s: path %q is not absolute", name, path)
+			}
+			if !taken.Add(path) {
+				return nil, fmt.Errorf("--%s: path %q is served more than once", name, path)
+			}
+		}
+		result[name] = paths
+	}
+	return result, nil
+}
+
+// fileConfig is what --config holds. Settings given as flags take precedence over it.
+type fileConfig struct {
+	TLS struct {
+		CertFile string `json:"certFile"`
+		KeyFile  string `json:"keyFile"`
+		CAFile   string `json:"caFile"`
+		// ClientCAFile verifies the client certificates the API server presents
+		ClientCAFile      string `json:"clientCaFile"`
+		RequireClientCert *bool  `json:"requireClientCert"`
+	} `json:"tls"`
+	Port                int    `json:"port"`
+	MetricsBindAddress  string `json:"metricsBindAddress"`
+	FailureMode         string `json:"failureMode"`
+	AnnotationDomain    string `json:"annotationDomain"`
+	AnnotationConflicts string `json:"annotationConflicts"`
+	DryRun              *bool  `json:"dryRun"`
+	// Defaults are sizing settings applying to every pod, keyed by annotation name without prefix
+	Defaults           map[string]string `json:"defaults"`
+	ExcludedNamespaces []string          `json:"excludedNamespaces"`
+}
+
+// loadConfigFile reads a YAML config file, refusing unknown fields
+func loadConfigFile(path string) (*fileConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("problem reading config file: %w", err)
+	}
+	var cfg fileConfig
+	if err := yaml.UnmarshalStrict(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("problem parsing config file %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// applyStartup sets the settings that only take effect on start, unless they were given as flags
+func (c *fileConfig) applyStartup(explicit mapset.Set[string]) {
+	setString := func(name string, target *string, value string) {
+		if value != "" && !explicit.Contains(name) {
+			*target = value
+		}
+	}
+	setString("tlsCertFile", &certFile, c.TLS.CertFile)
+	setString("tlsKeyFile", &keyFile, c.TLS.KeyFile)
+	setString("tlsCaFile", &caCrtFile, c.TLS.CAFile)
+	setString("tlsClientCaFile", &clientCaFile, c.TLS.ClientCAFile)
+	if c.TLS.RequireClientCert != nil && !explicit.Contains("require-client-cert") {
+		requireClientCert = *c.TLS.RequireClientCert
+	}
+	setString("metrics-bind-address", &metricsBindAddress, c.MetricsBindAddress)
+	if c.Port != 0 && !explicit.Contains("port") {
+		port = c.Port
+	}
+}
+
+// reloadableSettings take effect without restarting when the config file changes
+type reloadableSettings struct {
+	failureMode        failureMode
+	annotationDomain   string
+	conflicts          sizing.ConflictMode
+	dryRun             bool
+	defaults           map[string]string
+	excludedNamespaces []string
+}
+
+// hash tells settings apart, so that replicas running different ones stand out. fmt prints maps sorted by key.
+func (s reloadableSettings) hash() string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%+v", s))
+	return hex.EncodeToString(sum[:8])
+}
+
+// resolveSettings reads reloadable settings from flags, overridden by cfg unless given as flags. cfg may be nil.
+func resolveSettings(cfg *fileConfig, explicit mapset.Set[string]) (reloadableSettings, error) {
+	failureModeValue, domain, conflictsValue, dry := failureModeName, annotationDomain, annotationConflicts, dryRun
+	excluded := splitList(excludedNamespaces)
+	var defaults map[string]string
+	if cfg != nil {
+		override := func(name string, target *string, value string) {
+			if value != "" && !explicit.Contains(name) {
+				*target = value
+			}
+		}
+		override("failure-mode", &failureModeValue, cfg.FailureMode)
+		override("annotation-domain", &domain, cfg.AnnotationDomain)
+		override("annotation-conflicts", &conflictsValue, cfg.AnnotationConflicts)
+		if cfg.DryRun != nil && !explicit.Contains("dry-run") {
+			dry = *cfg.DryRun
+		}
+		if cfg.ExcludedNamespaces != nil && !explicit.Contains("excluded-namespaces") {
+			excluded = cfg.ExcludedNamespaces
+		}
+		defaults = cfg.Defaults
+	}
+
+	settings := reloadableSettings{annotationDomain: domain, dryRun: dry, defaults: defaults, excludedNamespaces: excluded}
+	var err error
+	if settings.failureMode, err = parseFailureMode(failureModeValue); err != nil {
+		return reloadableSettings{}, err
+	}
+	if settings.conflicts, err = sizing.ParseConflictMode(conflictsValue); err != nil {
+		return reloadableSettings{}, err
+	}
+	if err := sizing.ValidateDefaults(defaults); err != nil {
+		return reloadableSettings{}, fmt.Errorf("invalid defaults: %w", err)
+	}
+	return settings, nil
+}
+
+// reloadableHandler delegates to a handler that is replaced whenever settings are reloaded
+type reloadableHandler struct {
+	current atomic.Pointer[podSizingHandler]
+}
+
+var _ admission.Handler = &reloadableHandler{}
+
+func (h *reloadableHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	return h.current.Load().Handle(ctx, req)
+}
+
+// sizer returns the current Sizer, which changes when settings are reloaded
+func (h *reloadableHandler) sizer() *sizing.Sizer {
+	return h.current.Load().sizer
+}
+
+// watchConfigFile calls reload on SIGHUP, and whenever the directory of path changes, as ConfigMap volumes update
+// their files by swapping a symlink. It returns once watching started.
+func watchConfigFile(ctx context.Context, path string, reload func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("problem watching config file: %w", err)
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		_ = watcher.Close()
+		return fmt.Errorf("problem watching config file: %w", err)
+	}
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hangups)
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangups:
+				reload()
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !event.Has(fsnotify.Chmod) {
+					reload()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				zap.L().Warn("Problem watching config file", zap.Error(err))
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	mapset "github.com/deckarep/golang-set/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("Reading the config file", Label("Config"), func() {
+	writeConfig := func(path, content string) {
+		Expect(os.WriteFile(path, []byte(content), 0o644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		// Flags are only registered by main, tests start from their defaults
+		previous := []string{failureModeName, annotationConflicts, annotationDomain, excludedNamespaces}
+		failureModeName, annotationConflicts, annotationDomain, excludedNamespaces = string(failureModeError), string(sizing.ConflictsIgnore), "", ""
+		DeferCleanup(func() {
+			failureModeName, annotationConflicts, annotationDomain, excludedNamespaces = previous[0], previous[1], previous[2], previous[3]
+		})
+	})
+
+	It("reads settings, letting flags take precedence", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		writeConfig(path, `
+failureMode: allow
+annotationConflicts: warn
+annotationDomain: sizing.example.com
+excludedNamespaces: [kube-system]
+defaults:
+  request-cpu-fraction: "0.1"
+`)
+		cfg, err := loadConfigFile(path)
+		Expect(err).NotTo(HaveOccurred())
+
+		annotationConflicts = string(sizing.ConflictsDeny)
+		settings, err := resolveSettings(cfg, mapset.NewThreadUnsafeSet("annotation-conflicts"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(settings).To(Equal(reloadableSettings{
+			failureMode:        failureModeAllow,
+			annotationDomain:   "sizing.example.com",
+			conflicts:          sizing.ConflictsDeny,
+			defaults:           map[string]string{"request-cpu-fraction": "0.1"},
+			excludedNamespaces: []string{"kube-system"},
+		}))
+	})
+
+	It("hashes settings the same whenever they are equal", func() {
+		settings := func(fraction string) reloadableSettings {
+			return reloadableSettings{failureMode: failureModeAllow, defaults: map[string]string{
+				"request-cpu-fraction": fraction,
+				"limit-cpu-fraction":   "0.5",
+			}}
+		}
+		Expect(settings("0.1").hash()).To(Equal(settings("0.1").hash()))
+		Expect(settings("0.1").hash()).NotTo(Equal(settings("0.2").hash()))
+	})
+
+	It("refuses unknown fields and invalid settings", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		writeConfig(path, "failureMod: allow\n")
+		_, err := loadConfigFile(path)
+		Expect(err).To(HaveOccurred())
+
+		writeConfig(path, "defaults: {request-cpu-fraction: '3'}\n")
+		cfg, err := loadConfigFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = resolveSettings(cfg, mapset.NewThreadUnsafeSet[string]())
+		Expect(err).To(MatchError(ContainSubstring("invalid defaults")))
+	})
+
+	It("reloads when the file changes", func(ctx SpecContext) {
+		path := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		writeConfig(path, "failureMode: allow\n")
+		reloads := make(chan struct{}, 10)
+		Expect(watchConfigFile(ctx, path, func() { reloads <- struct{}{} })).To(Succeed())
+
+		writeConfig(path, "failureMode: deny\n")
+		Eventually(reloads).WithTimeout(5 * time.Second).Should(Receive())
+	})
+})
+
+var _ = Describe("Parsing webhook paths", Label("Config"), func() {
+	It("reads comma-separated paths by flag", func() {
+		paths, err := parseWebhookPaths(map[string]string{"mutate-paths": "/mutate", "validate-paths": "/validate-pods, /validate-workloads"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(paths).To(Equal(map[string][]string{
+			"mutate-paths":   {"/mutate"},
+			"validate-paths": {"/validate-pods", "/validate-workloads"},
+		}))
+	})
+
+	It("refuses relative, missing or repeated paths", func() {
+		_, err := parseWebhookPaths(map[string]string{"mutate-paths": "mutate"})
+		Expect(err).To(MatchError(ContainSubstring("not absolute")))
+		_, err = parseWebhookPaths(map[string]string{"mutate-paths": ""})
+		Expect(err).To(MatchError(ContainSubstring("lists no path")))
+		_, err = parseWebhookPaths(map[string]string{"mutate-paths": "/mutate", "validate-paths": "/mutate"})
+		Expect(err).To(MatchError(ContainSubstring("served more than once")))
+		_, err = parseWebhookPaths(map[string]string{"mutate-paths": shardedMutatePath})
+		Expect(err).To(MatchError(ContainSubstring("served more than once")))
+	})
+})
+
+var _ = Describe("Parsing resource lists", Label("Config"), func() {
+	It("reads resource=quantity pairs", func() {
+		resources, err := parseResourceList("cpu=100m, memory=128Mi")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resources).To(Equal(corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("100m"),
+			corev1.ResourceMemory: resource.MustParse("128Mi"),
+		}))
+		Expect(parseResourceList("")).To(BeEmpty())
+	})
+
+	It("refuses malformed pairs", func() {
+		for _, value := range []string{"cpu", "=1", "cpu=lots"} {
+			_, err := parseResourceList(value)
+			Expect(err).To(HaveOccurred(), value)
+		}
+	})
+})
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+const (
+	// sinkBackoff is the delay before the first retry of a decision, doubled on every other one
+	sinkBackoff = time.Second
+	// maxSinkBackoff caps the delay between retries
+	maxSinkBackoff = 30 * time.Second
+	// sinkTimeout bounds every attempt at posting a decision
+	sinkTimeout = 10 * time.Second
+)
+
+// errSinkRefused is returned when the sink refuses a decision for good, e.g. with a 400, which is not retried
+var errSinkRefused = errors.New("decision refused")
+
+// sinkRecord is what is posted to the sink for every decision
+type sinkRecord struct {
+	// RequestUID tells retried deliveries of the same decision apart from new ones
+	RequestUID string `json:"requestUID"`
+	decision
+}
+
+// decisionSink posts sizing decisions as JSON to an HTTP endpoint, e.g. for FinOps pipelines to ingest resource
+// changes as they happen. Decisions are queued rather than sent while admission requests wait, and dropped when the
+// queue is full or once retries are exhausted: the sink is best effort, and never fails admission.
+type decisionSink struct {
+	url     string
+	client  *http.Client
+	queue   chan sinkRecord
+	retries int
+	backoff time.Duration
+}
+
+// newDecisionSink posts decisions to url, queueing at most queueSize of them, retrying every one up to retries times
+func newDecisionSink(url string, queueSize, retries int) *decisionSink {
+	return &decisionSink{
+		url:     url,
+		client:  &http.Client{Timeout: sinkTimeout},
+		queue:   make(chan sinkRecord, queueSize),
+		retries: retries,
+		backoff: sinkBackoff,
+	}
+}
+
+// send queues a decision, dropping it when the queue is full
+func (s *decisionSink) send(requestUID string, entry decision) {
+	select {
+	case s.queue <- sinkRecord{RequestUID: requestUID, decision: entry}:
+	default:
+		sinkDecisionsTotal.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Run posts queued decisions one at a time until ctx is done, decisions still queued then being dropped
+func (s *decisionSink) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case record := <-s.queue:
+			s.deliver(ctx, record)
+		}
+	}
+	s.drain()
+}
+
+// drain drops the decisions left in the queue, counting them as such
+func (s *decisionSink) drain() {
+	for {
+		select {
+		case <-s.queue:
+			sinkDecisionsTotal.WithLabelValues("dropped").Inc()
+		default:
+			return
+		}
+	}
+}
+
+// deliver posts record, retrying with an exponential backoff on network errors, 5xx and 429 answers
+func (s *decisionSink) deliver(ctx context.Context, record sinkRecord) {
+	body, err := json.Marshal(record)
+	if err != nil {
+		sinkDecisionsTotal.WithLabelValues("failed").Inc()
+		zap.L().Error("Could not encode decision for the sink", zap.Error(err))
+		return
+	}
+	backoff := s.backoff
+	for attempt := 0; ; attempt++ {
+		err := s.post(ctx, body)
+		if err == nil {
+			sinkDecisionsTotal.WithLabelValues("sent").Inc()
+			return
+		}
+		if errors.Is(err, errSinkRefused) || attempt >= s.retries {
+			sinkDecisionsTotal.WithLabelValues("failed").Inc()
+			zap.L().Warn("Could not send decision to the sink", zap.String("uid", record.RequestUID),
+				zap.Int("attempts", attempt+1), zap.Error(err))
+			return
+		}
+		select {
+		case <-ctx.Done():
+			sinkDecisionsTotal.WithLabelValues("failed").Inc()
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxSinkBackoff)
+	}
+}
+
+func (s *decisionSink) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach sink: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusRequestTimeout:
+		return fmt.Errorf("sink answered %s", resp.Status)
+	}
+	return fmt.Errorf("%w: sink answered %s", errSinkRefused, resp.Status)
+}
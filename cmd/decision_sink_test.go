@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+)
+
+var _ = Describe("Posting sizing decisions to a sink", Label("Decisions"), func() {
+	var received chan sinkRecord
+	var attempts atomic.Int32
+	// answers are the status codes of the first attempts, before the sink accepts decisions
+	var answers []int
+	var server *httptest.Server
+	// sent counts decisions the sink accepted, which specs wait for rather than leaving deliveries in flight
+	var sent func() float64
+	BeforeEach(func() {
+		baseline := testutil.ToFloat64(sinkDecisionsTotal.WithLabelValues("sent"))
+		sent = func() float64 { return testutil.ToFloat64(sinkDecisionsTotal.WithLabelValues("sent")) - baseline }
+		received = make(chan sinkRecord, 10)
+		attempts.Store(0)
+		answers = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if attempt := int(attempts.Add(1)); attempt <= len(answers) {
+				w.WriteHeader(answers[attempt-1])
+				return
+			}
+			var record sinkRecord
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(json.NewDecoder(r.Body).Decode(&record)).To(Succeed())
+			received <- record
+		}))
+		DeferCleanup(server.Close)
+	})
+
+	sinkTo := func(ctx SpecContext, retries int) *decisionSink {
+		sink := newDecisionSink(server.URL, 10, retries)
+		sink.backoff = time.Millisecond
+		go sink.Run(ctx)
+		return sink
+	}
+
+	It("posts every decision as JSON", func(ctx SpecContext) {
+		sink := sinkTo(ctx, 0)
+		sink.send("uid-a", decision{Namespace: "default", Name: "a", Node: "node-a"})
+
+		var record sinkRecord
+		Eventually(received).Should(Receive(&record))
+		Expect(record.RequestUID).To(Equal("uid-a"))
+		Expect(record.decision).To(And(HaveField("Name", "a"), HaveField("Node", "node-a")))
+		Eventually(sent).Should(BeEquivalentTo(1))
+	})
+
+	It("retries server errors with a backoff", func(ctx SpecContext) {
+		answers = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+		sinkTo(ctx, 2).send("uid-a", decision{Name: "a"})
+
+		Eventually(received).Should(Receive(HaveField("RequestUID", "uid-a")))
+		Eventually(sent).Should(BeEquivalentTo(1))
+		Expect(attempts.Load()).To(BeEquivalentTo(3))
+	})
+
+	It("gives up once retries are exhausted, or when decisions are refused", func(ctx SpecContext) {
+		failed := testutil.ToFloat64(sinkDecisionsTotal.WithLabelValues("failed"))
+		answers = []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusBadRequest}
+		sink := sinkTo(ctx, 1)
+		sink.send("uid-a", decision{Name: "a"})
+		sink.send("uid-b", decision{Name: "b"})
+
+		Eventually(func() float64 { return testutil.ToFloat64(sinkDecisionsTotal.WithLabelValues("failed")) }).
+			Should(Equal(failed + 2))
+		Expect(attempts.Load()).To(BeEquivalentTo(3))
+		Consistently(received).ShouldNot(Receive())
+	})
+
+	It("drops decisions when the queue is full rather than holding admission requests up", func() {
+		dropped := testutil.ToFloat64(sinkDecisionsTotal.WithLabelValues("dropped"))
+		sink := newDecisionSink(server.URL, 1, 0)
+		sink.send("uid-a", decision{Name: "a"})
+		sink.send("uid-b", decision{Name: "b"})
+		Expect(testutil.ToFloat64(sinkDecisionsTotal.WithLabelValues("dropped"))).To(Equal(dropped + 1))
+	})
+	It("drops decisions still queued on shutdown", func(ctx SpecContext) {
+		dropped := testutil.ToFloat64(sinkDecisionsTotal.WithLabelValues("dropped"))
+		sink := newDecisionSink(server.URL, 10, 0)
+		sink.send("uid-a", decision{Name: "a"})
+		sink.send("uid-b", decision{Name: "b"})
+		stopped, stop := context.WithCancel(ctx)
+		stop()
+		sink.Run(stopped)
+		Expect(testutil.ToFloat64(sinkDecisionsTotal.WithLabelValues("dropped"))).To(Equal(dropped + 2))
+		Expect(sink.queue).To(BeEmpty())
+	})
+})
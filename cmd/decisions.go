@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// decisionsPath serves the last sizing decisions next to metrics, for dashboards that would otherwise scrape logs
+const decisionsPath = "/decisions"
+
+// decision is what sizing did to a pod, patches holding resources before and after
+type decision struct {
+	Time      time.Time              `json:"time"`
+	Namespace string                 `json:"namespace"`
+	Name      string                 `json:"name"`
+	Node      string                 `json:"node"`
+	DryRun    bool                   `json:"dryRun"`
+	Patches   []sizing.ResourcePatch `json:"patches"`
+}
+
+// decisionLog keeps the last sizing decisions in a ring buffer. It is safe for concurrent use.
+type decisionLog struct {
+	lock      sync.Mutex
+	decisions []decision
+	// next is where the next decision goes, overwriting the oldest one once the buffer is full
+	next int
+	full bool
+}
+
+func newDecisionLog(size int) *decisionLog {
+	return &decisionLog{decisions: make([]decision, size)}
+}
+
+// newDecision describes what sizing did to a pod
+func newDecision(namespace, name string, result *sizing.Result) decision {
+	return decision{
+		Time:      time.Now(),
+		Namespace: namespace,
+		Name:      name,
+		Node:      result.NodeName(),
+		DryRun:    result.DryRun(),
+		Patches:   slices.Collect(result.Patches()),
+	}
+}
+
+// record keeps the decision of a sized pod
+func (l *decisionLog) record(namespace, name string, result *sizing.Result) {
+	entry := newDecision(namespace, name, result)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.decisions[l.next] = entry
+	l.next = (l.next + 1) % len(l.decisions)
+	l.full = l.full || l.next == 0
+}
+
+// recent returns at most limit decisions, newest first, every one kept when limit is not positive
+func (l *decisionLog) recent(limit int) []decision {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	count := l.next
+	if l.full {
+		count = len(l.decisions)
+	}
+	if limit > 0 && limit < count {
+		count = limit
+	}
+	result := make([]decision, 0, count)
+	for i := range count {
+		result = append(result, l.decisions[(l.next-1-i+len(l.decisions))%len(l.decisions)])
+	}
+	return result
+}
+
+// ServeHTTP answers GET requests with the recent decisions as JSON, as many as the limit query parameter asks for
+func (l *decisionLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := 0
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		var err error
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 0 {
+			http.Error(w, "limit must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string][]decision{"decisions": l.recent(limit)})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"net/http"
+	"net/http/httptest"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Serving sizing decisions", Label("Decisions"), func() {
+	var result *sizing.Result
+	BeforeEach(func(ctx SpecContext) {
+		node := fixtures.NodeWithCapacity("4", "8G")
+		node.Name = "node-a"
+		pod := fixtures.PinToNode(fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil)), "node-a")
+		pod.Annotations = map[string]string{"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.1"}
+		var err error
+		result, err = sizing.New(fake.NewClientBuilder().WithObjects(node).Build(), sizing.Options{}).Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	names := func(decisions []decision) []string {
+		var result []string
+		for _, d := range decisions {
+			result = append(result, d.Name)
+		}
+		return result
+	}
+
+	It("keeps the last decisions, newest first", func() {
+		log := newDecisionLog(2)
+		Expect(log.recent(0)).To(BeEmpty())
+		log.record("default", "a", result)
+		Expect(names(log.recent(0))).To(Equal([]string{"a"}))
+		log.record("default", "b", result)
+		log.record("default", "c", result)
+		Expect(names(log.recent(0))).To(Equal([]string{"c", "b"}))
+		Expect(names(log.recent(1))).To(Equal([]string{"c"}))
+	})
+
+	It("serves them as JSON", func() {
+		log := newDecisionLog(10)
+		log.record("default", "a", result)
+		log.record("default", "b", result)
+
+		recorder := httptest.NewRecorder()
+		log.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, decisionsPath+"?limit=1", nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		var body struct {
+			Decisions []decision `json:"decisions"`
+		}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &body)).To(Succeed())
+		Expect(body.Decisions).To(HaveLen(1))
+		Expect(body.Decisions[0]).To(And(HaveField("Name", "b"), HaveField("Node", "node-a")))
+		Expect(body.Decisions[0].Patches).To(ContainElement(And(
+			HaveField("Old", HaveValue(Equal(resource.MustParse("100m")))),
+			HaveField("New", resource.MustParse("400m")),
+		)))
+	})
+
+	It("is read-only", func() {
+		recorder := httptest.NewRecorder()
+		newDecisionLog(1).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, decisionsPath, nil))
+		Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+
+		recorder = httptest.NewRecorder()
+		newDecisionLog(1).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, decisionsPath+"?limit=some", nil))
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+	})
+})
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"slices"
+)
+
+// fieldManager is the manager name we use for any write we perform ourselves. Appliers that should leave sized
+// resources alone can exclude fields owned by it, see the README.
+const fieldManager = "node-specific-sizing"
+
+// resourceDrift is a resource that no longer holds the value we applied
+type resourceDrift struct {
+	Container string               `json:"container"`
+	Property  rps.ResourceProperty `json:"property"`
+	Resource  corev1.ResourceName  `json:"resource"`
+	Applied   string               `json:"applied"`
+	// Actual is empty when the value was removed altogether
+	Actual string `json:"actual,omitempty"`
+}
+
+// driftFrom lists, in a stable order, every applied resource that the pod does not hold anymore
+func driftFrom(applied sizing.AppliedResources, pod *corev1.Pod) []resourceDrift {
+	var drifts []resourceDrift
+	for ctn := range sizing.LongRunningContainers(pod) {
+		reqs, ok := applied[ctn.Name]
+		if !ok {
+			continue
+		}
+		drifts = append(drifts, listDrift(ctn.Name, rps.ResourceRequests, reqs.Requests, ctn.Resources.Requests)...)
+		drifts = append(drifts, listDrift(ctn.Name, rps.ResourceLimits, reqs.Limits, ctn.Resources.Limits)...)
+	}
+	return drifts
+}
+
+func listDrift(containerName string, prop rps.ResourceProperty, applied, actual corev1.ResourceList) []resourceDrift {
+	var drifts []resourceDrift
+	for name, appliedQty := range applied {
+		drift := resourceDrift{Container: containerName, Property: prop, Resource: name, Applied: appliedQty.String()}
+		if actualQty, ok := actual[name]; !ok {
+			drifts = append(drifts, drift)
+		} else if actualQty.Cmp(appliedQty) != 0 {
+			drift.Actual = actualQty.String()
+			drifts = append(drifts, drift)
+		}
+	}
+	slices.SortFunc(drifts, func(a, b resourceDrift) int { return cmp.Compare(a.Resource, b.Resource) })
+	return drifts
+}
+
+// resourceManagers lists the field managers owning some container resources, which is where to look for whoever
+// reverted our values.
+func resourceManagers(pod *corev1.Pod) []string {
+	var managers []string
+	for _, entry := range pod.ManagedFields {
+		if entry.FieldsV1 != nil && bytes.Contains(entry.FieldsV1.Raw, []byte(`"f:resources"`)) && !slices.Contains(managers, entry.Manager) {
+			managers = append(managers, entry.Manager)
+		}
+	}
+	return managers
+}
+
+// driftDetector watches sized pods and reports every time their resources stop matching what we applied,
+// for instance when a GitOps tool re-applies a manifest on a cluster with in-place resize.
+type driftDetector struct{}
+
+var _ toolscache.ResourceEventHandler = &driftDetector{}
+
+func (dd *driftDetector) OnAdd(obj interface{}, _ bool) {
+	if pod, ok := obj.(*corev1.Pod); ok {
+		dd.report(pod, nil)
+	}
+}
+
+func (dd *driftDetector) OnUpdate(oldObj, newObj interface{}) {
+	oldPod, oldOk := oldObj.(*corev1.Pod)
+	newPod, newOk := newObj.(*corev1.Pod)
+	if oldOk && newOk {
+		dd.report(newPod, oldPod)
+	}
+}
+
+func (dd *driftDetector) OnDelete(interface{}) {}
+
+// report logs drift on pod, unless it was already there in the previous version of the pod
+func (dd *driftDetector) report(pod *corev1.Pod, previous *corev1.Pod) {
+	applied, err := sizing.AppliedResourcesFromAnnotations(pod.Annotations)
+	if err != nil {
+		zap.L().Warn("Cannot check pod for drift", zap.String("namespace", pod.Namespace), zap.String("name", pod.Name), zap.Error(err))
+		return
+	}
+
+	drifts := driftFrom(applied, pod)
+	if len(drifts) == 0 {
+		return
+	}
+	if previous != nil && slices.Equal(drifts, driftFrom(applied, previous)) {
+		return
+	}
+
+	zap.L().Warn("Sized resources were reverted",
+		zap.String("namespace", pod.Namespace),
+		zap.String("name", pod.Name),
+		zap.Any("drift", drifts),
+		zap.Strings("managers", resourceManagers(pod)))
+}
+
+// startPodHandler registers handler against the pod informer of the given cache
+func startPodHandler(ctx context.Context, informers cache.Informers, handler toolscache.ResourceEventHandler) error {
+	informer, err := informers.GetInformer(ctx, &corev1.Pod{})
+	if err != nil {
+		return fmt.Errorf("could not get pod informer: %w", err)
+	}
+	if _, err := informer.AddEventHandler(handler); err != nil {
+		return fmt.Errorf("could not register pod handler: %w", err)
+	}
+	return nil
+}
+
+// startNodeHandler registers handler against the node informer of the given cache
+func startNodeHandler(ctx context.Context, informers cache.Informers, handler toolscache.ResourceEventHandler) error {
+	informer, err := informers.GetInformer(ctx, &corev1.Node{})
+	if err != nil {
+		return fmt.Errorf("could not get node informer: %w", err)
+	}
+	if _, err := informer.AddEventHandler(handler); err != nil {
+		return fmt.Errorf("could not register node handler: %w", err)
+	}
+	return nil
+}
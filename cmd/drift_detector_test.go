@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Detecting drift", Label("DriftDetector"), func() {
+	sizedPod := func(memoryRequest string) *corev1.Pod {
+		pod := fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceMemory: resource.MustParse(memoryRequest)}, nil))
+		pod.Annotations = map[string]string{
+			sizing.AppliedResourcesAnnotation: `{"a":{"requests":{"memory":"200M"}}}`,
+		}
+		return pod
+	}
+
+	It("finds nothing when the pod holds the applied values", func() {
+		pod := sizedPod("200M")
+		applied, err := sizing.AppliedResourcesFromAnnotations(pod.Annotations)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(driftFrom(applied, pod)).To(BeEmpty())
+	})
+
+	It("finds reverted values", func() {
+		pod := sizedPod("100M")
+		applied, err := sizing.AppliedResourcesFromAnnotations(pod.Annotations)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(driftFrom(applied, pod)).To(ConsistOf(resourceDrift{
+			Container: "a",
+			Property:  "requests",
+			Resource:  corev1.ResourceMemory,
+			Applied:   "200M",
+			Actual:    "100M",
+		}))
+	})
+
+	It("ignores pods that were never sized", func() {
+		applied, err := sizing.AppliedResourcesFromAnnotations(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(driftFrom(applied, sizedPod("100M"))).To(BeEmpty())
+	})
+
+	It("names the managers owning container resources", func() {
+		pod := sizedPod("100M")
+		pod.ManagedFields = []metav1.ManagedFieldsEntry{
+			{Manager: "kube-controller-manager", FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:containers":{}}}`)}},
+			{Manager: "argocd-controller", FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:containers":{"k:{\"name\":\"a\"}":{"f:resources":{}}}}}`)}},
+		}
+		Expect(resourceManagers(pod)).To(Equal([]string{"argocd-controller"}))
+	})
+})
@@ -0,0 +1,173 @@
+//go:build e2e
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/apis/v1alpha1"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"net"
+	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"strconv"
+)
+
+// The end-to-end suite runs the webhook against a real API server, installed with the manifests of deploy/. It needs
+// the binaries of envtest, see the test-e2e target of the Makefile. There is no controller manager, so pods are
+// created the way the DaemonSet controller would.
+var _ = Describe("Sizing pods of a DaemonSet", Ordered, Label("e2e"), func() {
+	var (
+		env       *envtest.Environment
+		k8sClient client.Client
+		cancel    context.CancelFunc
+	)
+
+	BeforeAll(func() {
+		env = &envtest.Environment{
+			CRDDirectoryPaths:     []string{filepath.Join("..", "deploy", "crd")},
+			ErrorIfCRDPathMissing: true,
+			WebhookInstallOptions: envtest.WebhookInstallOptions{
+				Paths: []string{filepath.Join("..", "deploy", "mutatingadmissionwebhook.yaml")},
+			},
+		}
+		cfg, err := env.Start()
+		Expect(err).NotTo(HaveOccurred())
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		Expect(v1alpha1.AddToScheme(scheme)).To(Succeed())
+		k8sClient, err = client.New(cfg, client.Options{Scheme: scheme})
+		Expect(err).NotTo(HaveOccurred())
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		options := env.WebhookInstallOptions
+		server := webhook.NewServer(webhook.Options{
+			Host:    options.LocalServingHost,
+			Port:    options.LocalServingPort,
+			CertDir: options.LocalServingCertDir,
+		})
+		server.Register("/mutate", &webhook.Admission{Handler: &podSizingHandler{
+			sizer:   sizing.New(k8sClient, sizing.Options{NamespaceReader: k8sClient, PolicyReader: k8sClient}),
+			decoder: admission.NewDecoder(scheme),
+		}})
+		go func() {
+			defer GinkgoRecover()
+			Expect(server.Start(ctx)).To(Succeed())
+		}()
+
+		// The webhook fails open, pods created before it listens would silently keep their resources
+		address := net.JoinHostPort(options.LocalServingHost, strconv.Itoa(options.LocalServingPort))
+		Eventually(func() error {
+			conn, err := tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true})
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}).Should(Succeed())
+	})
+
+	AfterAll(func() {
+		if cancel != nil {
+			cancel()
+		}
+		Expect(env.Stop()).To(Succeed())
+	})
+
+	createNode := func(ctx context.Context, name, cpu, memory string) {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		Expect(k8sClient.Create(ctx, node)).To(Succeed())
+		node.Status.Capacity = corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpu),
+			corev1.ResourceMemory: resource.MustParse(memory),
+		}
+		Expect(k8sClient.Status().Update(ctx, node)).To(Succeed())
+	}
+
+	// createDaemonPod creates the pod the DaemonSet controller would create on a node
+	createDaemonPod := func(ctx context.Context, ds *appsv1.DaemonSet, nodeName string) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: *ds.Spec.Template.ObjectMeta.DeepCopy(),
+			Spec:       *ds.Spec.Template.Spec.DeepCopy(),
+		}
+		pod.Namespace = ds.Namespace
+		pod.Name = fmt.Sprintf("%s-%s", ds.Name, nodeName)
+		pod.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: "apps/v1", Kind: "DaemonSet", Name: ds.Name, UID: ds.UID, Controller: ptr.To(true),
+		}}
+		fixtures.PinToNode(pod, nodeName)
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+		return pod
+	}
+
+	It("sizes pods according to their node", func(ctx SpecContext) {
+		createNode(ctx, "small", "2", "4Gi")
+		createNode(ctx, "large", "16", "64Gi")
+
+		labels := map[string]string{"app": "agent", sizing.EnabledLabel: "true"}
+		ds := &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "default"},
+			Spec: appsv1.DaemonSetSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						Labels: labels,
+						Annotations: map[string]string{
+							"node-specific-sizing.manomano.tech/request-memory-fraction": "0.125",
+							"node-specific-sizing.manomano.tech/request-cpu-fraction":    "0.25",
+						},
+					},
+					Spec: corev1.PodSpec{Containers: []corev1.Container{{
+						Name:  "agent",
+						Image: "registry.k8s.io/pause:3.9",
+						Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse("100m"),
+							corev1.ResourceMemory: resource.MustParse("100Mi"),
+						}},
+					}}},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+		small := createDaemonPod(ctx, ds, "small")
+		large := createDaemonPod(ctx, ds, "large")
+
+		Expect(small.Spec.Containers[0].Resources.Requests.Memory().String()).To(Equal("512Mi"))
+		Expect(small.Spec.Containers[0].Resources.Requests.Cpu().String()).To(Equal("500m"))
+		Expect(large.Spec.Containers[0].Resources.Requests.Memory().String()).To(Equal("8Gi"))
+		Expect(large.Spec.Containers[0].Resources.Requests.Cpu().String()).To(Equal("4"))
+		Expect(large.Annotations).To(HaveKey(sizing.StatusAnnotation))
+	})
+
+	It("leaves pods that did not opt in alone", func(ctx SpecContext) {
+		pod := fixtures.PinToNode(fixtures.PodWithContainers(corev1.Container{
+			Name:  "other",
+			Image: "registry.k8s.io/pause:3.9",
+			Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+				corev1.ResourceMemory: resource.MustParse("100Mi"),
+			}},
+		}), "large")
+		pod.Name = "other"
+		pod.Namespace = "default"
+		pod.Annotations = map[string]string{"node-specific-sizing.manomano.tech/request-memory-fraction": "0.125"}
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+
+		Expect(pod.Spec.Containers[0].Resources.Requests.Memory().String()).To(Equal("100Mi"))
+	})
+})
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"sync"
+	"time"
+)
+
+// Event reasons
+const (
+	reasonSized        = "NodeSpecificSizing"
+	reasonSizingFailed = "NodeSpecificSizingFailed"
+	reasonVPAConflict  = "NodeSpecificSizingVPAConflict"
+)
+
+// pendingEventTTL bounds how long a decision waits for its pod to show up in the informer
+const pendingEventTTL = time.Minute
+
+// pendingKey identifies a pod under admission. Pods created by controllers have no name yet, only a generateName.
+type pendingKey struct {
+	namespace string
+	name      string
+	node      string
+}
+
+type pendingEvent struct {
+	at        time.Time
+	eventType string
+	reason    string
+	message   string
+}
+
+// sizingEvents records Events describing sizing decisions. Pods have neither name nor UID during admission,
+// so decisions are kept until the pod shows up in the pod informer, which is when its Event can be recorded.
+// Sizing failures deny pod creation, hence they are recorded on the owner of the pod instead.
+type sizingEvents struct {
+	recorder record.EventRecorder
+	// ownerEvents also records decisions on the owner of the pod, e.g. its DaemonSet
+	ownerEvents bool
+	// nodeResolvers must be those the pod was sized with, all built-in resolvers when nil
+	nodeResolvers sizing.NodeResolverChain
+
+	mu      sync.Mutex
+	pending map[pendingKey]pendingEvent
+}
+
+var _ toolscache.ResourceEventHandler = &sizingEvents{}
+
+func newSizingEvents(recorder record.EventRecorder, ownerEvents bool) *sizingEvents {
+	return &sizingEvents{
+		recorder:    recorder,
+		ownerEvents: ownerEvents,
+		pending:     make(map[pendingKey]pendingEvent),
+	}
+}
+
+// sized records a sizing decision for pod
+func (se *sizingEvents) sized(pod *corev1.Pod, result *sizing.Result) {
+	message := result.Summary()
+	// Pods a VerticalPodAutoscaler also sizes deserve attention, whatever was done about it
+	eventType, reason := corev1.EventTypeNormal, reasonSized
+	if result.VPAConflict() != "" {
+		eventType, reason = corev1.EventTypeWarning, reasonVPAConflict
+	}
+	if se.ownerEvents {
+		if owner := ownerReference(pod); owner != nil {
+			se.recorder.Event(owner, eventType, reason, message)
+		}
+	}
+
+	now := time.Now()
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	se.expire(now)
+	se.pending[pendingKeyOf(pod, result.NodeName())] = pendingEvent{at: now, eventType: eventType, reason: reason, message: message}
+}
+
+// failed records a sizing failure for pod
+func (se *sizingEvents) failed(pod *corev1.Pod, err error) {
+	if owner := ownerReference(pod); owner != nil {
+		se.recorder.Eventf(owner, corev1.EventTypeWarning, reasonSizingFailed, "Could not size pod: %v", err)
+	}
+}
+
+func (se *sizingEvents) OnAdd(obj interface{}, _ bool) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	nodeName, err := se.nodeResolvers.Resolve(context.Background(), pod)
+	if err != nil {
+		return
+	}
+
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	// Pods created with a generateName get their name after admission
+	for _, name := range []string{pod.Name, pod.GenerateName} {
+		key := pendingKey{namespace: pod.Namespace, name: name, node: nodeName}
+		if event, ok := se.pending[key]; ok {
+			delete(se.pending, key)
+			se.recorder.Event(pod, event.eventType, event.reason, event.message)
+			return
+		}
+	}
+}
+
+func (se *sizingEvents) OnUpdate(interface{}, interface{}) {}
+
+func (se *sizingEvents) OnDelete(interface{}) {}
+
+// expire forgets decisions for pods that never got created, e.g. because another webhook denied them
+func (se *sizingEvents) expire(now time.Time) {
+	for key, event := range se.pending {
+		if now.Sub(event.at) > pendingEventTTL {
+			delete(se.pending, key)
+		}
+	}
+}
+
+func pendingKeyOf(pod *corev1.Pod, nodeName string) pendingKey {
+	return pendingKey{namespace: pod.Namespace, name: cmp.Or(pod.Name, pod.GenerateName), node: nodeName}
+}
+
+func ownerReference(pod *corev1.Pod) *corev1.ObjectReference {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return nil
+	}
+	return &corev1.ObjectReference{
+		APIVersion: owner.APIVersion,
+		Kind:       owner.Kind,
+		Namespace:  pod.Namespace,
+		Name:       owner.Name,
+		UID:        owner.UID,
+	}
+}
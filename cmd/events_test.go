@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Recording sizing events", Label("Events"), func() {
+	var (
+		recorder *record.FakeRecorder
+		events   *sizingEvents
+		pod      *corev1.Pod
+		result   *sizing.Result
+	)
+
+	BeforeEach(func() {
+		recorder = record.NewFakeRecorder(10)
+		events = newSizingEvents(recorder, false)
+
+		pod = fixtures.PinToNode(fixtures.PodWithContainers(fixtures.ContainerWithResources("a", nil, nil), fixtures.ContainerWithResources("b", nil, nil)), "node-a")
+		pod.Namespace = "default"
+		pod.GenerateName = "agent-"
+		pod.Annotations = map[string]string{"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.1"}
+		pod.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: "apps/v1",
+			Kind:       "DaemonSet",
+			Name:       "agent",
+			UID:        "ds-uid",
+			Controller: ptr.To(true),
+		}}
+
+		node := fixtures.NodeWithCapacity("4", "8G")
+		node.Name = "node-a"
+		var err error
+		result, err = sizing.New(fake.NewClientBuilder().WithObjects(node).Build(), sizing.Options{}).Size(context.Background(), pod)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("records decisions on the pod once it is created", func() {
+		events.sized(pod, result)
+		Expect(recorder.Events).To(BeEmpty())
+
+		created := pod.DeepCopy()
+		created.Name = "agent-x7k2p"
+		events.OnAdd(created, false)
+		Expect(recorder.Events).To(Receive(HavePrefix("Normal NodeSpecificSizing Sized for node node-a")))
+		Expect(events.pending).To(BeEmpty())
+	})
+
+	It("ignores pods it did not size", func() {
+		other := fixtures.PinToNode(fixtures.PodWithContainers(), "node-b")
+		other.Namespace = "default"
+		other.GenerateName = "agent-"
+		events.sized(pod, result)
+		events.OnAdd(other, false)
+		Expect(recorder.Events).To(BeEmpty())
+	})
+
+	It("records decisions on the owner when asked to", func() {
+		events.ownerEvents = true
+		events.sized(pod, result)
+		Expect(recorder.Events).To(Receive(HavePrefix("Normal NodeSpecificSizing")))
+	})
+
+	It("records decisions on pods a VerticalPodAutoscaler also sizes as warnings", func() {
+		vpa := &unstructured.Unstructured{Object: map[string]any{"spec": map[string]any{
+			"targetRef": map[string]any{"apiVersion": "apps/v1", "kind": "DaemonSet", "name": "agent"},
+		}}}
+		vpa.SetGroupVersionKind(sizing.VerticalPodAutoscalerGVK)
+		vpa.SetNamespace("default")
+		vpa.SetName("agent")
+		mapper := meta.NewDefaultRESTMapper(nil)
+		mapper.Add(sizing.VerticalPodAutoscalerGVK, meta.RESTScopeNamespace)
+		node := fixtures.NodeWithCapacity("4", "8G")
+		node.Name = "node-a"
+		sizer := sizing.New(fake.NewClientBuilder().WithObjects(node).Build(), sizing.Options{
+			VPAReader: fake.NewClientBuilder().WithRESTMapper(mapper).WithObjects(vpa).Build(),
+			VPAs:      sizing.VPAWarn,
+		})
+		result, err := sizer.Size(context.Background(), pod)
+		Expect(err).NotTo(HaveOccurred())
+
+		events.ownerEvents = true
+		events.sized(pod, result)
+		Expect(recorder.Events).To(Receive(And(
+			HavePrefix("Warning NodeSpecificSizingVPAConflict Sized for node node-a"),
+			HaveSuffix("VerticalPodAutoscaler agent also sizes DaemonSet agent in Auto mode"))))
+	})
+
+	It("records failures on the owner", func() {
+		events.failed(pod, errors.New("cannot find data for node 'node-a'"))
+		Expect(recorder.Events).To(Receive(Equal("Warning NodeSpecificSizingFailed Could not size pod: cannot find data for node 'node-a'")))
+	})
+})
@@ -0,0 +1,181 @@
+// Command knss helps understanding sizing decisions without deploying the webhook, e.g. to find out why a DaemonSet
+// got given values on a given node.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/apis/v1alpha1"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	"io"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const usage = `Usage: knss <command> [flags]
+
+Commands:
+  simulate   Print the JSONPatch the webhook would apply to a pod on a given node
+  manifests  Print the manifests installing the webhook, for a given namespace, image and certificate mode
+
+Run knss <command> -h for the flags of a command.
+`
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = v1alpha1.AddToScheme(scheme)
+}
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "knss:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		_, _ = fmt.Fprint(stderr, usage)
+		return errors.New("missing command")
+	}
+	switch args[0] {
+	case "simulate":
+		return simulate(ctx, args[1:], stdin, stdout, stderr)
+	case "manifests":
+		return manifests(args[1:], stdout, stderr)
+	case "help", "-h", "--help":
+		_, _ = fmt.Fprint(stdout, usage)
+		return nil
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// simulate sizes a pod, or the pod template of a workload, for a node read from the cluster or from a file
+func simulate(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	podFile := flags.String("f", "", "Pod, or DaemonSet, Deployment, StatefulSet or ReplicaSet, as YAML or JSON. - reads stdin.")
+	nodeName := flags.String("node", "", "Node to size for, read from the cluster of the current kubeconfig context, along with namespace defaults.")
+	nodeFile := flags.String("node-file", "", "Node to size for, as YAML or JSON, instead of reading it from a cluster.")
+	policies := flags.Bool("policies", false, "Apply the SizingPolicies of the cluster, which requires their CRD. Only with --node.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *podFile == "" || (*nodeName == "") == (*nodeFile == "") {
+		flags.Usage()
+		return errors.New("-f and exactly one of --node or --node-file are required")
+	}
+
+	obj, err := decodeFile(*podFile, stdin)
+	if err != nil {
+		return err
+	}
+	pod, err := podOf(obj)
+	if err != nil {
+		return err
+	}
+
+	var nodeReader client.Reader
+	options := sizing.Options{}
+	if *nodeFile != "" {
+		obj, err := decodeFile(*nodeFile, stdin)
+		if err != nil {
+			return err
+		}
+		node, ok := obj.(*corev1.Node)
+		if !ok {
+			return fmt.Errorf("%s holds a %T, not a node", *nodeFile, obj)
+		}
+		*nodeName = node.Name
+		nodeReader = fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	} else {
+		cfg, err := config.GetConfig()
+		if err != nil {
+			return err
+		}
+		cluster, err := client.New(cfg, client.Options{Scheme: scheme})
+		if err != nil {
+			return err
+		}
+		nodeReader = cluster
+		options.NamespaceReader = cluster
+		if *policies {
+			options.PolicyReader = cluster
+		}
+	}
+
+	// The pod is sized for the given node, whatever it would have been bound to otherwise
+	pod.Spec.NodeName = *nodeName
+	if options.NodeResolvers, err = sizing.ParseNodeResolvers("node-name", ""); err != nil {
+		return err
+	}
+
+	result, patch, err := sizing.New(nodeReader, options).CreatePatch(ctx, pod, false)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(stderr, result.Summary())
+	if patch == nil {
+		_, _ = fmt.Fprintln(stdout, "[]")
+		return nil
+	}
+	rendered, err := json.MarshalIndent(patch, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(stdout, string(rendered))
+	return err
+}
+
+func decodeFile(path string, stdin io.Reader) (runtime.Object, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	obj, _, err := serializer.NewCodecFactory(scheme).UniversalDeserializer().Decode(data, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode %s: %w", path, err)
+	}
+	return obj, nil
+}
+
+// podOf returns the pod, or a pod made out of the template of a workload
+func podOf(obj runtime.Object) (*corev1.Pod, error) {
+	var template corev1.PodTemplateSpec
+	var namespace string
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		return o, nil
+	case *appsv1.DaemonSet:
+		template, namespace = o.Spec.Template, o.Namespace
+	case *appsv1.Deployment:
+		template, namespace = o.Spec.Template, o.Namespace
+	case *appsv1.StatefulSet:
+		template, namespace = o.Spec.Template, o.Namespace
+	case *appsv1.ReplicaSet:
+		template, namespace = o.Spec.Template, o.Namespace
+	default:
+		return nil, fmt.Errorf("cannot size a %T", obj)
+	}
+	pod := &corev1.Pod{ObjectMeta: template.ObjectMeta, Spec: template.Spec}
+	pod.Namespace = namespace
+	return pod, nil
+}
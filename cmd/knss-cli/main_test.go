@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var _ = Describe("Simulating sizing", Label("Simulate"), func() {
+	const node = `
+apiVersion: v1
+kind: Node
+metadata:
+  name: node-a
+status:
+  capacity:
+    cpu: "4"
+    memory: 8Gi
+`
+	const daemonSet = `
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+  namespace: monitoring
+spec:
+  selector:
+    matchLabels: {app: agent}
+  template:
+    metadata:
+      labels: {app: agent}
+      annotations:
+        node-specific-sizing.manomano.tech/request-memory-fraction: "0.125"
+    spec:
+      containers:
+        - name: agent
+          resources:
+            requests: {memory: 100Mi}
+`
+	var dir string
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(dir, "node.yaml"), []byte(node), 0o600)).To(Succeed())
+	})
+
+	It("prints the patch for the pod template of a workload", func(ctx SpecContext) {
+		var stdout, stderr bytes.Buffer
+		err := run(ctx, []string{"simulate", "-f", "-", "--node-file", filepath.Join(dir, "node.yaml")},
+			strings.NewReader(daemonSet), &stdout, &stderr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stdout.String()).To(ContainSubstring(`"path": "/spec/containers/0/resources/requests/memory"`))
+		Expect(stdout.String()).To(ContainSubstring(`"value": "1Gi"`))
+		Expect(stderr.String()).To(HavePrefix("Sized for node node-a"))
+	})
+
+	It("requires a single node", func(ctx SpecContext) {
+		var stdout, stderr bytes.Buffer
+		err := run(ctx, []string{"simulate", "-f", "-"}, strings.NewReader(daemonSet), &stdout, &stderr)
+		Expect(err).To(MatchError(ContainSubstring("exactly one of --node or --node-file")))
+	})
+})
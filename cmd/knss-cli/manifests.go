@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/deploy"
+	"io"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+	"slices"
+	"strings"
+)
+
+const (
+	// certModeCertManager has cert-manager issue the serving certificate and inject its CA
+	certModeCertManager = "cert-manager"
+	// certModeSelfSigned has the webhook generate its certificate on start, see --self-signed-certs
+	certModeSelfSigned = "self-signed"
+
+	// webhookContainer is the container of the webhook in its Deployment
+	webhookContainer = "node-specific-sizing"
+	// certVolume is the volume of the Deployment holding the serving certificate
+	certVolume = "cert"
+	// injectCAAnnotation tells cert-manager which certificate to inject the CA of into webhook configurations
+	injectCAAnnotation = "cert-manager.io/inject-ca-from"
+)
+
+// clusterScopedKinds are the kinds of the manifests that have no namespace
+var clusterScopedKinds = []string{"ClusterRole", "ClusterRoleBinding", "CustomResourceDefinition",
+	"MutatingWebhookConfiguration", "ValidatingWebhookConfiguration"}
+
+// manifestOptions tell how to render the manifests
+type manifestOptions struct {
+	namespace string
+	image     string
+	certMode  string
+}
+
+// manifests prints the manifests kustomization.yaml lists, in its order, adapted to the flags. They render the same
+// for the same flags, so that CI can diff them.
+func manifests(args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("manifests", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var options manifestOptions
+	flags.StringVar(&options.namespace, "namespace", "kube-system", "Namespace the webhook is installed in.")
+	flags.StringVar(&options.image, "image", "node-specific-sizing:latest", "Image of the webhook.")
+	flags.StringVar(&options.certMode, "cert-mode", certModeCertManager, "Who issues the serving certificate: cert-manager, or self-signed for the webhook itself.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if options.certMode != certModeCertManager && options.certMode != certModeSelfSigned {
+		flags.Usage()
+		return fmt.Errorf("unknown --cert-mode %q, expected cert-manager or self-signed", options.certMode)
+	}
+	if options.namespace == "" || options.image == "" {
+		flags.Usage()
+		return errors.New("--namespace and --image cannot be empty")
+	}
+
+	objects, err := renderManifests(options)
+	if err != nil {
+		return err
+	}
+	for i, obj := range objects {
+		rendered, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			_, _ = fmt.Fprintln(stdout, "---")
+		}
+		if _, err := stdout.Write(rendered); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderManifests reads the manifests kustomization.yaml lists and adapts them to options
+func renderManifests(options manifestOptions) ([]*unstructured.Unstructured, error) {
+	raw, err := deploy.Files.ReadFile("kustomization.yaml")
+	if err != nil {
+		return nil, err
+	}
+	var kustomization struct {
+		Namespace string   `json:"namespace"`
+		Resources []string `json:"resources"`
+	}
+	if err := yaml.Unmarshal(raw, &kustomization); err != nil {
+		return nil, fmt.Errorf("could not read kustomization.yaml: %w", err)
+	}
+
+	var result []*unstructured.Unstructured
+	for _, path := range kustomization.Resources {
+		raw, err := deploy.Files.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(raw), 4096)
+		for {
+			obj := &unstructured.Unstructured{}
+			if err := decoder.Decode(&obj.Object); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("could not decode %s: %w", path, err)
+			}
+			if len(obj.Object) == 0 {
+				continue
+			}
+			// The webhook issues its own certificate, cert-manager is not needed
+			if options.certMode == certModeSelfSigned && strings.HasPrefix(obj.GetAPIVersion(), "cert-manager.io/") {
+				continue
+			}
+			if err := adaptManifest(obj, kustomization.Namespace, options); err != nil {
+				return nil, fmt.Errorf("could not render %s %s: %w", obj.GetKind(), obj.GetName(), err)
+			}
+			result = append(result, obj)
+		}
+	}
+	return result, nil
+}
+
+// adaptManifest moves obj from the namespace of kustomization.yaml to the one of options, and applies the image and
+// certificate mode of options
+func adaptManifest(obj *unstructured.Unstructured, kustomizationNamespace string, options manifestOptions) error {
+	if !slices.Contains(clusterScopedKinds, obj.GetKind()) {
+		obj.SetNamespace(options.namespace)
+	}
+	switch obj.GetKind() {
+	case "ClusterRoleBinding", "RoleBinding":
+		return updateList(obj.Object, []string{"subjects"}, func(subject map[string]any) error {
+			if subject["kind"] == "ServiceAccount" {
+				subject["namespace"] = options.namespace
+			}
+			return nil
+		})
+	case "MutatingWebhookConfiguration", "ValidatingWebhookConfiguration":
+		annotations := obj.GetAnnotations()
+		if from, ok := annotations[injectCAAnnotation]; ok {
+			if options.certMode == certModeSelfSigned {
+				delete(annotations, injectCAAnnotation)
+			} else {
+				_, name, _ := strings.Cut(from, "/")
+				annotations[injectCAAnnotation] = options.namespace + "/" + name
+			}
+			obj.SetAnnotations(annotations)
+		}
+		return updateList(obj.Object, []string{"webhooks"}, func(webhook map[string]any) error {
+			return unstructured.SetNestedField(webhook, options.namespace, "clientConfig", "service", "namespace")
+		})
+	case "Certificate":
+		names, _, err := unstructured.NestedStringSlice(obj.Object, "spec", "dnsNames")
+		if err != nil || len(names) == 0 {
+			return err
+		}
+		for i, name := range names {
+			names[i] = strings.Replace(name, "."+kustomizationNamespace+".", "."+options.namespace+".", 1)
+		}
+		return unstructured.SetNestedStringSlice(obj.Object, names, "spec", "dnsNames")
+	case "Deployment":
+		return adaptDeployment(obj, options)
+	}
+	return nil
+}
+
+// adaptDeployment sets the image of the webhook, and has it issue its own certificate in the self-signed mode, in a
+// writable volume
+func adaptDeployment(obj *unstructured.Unstructured, options manifestOptions) error {
+	err := updateList(obj.Object, []string{"spec", "template", "spec", "containers"}, func(container map[string]any) error {
+		if container["name"] != webhookContainer {
+			return nil
+		}
+		container["image"] = options.image
+		if options.certMode != certModeSelfSigned {
+			return nil
+		}
+		args, _, err := unstructured.NestedStringSlice(container, "args")
+		if err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedStringSlice(container, append(args, "--self-signed-certs"), "args"); err != nil {
+			return err
+		}
+		return updateList(container, []string{"volumeMounts"}, func(mount map[string]any) error {
+			if mount["name"] == certVolume {
+				delete(mount, "readOnly")
+			}
+			return nil
+		})
+	})
+	if err != nil || options.certMode != certModeSelfSigned {
+		return err
+	}
+	return updateList(obj.Object, []string{"spec", "template", "spec", "volumes"}, func(volume map[string]any) error {
+		if volume["name"] == certVolume {
+			delete(volume, "secret")
+			volume["emptyDir"] = map[string]any{}
+		}
+		return nil
+	})
+}
+
+// updateList calls update on every object of the list found at path in obj, if any
+func updateList(obj map[string]any, path []string, update func(map[string]any) error) error {
+	items, found, err := unstructured.NestedSlice(obj, path...)
+	if err != nil || !found {
+		return err
+	}
+	for _, item := range items {
+		if item, ok := item.(map[string]any); ok {
+			if err := update(item); err != nil {
+				return err
+			}
+		}
+	}
+	return unstructured.SetNestedSlice(obj, items, path...)
+}
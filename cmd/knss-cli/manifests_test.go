@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var _ = Describe("Rendering manifests", Label("Manifests"), func() {
+	find := func(objects []*unstructured.Unstructured, kind string) []*unstructured.Unstructured {
+		var result []*unstructured.Unstructured
+		for _, obj := range objects {
+			if obj.GetKind() == kind {
+				result = append(result, obj)
+			}
+		}
+		return result
+	}
+
+	It("moves every namespaced manifest and reference to the namespace", func() {
+		objects, err := renderManifests(manifestOptions{namespace: "sizing", image: "example.com/knss:1.0", certMode: certModeCertManager})
+		Expect(err).NotTo(HaveOccurred())
+		for _, obj := range objects {
+			if obj.GetNamespace() != "" {
+				Expect(obj.GetNamespace()).To(Equal("sizing"), "%s %s", obj.GetKind(), obj.GetName())
+			}
+		}
+		Expect(find(objects, "Service")[0].GetNamespace()).To(Equal("sizing"))
+		Expect(find(objects, "ClusterRole")[0].GetNamespace()).To(BeEmpty())
+
+		subjects, _, _ := unstructured.NestedSlice(find(objects, "ClusterRoleBinding")[0].Object, "subjects")
+		Expect(subjects[0]).To(HaveKeyWithValue("namespace", "sizing"))
+		mutating := find(objects, "MutatingWebhookConfiguration")[0]
+		Expect(mutating.GetAnnotations()).To(HaveKeyWithValue("cert-manager.io/inject-ca-from", "sizing/node-specific-sizing-client-cert"))
+		webhooks, _, _ := unstructured.NestedSlice(mutating.Object, "webhooks")
+		namespace, _, _ := unstructured.NestedString(webhooks[0].(map[string]any), "clientConfig", "service", "namespace")
+		Expect(namespace).To(Equal("sizing"))
+		Expect(find(objects, "Certificate")).To(ContainElement(WithTransform(func(obj *unstructured.Unstructured) []string {
+			names, _, _ := unstructured.NestedStringSlice(obj.Object, "spec", "dnsNames")
+			return names
+		}, ContainElement("node-specific-sizing.sizing.svc"))))
+
+		containers, _, _ := unstructured.NestedSlice(find(objects, "Deployment")[0].Object, "spec", "template", "spec", "containers")
+		Expect(containers[0]).To(HaveKeyWithValue("image", "example.com/knss:1.0"))
+		Expect(containers[0]).NotTo(HaveKey("args"))
+	})
+
+	It("leaves cert-manager out in the self-signed mode", func() {
+		objects, err := renderManifests(manifestOptions{namespace: "kube-system", image: "knss", certMode: certModeSelfSigned})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(find(objects, "Certificate")).To(BeEmpty())
+		Expect(find(objects, "Issuer")).To(BeEmpty())
+		Expect(find(objects, "ValidatingWebhookConfiguration")[0].GetAnnotations()).NotTo(HaveKey("cert-manager.io/inject-ca-from"))
+
+		deployment := find(objects, "Deployment")[0]
+		containers, _, _ := unstructured.NestedSlice(deployment.Object, "spec", "template", "spec", "containers")
+		args, _, _ := unstructured.NestedStringSlice(containers[0].(map[string]any), "args")
+		Expect(args).To(Equal([]string{"--self-signed-certs"}))
+		volumes, _, _ := unstructured.NestedSlice(deployment.Object, "spec", "template", "spec", "volumes")
+		Expect(volumes[0]).To(And(HaveKey("emptyDir"), Not(HaveKey("secret"))))
+	})
+
+	It("renders the same manifests every time", func(ctx SpecContext) {
+		var first, second, stderr bytes.Buffer
+		Expect(run(ctx, []string{"manifests", "--namespace", "sizing"}, nil, &first, &stderr)).To(Succeed())
+		Expect(run(ctx, []string{"manifests", "--namespace", "sizing"}, nil, &second, &stderr)).To(Succeed())
+		Expect(first.String()).To(Equal(second.String()))
+		Expect(first.String()).To(ContainSubstring("\n---\napiVersion: cert-manager.io/v1\nkind: Issuer\n"))
+	})
+
+	It("refuses unknown certificate modes", func(ctx SpecContext) {
+		var stdout, stderr bytes.Buffer
+		Expect(run(ctx, []string{"manifests", "--cert-mode", "vault"}, nil, &stdout, &stderr)).To(MatchError(ContainSubstring("unknown --cert-mode")))
+	})
+})
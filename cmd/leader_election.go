@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// leaderElectionID names the Lease replicas compete for when --leader-elect is set
+const leaderElectionID = "node-specific-sizing.manomano.tech"
+
+// everyReplica is a runnable that runs on every replica, leader or not, like the webhook server and caches do
+type everyReplica func(ctx context.Context) error
+
+var _ manager.LeaderElectionRunnable = everyReplica(nil)
+
+func (r everyReplica) Start(ctx context.Context) error {
+	return r(ctx)
+}
+
+func (r everyReplica) NeedLeaderElection() bool {
+	return false
+}
+
+// leaderOnly is a runnable that only runs on the elected leader, or on every replica without --leader-elect
+func leaderOnly(start func(ctx context.Context) error) manager.Runnable {
+	return manager.RunnableFunc(start)
+}
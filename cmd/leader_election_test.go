@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+var _ = Describe("Running under leader election", Label("LeaderElection"), func() {
+	noop := func(context.Context) error { return nil }
+
+	It("runs on every replica what serves admission requests", func() {
+		Expect(everyReplica(noop).NeedLeaderElection()).To(BeFalse())
+	})
+
+	It("leaves the rest to the leader", func() {
+		// The manager treats runnables that don't tell as needing leader election
+		_, tells := leaderOnly(noop).(manager.LeaderElectionRunnable)
+		Expect(tells).To(BeFalse())
+	})
+})
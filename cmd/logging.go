@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+// logLevel is the level of the global logger, which SIGUSR1 switches to debug and back
+var logLevel = zap.NewAtomicLevel()
+
+// samplingFromEnv returns how log entries are sampled: of the entries with the same level and message logged within a
+// second, the first LOG_SAMPLING_INITIAL are written, then every LOG_SAMPLING_THEREAFTER-th. Either defaults to what
+// sampling sets, and an initial count of 0 turns sampling off.
+func samplingFromEnv(sampling *zap.SamplingConfig) (*zap.SamplingConfig, error) {
+	result := &zap.SamplingConfig{Initial: 100, Thereafter: 100}
+	if sampling != nil {
+		result.Initial, result.Thereafter = sampling.Initial, sampling.Thereafter
+	}
+	for name, count := range map[string]*int{"LOG_SAMPLING_INITIAL": &result.Initial, "LOG_SAMPLING_THEREAFTER": &result.Thereafter} {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("%s must be a non-negative integer, got %q", name, value)
+		}
+		*count = parsed
+	}
+	if result.Initial == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// toggleDebugOnSignal switches level to debug on SIGUSR1, and back to the level it had on the next one, so that debug
+// logs of a busy replica are only written while chasing an issue, which restarting it would lose
+func toggleDebugOnSignal(ctx context.Context, level zap.AtomicLevel) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	// Replicas logging at debug level already switch to info
+	back := level.Level()
+	if back == zapcore.DebugLevel {
+		back = zapcore.InfoLevel
+	}
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				if level.Level() == zapcore.DebugLevel {
+					level.SetLevel(back)
+				} else {
+					level.SetLevel(zapcore.DebugLevel)
+				}
+				zap.L().Info("Switched log level", zap.Stringer("level", level.Level()))
+			}
+		}
+	}()
+}
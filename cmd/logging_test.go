@@ -0,0 +1,46 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"os"
+	"syscall"
+)
+
+var _ = Describe("Logging", Label("Logging"), func() {
+	setenv := func(name, value string) {
+		Expect(os.Setenv(name, value)).To(Succeed())
+		DeferCleanup(os.Unsetenv, name)
+	}
+
+	It("samples like zap does by default", func() {
+		sampling, err := samplingFromEnv(zap.NewProductionConfig().Sampling)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sampling).To(Equal(&zap.SamplingConfig{Initial: 100, Thereafter: 100}))
+	})
+
+	It("reads sampling from the environment", func() {
+		setenv("LOG_SAMPLING_THEREAFTER", "1000")
+		sampling, err := samplingFromEnv(zap.NewProductionConfig().Sampling)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sampling).To(Equal(&zap.SamplingConfig{Initial: 100, Thereafter: 1000}))
+
+		setenv("LOG_SAMPLING_INITIAL", "0")
+		Expect(samplingFromEnv(nil)).To(BeNil())
+
+		setenv("LOG_SAMPLING_INITIAL", "some")
+		_, err = samplingFromEnv(nil)
+		Expect(err).To(MatchError(ContainSubstring("LOG_SAMPLING_INITIAL must be a non-negative integer")))
+	})
+
+	It("switches to debug and back on SIGUSR1", func(ctx SpecContext) {
+		level := zap.NewAtomicLevelAt(zapcore.WarnLevel)
+		toggleDebugOnSignal(ctx, level)
+		Expect(syscall.Kill(os.Getpid(), syscall.SIGUSR1)).To(Succeed())
+		Eventually(level.Level).Should(Equal(zapcore.DebugLevel))
+		Expect(syscall.Kill(os.Getpid(), syscall.SIGUSR1)).To(Succeed())
+		Eventually(level.Level).Should(Equal(zapcore.WarnLevel))
+	})
+})
@@ -3,27 +3,105 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/apis/v1alpha1"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/go-logr/zapr"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"go.uber.org/zap/zapio"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 	"syscall"
+	"time"
 )
 
 var (
-	globalClient                 client.Client
 	port                         int
 	certFile, keyFile, caCrtFile string
+	clientCaFile                 string
+	requireClientCert            bool
+	selfSignedCertificates       bool
+	selfSignedSecret             string
+	webhookService               string
+	webhookConfiguration         string
+	tracing                      bool
+	decisionCount                int
+	auditLogPath                 string
+	auditLogMaxBytes             int64
+	auditLogMaxBackups           int
+	decisionSinkURL              string
+	decisionSinkQueueSize        int
+	decisionSinkRetries          int
+	tracingSampleRatio           float64
+	usageFloorPercentile         float64
+	usageWindow, usageInterval   time.Duration
+	ownerEvents                  bool
+	annotationConflicts          string
+	dryRun                       bool
+	metricsBindAddress           string
+	verifySizing                 bool
+	reportWorkloadStatus         bool
+	nodeResolvers                string
+	externalNodeResolverURL      string
+	shards, shardIndex           int
+	shardBy, shardNodeLabel      string
+	shardPeerURL                 string
+	deductCommitted              bool
+	deductPodOverhead            bool
+	resourceQuotas               string
+	verticalPodAutoscalers       string
+	fallbackRequests             string
+	nodeCandidates               string
+	sizeOnceBound                bool
+	reconcileInterval            time.Duration
+	evictDaemonSetPods           bool
+	evictionRate                 float64
+	resultCacheSize              int
+	mutatePaths                  string
+	validatePaths                string
+	bindPaths                    string
+	configFile                   string
+	failureModeName              string
+	annotationDomain             string
+	excludedNamespaces           string
+	namespaceAllowlist           string
+	namespaceDenylist            string
+	requireEnabledLabel          bool
+	leaderElect                  bool
+	leaderElectionNamespace      string
+	shutdownTimeout              time.Duration
+	webhookTimeout               time.Duration
+	maxRequestBytes              int64
+	readTimeout, writeTimeout    time.Duration
+	maxInFlight                  int
+	maxRequestRate               float64
+	requestBurst                 int
+	printAnnotationSchema        bool
 )
 
 type teardownFn func()
@@ -32,20 +110,29 @@ func setupLogger() teardownFn {
 	// Setup logger
 	var logger *zap.Logger
 	if _, inDebug := os.LookupEnv("LOG_DEVEL"); inDebug {
-		logger, _ = zap.NewDevelopment()
+		loggerConfig := zap.NewDevelopmentConfig()
+		logLevel.SetLevel(loggerConfig.Level.Level())
+		loggerConfig.Level = logLevel
+		logger, _ = loggerConfig.Build()
 	} else {
 		loggerConfig := zap.NewProductionConfig()
 		loggerConfig.EncoderConfig.TimeKey = "time"
 		loggerConfig.EncoderConfig.MessageKey = "message"
 		loggerConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-		loggerConfig.Level = zap.NewAtomicLevel()
+		loggerConfig.Level = logLevel
 		levelFromEnv := os.Getenv("LOG_LEVEL")
 		if err := loggerConfig.Level.UnmarshalText([]byte(levelFromEnv)); err != nil {
 			panic(fmt.Errorf("could not parse log level %s: %w", levelFromEnv, err))
 		}
+		sampling, err := samplingFromEnv(loggerConfig.Sampling)
+		if err != nil {
+			panic(err)
+		}
+		loggerConfig.Sampling = sampling
 		logger, _ = loggerConfig.Build()
 	}
 	zap.ReplaceGlobals(logger)
+	ctrllog.SetLogger(zapr.NewLogger(logger.Named("controller-runtime")))
 
 	// Set Zap as default logger for some internal Go services
 	zapWriter := &zapio.Writer{Log: logger.WithOptions(zap.AddStacktrace(zap.InfoLevel)).Named("go"), Level: zap.InfoLevel}
@@ -70,34 +157,25 @@ func main() {
 	if err != nil {
 		zap.L().Fatal("Could not add to scheme", zap.Error(err))
 	}
-
-	ourCache, err := cache.New(config.GetConfigOrDie(), cache.Options{ByObject: map[client.Object]cache.ByObject{&corev1.Node{}: {}}})
+	err = metricsv1beta1.AddToScheme(scheme)
 	if err != nil {
-		zap.L().Fatal("Could not create our cache", zap.Error(err))
+		zap.L().Fatal("Could not add to scheme", zap.Error(err))
 	}
-
-	cacheCtx := context.Background()
-
-	go func() {
-		err = ourCache.Start(cacheCtx)
-		if err != nil {
-			zap.L().Fatal("Could not start our cache", zap.Error(err))
-		}
-	}()
-
-	success := ourCache.WaitForCacheSync(context.Background())
-	if !success {
-		zap.L().Warn("Could not warm cached client during initialization")
-	} else {
-		zap.L().Info("Done warming client cache")
+	err = appsv1.AddToScheme(scheme)
+	if err != nil {
+		zap.L().Fatal("Could not add to scheme", zap.Error(err))
 	}
-
-	globalClient, err = client.New(config.GetConfigOrDie(), client.Options{
-		Scheme: scheme,
-		Cache:  &client.CacheOptions{Reader: ourCache},
-	})
+	err = v1alpha1.AddToScheme(scheme)
 	if err != nil {
-		zap.L().Fatal("Failed to create a new client: %v", zap.Error(err))
+		zap.L().Fatal("Could not add to scheme", zap.Error(err))
+	}
+	err = admissionregistrationv1.AddToScheme(scheme)
+	if err != nil {
+		zap.L().Fatal("Could not add to scheme", zap.Error(err))
+	}
+	err = policyv1.AddToScheme(scheme)
+	if err != nil {
+		zap.L().Fatal("Could not add to scheme", zap.Error(err))
 	}
 
 	// init command flags
@@ -105,63 +183,488 @@ func main() {
 	flag.StringVar(&certFile, "tlsCertFile", "/tmp/k8s-webhook-server/serving-certs/tls.crt", "x509 Certificate file.")
 	flag.StringVar(&keyFile, "tlsKeyFile", "/tmp/k8s-webhook-server/serving-certs/tls.key", "x509 private key file.")
 	flag.StringVar(&caCrtFile, "tlsCaFile", "/tmp/k8s-webhook-server/serving-certs/ca.crt", "x509 Certificate file.")
+	flag.Float64Var(&usageFloorPercentile, "usage-floor-percentile", 0, "Keep containers sized above this percentile of their observed usage, from the metrics API. 0 disables.")
+	flag.DurationVar(&usageWindow, "usage-window", time.Hour, "How long observed usage is remembered.")
+	flag.DurationVar(&usageInterval, "usage-sample-interval", time.Minute, "How often observed usage is sampled.")
+	flag.BoolVar(&ownerEvents, "owner-events", false, "Also record sizing Events on the owner of sized pods, e.g. their DaemonSet.")
+	flag.StringVar(&annotationConflicts, "annotation-conflicts", string(sizing.ConflictsIgnore), "What to do when pod annotations, namespace defaults and policies disagree on a setting: ignore, warn or deny.")
+	flag.BoolVar(&dryRun, "dry-run", false, "Compute and report sizing in the status annotation, logs and metrics, without changing pod resources.")
+	flag.StringVar(&metricsBindAddress, "metrics-bind-address", ":8080", "Address the metrics endpoint binds to. 0 disables it.")
+	flag.BoolVar(&verifySizing, "verify-sizing", false, "Check that pods are created with the resources they were sized with, and report mismatches with Events and metrics.")
+	flag.BoolVar(&reportWorkloadStatus, "workload-status", false, "Annotate DaemonSets and Deployments with how many of their pods were sized, and clamped.")
+	flag.StringVar(&nodeResolvers, "node-resolvers", "affinity-match-fields,affinity-match-expressions,node-name,node-selector", "Comma-separated ways of telling which node a pod is bound to, tried in order. external requires --external-node-resolver-url.")
+	flag.StringVar(&externalNodeResolverURL, "external-node-resolver-url", "", "URL of a service the external node resolver posts pods to.")
+	flag.BoolVar(&deductPodOverhead, "deduct-pod-overhead", false, "Take the overhead RuntimeClasses set on pods, e.g. for Kata or gVisor, out of the pod budget.")
+	flag.BoolVar(&deductCommitted, "deduct-committed", false, "Apply fractions to what the requests of other pods leave free on the node, rather than to its capacity. Caches every pod.")
+	flag.IntVar(&shards, "shards", 1, "Number of shards replicas split admission requests and cached nodes into. 1 disables sharding.")
+	flag.IntVar(&shardIndex, "shard-index", -1, "Shard of this replica. -1 reads it from the hostname, as given to StatefulSet pods.")
+	flag.StringVar(&shardBy, "shard-by", string(shardByNode), "What requests are sharded by: namespace or node.")
+	flag.StringVar(&shardNodeLabel, "shard-node-label", "", "Shard nodes by this label, e.g. their node pool, rather than by name.")
+	flag.StringVar(&shardPeerURL, "shard-peer-url", "", "URL requests of other shards are forwarded to, formatted with the shard index, e.g. https://node-specific-sizing-%d.node-specific-sizing-shards.kube-system.svc:8443/mutate-shard")
+	flag.StringVar(&verticalPodAutoscalers, "vertical-pod-autoscalers", string(sizing.VPAIgnore), "What to do with pods a VerticalPodAutoscaler also sizes: ignore without looking them up, warn, skip sizing with a warning, or override it.")
+	flag.StringVar(&resourceQuotas, "resource-quotas", string(sizing.QuotasIgnore), "What to do when sized pods would exceed what ResourceQuotas of their namespace leave: ignore, clamp to what is left, or skip sizing with a warning.")
+	flag.StringVar(&nodeCandidates, "node-candidates", "", "Which node pods are sized for when their node affinity lists several, required or else preferred: smallest, largest or median, or bound to size them once bound to one, which requires Kubernetes 1.33 or later. Such pods fail sizing when empty.")
+	flag.DurationVar(&reconcileInterval, "reconcile-interval", 0, "How often sized pods are sized again with current node data and policies, to report those whose sizing is outdated, or resize or evict them as their policy says. 0 disables it.")
+	flag.BoolVar(&evictDaemonSetPods, "evict-outdated-daemonset-pods", false, "Evict DaemonSet pods whose sizing is outdated, for clusters without in-place resize, whatever their policy says. Requires --reconcile-interval.")
+	flag.Float64Var(&evictionRate, "eviction-rate", 1, "Pods evicted per minute at most because their sizing is outdated. 0 for no limit.")
+	flag.BoolVar(&sizeOnceBound, "size-once-bound", false, "Admit pods whose node is unknown as they are, then size them through the resize subresource once bound to a node. Requires Kubernetes 1.33 or later.")
+	flag.StringVar(&fallbackRequests, "fallback-requests", "", "Pod requests of pods whose node cannot be resolved or read, e.g. cpu=100m,memory=128Mi, rather than failing admission. SizingPolicies may set their own.")
+	flag.StringVar(&mutatePaths, "mutate-paths", "/mutate", "Comma-separated paths pods are sized on, e.g. one per webhook configuration with its own failure policy and timeout.")
+	flag.StringVar(&validatePaths, "validate-paths", "/validate", "Comma-separated paths sizing annotations of pods and workloads are validated on.")
+	flag.StringVar(&bindPaths, "bind-paths", "/bind", "Comma-separated paths pods pending sizing are sized on as they are bound.")
+	flag.IntVar(&resultCacheSize, "result-cache-size", 1024, "Number of sizing results of pods created from a template kept for the next pods of the same template on the same node. 0 disables the cache.")
+	flag.StringVar(&configFile, "config", "", "YAML config file, reloaded on SIGHUP or when it changes. Flags take precedence over it.")
+	flag.StringVar(&failureModeName, "failure-mode", string(failureModeError), "How pods that cannot be sized are answered: error, leaving it to the failurePolicy of the webhook, allow or deny.")
+	flag.StringVar(&annotationDomain, "annotation-domain", "", "Also read sizing annotations of pods and namespaces under this domain, e.g. sizing.example.com.")
+	flag.StringVar(&excludedNamespaces, "excluded-namespaces", "", "Comma-separated namespaces whose pods are never sized.")
+	flag.StringVar(&namespaceAllowlist, "namespace-allowlist", "", "Comma-separated namespaces whose pods alone are sized, whatever the webhook configuration sends. Any namespace when empty.")
+	flag.StringVar(&namespaceDenylist, "namespace-denylist", metav1.NamespaceSystem, "Comma-separated namespaces whose pods are never sized, whatever the webhook configuration sends.")
+	flag.BoolVar(&requireEnabledLabel, "require-enabled-label", true, "Leave pods without the "+sizing.EnabledLabel+"=true label alone, whatever the webhook configuration sends.")
+	flag.BoolVar(&leaderElect, "leader-elect", false, "Elect a leader among replicas, which alone runs what writes to the cluster on its own, such as sizing verification. Every replica serves the webhook.")
+	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "", "Namespace of the leader election lease, that of the webhook when running in a cluster.")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 8*time.Second, "How long in-flight admission requests are given to complete on shutdown. Keep it below the termination grace period of the pod.")
+	flag.Int64Var(&maxRequestBytes, "max-request-bytes", 3<<20, "Largest admission request body accepted, in bytes. The API server itself refuses objects above 3MiB.")
+	flag.DurationVar(&webhookTimeout, "webhook-timeout", defaultWebhookTimeout, "The timeoutSeconds of the mutating webhook configuration. Pods are sized within nine tenths of it, for the API server to get an answer, even an error, before giving up.")
+	flag.DurationVar(&readTimeout, "read-timeout", 10*time.Second, "How long reading an admission request body may take, 0 for no limit.")
+	flag.DurationVar(&writeTimeout, "write-timeout", 10*time.Second, "How long handling an admission request and writing its response may take, 0 for no limit.")
+	flag.IntVar(&maxInFlight, "max-in-flight", 0, "Pod admission requests handled at once, above which requests are refused with a 429. 0 for no limit.")
+	flag.Float64Var(&maxRequestRate, "max-request-rate", 0, "Pod admission requests handled per second, above which requests are refused with a 429. 0 for no limit.")
+	flag.IntVar(&requestBurst, "request-burst", 50, "Pod admission requests handled at once above --max-request-rate, before it applies.")
+	flag.StringVar(&clientCaFile, "tlsClientCaFile", "", "x509 CA bundle verifying the client certificates the API server presents.")
+	flag.BoolVar(&requireClientCert, "require-client-cert", false, "Refuse connections without a client certificate verified by --tlsClientCaFile.")
+	flag.BoolVar(&selfSignedCertificates, "self-signed-certs", false, "Generate a self-signed serving certificate on start, for use without cert-manager, and inject its CA into the webhook configurations.")
+	flag.StringVar(&selfSignedSecret, "self-signed-secret", "node-specific-sizing-cert", "Secret holding the self-signed certificate, in the namespace of the webhook.")
+	flag.StringVar(&webhookService, "webhook-service", "node-specific-sizing", "Service of the webhook, whose names the self-signed certificate is valid for.")
+	flag.StringVar(&webhookConfiguration, "webhook-configuration", "node-specific-sizing", "Mutating and validating webhook configurations the CA of the self-signed certificate is injected into.")
+	flag.IntVar(&decisionCount, "decisions", 100, "Number of recent sizing decisions served as JSON on "+decisionsPath+" of the metrics endpoint. 0 disables it.")
+	flag.StringVar(&auditLogPath, "audit-log", "", "File every mutation is appended to as a JSON line, - for stdout. Disabled when empty.")
+	flag.Int64Var(&auditLogMaxBytes, "audit-log-max-bytes", 100<<20, "Size above which the audit log file is rotated, in bytes. 0 disables rotation.")
+	flag.IntVar(&auditLogMaxBackups, "audit-log-max-backups", 5, "Number of rotated audit log files kept.")
+	flag.StringVar(&decisionSinkURL, "decision-sink-url", "", "URL every sizing decision is posted to as JSON, e.g. for FinOps pipelines. Disabled when empty.")
+	flag.IntVar(&decisionSinkQueueSize, "decision-sink-queue-size", 1000, "Number of sizing decisions waiting to be posted, above which new ones are dropped.")
+	flag.IntVar(&decisionSinkRetries, "decision-sink-retries", 5, "Number of times posting a sizing decision is retried, with an exponential backoff.")
+	flag.BoolVar(&tracing, "tracing", false, "Export traces of admission requests over OTLP, configured by the standard OTEL_EXPORTER_OTLP_* environment variables.")
+	flag.Float64Var(&tracingSampleRatio, "tracing-sample-ratio", 1, "Ratio of admission requests traced, unless the API server already decided to trace them.")
+	flag.BoolVar(&printAnnotationSchema, "print-annotation-schema", false, "Print the JSON Schema of sizing annotations, also served on "+annotationSchemaPath+" of the metrics endpoint, and exit.")
 	flag.Parse()
 
-	certBytes, err := os.ReadFile(certFile)
-	if err != nil {
-		zap.L().Fatal("Failed to read the certificate file: %v", zap.Error(err))
+	if printAnnotationSchema {
+		if err := writeAnnotationSchema(os.Stdout); err != nil {
+			zap.L().Fatal("Could not print the annotation schema", zap.Error(err))
+		}
+		return
 	}
 
-	certKeyBytes, err := os.ReadFile(keyFile)
+	explicitFlags := mapset.NewThreadUnsafeSet[string]()
+	flag.Visit(func(f *flag.Flag) { explicitFlags.Add(f.Name) })
+	var fileCfg *fileConfig
+	if configFile != "" {
+		if fileCfg, err = loadConfigFile(configFile); err != nil {
+			zap.L().Fatal("Invalid --config", zap.Error(err))
+		}
+		fileCfg.applyStartup(explicitFlags)
+	}
+	settings, err := resolveSettings(fileCfg, explicitFlags)
 	if err != nil {
-		zap.L().Fatal("Failed to read the private key file: %v", zap.Error(err))
+		zap.L().Fatal("Invalid settings", zap.Error(err))
+	}
+	if webhookTimeout <= 0 {
+		zap.L().Fatal("Invalid --webhook-timeout, it must be positive", zap.Duration("timeout", webhookTimeout))
+	}
+	quotas, err := sizing.ParseQuotaMode(resourceQuotas)
+	if err != nil {
+		zap.L().Fatal("Invalid --resource-quotas", zap.Error(err))
+	}
+	vpas, err := sizing.ParseVPAMode(verticalPodAutoscalers)
+	if err != nil {
+		zap.L().Fatal("Invalid --vertical-pod-autoscalers", zap.Error(err))
+	}
+	resolvers, err := sizing.ParseNodeResolvers(nodeResolvers, externalNodeResolverURL)
+	if err != nil {
+		zap.L().Fatal("Invalid --node-resolvers", zap.Error(err))
+	}
+	candidates, err := sizing.ParseCandidateChoice(nodeCandidates)
+	if err != nil {
+		zap.L().Fatal("Invalid --node-candidates", zap.Error(err))
+	}
+	fallback, err := parseResourceList(fallbackRequests)
+	if err != nil {
+		zap.L().Fatal("Invalid --fallback-requests", zap.Error(err))
+	}
+	paths, err := parseWebhookPaths(map[string]string{"mutate-paths": mutatePaths, "validate-paths": validatePaths, "bind-paths": bindPaths})
+	if err != nil {
+		zap.L().Fatal("Invalid webhook paths", zap.Error(err))
+	}
+	ring, err := parseShardRing(shardBy, shardIndex, shards, shardNodeLabel)
+	if err != nil {
+		zap.L().Fatal("Invalid sharding", zap.Error(err))
+	}
+
+	nodeCache := cache.ByObject{}
+	if ring != nil {
+		// Nodes of other shards are only kept by name, to keep memory flat as clusters grow
+		nodeCache.Transform = ring.trimNode
+	}
+
+	var certs *selfSignedCerts
+	if selfSignedCertificates {
+		bootstrapClient, err := client.New(config.GetConfigOrDie(), client.Options{Scheme: scheme})
+		if err != nil {
+			zap.L().Fatal("Failed to create a new client", zap.Error(err))
+		}
+		namespace := os.Getenv("POD_NAMESPACE")
+		if namespace == "" {
+			namespace = "kube-system"
+		}
+		certs = newSelfSignedCerts(bootstrapClient, namespace, selfSignedSecret, webhookService, webhookConfiguration)
+		if err := certs.ensure(context.Background(), certFile, keyFile, caCrtFile); err != nil {
+			zap.L().Fatal("Could not bootstrap a self-signed certificate", zap.Error(err))
+		}
 	}
 
-	pair, err := tls.X509KeyPair(certBytes, certKeyBytes)
+	// The watcher reloads the certificate when cert-manager renews it
+	certWatcher, err := certwatcher.New(certFile, keyFile)
 	if err != nil {
 		zap.L().Fatal("Failed to load certificate key pair: %v", zap.Error(err))
 	}
 
-	// XXX find a way for apiserver to present client certificate for mTLS
-	//caCertPool := x509.NewCertPool()
-	//caCertPool.AppendCertsFromPEM(caCrtBytes)
+	tlsOpts := []func(*tls.Config){
+		func(tlsConfig *tls.Config) {
+			tlsConfig.GetCertificate = certWatcher.GetCertificate
+		},
+	}
+	clientAuth, err := clientAuthTLS(clientCaFile, requireClientCert)
+	if err != nil {
+		zap.L().Fatal("Invalid client certificate verification", zap.Error(err))
+	}
+	if clientAuth != nil {
+		tlsOpts = append(tlsOpts, clientAuth)
+	}
+	webhookServer := webhook.NewServer(webhook.Options{Port: port, TLSOpts: tlsOpts})
 
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{pair},
-		//ClientCAs:    caCertPool, // XXX find a way for apiserver to present client certificate for mTLS
-		//ClientAuth:   tls.RequireAndVerifyClientCert, // XXX find a way for apiserver to present client certificate for mTLS
+	// The self check is given its readers once the manager exists, it is only served once the manager starts
+	check := &selfCheck{}
+	metricsOptions := metricsserver.Options{BindAddress: metricsBindAddress, ExtraHandlers: map[string]http.Handler{
+		selfCheckPath:        check,
+		annotationSchemaPath: http.HandlerFunc(serveAnnotationSchema),
+		caBundlePath:         caBundleServer{file: caCrtFile},
+	}}
+	var decisions *decisionLog
+	if decisionCount > 0 {
+		decisions = newDecisionLog(decisionCount)
+		metricsOptions.ExtraHandlers[decisionsPath] = decisions
 	}
 
-	webhookServer := &WebhookServer{
-		server: &http.Server{
-			Addr:      fmt.Sprintf(":%v", port),
-			TLSConfig: tlsConfig,
-		},
+	// Every replica serves the webhook, only the leader runs what writes to the cluster on its own
+	mgr, err := manager.New(config.GetConfigOrDie(), manager.Options{
+		Scheme: scheme,
+		Cache: cache.Options{ByObject: map[client.Object]cache.ByObject{
+			&corev1.Node{}:      nodeCache,
+			&corev1.Namespace{}: {},
+			// Only sized pods are of interest, there's no need to keep all others in memory
+			&corev1.Pod{}: {Label: labels.SelectorFromSet(labels.Set{sizing.EnabledLabel: "true"})},
+		}},
+		Metrics:                       metricsOptions,
+		WebhookServer:                 webhookServer,
+		LeaderElection:                leaderElect,
+		LeaderElectionID:              leaderElectionID,
+		LeaderElectionNamespace:       leaderElectionNamespace,
+		LeaderElectionReleaseOnCancel: true,
+		GracefulShutdownTimeout:       &shutdownTimeout,
+	})
+	if err != nil {
+		zap.L().Fatal("Could not create the manager", zap.Error(err))
 	}
+	ourCache, cachedClient := mgr.GetCache(), mgr.GetClient()
+	metrics.Registry.MustRegister(&nodeSizingCollector{reader: cachedClient})
 
-	// define http server and server handler
-	mux := http.NewServeMux()
-	mux.HandleFunc("/mutate", webhookServer.serve)
-	webhookServer.server.Handler = mux
+	// listening OS shutdown signal
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	toggleDebugOnSignal(ctx, logLevel)
 
-	zap.L().Info("Starting webhook server", zap.String("address", webhookServer.server.Addr))
+	if tracing {
+		shutdownTracing, err := setupTracing(ctx, tracingSampleRatio)
+		if err != nil {
+			zap.L().Fatal("Could not set up tracing", zap.Error(err))
+		}
+		defer func() {
+			if err := shutdownTracing(context.Background()); err != nil {
+				zap.L().Warn("Could not flush traces", zap.Error(err))
+			}
+		}()
+	}
 
-	// start webhook server in new routine
-	go func() {
-		if err := webhookServer.server.ListenAndServeTLS("", ""); err != nil {
-			zap.L().Fatal("Failed to listen and serve webhook server: %v", zap.Error(err))
+	if err := mgr.Add(everyReplica(certWatcher.Start)); err != nil {
+		zap.L().Fatal("Failed to watch certificate files", zap.Error(err))
+	}
+	// Every replica renews the certificate when it expires soon, and reloads it when another one did
+	if certs != nil {
+		if err := mgr.Add(everyReplica(func(ctx context.Context) error {
+			certs.Run(ctx, selfSignedCheckInterval, certFile, keyFile, caCrtFile)
+			return nil
+		})); err != nil {
+			zap.L().Fatal("Could not start checking the self-signed certificate", zap.Error(err))
 		}
-	}()
+	}
+
+	// Drift is only logged once, by the leader
+	if err := mgr.Add(leaderOnly(func(ctx context.Context) error {
+		return startPodHandler(ctx, ourCache, &driftDetector{})
+	})); err != nil {
+		zap.L().Fatal("Could not start drift detection", zap.Error(err))
+	}
 
-	// listening OS shutdown singal
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
-	<-signalChan
+	// Policies are optional, the webhook keeps working from annotations alone when their CRD is not installed
+	_, err = ourCache.GetInformer(ctx, &v1alpha1.SizingPolicy{})
+	policiesAvailable := err == nil
+	if !policiesAvailable {
+		zap.L().Warn("Sizing policies are not available, is the CRD installed?", zap.Error(err))
+	}
 
-	zap.L().Info("Got OS shutdown signal, shutting down webhook server gracefully.")
-	cacheCtx.Done()
-	err = webhookServer.server.Shutdown(context.Background())
-	if err != nil {
-		zap.L().Error("Problem while shutting down webhook server", zap.Error(err))
+	// Quotas and limit ranges are cached before the first admission request needs them
+	if quotas != sizing.QuotasIgnore {
+		if _, err := ourCache.GetInformer(ctx, &corev1.ResourceQuota{}); err != nil {
+			zap.L().Fatal("Could not watch resource quotas", zap.Error(err))
+		}
+	}
+	if _, err := ourCache.GetInformer(ctx, &corev1.LimitRange{}); err != nil {
+		zap.L().Fatal("Could not watch limit ranges", zap.Error(err))
+	}
+
+	// VerticalPodAutoscalers are optional too, conflicts go unnoticed when their CRD is not installed
+	vpasAvailable := false
+	if vpas != sizing.VPAIgnore {
+		vpa := &unstructured.Unstructured{}
+		vpa.SetGroupVersionKind(sizing.VerticalPodAutoscalerGVK)
+		_, err = ourCache.GetInformer(ctx, vpa)
+		if vpasAvailable = err == nil; !vpasAvailable {
+			zap.L().Warn("Vertical pod autoscalers are not available, is their CRD installed?", zap.Error(err))
+		}
+	}
+
+	var nodeReader client.Reader = cachedClient
+	if ring != nil {
+		nodeReader = &shardNodeReader{Reader: cachedClient, ring: ring, apiReader: mgr.GetAPIReader()}
+	}
+	sizerOptions := sizing.Options{
+		NamespaceReader:   cachedClient,
+		LimitRangeReader:  cachedClient,
+		NodeResolvers:     resolvers,
+		DeductPodOverhead: deductPodOverhead,
+		// Nodes that just joined may not be cached yet
+		NodeAPIReader:    mgr.GetAPIReader(),
+		FallbackRequests: fallback,
+		Candidates:       candidates,
+		ResultCacheSize:  resultCacheSize,
+		NodeSnapshots:    sizing.NewNodeSnapshots(),
+	}
+	if err := mgr.Add(everyReplica(func(ctx context.Context) error {
+		return startNodeHandler(ctx, ourCache, sizerOptions.NodeSnapshots)
+	})); err != nil {
+		zap.L().Fatal("Could not start node snapshots", zap.Error(err))
+	}
+	if policiesAvailable {
+		sizerOptions.PolicyReader = cachedClient
+	}
+	if quotas != sizing.QuotasIgnore {
+		sizerOptions.QuotaReader, sizerOptions.Quotas = cachedClient, quotas
+	}
+	if vpasAvailable {
+		// Unstructured objects are only read from the cache when asked to it directly
+		sizerOptions.VPAReader, sizerOptions.VPAs = ourCache, vpas
+	}
+	if usageFloorPercentile > 0 {
+		// The metrics API can't be watched, hence the direct reader. Every replica sizes pods, hence tracks usage.
+		usage := newUsageTracker(mgr.GetAPIReader(), cachedClient, usageWindow, usageFloorPercentile)
+		if err := mgr.Add(everyReplica(func(ctx context.Context) error {
+			usage.Run(ctx, usageInterval)
+			return nil
+		})); err != nil {
+			zap.L().Fatal("Could not start usage tracking", zap.Error(err))
+		}
+		sizerOptions.UsageFloors = usage
+	}
+
+	if deductCommitted {
+		// Sized pods are only a fraction of all pods, which need a cache of their own
+		podCache, err := cache.New(mgr.GetConfig(), cache.Options{
+			Scheme:           scheme,
+			Mapper:           mgr.GetRESTMapper(),
+			ByObject:         map[client.Object]cache.ByObject{&corev1.Pod{}: {}},
+			DefaultTransform: cache.TransformStripManagedFields(),
+		})
+		if err != nil {
+			zap.L().Fatal("Could not create the pod cache", zap.Error(err))
+		}
+		if err := podCache.IndexField(ctx, &corev1.Pod{}, sizing.PodNodeNameField, sizing.IndexPodNodeName); err != nil {
+			zap.L().Fatal("Could not index pods by node", zap.Error(err))
+		}
+		// The manager starts caches it is given, and waits for them to sync, on every replica
+		if err := mgr.Add(podCache); err != nil {
+			zap.L().Fatal("Could not start the pod cache", zap.Error(err))
+		}
+		sizerOptions.Committed = sizing.NewCommittedResources(podCache)
+	}
+
+	recorder := mgr.GetEventRecorderFor(fieldManager)
+	events := newSizingEvents(recorder, ownerEvents)
+	events.nodeResolvers = resolvers
+	// Decisions are pending on the replica that sized the pod, which is the one to record its Event
+	if err := mgr.Add(everyReplica(func(ctx context.Context) error {
+		return startPodHandler(ctx, ourCache, events)
+	})); err != nil {
+		zap.L().Fatal("Could not start sizing events", zap.Error(err))
+	}
+	if verifySizing {
+		if err := mgr.Add(leaderOnly(func(ctx context.Context) error {
+			return startPodHandler(ctx, ourCache, &sizingVerifier{recorder: recorder})
+		})); err != nil {
+			zap.L().Fatal("Could not start sizing verification", zap.Error(err))
+		}
+	}
+	if reportWorkloadStatus {
+		reporter := newWorkloadStatusReporter(cachedClient, cachedClient)
+		if err := mgr.Add(leaderOnly(func(ctx context.Context) error {
+			reporter.Run(ctx, workloadStatusInterval)
+			return nil
+		})); err != nil {
+			zap.L().Fatal("Could not start workload status reporting", zap.Error(err))
+		}
+	}
+
+	var audit *auditLog
+	if auditLogPath != "" {
+		if audit, err = openAuditLog(auditLogPath, auditLogMaxBytes, auditLogMaxBackups); err != nil {
+			zap.L().Fatal("Could not open the audit log", zap.Error(err))
+		}
+		defer audit.Close()
+	}
+	var sink *decisionSink
+	if decisionSinkURL != "" {
+		sink = newDecisionSink(decisionSinkURL, decisionSinkQueueSize, decisionSinkRetries)
+		if err := mgr.Add(everyReplica(func(ctx context.Context) error {
+			sink.Run(ctx)
+			return nil
+		})); err != nil {
+			zap.L().Fatal("Could not start the decision sink", zap.Error(err))
+		}
+	}
+
+	guard := newAdmissionGuard(namespaceAllowlist, namespaceDenylist, requireEnabledLabel)
+	// Settings of the config file are applied by replacing the handler, the sizer included
+	newSizingHandler := func(settings reloadableSettings) *podSizingHandler {
+		options := sizerOptions
+		options.Conflicts = settings.conflicts
+		options.Defaults = settings.defaults
+		options.AnnotationDomain = settings.annotationDomain
+		return &podSizingHandler{
+			sizer:              sizing.New(nodeReader, options),
+			decoder:            admission.NewDecoder(scheme),
+			events:             events,
+			dryRun:             settings.dryRun,
+			failureMode:        settings.failureMode,
+			excludedNamespaces: mapset.NewThreadUnsafeSet(settings.excludedNamespaces...),
+			guard:              guard,
+			decisions:          decisions,
+			audit:              audit,
+			sink:               sink,
+			sizeOnceBound:      sizeOnceBound,
+			timeout:            webhookTimeout,
+			annotationDomain:   settings.annotationDomain,
+		}
+	}
+	sizingHandler := &reloadableHandler{}
+	sizingHandler.current.Store(newSizingHandler(settings))
+	recordSettings(settings)
+	boundSizer := &boundPodSizer{
+		client:   cachedClient,
+		sizer:    sizingHandler.sizer,
+		recorder: recorder,
+	}
+	check.nodeReader, check.sizer = nodeReader, boundSizer.sizer
+	if sizeOnceBound || candidates == sizing.CandidatesBound {
+		if err := mgr.Add(leaderOnly(func(ctx context.Context) error {
+			return startPodHandler(ctx, ourCache, boundSizer)
+		})); err != nil {
+			zap.L().Fatal("Could not start sizing bound pods", zap.Error(err))
+		}
+	}
+	if evictDaemonSetPods && reconcileInterval <= 0 {
+		zap.L().Fatal("--evict-outdated-daemonset-pods requires --reconcile-interval")
 	}
+	if reconcileInterval > 0 {
+		reconciler := newSizingReconciler(cachedClient, boundSizer.sizer, recorder, newPodEvictor(cachedClient, evictionRate))
+		reconciler.evictDaemonSetPods = evictDaemonSetPods
+		if err := mgr.Add(leaderOnly(func(ctx context.Context) error {
+			reconciler.Run(ctx, reconcileInterval)
+			return nil
+		})); err != nil {
+			zap.L().Fatal("Could not start sizing reconciliation", zap.Error(err))
+		}
+	}
+	if configFile != "" {
+		reload := func() {
+			fileCfg, err := loadConfigFile(configFile)
+			if err == nil {
+				settings, err = resolveSettings(fileCfg, explicitFlags)
+			}
+			if err != nil {
+				zap.L().Error("Keeping previous settings", zap.String("config", configFile), zap.Error(err))
+				return
+			}
+			sizingHandler.current.Store(newSizingHandler(settings))
+			recordSettings(settings)
+			zap.L().Info("Reloaded settings", zap.String("config", configFile))
+		}
+		if err := watchConfigFile(ctx, configFile, reload); err != nil {
+			zap.L().Fatal("Could not watch --config", zap.Error(err))
+		}
+	}
+	// Requests still in flight when --shutdown-timeout is about to expire are canceled, for the replica to exit cleanly
+	drained := draining(ctx, shutdownTimeout)
+	limits := requestLimits{maxBytes: maxRequestBytes, readTimeout: readTimeout, writeTimeout: writeTimeout}
+	var mutator admission.Handler = sizingHandler
+	if ring != nil {
+		webhookServer.Register(shardedMutatePath, traced(shardedMutatePath, cancelOnShutdown(drained, limits.wrap(decodeRequests(maxRequestBytes, &webhook.Admission{Handler: mutator})))))
+		sharded, err := newShardedHandler(mutator, ring, admission.NewDecoder(scheme), shardPeerURL, caCrtFile,
+			func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				// Peers verify client certificates like they do for the API server, they get the one we serve
+				return certWatcher.GetCertificate(nil)
+			})
+		if err != nil {
+			zap.L().Fatal("Failed to set up sharding", zap.Error(err))
+		}
+		sharded.nodeResolvers = resolvers
+		sharded.timeout = webhookTimeout
+		sharded.nodeReader = cachedClient
+		mutator = sharded
+		zap.L().Info("Sharding requests", zap.String("by", shardBy), zap.Int("shard", ring.index), zap.Int("shards", ring.count))
+	}
+	validator := &annotationValidator{decoder: admission.NewDecoder(scheme)}
+	binder := &bindingHandler{decoder: admission.NewDecoder(scheme), reader: cachedClient, sizer: boundSizer}
+	// Sizing alone is throttled, validating and binding are cheap and must not be refused for its sake
+	throttle := newAdmissionThrottle(maxInFlight, maxRequestRate, requestBurst)
+	for name, handler := range map[string]admission.Handler{"mutate-paths": mutator, "validate-paths": validator, "bind-paths": binder} {
+		for _, path := range paths[name] {
+			served := limits.wrap(decodeRequests(maxRequestBytes, &webhook.Admission{Handler: handler}))
+			if name == "mutate-paths" {
+				served = throttle.wrap(served)
+			}
+			webhookServer.Register(path, traced(path, cancelOnShutdown(drained, served)))
+		}
+	}
+
+	zap.L().Info("Starting manager", zap.Int("port", port), zap.Bool("leaderElection", leaderElect))
+
+	// Start blocks until the context is canceled. It then stops runnables, then caches, and last the webhook server,
+	// which stops accepting connections and waits for in-flight requests, all within --shutdown-timeout.
+	if err := mgr.Start(ctx); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			zap.L().Fatal("In-flight admission requests did not complete in time", zap.Duration("timeout", shutdownTimeout), zap.Error(err))
+		}
+		zap.L().Fatal("Manager failed", zap.Error(err))
+	}
+
+	zap.L().Info("Got OS shutdown signal, webhook server shut down gracefully.")
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"strconv"
+)
+
+const metricsNamespace = "node_specific_sizing"
+
+var (
+	sizedPodsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sized_pods_total",
+		Help:      "Pods sized on admission.",
+	}, []string{"dry_run"})
+
+	resourcePatchesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "resource_patches_total",
+		Help:      "Container resources sized on admission, by property and resource.",
+	}, []string{"dry_run", "property", "resource"})
+
+	sizingVerificationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sizing_verifications_total",
+		Help:      "Sized pods checked against their applied resources once created, by result: match or mismatch.",
+	}, []string{"result"})
+
+	shardRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "shard_requests_total",
+		Help:      "Admission requests seen by a sharded replica, by outcome: owned, forwarded or forward-failed.",
+	}, []string{"outcome"})
+
+	boundSizingsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "bound_sizings_total",
+		Help:      "Pods admitted before their node was known, by outcome: deferred on admission, then resized on binding, or resized or failed once bound.",
+	}, []string{"outcome"})
+
+	reconciledPodsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconciled_pods_total",
+		Help:      "Sized pods sized again by periodic reconciliation, by outcome: current, outdated, then resized, evicted, throttled by the eviction rate, blocked by a disruption budget, or failed.",
+	}, []string{"outcome"})
+
+	admissionRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "admission_requests_total",
+		Help:      "Pod admission requests answered by this replica, by outcome: patched, allowed, denied or errored.",
+	}, []string{"outcome"})
+
+	sizingFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sizing_failures_total",
+		Help:      "Pods that could not be sized on admission, by reason: NodeNotFound, AnnotationInvalid or BudgetComputeFailed.",
+	}, []string{"reason"})
+
+	sinkDecisionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sink_decisions_total",
+		Help:      "Sizing decisions posted to the decision sink, by outcome: sent, failed once retries are exhausted, or dropped as the queue was full or on shutdown.",
+	}, []string{"outcome"})
+
+	throttledRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "throttled_requests_total",
+		Help:      "Admission requests refused with a 429, by limit reached: concurrency or rate.",
+	}, []string{"limit"})
+
+	settingsInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "settings_info",
+		Help:      "Always 1, labeled with a hash of the reloadable settings in use, which replicas should share.",
+	}, []string{"hash"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(sizedPodsTotal, resourcePatchesTotal, sizingVerificationsTotal, shardRequestsTotal, boundSizingsTotal,
+		reconciledPodsTotal, admissionRequestsTotal, sizingFailuresTotal, sinkDecisionsTotal, throttledRequestsTotal, settingsInfo)
+	metrics.Registry.MustRegister(sizing.Collectors()...)
+}
+
+// recordSizing counts a sizing result
+func recordSizing(result *sizing.Result) {
+	dryRun := strconv.FormatBool(result.DryRun())
+	sizedPodsTotal.WithLabelValues(dryRun).Inc()
+	for patch := range result.Patches() {
+		resourcePatchesTotal.WithLabelValues(dryRun, string(patch.Property), string(patch.Resource)).Inc()
+	}
+}
+
+// recordAdmission counts an admission response by outcome
+func recordAdmission(response admission.Response) {
+	admissionRequestsTotal.WithLabelValues(admissionOutcome(response)).Inc()
+}
+
+// admissionOutcome tells how an admission request was answered: patched, allowed, denied or errored
+func admissionOutcome(response admission.Response) string {
+	switch {
+	case response.Allowed && len(response.Patches) > 0:
+		return "patched"
+	case response.Allowed:
+		return "allowed"
+	case response.Result != nil && response.Result.Code >= http.StatusInternalServerError:
+		return "errored"
+	}
+	return "denied"
+}
+
+// recordSettings labels settingsInfo with the hash of the settings now in use, replacing the previous one
+func recordSettings(settings reloadableSettings) {
+	settingsInfo.Reset()
+	settingsInfo.WithLabelValues(settings.hash()).Set(1)
+}
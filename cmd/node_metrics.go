@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+var (
+	nodeCapacityDesc = prometheus.NewDesc(metricsNamespace+"_node_capacity",
+		"Capacity of nodes fractions are applied to, by node and resource, in cores for CPU and bytes otherwise.",
+		[]string{"node", "resource"}, nil)
+	nodeGrantedDesc = prometheus.NewDesc(metricsNamespace+"_node_granted",
+		"Resources granted to sized pods running on nodes, by node, property and resource, in cores for CPU and bytes otherwise.",
+		[]string{"node", "property", "resource"}, nil)
+)
+
+// nodeSizingCollector tells, on every scrape, how much of each node sized pods consume, from cached nodes and pods.
+// Nodes going away take their series along, which gauges set on admission would keep reporting.
+type nodeSizingCollector struct {
+	reader client.Reader
+}
+
+var _ prometheus.Collector = &nodeSizingCollector{}
+
+func (c *nodeSizingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- nodeCapacityDesc
+	ch <- nodeGrantedDesc
+}
+
+func (c *nodeSizingCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+	var nodes corev1.NodeList
+	if err := c.reader.List(ctx, &nodes); err != nil {
+		ch <- prometheus.NewInvalidMetric(nodeCapacityDesc, err)
+		return
+	}
+	var pods corev1.PodList
+	if err := c.reader.List(ctx, &pods); err != nil {
+		ch <- prometheus.NewInvalidMetric(nodeGrantedDesc, err)
+		return
+	}
+
+	granted := make(map[string]map[string]corev1.ResourceList)
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		applied, err := sizing.AppliedResourcesFromAnnotations(pod.Annotations)
+		if err != nil {
+			zap.L().Debug("Ignoring unreadable applied resources", zap.String("namespace", pod.Namespace), zap.String("name", pod.Name), zap.Error(err))
+			continue
+		}
+		if len(applied) == 0 {
+			continue
+		}
+		if granted[pod.Spec.NodeName] == nil {
+			granted[pod.Spec.NodeName] = map[string]corev1.ResourceList{"requests": {}, "limits": {}}
+		}
+		for _, resources := range applied {
+			addResources(granted[pod.Spec.NodeName]["requests"], resources.Requests)
+			addResources(granted[pod.Spec.NodeName]["limits"], resources.Limits)
+		}
+	}
+
+	for _, node := range nodes.Items {
+		for name, quantity := range node.Status.Capacity {
+			if isSizedResource(name) {
+				ch <- prometheus.MustNewConstMetric(nodeCapacityDesc, prometheus.GaugeValue, quantityValue(name, quantity), node.Name, string(name))
+			}
+		}
+		for property, resources := range granted[node.Name] {
+			for name, quantity := range resources {
+				ch <- prometheus.MustNewConstMetric(nodeGrantedDesc, prometheus.GaugeValue, quantityValue(name, quantity), node.Name, property, string(name))
+			}
+		}
+	}
+}
+
+// isSizedResource tells whether fractions may apply to a resource, leaving out e.g. pods and ephemeral storage
+func isSizedResource(name corev1.ResourceName) bool {
+	return name == corev1.ResourceCPU || name == corev1.ResourceMemory || strings.HasPrefix(string(name), corev1.ResourceHugePagesPrefix)
+}
+
+func addResources(total, resources corev1.ResourceList) {
+	for name, quantity := range resources {
+		sum := total[name]
+		sum.Add(quantity)
+		total[name] = sum
+	}
+}
+
+// quantityValue returns CPU in cores and anything else in its base unit
+func quantityValue(name corev1.ResourceName, quantity resource.Quantity) float64 {
+	if name == corev1.ResourceCPU {
+		return float64(quantity.MilliValue()) / 1000
+	}
+	return quantity.AsApproximateFloat64()
+}
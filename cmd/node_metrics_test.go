@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+)
+
+var _ = Describe("Node sizing metrics", Label("Metrics"), func() {
+	sizedPod := func(name, nodeName, applied string) *corev1.Pod {
+		pod := fixtures.PodWithContainers()
+		pod.Name, pod.Namespace = name, "default"
+		pod.Spec.NodeName = nodeName
+		pod.Annotations = map[string]string{sizing.AppliedResourcesAnnotation: applied}
+		return pod
+	}
+
+	It("reports node capacity and what sized pods were granted on it", func() {
+		node := fixtures.NodeWithCapacity("4", "8G")
+		node.Name = "node-a"
+		reader := fake.NewClientBuilder().WithObjects(
+			node,
+			sizedPod("a", "node-a", `{"app": {"requests": {"cpu": "400m"}, "limits": {"memory": "1G"}}}`),
+			sizedPod("b", "node-a", `{"app": {"requests": {"cpu": "100m"}}, "sidecar": {"requests": {"cpu": "500m"}}}`),
+			sizedPod("pending", "", `{"app": {"requests": {"cpu": "1"}}}`),
+		).Build()
+
+		Expect(testutil.CollectAndCompare(&nodeSizingCollector{reader: reader}, strings.NewReader(`
+# HELP node_specific_sizing_node_capacity Capacity of nodes fractions are applied to, by node and resource, in cores for CPU and bytes otherwise.
+# TYPE node_specific_sizing_node_capacity gauge
+node_specific_sizing_node_capacity{node="node-a",resource="cpu"} 4
+node_specific_sizing_node_capacity{node="node-a",resource="memory"} 8e+09
+# HELP node_specific_sizing_node_granted Resources granted to sized pods running on nodes, by node, property and resource, in cores for CPU and bytes otherwise.
+# TYPE node_specific_sizing_node_granted gauge
+node_specific_sizing_node_granted{node="node-a",property="limits",resource="memory"} 1e+09
+node_specific_sizing_node_granted{node="node-a",property="requests",resource="cpu"} 1
+`))).To(Succeed())
+	})
+})
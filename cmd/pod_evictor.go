@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+)
+
+var (
+	// errEvictionThrottled is returned for pods over the eviction rate, which are evicted on a later reconciliation
+	errEvictionThrottled = errors.New("eviction rate reached")
+	// errDisruptionBudget is returned for pods a PodDisruptionBudget does not allow evicting right now
+	errDisruptionBudget = errors.New("disruption budget exhausted")
+)
+
+// podEvictor evicts pods whose sizing is outdated, for their controller to create them again, sized anew, on clusters
+// without in-place resize. It spaces evictions out, and leaves pods alone while a PodDisruptionBudget selecting them
+// allows no disruption rather than having the API server refuse them.
+type podEvictor struct {
+	client client.Client
+	// limiter is nil for no limit
+	limiter *rate.Limiter
+}
+
+// newPodEvictor allows perMinute evictions per minute, any number when not positive
+func newPodEvictor(c client.Client, perMinute float64) *podEvictor {
+	evictor := &podEvictor{client: c}
+	if perMinute > 0 {
+		evictor.limiter = rate.NewLimiter(rate.Every(time.Duration(float64(time.Minute)/perMinute)), 1)
+	}
+	return evictor
+}
+
+// evict evicts pod through the Eviction API, unless errEvictionThrottled or errDisruptionBudget says why not
+func (pe *podEvictor) evict(ctx context.Context, pod *corev1.Pod) error {
+	budget, err := pe.blockingBudget(ctx, pod)
+	if err != nil {
+		return err
+	}
+	if budget != "" {
+		return fmt.Errorf("%w: %s", errDisruptionBudget, budget)
+	}
+	if pe.limiter != nil && !pe.limiter.Allow() {
+		return errEvictionThrottled
+	}
+	eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: pod.Name}}
+	return pe.client.SubResource("eviction").Create(ctx, pod, eviction)
+}
+
+// blockingBudget returns the name of a PodDisruptionBudget selecting pod that allows no disruption, if any
+func (pe *podEvictor) blockingBudget(ctx context.Context, pod *corev1.Pod) (string, error) {
+	var budgets policyv1.PodDisruptionBudgetList
+	if err := pe.client.List(ctx, &budgets, client.InNamespace(pod.Namespace)); err != nil {
+		return "", fmt.Errorf("could not list pod disruption budgets of namespace %s: %w", pod.Namespace, err)
+	}
+	for _, budget := range budgets.Items {
+		// Budgets without a selector select no pod, those with an empty one select every pod of their namespace
+		selector, err := metav1.LabelSelectorAsSelector(budget.Spec.Selector)
+		if err == nil && selector.Matches(labels.Set(pod.Labels)) && budget.Status.DisruptionsAllowed < 1 {
+			return budget.Name, nil
+		}
+	}
+	return "", nil
+}
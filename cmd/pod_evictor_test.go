@@ -0,0 +1,57 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Evicting outdated pods", Label("PodEvictor"), func() {
+	pod := func(name string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: map[string]string{"app": "agent"}}}
+	}
+	budget := func(selector *metav1.LabelSelector, disruptionsAllowed int32) *policyv1.PodDisruptionBudget {
+		return &policyv1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "agent"},
+			Spec:       policyv1.PodDisruptionBudgetSpec{Selector: selector},
+			Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: disruptionsAllowed},
+		}
+	}
+	gone := func(ctx SpecContext, c client.Client, pod *corev1.Pod) bool {
+		return apierrors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(pod), &corev1.Pod{}))
+	}
+
+	It("leaves pods alone while a disruption budget selecting them allows no disruption", func(ctx SpecContext) {
+		a := pod("agent-a")
+		c := fake.NewClientBuilder().WithObjects(a, budget(&metav1.LabelSelector{MatchLabels: a.Labels}, 0)).Build()
+		Expect(newPodEvictor(c, 0).evict(ctx, a)).To(MatchError(errDisruptionBudget))
+		Expect(gone(ctx, c, a)).To(BeFalse())
+	})
+
+	It("evicts pods disruption budgets allow disrupting", func(ctx SpecContext) {
+		a := pod("agent-a")
+		c := fake.NewClientBuilder().WithObjects(a, budget(&metav1.LabelSelector{MatchLabels: a.Labels}, 1)).Build()
+		Expect(newPodEvictor(c, 0).evict(ctx, a)).To(Succeed())
+		Expect(gone(ctx, c, a)).To(BeTrue())
+	})
+
+	It("ignores disruption budgets without a selector", func(ctx SpecContext) {
+		a := pod("agent-a")
+		c := fake.NewClientBuilder().WithObjects(a, budget(nil, 0)).Build()
+		Expect(newPodEvictor(c, 0).evict(ctx, a)).To(Succeed())
+	})
+
+	It("spaces evictions out", func(ctx SpecContext) {
+		a, b := pod("agent-a"), pod("agent-b")
+		c := fake.NewClientBuilder().WithObjects(a, b).Build()
+		evictor := newPodEvictor(c, 1)
+		Expect(evictor.evict(ctx, a)).To(Succeed())
+		Expect(evictor.evict(ctx, b)).To(MatchError(errEvictionThrottled))
+		Expect(gone(ctx, c, b)).To(BeFalse())
+	})
+})
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// cancelOnShutdown cancels the requests handler serves once ctx is done, as well as when their client disconnects, so
+// that in-flight admissions answer with what they have rather than hold the shutdown of the webhook server
+func cancelOnShutdown(ctx context.Context, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCtx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		stop := context.AfterFunc(ctx, cancel)
+		defer stop()
+		handler.ServeHTTP(w, r.WithContext(requestCtx))
+	})
+}
+
+// draining returns a context done once ctx has been done for most of grace. Requests keep being served normally
+// while the replica drains, e.g. until endpoints no longer list it, and those still in flight then are canceled with a
+// tenth of grace left to answer them.
+func draining(ctx context.Context, grace time.Duration) context.Context {
+	drained, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	context.AfterFunc(ctx, func() { time.AfterFunc(grace-grace/10, cancel) })
+	return drained
+}
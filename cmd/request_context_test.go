@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"net/http"
+	"net/http/httptest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"time"
+)
+
+var _ = Describe("Canceling requests", Label("RequestContext"), func() {
+	It("cancels in-flight requests on shutdown", func(ctx SpecContext) {
+		shutdown, stop := context.WithCancel(ctx)
+		var seen error
+		handler := cancelOnShutdown(shutdown, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			seen = r.Context().Err()
+		}))
+		time.AfterFunc(50*time.Millisecond, stop)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/mutate", nil))
+		Expect(seen).To(MatchError(context.Canceled))
+	})
+
+	It("has admissions in flight answer once canceled", func(ctx SpecContext) {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		hanging := fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		}).Build()
+		sizer := sizing.New(fake.NewClientBuilder().Build(), sizing.Options{NodeAPIReader: hanging})
+		handler := &webhook.Admission{Handler: &podSizingHandler{sizer: sizer, decoder: admission.NewDecoder(scheme)}}
+
+		pod := fixtures.PinToNode(fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil)), "node-a")
+		pod.Annotations = map[string]string{"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.1"}
+		req := admissionRequestFor("Pod", pod)
+		review := &admissionv1.AdmissionReview{Request: &req.AdmissionRequest}
+		review.SetGroupVersionKind(admissionv1.SchemeGroupVersion.WithKind("AdmissionReview"))
+		body, err := json.Marshal(review)
+		Expect(err).NotTo(HaveOccurred())
+
+		// The API server disconnecting cancels the request like a shutdown does
+		disconnected, disconnect := context.WithCancel(ctx)
+		time.AfterFunc(50*time.Millisecond, disconnect)
+		request := httptest.NewRequestWithContext(disconnected, http.MethodPost, "/mutate", bytes.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		start := time.Now()
+		handler.ServeHTTP(recorder, request)
+		Expect(time.Since(start)).To(BeNumerically("<", 500*time.Millisecond))
+
+		var answer admissionv1.AdmissionReview
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &answer)).To(Succeed())
+		Expect(answer.Response.Allowed).To(BeFalse())
+		Expect(answer.Response.Result.Message).To(ContainSubstring("context canceled"))
+	})
+
+	It("drains for most of the grace period", func(ctx SpecContext) {
+		stopping, stop := context.WithCancel(ctx)
+		drained := draining(stopping, 200*time.Millisecond)
+		stop()
+		Consistently(drained.Done(), 150*time.Millisecond).ShouldNot(BeClosed())
+		Eventually(drained.Done(), 100*time.Millisecond).Should(BeClosed())
+	})
+})
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer/protobuf"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const protobufContentType = "application/vnd.kubernetes.protobuf"
+
+// admissionReviewProtobuf decodes protobuf-encoded AdmissionReviews
+var admissionReviewProtobuf = func() *protobuf.Serializer {
+	scheme := runtime.NewScheme()
+	if err := admissionv1.AddToScheme(scheme); err != nil {
+		panic(err)
+	}
+	return protobuf.NewSerializer(scheme, scheme)
+}()
+
+// decodeRequests hands admission requests over as the plain JSON the webhook framework expects: gzip bodies are
+// decompressed, media type parameters such as charset=utf-8 dropped, and protobuf AdmissionReviews converted. Bodies
+// are bounded to maxBytes once decompressed as well.
+func decodeRequests(maxBytes int64, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+		case "", "identity":
+		case "gzip":
+			reader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid gzip body: %s", err), http.StatusBadRequest)
+				return
+			}
+			defer reader.Close()
+			r.Body = http.MaxBytesReader(w, reader, maxBytes)
+			r.Header.Del("Content-Encoding")
+			r.ContentLength = -1
+		default:
+			http.Error(w, fmt.Sprintf("unsupported content encoding %q, expected gzip", encoding), http.StatusUnsupportedMediaType)
+			return
+		}
+
+		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			// Left to the framework, which answers with an AdmissionReview
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") {
+			http.Error(w, fmt.Sprintf("unsupported charset %q, expected utf-8", charset), http.StatusUnsupportedMediaType)
+			return
+		}
+		switch mediaType {
+		case "application/json":
+		case protobufContentType:
+			body, err := protobufToJSON(r.Body)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid protobuf AdmissionReview: %s", err), http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+		default:
+			handler.ServeHTTP(w, r)
+			return
+		}
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// protobufToJSON converts a protobuf AdmissionReview, the response is written as JSON whatever the request was
+func protobufToJSON(body io.Reader) ([]byte, error) {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	var review admissionv1.AdmissionReview
+	if _, _, err := admissionReviewProtobuf.Decode(raw, nil, &review); err != nil {
+		return nil, err
+	}
+	review.SetGroupVersionKind(admissionv1.SchemeGroupVersion.WithKind("AdmissionReview"))
+	return json.Marshal(&review)
+}
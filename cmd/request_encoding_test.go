@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"io"
+	admissionv1 "k8s.io/api/admission/v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+var _ = Describe("Decoding requests", Label("RequestEncoding"), func() {
+	// echo answers with the content type and body it was handed
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(r.Header.Get("Content-Type") + " " + string(body)))
+	})
+	decoding := decodeRequests(64, echo)
+
+	serve := func(contentType, contentEncoding string, body io.Reader) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/mutate", body)
+		request.Header.Set("Content-Type", contentType)
+		request.Header.Set("Content-Encoding", contentEncoding)
+		decoding.ServeHTTP(recorder, request)
+		return recorder
+	}
+	gzipped := func(body string) io.Reader {
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		_, err := writer.Write([]byte(body))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.Close()).To(Succeed())
+		return &buffer
+	}
+
+	It("drops media type parameters of JSON", func() {
+		recorder := serve("application/json; charset=utf-8", "", strings.NewReader("{}"))
+		Expect(recorder.Body.String()).To(Equal("application/json {}"))
+
+		recorder = serve("application/json; charset=latin1", "", strings.NewReader("{}"))
+		Expect(recorder.Code).To(Equal(http.StatusUnsupportedMediaType))
+	})
+
+	It("decompresses gzip bodies, within the size limit", func() {
+		recorder := serve("application/json", "gzip", gzipped("{}"))
+		Expect(recorder.Body.String()).To(Equal("application/json {}"))
+
+		recorder = serve("application/json", "gzip", gzipped(strings.Repeat(" ", 100)))
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(recorder.Body.String()).To(ContainSubstring("too large"))
+
+		recorder = serve("application/json", "br", strings.NewReader("{}"))
+		Expect(recorder.Code).To(Equal(http.StatusUnsupportedMediaType))
+	})
+
+	It("converts protobuf AdmissionReviews to JSON", func() {
+		review := &admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{UID: "42"}}
+		review.SetGroupVersionKind(admissionv1.SchemeGroupVersion.WithKind("AdmissionReview"))
+		var body bytes.Buffer
+		Expect(admissionReviewProtobuf.Encode(review, &body)).To(Succeed())
+
+		handler := decodeRequests(1<<10, echo)
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/mutate", &body)
+		request.Header.Set("Content-Type", protobufContentType)
+		handler.ServeHTTP(response, request)
+		Expect(response.Body.String()).To(HavePrefix("application/json "))
+		Expect(response.Body.String()).To(ContainSubstring(`"uid":"42"`))
+		Expect(response.Body.String()).To(ContainSubstring(`"kind":"AdmissionReview"`))
+	})
+})
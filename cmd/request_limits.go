@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// requestLimits bounds what a client may send, and how long it may take, so that a misbehaving one can't exhaust
+// memory or hold connections. Headers are bounded by the webhook server itself, to 1MiB read within 32s.
+type requestLimits struct {
+	// maxBytes is the largest body accepted
+	maxBytes int64
+	// readTimeout bounds reading the body, writeTimeout handling the request and writing the response. Both start
+	// once headers are read, zero disables them.
+	readTimeout, writeTimeout time.Duration
+}
+
+// wrap applies limits to the requests handler serves
+func (l requestLimits) wrap(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > l.maxBytes {
+			http.Error(w, fmt.Sprintf("request body is larger than %d bytes", l.maxBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, l.maxBytes)
+
+		controller := http.NewResponseController(w)
+		now := time.Now()
+		if l.readTimeout > 0 {
+			l.setDeadline(controller.SetReadDeadline, now.Add(l.readTimeout))
+		}
+		if l.writeTimeout > 0 {
+			l.setDeadline(controller.SetWriteDeadline, now.Add(l.writeTimeout))
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (l requestLimits) setDeadline(set func(time.Time) error, deadline time.Time) {
+	if err := set(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		zap.L().Warn("Could not set connection deadline", zap.Error(err))
+	}
+}
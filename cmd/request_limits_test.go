@@ -0,0 +1,45 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+var _ = Describe("Limiting requests", Label("RequestLimits"), func() {
+	// echo answers with the body it could read, or a 400
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write(body)
+	})
+	limited := requestLimits{maxBytes: 8}.wrap(echo)
+
+	It("serves requests within limits", func() {
+		recorder := httptest.NewRecorder()
+		limited.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/mutate", strings.NewReader("{}")))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(Equal("{}"))
+	})
+
+	It("refuses bodies announced as too large without reading them", func() {
+		recorder := httptest.NewRecorder()
+		limited.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/mutate", strings.NewReader("0123456789")))
+		Expect(recorder.Code).To(Equal(http.StatusRequestEntityTooLarge))
+	})
+
+	It("stops reading bodies past the limit", func() {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/mutate", strings.NewReader("0123456789"))
+		request.ContentLength = -1
+		limited.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(recorder.Body.String()).To(ContainSubstring("too large"))
+	})
+})
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+)
+
+const (
+	// selfCheckPath serves the self check next to metrics, for liveness probes to tell the webhook can still size pods
+	selfCheckPath = "/selfcheck"
+	// selfCheckTimeout bounds a self check, which waits for caches to sync
+	selfCheckTimeout = 5 * time.Second
+	// selfCheckNamespace is where the canned pod pretends to be created. It should not exist, for namespace defaults
+	// and quotas not to get in the way.
+	selfCheckNamespace = "node-specific-sizing-self-check"
+)
+
+// selfCheckReport is what the self check answers, as JSON
+type selfCheckReport struct {
+	OK   bool   `json:"ok"`
+	Node string `json:"node,omitempty"`
+	// Latency is how long sizing the canned pod took, node lookup excluded
+	Latency    string `json:"latency"`
+	Operations int    `json:"operations"`
+	Error      string `json:"error,omitempty"`
+}
+
+// selfCheck sizes a canned pod for the first cached node, through the same sizer and patch rendering as admission
+// requests, without any side effect. It proves caches are synced and sizing works, which a TCP probe does not.
+type selfCheck struct {
+	nodeReader client.Reader
+	sizer      func() *sizing.Sizer
+}
+
+// selfCheckPod is a DaemonSet-like pod pinned to nodeName, asking for a tenth of its CPU and memory
+func selfCheckPod(nodeName string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:    selfCheckNamespace,
+			GenerateName: "self-check-",
+			Labels:       map[string]string{sizing.EnabledLabel: "true"},
+			Annotations: map[string]string{
+				sizing.AnnotationPrefix + "request-cpu-fraction":    "0.1",
+				sizing.AnnotationPrefix + "request-memory-fraction": "0.1",
+			},
+		},
+		Spec: corev1.PodSpec{
+			Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchFields: []corev1.NodeSelectorRequirement{{
+						Key:      "metadata.name",
+						Operator: corev1.NodeSelectorOpIn,
+						Values:   []string{nodeName},
+					}}}},
+				},
+			}},
+			Containers: []corev1.Container{{
+				Name:  "check",
+				Image: "self-check",
+				Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("10m"),
+					corev1.ResourceMemory: resource.MustParse("10Mi"),
+				}},
+			}},
+		},
+	}
+}
+
+// run sizes the canned pod, the report telling why it could not be
+func (sc *selfCheck) run(ctx context.Context) selfCheckReport {
+	var nodes corev1.NodeList
+	if err := sc.nodeReader.List(ctx, &nodes); err != nil {
+		return selfCheckReport{Error: fmt.Sprintf("could not list nodes: %v", err)}
+	}
+	if len(nodes.Items) == 0 {
+		return selfCheckReport{Error: "no node is cached"}
+	}
+	report := selfCheckReport{Node: nodes.Items[0].Name}
+	start := time.Now()
+	result, patch, err := sc.sizer().CreatePatch(ctx, selfCheckPod(report.Node), false)
+	report.Latency = time.Since(start).String()
+	report.Operations = len(patch)
+	switch {
+	case err != nil:
+		report.Error = err.Error()
+	case result.Unconfigured() || len(result.AppliedResources()) == 0:
+		report.Error = "the canned pod was not sized"
+	default:
+		report.OK = true
+	}
+	return report
+}
+
+// ServeHTTP answers GET requests with the report of a self check, with a 503 status when it failed
+func (sc *selfCheck) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), selfCheckTimeout)
+	defer cancel()
+	report := sc.run(ctx)
+	w.Header().Set("Content-Type", "application/json")
+	if !report.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
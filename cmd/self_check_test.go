@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Self check", Label("SelfCheck"), func() {
+	serve := func(check *selfCheck) (int, selfCheckReport) {
+		recorder := httptest.NewRecorder()
+		check.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, selfCheckPath, nil))
+		var report selfCheckReport
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &report)).To(Succeed())
+		return recorder.Code, report
+	}
+
+	It("sizes a canned pod for the first cached node", func() {
+		node := fixtures.NodeWithCapacity("4", "8G")
+		node.Name = "node-a"
+		reader := fake.NewClientBuilder().WithObjects(node).Build()
+		sizer := sizing.New(reader, sizing.Options{})
+		code, report := serve(&selfCheck{nodeReader: reader, sizer: func() *sizing.Sizer { return sizer }})
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(report.OK).To(BeTrue())
+		Expect(report.Node).To(Equal("node-a"))
+		Expect(report.Operations).To(BeNumerically(">", 0))
+		Expect(report.Latency).NotTo(BeEmpty())
+	})
+
+	It("fails without nodes", func() {
+		reader := fake.NewClientBuilder().Build()
+		sizer := sizing.New(reader, sizing.Options{})
+		code, report := serve(&selfCheck{nodeReader: reader, sizer: func() *sizing.Sizer { return sizer }})
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(report).To(Equal(selfCheckReport{Error: "no node is cached"}))
+	})
+})
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"go.uber.org/zap"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+)
+
+const (
+	// selfSignedValidity is how long generated certificates are valid
+	selfSignedValidity = 365 * 24 * time.Hour
+	// selfSignedCAValidity is how long generated CAs are valid, for certificates to be renewed several times by the same
+	selfSignedCAValidity = 5 * selfSignedValidity
+	// selfSignedRenewBefore is how long before expiry certificates are generated again
+	selfSignedRenewBefore = 30 * 24 * time.Hour
+	// selfSignedCheckInterval is how often the certificate is checked, and read again from the Secret
+	selfSignedCheckInterval = time.Hour
+	// caKeyKey holds the key of the CA in the Secret, for certificates to be renewed without changing the CA
+	caKeyKey = "ca.key"
+)
+
+// selfSignedCerts bootstraps a serving certificate without cert-manager. It is kept in a Secret shared by replicas, and
+// its CA injected into the webhook configurations. Certificates are renewed by the same CA, and CAs about to expire
+// are published together with the new one until they do, so that replicas still serving a certificate of the old CA
+// are trusted until they read the new one.
+type selfSignedCerts struct {
+	client client.Client
+	// secret holds the certificate, its key and its CA, with the keys cert-manager uses
+	secret types.NamespacedName
+	// dnsNames are those of the webhook Service
+	dnsNames []string
+	// webhookConfiguration names both the mutating and validating configurations to inject the CA into
+	webhookConfiguration string
+	now                  func() time.Time
+}
+
+func newSelfSignedCerts(c client.Client, namespace, secretName, serviceName, webhookConfiguration string) *selfSignedCerts {
+	return &selfSignedCerts{
+		client: c,
+		secret: types.NamespacedName{Namespace: namespace, Name: secretName},
+		dnsNames: []string{
+			fmt.Sprintf("%s.%s.svc", serviceName, namespace),
+			fmt.Sprintf("%s.%s.svc.cluster.local", serviceName, namespace),
+		},
+		webhookConfiguration: webhookConfiguration,
+		now:                  time.Now,
+	}
+}
+
+// ensure makes sure a valid certificate is in the Secret and in the webhook configurations, and writes it to the
+// given files for the certificate watcher to read. Files are only written when they changed, e.g. because another
+// replica renewed the certificate, for the watcher to reload it.
+func (s *selfSignedCerts) ensure(ctx context.Context, certFile, keyFile, caFile string) error {
+	secret, err := s.ensureSecret(ctx)
+	if err != nil {
+		return err
+	}
+	for file, key := range map[string]string{certFile: corev1.TLSCertKey, keyFile: corev1.TLSPrivateKeyKey, caFile: "ca.crt"} {
+		if current, err := os.ReadFile(file); err == nil && bytes.Equal(current, secret.Data[key]) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+			return fmt.Errorf("problem writing certificate: %w", err)
+		}
+		if err := os.WriteFile(file, secret.Data[key], 0o600); err != nil {
+			return fmt.Errorf("problem writing certificate: %w", err)
+		}
+	}
+	return s.injectCABundle(ctx, secret.Data["ca.crt"])
+}
+
+// Run checks the certificate every interval until ctx is done, renewing it before it expires, and reloading it when
+// another replica did
+func (s *selfSignedCerts) Run(ctx context.Context, interval time.Duration, certFile, keyFile, caFile string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.ensure(ctx, certFile, keyFile, caFile); err != nil {
+			zap.L().Warn("Could not check the self-signed certificate", zap.Error(err))
+		}
+	}
+}
+
+// ensureSecret returns the Secret holding a valid certificate, generating one when it is missing or expires soon
+func (s *selfSignedCerts) ensureSecret(ctx context.Context) (*corev1.Secret, error) {
+	var secret corev1.Secret
+	err := s.client.Get(ctx, s.secret, &secret)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("problem reading secret %s: %w", s.secret, err)
+	}
+	exists := err == nil
+	if exists && s.valid(secret.Data) {
+		return &secret, nil
+	}
+
+	data, err := s.generate(secret.Data)
+	if err != nil {
+		return nil, err
+	}
+	secret.Data, secret.Type = data, corev1.SecretTypeTLS
+	if exists {
+		zap.L().Info("Renewing self-signed certificate", zap.Stringer("secret", s.secret))
+		err = s.client.Update(ctx, &secret, client.FieldOwner(fieldManager))
+	} else {
+		zap.L().Info("Generating self-signed certificate", zap.Stringer("secret", s.secret))
+		secret.ObjectMeta = metav1.ObjectMeta{Namespace: s.secret.Namespace, Name: s.secret.Name}
+		err = s.client.Create(ctx, &secret, client.FieldOwner(fieldManager))
+	}
+	if apierrors.IsAlreadyExists(err) || apierrors.IsConflict(err) {
+		// Another replica got there first, its certificate is as good as ours
+		if err := s.client.Get(ctx, s.secret, &secret); err != nil {
+			return nil, fmt.Errorf("problem reading secret %s: %w", s.secret, err)
+		}
+		return &secret, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("problem storing secret %s: %w", s.secret, err)
+	}
+	return &secret, nil
+}
+
+// valid tells whether data holds a certificate for our DNS names, that doesn't expire soon
+func (s *selfSignedCerts) valid(data map[string][]byte) bool {
+	pair, err := tls.X509KeyPair(data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey])
+	if err != nil || len(data["ca.crt"]) == 0 {
+		return false
+	}
+	if pair.Leaf == nil {
+		if pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+			return false
+		}
+	}
+	for _, name := range s.dnsNames {
+		if pair.Leaf.VerifyHostname(name) != nil {
+			return false
+		}
+	}
+	return s.now().Add(selfSignedRenewBefore).Before(pair.Leaf.NotAfter)
+}
+
+// generate returns a certificate for our DNS names, as Secret data along with its CA. The CA of previous is kept when
+// it outlives the new certificate, otherwise a new one is generated and published along with the previous one.
+func (s *selfSignedCerts) generate(previous map[string][]byte) (map[string][]byte, error) {
+	now := s.now()
+	ca, caKey := reusableCA(previous, now.Add(selfSignedValidity))
+	if ca == nil {
+		var err error
+		if ca, caKey, err = generateCA(now); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("problem generating key: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano() + 1),
+		Subject:      pkix.Name{CommonName: s.dnsNames[0]},
+		DNSNames:     s.dnsNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(selfSignedValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		// Client authentication lets replicas present it to their peers when sharding
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("problem generating certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("problem encoding key: %w", err)
+	}
+	caKeyDER, err := x509.MarshalECPrivateKey(caKey)
+	if err != nil {
+		return nil, fmt.Errorf("problem encoding CA key: %w", err)
+	}
+
+	return map[string][]byte{
+		corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		"ca.crt":                caBundle(ca, previous["ca.crt"], now),
+		caKeyKey:                pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: caKeyDER}),
+	}, nil
+}
+
+func generateCA(now time.Time) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("problem generating CA key: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(now.UnixNano()),
+		Subject:               pkix.Name{CommonName: "node-specific-sizing-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedCAValidity),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("problem generating CA: %w", err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("problem generating CA: %w", err)
+	}
+	return ca, caKey, nil
+}
+
+// reusableCA returns the CA of data matching its key, when it is valid until notAfter
+func reusableCA(data map[string][]byte, notAfter time.Time) (*x509.Certificate, *ecdsa.PrivateKey) {
+	block, _ := pem.Decode(data[caKeyKey])
+	if block == nil {
+		return nil, nil
+	}
+	caKey, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil
+	}
+	for _, ca := range parseCertificates(data["ca.crt"]) {
+		if public, ok := ca.PublicKey.(*ecdsa.PublicKey); ok && public.Equal(&caKey.PublicKey) && ca.IsCA &&
+			!ca.NotAfter.Before(notAfter) {
+			return ca, caKey
+		}
+	}
+	return nil, nil
+}
+
+// caBundle returns ca as PEM, followed by the CAs of previous that are still valid
+func caBundle(ca *x509.Certificate, previous []byte, now time.Time) []byte {
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+	for _, cert := range parseCertificates(previous) {
+		if !cert.Equal(ca) && now.Before(cert.NotAfter) {
+			bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+		}
+	}
+	return bundle
+}
+
+// parseCertificates returns the certificates of a PEM bundle, skipping those it cannot parse
+func parseCertificates(bundle []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(bundle); block != nil; block, rest = pem.Decode(rest) {
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+	return certs
+}
+
+// injectCABundle sets the CA of every webhook of the mutating and validating configurations. Missing configurations
+// are skipped, the validating one is optional.
+func (s *selfSignedCerts) injectCABundle(ctx context.Context, caBundle []byte) error {
+	var mutating admissionregistrationv1.MutatingWebhookConfiguration
+	if err := s.patchIfFound(ctx, &mutating, func() bool {
+		changed := false
+		for i := range mutating.Webhooks {
+			changed = setCABundle(&mutating.Webhooks[i].ClientConfig, caBundle) || changed
+		}
+		return changed
+	}); err != nil {
+		return err
+	}
+	var validating admissionregistrationv1.ValidatingWebhookConfiguration
+	return s.patchIfFound(ctx, &validating, func() bool {
+		changed := false
+		for i := range validating.Webhooks {
+			changed = setCABundle(&validating.Webhooks[i].ClientConfig, caBundle) || changed
+		}
+		return changed
+	})
+}
+
+func (s *selfSignedCerts) patchIfFound(ctx context.Context, obj client.Object, mutate func() bool) error {
+	if err := s.client.Get(ctx, types.NamespacedName{Name: s.webhookConfiguration}, obj); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("problem reading webhook configuration %s: %w", s.webhookConfiguration, err)
+	}
+	base := obj.DeepCopyObject().(client.Object)
+	if !mutate() {
+		return nil
+	}
+	if err := s.client.Patch(ctx, obj, client.MergeFrom(base), client.FieldOwner(fieldManager)); err != nil {
+		return fmt.Errorf("problem injecting CA into webhook configuration %s: %w", s.webhookConfiguration, err)
+	}
+	return nil
+}
+
+func setCABundle(config *admissionregistrationv1.WebhookClientConfig, caBundle []byte) bool {
+	if bytes.Equal(config.CABundle, caBundle) {
+		return false
+	}
+	config.CABundle = caBundle
+	return true
+}
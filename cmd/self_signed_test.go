@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"time"
+)
+
+var _ = Describe("Bootstrapping a self-signed certificate", Label("SelfSigned"), func() {
+	var c client.Client
+	var certs *selfSignedCerts
+	var dir string
+	var managers *[]string
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(admissionregistrationv1.AddToScheme(scheme)).To(Succeed())
+		var funcs interceptor.Funcs
+		funcs, managers = recordFieldManagers()
+		c = fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(funcs).WithObjects(&admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-specific-sizing"},
+			Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "node-specific-sizing.svc.cluster.local"}},
+		}).Build()
+		certs = newSelfSignedCerts(c, "kube-system", "node-specific-sizing-cert", "node-specific-sizing", "node-specific-sizing")
+		dir = GinkgoT().TempDir()
+	})
+
+	ensure := func(ctx SpecContext) *corev1.Secret {
+		Expect(certs.ensure(ctx, filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt"))).To(Succeed())
+		var secret corev1.Secret
+		Expect(c.Get(ctx, types.NamespacedName{Namespace: "kube-system", Name: "node-specific-sizing-cert"}, &secret)).To(Succeed())
+		return &secret
+	}
+
+	It("generates a certificate for the service, and injects its CA", func(ctx SpecContext) {
+		secret := ensure(ctx)
+		pair, err := tls.LoadX509KeyPair(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pair.Leaf.DNSNames).To(ConsistOf("node-specific-sizing.kube-system.svc", "node-specific-sizing.kube-system.svc.cluster.local"))
+		Expect(os.ReadFile(filepath.Join(dir, "ca.crt"))).To(Equal(secret.Data["ca.crt"]))
+
+		var mutating admissionregistrationv1.MutatingWebhookConfiguration
+		Expect(c.Get(ctx, types.NamespacedName{Name: "node-specific-sizing"}, &mutating)).To(Succeed())
+		Expect(mutating.Webhooks[0].ClientConfig.CABundle).To(Equal(secret.Data["ca.crt"]))
+	})
+
+	It("keeps a valid certificate across restarts", func(ctx SpecContext) {
+		first := ensure(ctx)
+		Expect(ensure(ctx).Data).To(Equal(first.Data))
+	})
+
+	// verifies tells whether the certificate of data is issued by a CA of bundle
+	verifies := func(data map[string][]byte, bundle []byte) bool {
+		roots := x509.NewCertPool()
+		Expect(roots.AppendCertsFromPEM(bundle)).To(BeTrue())
+		pair, err := tls.X509KeyPair(data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey])
+		Expect(err).NotTo(HaveOccurred())
+		_, err = pair.Leaf.Verify(x509.VerifyOptions{Roots: roots, CurrentTime: certs.now()})
+		return err == nil
+	}
+
+	It("renews certificates that expire soon, with the same CA", func(ctx SpecContext) {
+		first := ensure(ctx)
+		certs.now = func() time.Time { return time.Now().Add(selfSignedValidity - selfSignedRenewBefore/2) }
+		renewed := ensure(ctx)
+		Expect(renewed.Data[corev1.TLSCertKey]).NotTo(Equal(first.Data[corev1.TLSCertKey]))
+		Expect(renewed.Data["ca.crt"]).To(Equal(first.Data["ca.crt"]))
+		Expect(verifies(renewed.Data, first.Data["ca.crt"])).To(BeTrue())
+	})
+
+	It("publishes the previous CA along with a new one until it expires", func(ctx SpecContext) {
+		// Certificates are renewed before they expire, until the CA does not outlive the next one
+		previous := ensure(ctx)
+		renewed := previous
+		for bytes.Equal(renewed.Data[caKeyKey], previous.Data[caKeyKey]) {
+			previous = renewed
+			pair, err := tls.X509KeyPair(previous.Data[corev1.TLSCertKey], previous.Data[corev1.TLSPrivateKeyKey])
+			Expect(err).NotTo(HaveOccurred())
+			renewAt := pair.Leaf.NotAfter.Add(-selfSignedRenewBefore / 2)
+			certs.now = func() time.Time { return renewAt }
+			renewed = ensure(ctx)
+		}
+		Expect(parseCertificates(renewed.Data["ca.crt"])).To(HaveLen(2))
+		// Replicas serving the previous certificate are trusted until they read the new one
+		Expect(verifies(previous.Data, renewed.Data["ca.crt"])).To(BeTrue())
+		Expect(verifies(renewed.Data, renewed.Data["ca.crt"])).To(BeTrue())
+
+		var mutating admissionregistrationv1.MutatingWebhookConfiguration
+		Expect(c.Get(ctx, types.NamespacedName{Name: "node-specific-sizing"}, &mutating)).To(Succeed())
+		Expect(mutating.Webhooks[0].ClientConfig.CABundle).To(Equal(renewed.Data["ca.crt"]))
+
+		expired := certs.now().Add(selfSignedValidity)
+		certs.now = func() time.Time { return expired }
+		Expect(parseCertificates(ensure(ctx).Data["ca.crt"])).To(HaveLen(1))
+	})
+
+	It("reloads certificates renewed by another replica", func(ctx SpecContext) {
+		secret := ensure(ctx)
+		data, err := certs.generate(secret.Data)
+		Expect(err).NotTo(HaveOccurred())
+		secret.Data = data
+		Expect(c.Update(ctx, secret)).To(Succeed())
+
+		ensure(ctx)
+		Expect(os.ReadFile(filepath.Join(dir, "tls.crt"))).To(Equal(data[corev1.TLSCertKey]))
+	})
+
+	It("writes as our field manager", func(ctx SpecContext) {
+		ensure(ctx)
+		certs.now = func() time.Time { return time.Now().Add(selfSignedCAValidity - selfSignedValidity/2) }
+		ensure(ctx)
+		Expect(*managers).To(HaveLen(4))
+		Expect(*managers).To(HaveEach(fieldManager))
+	})
+})
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.uber.org/zap"
+	"hash/fnv"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shardedMutatePath serves requests forwarded by other replicas, which are never forwarded again
+const shardedMutatePath = "/mutate-shard"
+
+type shardKey string
+
+const (
+	shardByNamespace shardKey = "namespace"
+	shardByNode      shardKey = "node"
+)
+
+// shardRing spreads keys over replicas with rendezvous hashing: changing the number of shards only moves the keys of
+// the shards added or removed.
+type shardRing struct {
+	by    shardKey
+	index int
+	count int
+	// nodeLabel hashes nodes by this label, e.g. their node pool, rather than by name
+	nodeLabel string
+}
+
+func parseShardRing(by string, index, count int, nodeLabel string) (*shardRing, error) {
+	if count <= 1 {
+		return nil, nil
+	}
+	switch shardKey(by) {
+	case shardByNamespace, shardByNode:
+	default:
+		return nil, fmt.Errorf("unknown shard key %q, expected namespace or node", by)
+	}
+	if index < 0 {
+		// StatefulSet pods are named after their ordinal
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("could not tell shard index from hostname: %w", err)
+		}
+		index, err = strconv.Atoi(hostname[strings.LastIndex(hostname, "-")+1:])
+		if err != nil {
+			return nil, fmt.Errorf("could not tell shard index from hostname %s: %w", hostname, err)
+		}
+	}
+	if index >= count {
+		return nil, fmt.Errorf("shard index %d is out of %d shards", index, count)
+	}
+	return &shardRing{by: shardKey(by), index: index, count: count, nodeLabel: nodeLabel}, nil
+}
+
+// owner returns the shard a key belongs to
+func (r *shardRing) owner(key string) int {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	hash := h.Sum64()
+
+	var owner int
+	var best uint64
+	for shard := range r.count {
+		if score := mix64(hash ^ mix64(uint64(shard))); shard == 0 || score > best {
+			owner, best = shard, score
+		}
+	}
+	return owner
+}
+
+// mix64 is the splitmix64 finalizer, FNV alone leaves scores of close shards correlated
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// nodeKey is what a node is hashed by
+func (r *shardRing) nodeKey(node *corev1.Node) string {
+	if r.nodeLabel != "" {
+		if value, ok := node.Labels[r.nodeLabel]; ok {
+			return value
+		}
+	}
+	return node.Name
+}
+
+// ownsNode tells whether this replica keeps the node in its cache. Sharding by namespace needs all nodes.
+func (r *shardRing) ownsNode(node *corev1.Node) bool {
+	return r.by != shardByNode || r.owner(r.nodeKey(node)) == r.index
+}
+
+// trimNode is a cache transform keeping only what is needed to tell the shard of nodes this replica does not own
+func (r *shardRing) trimNode(obj interface{}) (interface{}, error) {
+	node, ok := obj.(*corev1.Node)
+	if !ok || r.ownsNode(node) {
+		return obj, nil
+	}
+	trimmed := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: node.Name, ResourceVersion: node.ResourceVersion}}
+	if value, ok := node.Labels[r.nodeLabel]; ok && r.nodeLabel != "" {
+		trimmed.Labels = map[string]string{r.nodeLabel: value}
+	}
+	return trimmed, nil
+}
+
+// shardNodeReader reads nodes owned by this replica from the cache, and other nodes from the API server, so that a
+// request reaching the wrong replica is still sized correctly.
+type shardNodeReader struct {
+	client.Reader
+	ring      *shardRing
+	apiReader client.Reader
+}
+
+func (r *shardNodeReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if err := r.Reader.Get(ctx, key, obj, opts...); err != nil {
+		return err
+	}
+	node, ok := obj.(*corev1.Node)
+	if !ok || r.ring.ownsNode(node) {
+		return nil
+	}
+	return r.apiReader.Get(ctx, key, obj, opts...)
+}
+
+// List lists nodes from the API server when sharding by node, the cache not holding the labels nor the capacity of
+// nodes of other shards, e.g. for pods sized for the nodes of their zone
+func (r *shardNodeReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if _, ok := list.(*corev1.NodeList); ok && r.ring.by == shardByNode {
+		return r.apiReader.List(ctx, list, opts...)
+	}
+	return r.Reader.List(ctx, list, opts...)
+}
+
+// forwardDeadline is how long the replica owning a request gets to answer it: half of what sizing may take, for the
+// request to still be sized here when that replica hangs
+func forwardDeadline(timeout time.Duration) time.Duration {
+	return sizingDeadline(timeout) / 2
+}
+
+// shardedHandler handles requests of its shard, and forwards the others to the replica owning them
+type shardedHandler struct {
+	handler       admission.Handler
+	ring          *shardRing
+	decoder       admission.Decoder
+	nodeResolvers sizing.NodeResolverChain
+	nodeReader    client.Reader
+	// peerURL is formatted with the index of the shard to forward to
+	peerURL string
+	client  *http.Client
+	// timeout is the one of the webhook configuration, which forwarding and handling requests here must both fit in
+	timeout time.Duration
+}
+
+var _ admission.Handler = &shardedHandler{}
+
+// newShardedHandler forwards requests to peers presenting clientCertificate, when they verify client certificates
+func newShardedHandler(handler admission.Handler, ring *shardRing, decoder admission.Decoder, peerURL, caFile string,
+	clientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) (*shardedHandler, error) {
+	if !strings.Contains(peerURL, "%d") {
+		return nil, fmt.Errorf("peer URL %q has no %%d for the shard index", peerURL)
+	}
+	pool, err := loadCertPool(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read peer CA: %w", err)
+	}
+	return &shardedHandler{
+		handler: handler,
+		ring:    ring,
+		decoder: decoder,
+		peerURL: peerURL,
+		client: &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:              pool,
+			GetClientCertificate: clientCertificate,
+		}}},
+	}, nil
+}
+
+func (h *shardedHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	timeout := cmp.Or(h.timeout, defaultWebhookTimeout)
+	ctx, cancel := context.WithTimeout(ctx, sizingDeadline(timeout))
+	defer cancel()
+	key, err := h.key(ctx, req)
+	if err != nil {
+		// Sizing will fail the same way, and explain why
+		return h.handler.Handle(ctx, req)
+	}
+	owner := h.ring.owner(key)
+	if owner == h.ring.index {
+		shardRequestsTotal.WithLabelValues("owned").Inc()
+		return h.handler.Handle(ctx, req)
+	}
+
+	response, err := h.forward(ctx, owner, req, forwardDeadline(timeout))
+	if err != nil {
+		shardRequestsTotal.WithLabelValues("forward-failed").Inc()
+		zap.L().Warn("Could not forward request to its shard, handling it here",
+			zap.String("uid", string(req.UID)), zap.String("key", key), zap.Int("shard", owner), zap.Error(err))
+		return h.handler.Handle(ctx, req)
+	}
+	shardRequestsTotal.WithLabelValues("forwarded").Inc()
+	return response
+}
+
+// key returns what the request is hashed by
+func (h *shardedHandler) key(ctx context.Context, req admission.Request) (string, error) {
+	if h.ring.by == shardByNamespace {
+		return req.Namespace, nil
+	}
+	var pod corev1.Pod
+	if err := h.decoder.Decode(req, &pod); err != nil {
+		return "", err
+	}
+	nodeName, err := h.nodeResolvers.Resolve(ctx, &pod)
+	if err != nil || h.ring.nodeLabel == "" {
+		return nodeName, err
+	}
+	// Node pools are told by a label, which even nodes of other shards keep in the cache
+	var node corev1.Node
+	if err := h.nodeReader.Get(ctx, client.ObjectKey{Name: nodeName}, &node); err != nil {
+		return "", err
+	}
+	return h.ring.nodeKey(&node), nil
+}
+
+func (h *shardedHandler) forward(ctx context.Context, shard int, req admission.Request, deadline time.Duration) (admission.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, deadline)
+	defer cancel()
+	review := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: admissionv1.SchemeGroupVersion.String(), Kind: "AdmissionReview"},
+		Request:  &req.AdmissionRequest,
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		return admission.Response{}, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf(h.peerURL, shard), bytes.NewReader(body))
+	if err != nil {
+		return admission.Response{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	// The peer continues the trace of the request
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(httpReq.Header))
+	httpResp, err := h.client.Do(httpReq)
+	if err != nil {
+		return admission.Response{}, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return admission.Response{}, fmt.Errorf("shard %d answered %s", shard, httpResp.Status)
+	}
+
+	var answer admissionv1.AdmissionReview
+	if err := json.NewDecoder(httpResp.Body).Decode(&answer); err != nil {
+		return admission.Response{}, err
+	}
+	if answer.Response == nil {
+		return admission.Response{}, fmt.Errorf("shard %d sent no response", shard)
+	}
+	return admission.Response{AdmissionResponse: *answer.Response}, nil
+}
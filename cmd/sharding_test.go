@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"net/http"
+	"net/http/httptest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"time"
+)
+
+var _ = Describe("Sharding", Label("Sharding"), func() {
+	// nodeOfShard returns the name of a node hashed to the given shard
+	nodeOfShard := func(ring *shardRing, shard int) string {
+		for i := 0; ; i++ {
+			if name := fmt.Sprintf("node-%d", i); ring.owner(name) == shard {
+				return name
+			}
+		}
+	}
+
+	It("moves few keys when shards are added", func() {
+		before := &shardRing{count: 4}
+		after := &shardRing{count: 5}
+		var moved int
+		for i := range 1000 {
+			key := fmt.Sprintf("node-%d", i)
+			if owner := after.owner(key); owner != before.owner(key) {
+				Expect(owner).To(Equal(4))
+				moved++
+			}
+		}
+		Expect(moved).To(BeNumerically("~", 200, 50))
+	})
+
+	It("validates its configuration", func() {
+		Expect(parseShardRing("node", -1, 1, "")).To(BeNil())
+		_, err := parseShardRing("zone", 0, 2, "")
+		Expect(err).To(HaveOccurred())
+		_, err = parseShardRing("node", 2, 2, "")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("only keeps the name and pool of nodes of other shards", func() {
+		ring := &shardRing{by: shardByNode, count: 2, nodeLabel: "pool"}
+		node := fixtures.NodeWithCapacity("4", "8G")
+		node.Labels = map[string]string{"pool": nodeOfShard(ring, 1), "zone": "a"}
+		node.Name = "node-a"
+		trimmed, err := ring.trimNode(node)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(trimmed).To(Equal(&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:   "node-a",
+			Labels: map[string]string{"pool": node.Labels["pool"]},
+		}}))
+
+		node.Labels["pool"] = nodeOfShard(ring, 0)
+		Expect(ring.trimNode(node)).To(BeIdenticalTo(node))
+	})
+
+	It("reads nodes of other shards from the API server", func(ctx SpecContext) {
+		ring := &shardRing{by: shardByNode, count: 2}
+		node := fixtures.NodeWithCapacity("4", "8G")
+		node.Name = nodeOfShard(ring, 1)
+		trimmed, _ := ring.trimNode(node.DeepCopy())
+		reader := &shardNodeReader{
+			Reader:    fake.NewClientBuilder().WithObjects(trimmed.(*corev1.Node)).Build(),
+			ring:      ring,
+			apiReader: fake.NewClientBuilder().WithObjects(node).Build(),
+		}
+		var got corev1.Node
+		Expect(reader.Get(ctx, client.ObjectKeyFromObject(node), &got)).To(Succeed())
+		Expect(got.Status.Capacity).To(HaveKey(corev1.ResourceMemory))
+	})
+
+	It("sizes pods on nodes of other shards from their full capacity", func(ctx SpecContext) {
+		ring := &shardRing{by: shardByNode, count: 2}
+		node := fixtures.NodeWithCapacity("4", "8G")
+		node.Name = nodeOfShard(ring, 1)
+		trimmed, _ := ring.trimNode(node.DeepCopy())
+		cached := fake.NewClientBuilder().WithObjects(trimmed.(*corev1.Node)).Build()
+		reader := &shardNodeReader{Reader: cached, ring: ring, apiReader: fake.NewClientBuilder().WithObjects(node).Build()}
+		// Both fake clients give the node the same resource version, as the cache and the API server would
+		var inCache corev1.Node
+		Expect(cached.Get(ctx, client.ObjectKeyFromObject(node), &inCache)).To(Succeed())
+		snapshots := sizing.NewNodeSnapshots()
+		snapshots.OnAdd(&inCache, true)
+
+		pod := fixtures.PinToNode(fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil)), node.Name)
+		pod.Annotations = map[string]string{"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.1"}
+		_, patch, err := sizing.New(reader, sizing.Options{NodeSnapshots: snapshots}).CreatePatch(ctx, pod, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patch).To(ContainElement(HaveField("Value", "400m")))
+	})
+
+	It("sizes pods for the nodes of their zone across shards", func(ctx SpecContext) {
+		ring := &shardRing{by: shardByNode, count: 2}
+		owned, other := fixtures.NodeWithCapacity("4", "8G"), fixtures.NodeWithCapacity("8", "16G")
+		owned.Name, other.Name = nodeOfShard(ring, 0), nodeOfShard(ring, 1)
+		for _, node := range []*corev1.Node{owned, other} {
+			node.Labels = map[string]string{corev1.LabelTopologyZone: "eu-west-1a"}
+		}
+		trimmed, _ := ring.trimNode(other.DeepCopy())
+		reader := &shardNodeReader{
+			Reader:    fake.NewClientBuilder().WithObjects(owned.DeepCopy(), trimmed.(*corev1.Node)).Build(),
+			ring:      ring,
+			apiReader: fake.NewClientBuilder().WithObjects(owned, other).Build(),
+		}
+
+		pod := fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil))
+		pod.Annotations = map[string]string{
+			"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.5",
+			sizing.TopologyAggregateAnnotation:                        "average",
+		}
+		pod.Spec.NodeSelector = map[string]string{corev1.LabelTopologyZone: "eu-west-1a"}
+		_, patch, err := sizing.New(reader, sizing.Options{}).CreatePatch(ctx, pod, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patch).To(ContainElement(HaveField("Value", "3")))
+	})
+
+	Describe("handling requests", func() {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		ring := &shardRing{by: shardByNode, count: 2}
+
+		var handler *shardedHandler
+		BeforeEach(func() {
+			node := fixtures.NodeWithCapacity("4", "8G")
+			node.Name = nodeOfShard(ring, 1)
+			peer := httptest.NewTLSServer(&webhook.Admission{Handler: &podSizingHandler{
+				sizer:   sizing.New(fake.NewClientBuilder().WithObjects(node).Build(), sizing.Options{}),
+				decoder: admission.NewDecoder(scheme),
+			}})
+			DeferCleanup(peer.Close)
+
+			handler = &shardedHandler{
+				// This replica knows no node, it can only size pods by forwarding them
+				handler: &podSizingHandler{
+					sizer:   sizing.New(fake.NewClientBuilder().Build(), sizing.Options{}),
+					decoder: admission.NewDecoder(scheme),
+				},
+				ring:    ring,
+				decoder: admission.NewDecoder(scheme),
+				peerURL: peer.URL + "/%d",
+				client:  peer.Client(),
+			}
+		})
+
+		requestFor := func(nodeName string) admission.Request {
+			pod := fixtures.PinToNode(fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+				corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil)), nodeName)
+			pod.Annotations = map[string]string{"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.1"}
+			return admissionRequestFor("Pod", pod)
+		}
+
+		It("forwards requests of other shards", func(ctx SpecContext) {
+			before := testutil.ToFloat64(shardRequestsTotal.WithLabelValues("forwarded"))
+			response := handler.Handle(ctx, requestFor(nodeOfShard(ring, 1)))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Patch).NotTo(BeEmpty())
+			Expect(testutil.ToFloat64(shardRequestsTotal.WithLabelValues("forwarded"))).To(Equal(before + 1))
+		})
+
+		It("handles requests of its own shard", func(ctx SpecContext) {
+			before := testutil.ToFloat64(shardRequestsTotal.WithLabelValues("owned"))
+			response := handler.Handle(ctx, requestFor(nodeOfShard(ring, 0)))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(testutil.ToFloat64(shardRequestsTotal.WithLabelValues("owned"))).To(Equal(before + 1))
+		})
+
+		It("handles requests itself when their shard is unreachable", func(ctx SpecContext) {
+			handler.peerURL = "https://127.0.0.1:1/%d"
+			before := testutil.ToFloat64(shardRequestsTotal.WithLabelValues("forward-failed"))
+			handler.Handle(ctx, requestFor(nodeOfShard(ring, 1)))
+			Expect(testutil.ToFloat64(shardRequestsTotal.WithLabelValues("forward-failed"))).To(Equal(before + 1))
+		})
+
+		It("handles requests itself in time when their shard never answers", func(ctx SpecContext) {
+			released := make(chan struct{})
+			hanging := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+				<-released
+			}))
+			// Cleanups run in reverse order, the server closes once its handler returned
+			DeferCleanup(hanging.Close)
+			DeferCleanup(func() { close(released) })
+			handler.peerURL, handler.client = hanging.URL+"/%d", hanging.Client()
+			handler.timeout = time.Second
+
+			before := testutil.ToFloat64(shardRequestsTotal.WithLabelValues("forward-failed"))
+			start := time.Now()
+			handler.Handle(ctx, requestFor(nodeOfShard(ring, 1)))
+			Expect(time.Since(start)).To(BeNumerically("<", sizingDeadline(handler.timeout)))
+			Expect(testutil.ToFloat64(shardRequestsTotal.WithLabelValues("forward-failed"))).To(Equal(before + 1))
+		})
+	})
+})
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/apis/v1alpha1"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+	"strings"
+	"time"
+)
+
+const reasonSizingOutdated = "NodeSpecificSizingOutdated"
+
+// sizingReconciler periodically sizes running pods again, with current node data and policies, and reports those whose
+// resources do not match anymore, e.g. after their node was replaced by a larger one. Policies may also have them
+// resized in place or evicted, see v1alpha1.DriftAction.
+type sizingReconciler struct {
+	client   client.Client
+	sizer    func() *sizing.Sizer
+	recorder record.EventRecorder
+	evictor  *podEvictor
+	// evictDaemonSetPods evicts outdated DaemonSet pods whatever their policy says, for clusters without in-place resize
+	evictDaemonSetPods bool
+	// reported holds the outdated resources last reported for every pod, which are only reported again when they change
+	reported map[types.UID][]resourceDrift
+}
+
+func newSizingReconciler(c client.Client, sizer func() *sizing.Sizer, recorder record.EventRecorder, evictor *podEvictor) *sizingReconciler {
+	return &sizingReconciler{client: c, sizer: sizer, recorder: recorder, evictor: evictor, reported: make(map[types.UID][]resourceDrift)}
+}
+
+func (sr *sizingReconciler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := sr.reconcile(ctx); err != nil {
+			zap.L().Warn("Could not reconcile pod sizing", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcile goes through every sized pod once
+func (sr *sizingReconciler) reconcile(ctx context.Context) error {
+	var pods corev1.PodList
+	if err := sr.client.List(ctx, &pods); err != nil {
+		return fmt.Errorf("could not list pods: %w", err)
+	}
+	seen := make(map[types.UID]bool, len(pods.Items))
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		seen[pod.UID] = true
+		if pod.Spec.NodeName == "" || pod.DeletionTimestamp != nil ||
+			pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if _, ok := pod.Annotations[sizing.AppliedResourcesAnnotation]; !ok {
+			continue
+		}
+		sr.reconcilePod(ctx, pod.DeepCopy())
+	}
+	// Pods that are gone are forgotten
+	for uid := range sr.reported {
+		if !seen[uid] {
+			delete(sr.reported, uid)
+		}
+	}
+	return nil
+}
+
+func (sr *sizingReconciler) reconcilePod(ctx context.Context, pod *corev1.Pod) {
+	ctx, cancel := context.WithTimeout(ctx, boundSizingTimeout)
+	defer cancel()
+	logger := zap.L().With(zap.String("namespace", pod.Namespace), zap.String("name", pod.Name), zap.String("node", pod.Spec.NodeName))
+	ctx = sizing.ContextWithLogger(ctx, logger)
+
+	result, err := sr.sizer().Size(ctx, pod)
+	if err != nil {
+		reconciledPodsTotal.WithLabelValues("failed").Inc()
+		logger.Warn("Could not size pod again", zap.Error(err))
+		return
+	}
+	drifts := driftFrom(result.AppliedResources(), pod)
+	if len(drifts) == 0 || result.DryRun() || result.Unconfigured() {
+		delete(sr.reported, pod.UID)
+		reconciledPodsTotal.WithLabelValues("current").Inc()
+		return
+	}
+	reconciledPodsTotal.WithLabelValues("outdated").Inc()
+	action := result.DriftAction()
+	if owner := metav1.GetControllerOf(pod); sr.evictDaemonSetPods && action == v1alpha1.DriftActionReport &&
+		owner != nil && owner.APIVersion == "apps/v1" && owner.Kind == "DaemonSet" {
+		action = v1alpha1.DriftActionEvict
+	}
+
+	if !slices.Equal(sr.reported[pod.UID], drifts) {
+		sr.reported[pod.UID] = drifts
+		var outdated []string
+		for _, drift := range drifts {
+			outdated = append(outdated, fmt.Sprintf("%s %s %s: now sized %s, has %q", drift.Container, drift.Property, drift.Resource, drift.Applied, drift.Actual))
+		}
+		logger.Info("Pod sizing is outdated", zap.Any("drift", drifts), zap.String("action", string(action)))
+		sr.recorder.Eventf(pod, corev1.EventTypeWarning, reasonSizingOutdated, "Pod sizing is outdated for node %s: %s",
+			result.NodeName(), strings.Join(outdated, "; "))
+	}
+
+	switch action {
+	case v1alpha1.DriftActionResize:
+		_, patch, err := sr.sizer().CreatePatch(ctx, pod, false)
+		if err == nil {
+			err = resizeInPlace(ctx, sr.client, pod, patch)
+		}
+		sr.acted(logger, pod, "resized", err)
+	case v1alpha1.DriftActionEvict:
+		sr.acted(logger, pod, "evicted", sr.evictor.evict(ctx, pod))
+	}
+}
+
+// acted counts what was done about an outdated pod, which is tried again on the next reconciliation when it was not
+// done, e.g. because a PodDisruptionBudget does not allow the eviction yet
+func (sr *sizingReconciler) acted(logger *zap.Logger, pod *corev1.Pod, outcome string, err error) {
+	switch {
+	case errors.Is(err, errEvictionThrottled):
+		reconciledPodsTotal.WithLabelValues("throttled").Inc()
+		return
+	case errors.Is(err, errDisruptionBudget):
+		reconciledPodsTotal.WithLabelValues("blocked").Inc()
+		logger.Info("Outdated pod is not evicted yet", zap.Error(err))
+		return
+	case err != nil:
+		reconciledPodsTotal.WithLabelValues("failed").Inc()
+		logger.Warn("Could not act on outdated pod sizing", zap.String("outcome", outcome), zap.Error(err))
+		return
+	}
+	reconciledPodsTotal.WithLabelValues(outcome).Inc()
+	delete(sr.reported, pod.UID)
+}
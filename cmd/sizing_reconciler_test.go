@@ -0,0 +1,107 @@
+package main
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/apis/v1alpha1"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+var _ = Describe("Reconciling pod sizing", Label("SizingReconciler"), func() {
+	var (
+		recorder *record.FakeRecorder
+		pod      *corev1.Pod
+	)
+
+	// The pod was sized for a node of 4G, which got replaced by one of 8G under the same name
+	BeforeEach(func() {
+		recorder = record.NewFakeRecorder(10)
+		pod = fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("400M")}, nil))
+		pod.Name, pod.Namespace, pod.UID = "sized", "default", "sized-uid"
+		pod.Spec.NodeName = "node-a"
+		pod.Annotations = map[string]string{
+			"node-specific-sizing.manomano.tech/request-memory-fraction": "0.1",
+			sizing.AppliedResourcesAnnotation:                            `{"a":{"requests":{"memory":"400M"}}}`,
+		}
+	})
+
+	reconcilerWith := func(onDrift v1alpha1.DriftAction) (*sizingReconciler, client.WithWatch) {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(v1alpha1.AddToScheme(scheme)).To(Succeed())
+		node := fixtures.NodeWithCapacity("4", "8G")
+		node.Name = "node-a"
+		policy := &v1alpha1.SizingPolicy{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Spec: v1alpha1.SizingPolicySpec{OnDrift: onDrift}}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node, pod, policy).Build()
+		sizer := sizing.New(c, sizing.Options{PolicyReader: c})
+		return newSizingReconciler(c, func() *sizing.Sizer { return sizer }, recorder, newPodEvictor(c, 0)), c
+	}
+
+	It("reports outdated pods once", func(ctx SpecContext) {
+		reconciler, _ := reconcilerWith(v1alpha1.DriftActionReport)
+		before := testutil.ToFloat64(reconciledPodsTotal.WithLabelValues("outdated"))
+		Expect(reconciler.reconcile(ctx)).To(Succeed())
+		Expect(recorder.Events).To(Receive(Equal(
+			`Warning NodeSpecificSizingOutdated Pod sizing is outdated for node node-a: a requests memory: now sized 800M, has "400M"`)))
+
+		Expect(reconciler.reconcile(ctx)).To(Succeed())
+		Expect(recorder.Events).To(BeEmpty())
+		Expect(testutil.ToFloat64(reconciledPodsTotal.WithLabelValues("outdated"))).To(Equal(before + 2))
+	})
+
+	It("leaves pods sized for their current node alone", func(ctx SpecContext) {
+		pod.Spec.Containers[0].Resources.Requests[corev1.ResourceMemory] = resource.MustParse("800M")
+		reconciler, _ := reconcilerWith(v1alpha1.DriftActionResize)
+		before := testutil.ToFloat64(reconciledPodsTotal.WithLabelValues("current"))
+		Expect(reconciler.reconcile(ctx)).To(Succeed())
+		Expect(recorder.Events).To(BeEmpty())
+		Expect(testutil.ToFloat64(reconciledPodsTotal.WithLabelValues("current"))).To(Equal(before + 1))
+	})
+
+	It("resizes outdated pods in place when their policy says so", func(ctx SpecContext) {
+		reconciler, c := reconcilerWith(v1alpha1.DriftActionResize)
+		Expect(reconciler.reconcile(ctx)).To(Succeed())
+		var stored corev1.Pod
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(pod), &stored)).To(Succeed())
+		Expect(stored.Spec.Containers[0].Resources.Requests.Memory().String()).To(Equal("800M"))
+		Expect(stored.Annotations[sizing.AppliedResourcesAnnotation]).To(ContainSubstring("800M"))
+	})
+
+	It("resizes as our field manager", func(ctx SpecContext) {
+		reconciler, c := reconcilerWith(v1alpha1.DriftActionResize)
+		funcs, managers := recordFieldManagers()
+		reconciler.client = interceptor.NewClient(c, funcs)
+		Expect(reconciler.reconcile(ctx)).To(Succeed())
+		Expect(*managers).To(Equal([]string{fieldManager, fieldManager}))
+	})
+
+	It("evicts outdated pods when their policy says so", func(ctx SpecContext) {
+		reconciler, c := reconcilerWith(v1alpha1.DriftActionEvict)
+		Expect(reconciler.reconcile(ctx)).To(Succeed())
+		err := c.Get(ctx, client.ObjectKeyFromObject(pod), &corev1.Pod{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("evicts outdated DaemonSet pods when told to, whatever their policy says", func(ctx SpecContext) {
+		pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "DaemonSet", Name: "agent", Controller: ptr.To(true)}}
+		reconciler, c := reconcilerWith(v1alpha1.DriftActionReport)
+		reconciler.evictDaemonSetPods = true
+		Expect(reconciler.reconcile(ctx)).To(Succeed())
+		err := c.Get(ctx, client.ObjectKeyFromObject(pod), &corev1.Pod{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"strings"
+)
+
+const reasonSizingMismatch = "NodeSpecificSizingMismatch"
+
+// sizingVerifier checks that pods get created with the resources we patched them with. Webhooks called after us and
+// apiserver defaulting may change them, which drift detection alone would take for the pod starting that way.
+type sizingVerifier struct {
+	recorder record.EventRecorder
+}
+
+var _ toolscache.ResourceEventHandler = &sizingVerifier{}
+
+func (sv *sizingVerifier) OnAdd(obj interface{}, isInInitialList bool) {
+	pod, ok := obj.(*corev1.Pod)
+	// Pods listed on startup were created long ago, possibly by a previous version of the webhook
+	if !ok || isInInitialList {
+		return
+	}
+	sv.verify(pod)
+}
+
+func (sv *sizingVerifier) OnUpdate(interface{}, interface{}) {}
+
+func (sv *sizingVerifier) OnDelete(interface{}) {}
+
+func (sv *sizingVerifier) verify(pod *corev1.Pod) {
+	applied, err := sizing.AppliedResourcesFromAnnotations(pod.Annotations)
+	if err != nil {
+		zap.L().Warn("Cannot verify pod sizing", zap.String("namespace", pod.Namespace), zap.String("name", pod.Name), zap.Error(err))
+		return
+	}
+	if applied == nil {
+		return
+	}
+
+	drifts := driftFrom(applied, pod)
+	if len(drifts) == 0 {
+		sizingVerificationsTotal.WithLabelValues("match").Inc()
+		return
+	}
+	sizingVerificationsTotal.WithLabelValues("mismatch").Inc()
+
+	zap.L().Warn("Pod was created with other resources than sized",
+		zap.String("namespace", pod.Namespace),
+		zap.String("name", pod.Name),
+		zap.Any("drift", drifts),
+		zap.Strings("managers", resourceManagers(pod)))
+
+	var mismatches []string
+	for _, drift := range drifts {
+		mismatches = append(mismatches, fmt.Sprintf("%s %s %s: sized %s, got %q", drift.Container, drift.Property, drift.Resource, drift.Applied, drift.Actual))
+	}
+	sv.recorder.Eventf(pod, corev1.EventTypeWarning, reasonSizingMismatch, "Pod was created with other resources than sized: %s", strings.Join(mismatches, "; "))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("Verifying sizing", Label("SizingVerifier"), func() {
+	var (
+		recorder *record.FakeRecorder
+		verifier *sizingVerifier
+	)
+
+	BeforeEach(func() {
+		recorder = record.NewFakeRecorder(10)
+		verifier = &sizingVerifier{recorder: recorder}
+	})
+
+	createdPod := func(memoryRequest string) *corev1.Pod {
+		pod := fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceMemory: resource.MustParse(memoryRequest)}, nil))
+		pod.Annotations = map[string]string{
+			sizing.AppliedResourcesAnnotation: `{"a":{"requests":{"memory":"200M"}}}`,
+		}
+		return pod
+	}
+
+	It("counts pods created as sized", func() {
+		before := testutil.ToFloat64(sizingVerificationsTotal.WithLabelValues("match"))
+		verifier.OnAdd(createdPod("200M"), false)
+		Expect(testutil.ToFloat64(sizingVerificationsTotal.WithLabelValues("match"))).To(Equal(before + 1))
+		Expect(recorder.Events).To(BeEmpty())
+	})
+
+	It("reports pods created with other resources", func() {
+		before := testutil.ToFloat64(sizingVerificationsTotal.WithLabelValues("mismatch"))
+		verifier.OnAdd(createdPod("256Mi"), false)
+		Expect(testutil.ToFloat64(sizingVerificationsTotal.WithLabelValues("mismatch"))).To(Equal(before + 1))
+		Expect(recorder.Events).To(Receive(Equal(
+			`Warning NodeSpecificSizingMismatch Pod was created with other resources than sized: a requests memory: sized 200M, got "256Mi"`)))
+	})
+
+	It("leaves pods that existed on startup alone", func() {
+		verifier.OnAdd(createdPod("256Mi"), true)
+		Expect(recorder.Events).To(BeEmpty())
+	})
+})
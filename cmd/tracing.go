@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var tracer = otel.Tracer("github.com/ManoManoTech/kubernetes-node-specific-sizing/cmd")
+
+// setupTracing exports spans over OTLP, configured by the standard OTEL_EXPORTER_OTLP_* variables, sampling the given
+// ratio of requests the API server did not already decide to trace. The returned function flushes remaining spans.
+func setupTracing(ctx context.Context, sampleRatio float64) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("problem creating OTLP exporter: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("node-specific-sizing"))),
+	)
+	otel.SetTracerProvider(provider)
+	// The API server propagates W3C trace context when its own tracing is enabled
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// traced records a span for every request handler serves, continuing the trace of the caller if any
+func traced(path string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, "serve", trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPRoute(path), attribute.Int64("http.request.body.size", r.ContentLength)))
+		defer span.End()
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// endAdmissionSpan ends a span around an admission handler, recording how the request was answered
+func endAdmissionSpan(span trace.Span, response admission.Response) {
+	span.SetAttributes(attribute.Bool("allowed", response.Allowed), attribute.Int("patches", len(response.Patches)))
+	if response.Result != nil && response.Result.Code >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, response.Result.Message)
+	}
+	span.End()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"net/http"
+	"net/http/httptest"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"strings"
+	"sync"
+)
+
+// spanExporter records spans of every test. Tracers obtained before a TracerProvider is set only delegate to the first
+// one, which is hence set once.
+var spanExporter = sync.OnceValue(func() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return exporter
+})
+
+var _ = Describe("Tracing admission requests", Label("Tracing"), func() {
+	var spans *tracetest.InMemoryExporter
+	BeforeEach(func() {
+		spans = spanExporter()
+		spans.Reset()
+	})
+
+	// attributes returns the attributes of the ended span of the given name
+	attributes := func(name string) map[attribute.Key]any {
+		for _, span := range spans.GetSpans() {
+			if span.Name == name {
+				result := make(map[attribute.Key]any)
+				for _, kv := range span.Attributes {
+					result[kv.Key] = kv.Value.AsInterface()
+				}
+				return result
+			}
+		}
+		Fail("no span named " + name)
+		return nil
+	}
+
+	It("records the sizing of a pod", func(ctx SpecContext) {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		node := fixtures.NodeWithCapacity("4", "8G")
+		node.Name = "node-a"
+		pod := fixtures.PinToNode(fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil)), "node-a")
+		pod.Namespace = "team"
+		pod.Annotations = map[string]string{"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.1"}
+		handler := &podSizingHandler{
+			sizer:   sizing.New(fake.NewClientBuilder().WithObjects(node).Build(), sizing.Options{}),
+			decoder: admission.NewDecoder(scheme),
+		}
+
+		req := admissionRequestFor("Pod", pod)
+		req.Namespace = pod.Namespace
+		handler.Handle(ctx, req)
+		Expect(attributes("mutate")).To(HaveKeyWithValue(attribute.Key("namespace"), "team"))
+		Expect(attributes("mutate")).To(HaveKeyWithValue(attribute.Key("allowed"), true))
+		Expect(attributes("Size")).To(HaveKeyWithValue(attribute.Key("node"), "node-a"))
+		Expect(attributes("Size")).To(HaveKeyWithValue(attribute.Key("containers"), int64(1)))
+		Expect(attributes("Size")).To(HaveKeyWithValue(attribute.Key("patches"), int64(1)))
+		Expect(attributes("CreatePatch")).To(HaveKey(attribute.Key("operations")))
+	})
+
+	It("continues the trace of the API server", func() {
+		traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+		request := httptest.NewRequest(http.MethodPost, "/mutate", strings.NewReader("{}"))
+		request.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+		traced("/mutate", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(httptest.NewRecorder(), request)
+		Expect(spans.GetSpans()).To(HaveLen(1))
+		Expect(spans.GetSpans()[0].SpanContext.TraceID().String()).To(Equal(traceID))
+	})
+})
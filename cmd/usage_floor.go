@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	"math"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+	"sync"
+	"time"
+)
+
+// usageKey identifies a container across pod generations: pods of a given controller on a given node get replaced,
+// but keep running the same containers with similar usage.
+type usageKey struct {
+	owner     types.UID
+	node      string
+	container string
+}
+
+type usageSample struct {
+	at    time.Time
+	usage corev1.ResourceList
+}
+
+// usageTracker samples the metrics API to keep a window of observed usage per container. Its floors keep the
+// sizing of containers above what they have recently been observed to use, which fractions alone can't guarantee
+// on small nodes.
+type usageTracker struct {
+	// metricsReader must not be backed by a cache, the metrics API does not support watches
+	metricsReader client.Reader
+	podReader     client.Reader
+	window        time.Duration
+	// percentile is in ]0, 100]
+	percentile float64
+
+	mu      sync.Mutex
+	samples map[usageKey][]usageSample
+	// owners counts the containers sampled by controller, for pods of others to be told apart without their node
+	owners map[types.UID]int
+}
+
+var _ sizing.ContainerFloorSource = &usageTracker{}
+var _ sizing.ContainerFloorMatcher = &usageTracker{}
+
+func newUsageTracker(metricsReader, podReader client.Reader, window time.Duration, percentile float64) *usageTracker {
+	return &usageTracker{
+		metricsReader: metricsReader,
+		podReader:     podReader,
+		window:        window,
+		percentile:    percentile,
+		samples:       make(map[usageKey][]usageSample),
+		owners:        make(map[types.UID]int),
+	}
+}
+
+// Run samples usage every interval until the context is done
+func (ut *usageTracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := ut.sample(ctx, time.Now()); err != nil {
+			zap.L().Warn("Could not sample container usage", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (ut *usageTracker) sample(ctx context.Context, now time.Time) error {
+	var podMetrics metricsv1beta1.PodMetricsList
+	if err := ut.metricsReader.List(ctx, &podMetrics, client.MatchingLabels{sizing.EnabledLabel: "true"}); err != nil {
+		return fmt.Errorf("could not list pod metrics: %w", err)
+	}
+
+	for _, pm := range podMetrics.Items {
+		// Metrics don't tell which node or controller the pod belongs to, so the pod itself is needed
+		var pod corev1.Pod
+		if err := ut.podReader.Get(ctx, client.ObjectKey{Namespace: pm.Namespace, Name: pm.Name}, &pod); err != nil {
+			continue
+		}
+		owner := metav1.GetControllerOf(&pod)
+		if owner == nil || pod.Spec.NodeName == "" {
+			continue
+		}
+		for _, ctn := range pm.Containers {
+			ut.record(usageKey{owner: owner.UID, node: pod.Spec.NodeName, container: ctn.Name}, usageSample{at: now, usage: ctn.Usage})
+		}
+	}
+
+	ut.expire(now)
+	return nil
+}
+
+func (ut *usageTracker) record(key usageKey, sample usageSample) {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	if _, ok := ut.samples[key]; !ok {
+		ut.owners[key.owner]++
+	}
+	ut.samples[key] = append(ut.samples[key], sample)
+}
+
+// expire forgets samples that fell out of the window, as well as containers that have not been seen within it
+func (ut *usageTracker) expire(now time.Time) {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	for key, samples := range ut.samples {
+		samples = slices.DeleteFunc(samples, func(s usageSample) bool { return now.Sub(s.at) > ut.window })
+		if len(samples) == 0 {
+			delete(ut.samples, key)
+			if ut.owners[key.owner]--; ut.owners[key.owner] == 0 {
+				delete(ut.owners, key.owner)
+			}
+		} else {
+			ut.samples[key] = samples
+		}
+	}
+}
+
+// MayHaveFloors tells whether containers of the controller of the pod have been sampled, on any node
+func (ut *usageTracker) MayHaveFloors(pod *corev1.Pod) bool {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return false
+	}
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	return ut.owners[owner.UID] > 0
+}
+
+// Floors returns, by container name, the observed usage percentile as a minimum
+func (ut *usageTracker) Floors(pod *corev1.Pod, nodeName string) map[string]*rps.ResourceProperties {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return nil
+	}
+
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	result := make(map[string]*rps.ResourceProperties)
+	for ctn := range sizing.LongRunningContainers(pod) {
+		samples := ut.samples[usageKey{owner: owner.UID, node: nodeName, container: ctn.Name}]
+		if len(samples) == 0 {
+			continue
+		}
+
+		valuesByResource := make(map[corev1.ResourceName][]float64)
+		for _, s := range samples {
+			for name, qty := range s.usage {
+				valuesByResource[name] = append(valuesByResource[name], qty.AsApproximateFloat64())
+			}
+		}
+
+		floor := rps.New()
+		for name, values := range valuesByResource {
+			floor.BindPropertyFloat(rps.ResourceQuantity, rps.ResourcePodMinimum, name, percentile(values, ut.percentile))
+		}
+		result[ctn.Name] = floor
+	}
+	return result
+}
+
+// percentile uses the nearest-rank method. It sorts values in place.
+func percentile(values []float64, p float64) float64 {
+	slices.Sort(values)
+	rank := int(math.Ceil(p / 100 * float64(len(values))))
+	return values[max(rank, 1)-1]
+}
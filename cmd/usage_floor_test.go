@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"time"
+)
+
+var _ = Describe("Observed usage floors", Label("UsageFloor"), func() {
+	It("computes nearest-rank percentiles", func() {
+		Expect(percentile([]float64{5, 1, 4, 2, 3, 6, 7, 8, 9, 10}, 90)).To(Equal(9.0))
+		Expect(percentile([]float64{3}, 90)).To(Equal(3.0))
+		Expect(percentile([]float64{1, 2}, 1)).To(Equal(1.0))
+	})
+
+	Describe("sampling the metrics API", func() {
+		owner := metav1.OwnerReference{APIVersion: "apps/v1", Kind: "DaemonSet", Name: "agent", UID: "ds-uid", Controller: ptr.To(true)}
+		labels := map[string]string{sizing.EnabledLabel: "true"}
+
+		var tracker *usageTracker
+		now := time.Now()
+
+		BeforeEach(func(ctx SpecContext) {
+			scheme := runtime.NewScheme()
+			Expect(corev1.AddToScheme(scheme)).To(Succeed())
+			Expect(metricsv1beta1.AddToScheme(scheme)).To(Succeed())
+
+			running := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "agent-abcde", Labels: labels, OwnerReferences: []metav1.OwnerReference{owner}},
+				Spec:       corev1.PodSpec{NodeName: "node-a"},
+			}
+			usage := &metricsv1beta1.PodMetrics{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "agent-abcde", Labels: labels},
+				Containers: []metricsv1beta1.ContainerMetrics{{
+					Name:  "agent",
+					Usage: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("300M")},
+				}},
+			}
+			reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(running, usage).Build()
+
+			tracker = newUsageTracker(reader, reader, time.Hour, 90)
+			Expect(tracker.sample(ctx, now)).To(Succeed())
+		})
+
+		It("floors replacement pods of the same controller on the same node", func() {
+			replacement := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{owner}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "agent"}}},
+			}
+			floors := tracker.Floors(replacement, "node-a")
+			Expect(floors).To(HaveKey("agent"))
+			Expect(fixtures.BoundValue(floors["agent"], rps.ResourcePodMinimum, corev1.ResourceMemory)).To(BeNumerically("~", 300e6))
+
+			Expect(tracker.Floors(replacement, "node-b")).To(BeEmpty())
+		})
+
+		It("tells pods of controllers it has not sampled apart", func() {
+			Expect(tracker.MayHaveFloors(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{owner}}})).To(BeTrue())
+			other := metav1.OwnerReference{APIVersion: "apps/v1", Kind: "DaemonSet", Name: "other", UID: "other-uid", Controller: ptr.To(true)}
+			Expect(tracker.MayHaveFloors(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{other}}})).To(BeFalse())
+			Expect(tracker.MayHaveFloors(&corev1.Pod{})).To(BeFalse())
+		})
+
+		It("forgets samples outside of the window", func() {
+			tracker.expire(now.Add(2 * time.Hour))
+			Expect(tracker.samples).To(BeEmpty())
+			Expect(tracker.owners).To(BeEmpty())
+		})
+	})
+})
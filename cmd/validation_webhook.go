@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// annotationValidator rejects workloads carrying malformed sizing annotations at creation time, rather than letting
+// their pods fail admission later on, which is much harder to debug.
+type annotationValidator struct {
+	decoder admission.Decoder
+}
+
+var _ admission.Handler = &annotationValidator{}
+
+func (v *annotationValidator) Handle(_ context.Context, req admission.Request) admission.Response {
+	annotations, err := v.sizingAnnotations(req)
+	if err != nil {
+		zap.L().Warn("Could not decode raw object", zap.Any("kind", req.Kind), zap.Error(err))
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if err := sizing.ValidateAnnotations(annotations); err != nil {
+		zap.L().Info("Rejecting malformed sizing annotations",
+			zap.Any("kind", req.Kind),
+			zap.String("namespace", req.Namespace),
+			zap.String("name", req.Name),
+			zap.Error(err))
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}
+
+// sizingAnnotations returns the annotations that pods would be sized from: the object's own for pods, the pod
+// template's for workloads.
+func (v *annotationValidator) sizingAnnotations(req admission.Request) (map[string]string, error) {
+	switch req.Kind.Kind {
+	case "Pod":
+		var pod corev1.Pod
+		err := v.decoder.Decode(req, &pod)
+		return pod.Annotations, err
+	case "Deployment":
+		var deployment appsv1.Deployment
+		err := v.decoder.Decode(req, &deployment)
+		return deployment.Spec.Template.Annotations, err
+	case "DaemonSet":
+		var daemonSet appsv1.DaemonSet
+		err := v.decoder.Decode(req, &daemonSet)
+		return daemonSet.Spec.Template.Annotations, err
+	case "StatefulSet":
+		var statefulSet appsv1.StatefulSet
+		err := v.decoder.Decode(req, &statefulSet)
+		return statefulSet.Spec.Template.Annotations, err
+	}
+	return nil, fmt.Errorf("unsupported kind %s", req.Kind.Kind)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func admissionRequestFor(kind string, obj runtime.Object) admission.Request {
+	raw, err := json.Marshal(obj)
+	Expect(err).NotTo(HaveOccurred())
+	return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Kind: kind},
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+}
+
+var _ = Describe("Validating sizing annotations", Label("Validation"), func() {
+	scheme := runtime.NewScheme()
+	Expect(corev1.AddToScheme(scheme)).To(Succeed())
+	Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+	validator := &annotationValidator{decoder: admission.NewDecoder(scheme)}
+
+	daemonSetWith := func(annotations map[string]string) *appsv1.DaemonSet {
+		return &appsv1.DaemonSet{Spec: appsv1.DaemonSetSpec{Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+		}}}
+	}
+
+	It("allows well-formed annotations", func(ctx SpecContext) {
+		response := validator.Handle(ctx, admissionRequestFor("DaemonSet", daemonSetWith(map[string]string{
+			"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.1",
+		})))
+		Expect(response.Allowed).To(BeTrue())
+	})
+
+	DescribeTable("denies malformed annotations",
+		func(ctx SpecContext, annotations map[string]string, message string) {
+			response := validator.Handle(ctx, admissionRequestFor("DaemonSet", daemonSetWith(annotations)))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring(message))
+		},
+		Entry("fraction above 1", map[string]string{"node-specific-sizing.manomano.tech/request-cpu-fraction": "1.5"}, "cannot be > 1"),
+		Entry("unparsable quantity", map[string]string{"node-specific-sizing.manomano.tech/minimum-memory": "lots"}, "cannot be parsed as a quantity"),
+		Entry("minimum above maximum", map[string]string{
+			"node-specific-sizing.manomano.tech/minimum-cpu": "2",
+			"node-specific-sizing.manomano.tech/maximum-cpu": "1",
+		}, "cannot be above maximum"),
+	)
+
+	It("validates pods too", func(ctx SpecContext) {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			"node-specific-sizing.manomano.tech/limit-memory-fraction": "0",
+		}}}
+		Expect(validator.Handle(ctx, admissionRequestFor("Pod", pod)).Allowed).To(BeFalse())
+	})
+})
@@ -1,145 +1,254 @@
 package main
 
 import (
+	"cmp"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	mapset "github.com/deckarep/golang-set/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
-	"io/ioutil"
+	"gomodules.xyz/jsonpatch/v2"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/utils/ptr"
 	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"slices"
+	"strings"
 	"time"
 )
 
-var (
-	runtimeScheme = runtime.NewScheme()
-	codecs        = serializer.NewCodecFactory(runtimeScheme)
-	deserializer  = codecs.UniversalDeserializer()
-)
+// podSizingHandler sizes pods on admission
+type podSizingHandler struct {
+	sizer   *sizing.Sizer
+	decoder admission.Decoder
+	// events is optional
+	events *sizingEvents
+	// dryRun computes and reports sizing for every pod, without applying it
+	dryRun bool
+	// failureMode tells how pods that cannot be sized are answered, failureModeError when empty
+	failureMode failureMode
+	// excludedNamespaces are left alone, optional
+	excludedNamespaces mapset.Set[string]
+	// guard leaves alone pods the webhook configuration should not have sent, optional
+	guard *admissionGuard
+	// decisions keeps the last sizing decisions, optional
+	decisions *decisionLog
+	// audit records every mutation, optional
+	audit *auditLog
+	// sink posts sizing decisions to an HTTP endpoint, optional
+	sink *decisionSink
+	// sizeOnceBound admits pods whose node is unknown unsized, marked for boundPodSizer. Pods listing several candidate
+	// nodes are whatever it says when the Sizer is given sizing.CandidatesBound.
+	sizeOnceBound bool
+	// timeout is the timeoutSeconds of the webhook configuration, defaultWebhookTimeout when 0
+	timeout time.Duration
+	// annotationDomain is read like sizing.AnnotationPrefix, see sizing.Options
+	annotationDomain string
+}
+
+// defaultWebhookTimeout matches the timeoutSeconds of the webhook configurations of deploy/
+const defaultWebhookTimeout = 2 * time.Second
 
-type WebhookServer struct {
-	server *http.Server
+// sizingDeadline is how long sizing may take for the API server, which gives up after timeout, to still be answered:
+// a tenth of the timeout is kept to write the response. Steps of sizing get a share of it, see sizing.BudgetExceededError.
+func sizingDeadline(timeout time.Duration) time.Duration {
+	return timeout - timeout/10
 }
 
-// Webhook Server parameters
-type WhSvrParameters struct {
-	port           int    // webhook server port
-	certFile       string // path to the x509 certificate for https
-	keyFile        string // path to the x509 private key matching `CertFile`
-	sidecarCfgFile string // path to sidecar injector configuration file
+var _ admission.Handler = &podSizingHandler{}
+
+// Handle is the main mutation process
+func (h *podSizingHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	ctx, span := tracer.Start(ctx, "mutate", trace.WithAttributes(
+		attribute.String("namespace", req.Namespace),
+		attribute.String("operation", string(req.Operation))))
+	start := time.Now()
+	decision := admissionDecision{annotations: map[string]string{}, patches: []sizing.ResourcePatch{}}
+	response := h.mutate(ctx, req, &decision)
+	endAdmissionSpan(span, response)
+	recordAdmission(response)
+	decision.log(req, response, time.Since(start))
+	return response
 }
 
-type patchOperation struct {
-	Op    string      `json:"op"`
-	Path  string      `json:"path"`
-	Value interface{} `json:"value,omitempty"`
+// admissionDecision is what is known of an admission request once answered, logged at info level with the same fields
+// whatever the outcome, for dashboards to be built on logs
+type admissionDecision struct {
+	pod  string
+	node string
+	// annotations are the sizing annotations of the pod
+	annotations map[string]string
+	patches     []sizing.ResourcePatch
+	dryRun      bool
+	reason      sizing.FailureReason
 }
 
-// main mutation process
-func (whsvr *WebhookServer) mutate(ctx context.Context, ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
-	req := ar.Request
+func (d *admissionDecision) log(req admission.Request, response admission.Response, duration time.Duration) {
+	var code int32
+	var message string
+	if response.Result != nil {
+		code, message = response.Result.Code, response.Result.Message
+	}
+	zap.L().Info("Admission decision",
+		zap.String("uid", string(req.UID)),
+		zap.String("namespace", req.Namespace),
+		zap.String("pod", d.pod),
+		zap.String("operation", string(req.Operation)),
+		zap.String("node", d.node),
+		zap.Any("annotations", d.annotations),
+		zap.Any("patches", d.patches),
+		zap.Bool("dryRun", d.dryRun),
+		zap.String("outcome", admissionOutcome(response)),
+		zap.Int32("code", code),
+		zap.String("reason", string(d.reason)),
+		zap.String("message", message),
+		zap.Strings("warnings", response.Warnings),
+		zap.Duration("duration", duration))
+}
+
+func (h *podSizingHandler) mutate(ctx context.Context, req admission.Request, decision *admissionDecision) admission.Response {
+	ctx, cancelFn := context.WithTimeout(ctx, sizingDeadline(cmp.Or(h.timeout, defaultWebhookTimeout)))
+	defer cancelFn()
+	// Every log line of the request carries its UID, so that logs of concurrent admissions can be told apart
+	logger := zap.L().With(zap.String("uid", string(req.UID)), zap.String("namespace", req.Namespace))
+
+	if h.excludedNamespaces != nil && h.excludedNamespaces.Contains(req.Namespace) {
+		return admission.Allowed("namespace is excluded from sizing")
+	}
+	// Subresources, e.g. pods/ephemeralcontainers when debugging, carry the whole pod but may only change their own
+	// part of it. Ephemeral containers may not set resources anyway.
+	if req.SubResource != "" {
+		return admission.Allowed("subresources are not sized")
+	}
+	// Sizing an existing pod again would compound, its resources are derived from the ones it was created with. Such
+	// pods are watched by the drift detector instead.
+	if req.Operation != admissionv1.Create {
+		return admission.Allowed("only pods being created are sized")
+	}
+
 	var pod corev1.Pod
-	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
-		zap.L().Warn("Could not unmarshal raw object", zap.Any("raw", req.Object.Raw))
-		return &admissionv1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
+	if err := h.decoder.Decode(req, &pod); err != nil {
+		logger.Warn("Could not decode raw object", zap.Any("raw", req.Object.Raw), zap.Error(err))
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	decision.pod = cmp.Or(pod.Name, pod.GenerateName)
+	for key, value := range pod.Annotations {
+		if strings.HasPrefix(key, sizing.AnnotationPrefix) || h.annotationDomain != "" && strings.HasPrefix(key, h.annotationDomain+"/") {
+			decision.annotations[key] = value
+		}
+	}
+	logger = logger.With(zap.String("name", decision.pod))
+
+	if h.guard != nil {
+		if refusal := h.guard.refusal(req.Namespace, &pod); refusal != "" {
+			logger.Warn("Pod should not have been sent for sizing, check the webhook configuration", zap.String("reason", refusal))
+			return admission.Allowed(refusal)
 		}
 	}
 
-	zap.L().Info("AdmissionReview request",
+	logger.Debug("AdmissionReview request",
 		zap.Any("kind", req.Kind),
-		zap.String("namespace", req.Namespace),
-		zap.String("name", req.Name),
-		zap.Any("uid", req.UID),
 		zap.Any("operation", req.Operation),
 		zap.Any("userInfo", req.UserInfo))
+	ctx = sizing.ContextWithLogger(ctx, logger)
+
+	// Dry-run requests must not have side effects, the pod will not be created anyway
+	sideEffects := !ptr.Deref(req.DryRun, false)
+	dryRun := h.dryRun || !sideEffects
 
-	patchBytes, err := createPatch(ctx, &pod)
+	result, patch, err := h.sizer.CreatePatch(ctx, &pod, dryRun)
 	if err != nil {
-		zap.L().Debug("Could not create patch", zap.Error(err))
-		return &admissionv1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
+		reason := sizing.FailureReasonOf(err)
+		decision.reason = reason
+		logger.Debug("Could not create patch", zap.String("reason", string(reason)), zap.Error(err))
+		if h.events != nil && sideEffects {
+			h.events.failed(&pod, err)
 		}
-	}
-
-	zap.L().Debug("AdmissionResponse", zap.String("patch", string(patchBytes)))
-	return &admissionv1.AdmissionResponse{
-		Allowed: true,
-		Patch:   patchBytes,
-		PatchType: func() *admissionv1.PatchType {
-			pt := admissionv1.PatchTypeJSONPatch
-			return &pt
-		}(),
-	}
-}
-
-// Serve method for webhook server
-func (whsvr *WebhookServer) serve(w http.ResponseWriter, r *http.Request) {
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(3*time.Second))
-	defer cancelFn()
-
-	var body []byte
-	if r.Body != nil {
-		if data, err := ioutil.ReadAll(r.Body); err == nil {
-			body = data
+		var nodeErr *sizing.NodeUnavailableError
+		var candidatesErr *sizing.CandidatesPendingError
+		if errors.As(err, &candidatesErr) || h.sizeOnceBound && errors.As(err, &nodeErr) {
+			return pendingSizing(&pod, sideEffects)
 		}
+		sizingFailuresTotal.WithLabelValues(string(reason)).Inc()
+		var conflictErr *sizing.SettingsConflictError
+		var enforcedErr *sizing.EnforcedSettingsError
+		if errors.As(err, &conflictErr) || errors.As(err, &enforcedErr) {
+			return withFailureReason(admission.Denied(err.Error()), reason)
+		}
+		switch h.failureMode {
+		case failureModeAllow:
+			return admission.Allowed("").WithWarnings(fmt.Sprintf("pod was not sized (%s): %s", reason, err))
+		case failureModeDeny:
+			return withFailureReason(admission.Denied(err.Error()), reason)
+		}
+		return withFailureReason(admission.Errored(failureCodes[reason], err), reason)
 	}
-	if len(body) == 0 {
-		zap.L().Warn("request error: empty body")
-		http.Error(w, "empty body", http.StatusBadRequest)
-		return
+	if result.Unconfigured() {
+		return admission.Allowed("no sizing settings apply to the pod")
 	}
-
-	// verify the content type is accurate
-	contentType := r.Header.Get("Content-Type")
-	if contentType != "application/json" {
-		zap.L().Warn(fmt.Sprintf("Content-Type=%s, expect application/json", contentType), zap.String("content-type", contentType))
-		http.Error(w, "invalid Content-Type, expect `application/json`", http.StatusUnsupportedMediaType)
-		return
+	logger = logger.With(zap.String("node", result.NodeName()))
+	decision.node, decision.dryRun = result.NodeName(), result.DryRun()
+	decision.patches = append(decision.patches, slices.Collect(result.Patches())...)
+	if dryRun {
+		logger.Debug("Dry run, resources are left as is")
 	}
-
-	var admissionResponse *admissionv1.AdmissionResponse
-	ar := admissionv1.AdmissionReview{}
-	if _, _, err := deserializer.Decode(body, nil, &ar); err != nil {
-		zap.L().Warn(fmt.Sprintf("Can't decode body: %v", err), zap.Error(err))
-		admissionResponse = &admissionv1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
-		}
-	} else {
-		admissionResponse = whsvr.mutate(ctx, &ar)
+	recordSizing(result)
+	if h.decisions != nil && sideEffects {
+		h.decisions.record(req.Namespace, cmp.Or(pod.Name, pod.GenerateName), result)
 	}
-
-	admissionReview := admissionv1.AdmissionReview{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: "admission.k8s.io/v1",
-			Kind:       "AdmissionReview",
-		},
+	if h.sink != nil && sideEffects {
+		h.sink.send(string(req.UID), newDecision(req.Namespace, cmp.Or(pod.Name, pod.GenerateName), result))
 	}
-	if admissionResponse != nil {
-		admissionReview.Response = admissionResponse
-		if ar.Request != nil {
-			admissionReview.Response.UID = ar.Request.UID
+	if h.audit != nil && sideEffects && len(patch) > 0 {
+		err := h.audit.record(auditEntry{
+			Time:       time.Now(),
+			RequestUID: string(req.UID),
+			UID:        string(pod.UID),
+			Namespace:  req.Namespace,
+			Name:       cmp.Or(pod.Name, pod.GenerateName),
+			Node:       result.NodeName(),
+			DryRun:     result.DryRun(),
+			Patch:      patch,
+		})
+		if err != nil {
+			logger.Error("Could not record mutation in the audit log", zap.Error(err))
 		}
 	}
-
-	resp, err := json.Marshal(admissionReview)
-	if err != nil {
-		zap.L().Error(fmt.Sprintf("Can't encode response: %v", err), zap.Error(err))
-		http.Error(w, fmt.Sprintf("could not encode response: %v", err), http.StatusInternalServerError)
+	if h.events != nil && sideEffects {
+		h.events.sized(&pod, result)
 	}
 
-	if _, err := w.Write(resp); err != nil {
-		zap.L().Error(fmt.Sprintf("Can't write response: %v", err), zap.Error(err))
-		http.Error(w, fmt.Sprintf("could not write response: %v", err), http.StatusInternalServerError)
+	logger.Debug("AdmissionResponse", zap.Any("patch", patch))
+	return admission.Patched("", patch...).WithWarnings(result.Warnings()...)
+}
+
+// failureCodes are the status codes pods that cannot be sized are answered with when failureModeError is used
+var failureCodes = map[sizing.FailureReason]int32{
+	sizing.FailureNodeNotFound:        http.StatusServiceUnavailable,
+	sizing.FailureAnnotationInvalid:   http.StatusUnprocessableEntity,
+	sizing.FailureBudgetComputeFailed: http.StatusInternalServerError,
+}
+
+// withFailureReason sets the reason of a refusal, which API clients get along with its message
+func withFailureReason(response admission.Response, reason sizing.FailureReason) admission.Response {
+	response.Result.Reason = metav1.StatusReason(reason)
+	return response
+}
+
+// pendingSizing admits a pod as it is, marked to be sized once bound to a node
+func pendingSizing(pod *corev1.Pod, sideEffects bool) admission.Response {
+	if !sideEffects {
+		return admission.Allowed("pod would be sized once bound to a node")
+	}
+	boundSizingsTotal.WithLabelValues("deferred").Inc()
+	if pod.Annotations == nil {
+		return admission.Patched("pod is sized once bound to a node", jsonpatch.NewOperation("add", "/metadata/annotations",
+			map[string]string{sizing.PendingSizingAnnotation: "true"}))
 	}
+	return admission.Patched("pod is sized once bound to a node", jsonpatch.NewOperation("add", pendingSizingPath, "true"))
 }
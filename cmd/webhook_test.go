@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	mapset "github.com/deckarep/golang-set/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"maps"
+	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"slices"
+	"time"
+)
+
+var _ = Describe("Handling pod admission", Label("Webhook"), func() {
+	scheme := runtime.NewScheme()
+	Expect(corev1.AddToScheme(scheme)).To(Succeed())
+
+	node := fixtures.NodeWithCapacity("4", "8G")
+	node.Name = "node-a"
+	sizer := sizing.New(fake.NewClientBuilder().WithObjects(node).Build(), sizing.Options{})
+
+	var pod *corev1.Pod
+	BeforeEach(func() {
+		pod = fixtures.PinToNode(fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil)), "node-a")
+		pod.Annotations = map[string]string{"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.1"}
+	})
+
+	paths := func(response admission.Response) []string {
+		var result []string
+		for _, op := range response.Patches {
+			result = append(result, op.Path)
+		}
+		return result
+	}
+
+	It("patches resources", func(ctx SpecContext) {
+		handler := &podSizingHandler{sizer: sizer, decoder: admission.NewDecoder(scheme)}
+		response := handler.Handle(ctx, admissionRequestFor("Pod", pod))
+		Expect(response.Allowed).To(BeTrue())
+		Expect(paths(response)).To(ContainElement("/spec/containers/0/resources/requests/cpu"))
+	})
+
+	It("counts requests by outcome", func(ctx SpecContext) {
+		handler := &podSizingHandler{sizer: sizer, decoder: admission.NewDecoder(scheme)}
+		before := testutil.ToFloat64(admissionRequestsTotal.WithLabelValues("patched"))
+		handler.Handle(ctx, admissionRequestFor("Pod", pod))
+		Expect(testutil.ToFloat64(admissionRequestsTotal.WithLabelValues("patched"))).To(Equal(before + 1))
+
+		before = testutil.ToFloat64(admissionRequestsTotal.WithLabelValues("errored"))
+		handler.Handle(ctx, admissionRequestFor("Pod", fixtures.PinToNode(pod, "unknown")))
+		Expect(testutil.ToFloat64(admissionRequestsTotal.WithLabelValues("errored"))).To(Equal(before + 1))
+	})
+
+	It("answers before the webhook timeout, telling what was slow", func(ctx SpecContext) {
+		hanging := fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		}).Build()
+		slow := sizing.New(fake.NewClientBuilder().Build(), sizing.Options{NodeAPIReader: hanging})
+		handler := &podSizingHandler{sizer: slow, decoder: admission.NewDecoder(scheme), timeout: 500 * time.Millisecond}
+		start := time.Now()
+		response := handler.Handle(ctx, admissionRequestFor("Pod", fixtures.PinToNode(pod, "node-b")))
+		Expect(time.Since(start)).To(BeNumerically("<", 450*time.Millisecond))
+		Expect(response.Allowed).To(BeFalse())
+		Expect(response.Result.Message).To(ContainSubstring("node lookup did not complete within its budget"))
+	})
+
+	It("logs with the UID of the request", func(ctx SpecContext) {
+		core, logs := observer.New(zap.DebugLevel)
+		DeferCleanup(zap.ReplaceGlobals(zap.New(core)))
+		handler := &podSizingHandler{sizer: sizer, decoder: admission.NewDecoder(scheme)}
+		req := admissionRequestFor("Pod", pod)
+		req.UID = "42"
+		handler.Handle(ctx, req)
+
+		Expect(logs.FilterMessage("concluding patch process").All()).To(HaveLen(1))
+		for _, entry := range logs.All() {
+			Expect(entry.ContextMap()).To(HaveKeyWithValue("uid", "42"), entry.Message)
+		}
+		Expect(logs.FilterMessage("AdmissionResponse").All()[0].ContextMap()).To(HaveKeyWithValue("node", "node-a"))
+	})
+
+	It("keeps sizing decisions", func(ctx SpecContext) {
+		handler := &podSizingHandler{sizer: sizer, decoder: admission.NewDecoder(scheme), decisions: newDecisionLog(1)}
+		pod.GenerateName = "agent-"
+		handler.Handle(ctx, admissionRequestFor("Pod", pod))
+		Expect(handler.decisions.recent(0)).To(ConsistOf(HaveField("Name", "agent-")))
+	})
+
+	It("queues sizing decisions for the sink", func(ctx SpecContext) {
+		sink := newDecisionSink("http://sink.invalid", 1, 0)
+		handler := &podSizingHandler{sizer: sizer, decoder: admission.NewDecoder(scheme), sink: sink}
+		req := admissionRequestFor("Pod", pod)
+		req.UID = "42"
+		handler.Handle(ctx, req)
+		Expect(sink.queue).To(Receive(And(HaveField("RequestUID", "42"), HaveField("Node", "node-a"))))
+	})
+
+	It("logs one decision per admission at info level, with the same fields whatever the outcome", func(ctx SpecContext) {
+		core, logs := observer.New(zap.InfoLevel)
+		DeferCleanup(zap.ReplaceGlobals(zap.New(core)))
+		handler := &podSizingHandler{sizer: sizer, decoder: admission.NewDecoder(scheme)}
+		handler.Handle(ctx, admissionRequestFor("Pod", pod))
+		handler.Handle(ctx, admissionRequestFor("Pod", fixtures.PinToNode(pod.DeepCopy(), "unknown")))
+
+		entries := logs.All()
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Message).To(Equal("Admission decision"))
+		Expect(entries[0].ContextMap()).To(And(
+			HaveKeyWithValue("node", "node-a"),
+			HaveKeyWithValue("outcome", "patched"),
+			HaveKeyWithValue("annotations", HaveKeyWithValue("node-specific-sizing.manomano.tech/request-cpu-fraction", "0.1")),
+			HaveKeyWithValue("patches", ContainElement(HaveField("Resource", corev1.ResourceCPU))),
+		))
+		Expect(entries[1].ContextMap()).To(And(
+			HaveKeyWithValue("outcome", "errored"),
+			HaveKeyWithValue("reason", "NodeNotFound"),
+			HaveKeyWithValue("code", BeEquivalentTo(503)),
+		))
+		Expect(slices.Sorted(maps.Keys(entries[1].ContextMap()))).To(Equal(slices.Sorted(maps.Keys(entries[0].ContextMap()))))
+	})
+
+	It("records mutations in the audit log", func(ctx SpecContext) {
+		audit, err := openAuditLog(filepath.Join(GinkgoT().TempDir(), "audit.log"), 0, 0)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(audit.Close)
+		handler := &podSizingHandler{sizer: sizer, decoder: admission.NewDecoder(scheme), audit: audit}
+		handler.Handle(ctx, admissionRequestFor("Pod", pod))
+		Expect(audit.previous).NotTo(BeEmpty())
+	})
+
+	It("only reports sizing in dry-run mode", func(ctx SpecContext) {
+		handler := &podSizingHandler{sizer: sizer, decoder: admission.NewDecoder(scheme), dryRun: true}
+		response := handler.Handle(ctx, admissionRequestFor("Pod", pod))
+		Expect(response.Allowed).To(BeTrue())
+		Expect(paths(response)).To(Equal([]string{"/metadata/annotations/node-specific-sizing.manomano.tech~1status"}))
+		Expect(response.Patches[0].Value).To(ContainSubstring(`"dryRun":true`))
+	})
+
+	It("honors dry-run requests", func(ctx SpecContext) {
+		handler := &podSizingHandler{sizer: sizer, decoder: admission.NewDecoder(scheme)}
+		req := admissionRequestFor("Pod", pod)
+		req.DryRun = ptr.To(true)
+		response := handler.Handle(ctx, req)
+		Expect(response.Allowed).To(BeTrue())
+		Expect(paths(response)).NotTo(ContainElement("/spec/containers/0/resources/requests/cpu"))
+	})
+
+	It("leaves excluded namespaces alone", func(ctx SpecContext) {
+		handler := &podSizingHandler{sizer: sizer, decoder: admission.NewDecoder(scheme), excludedNamespaces: mapset.NewThreadUnsafeSet("kube-system")}
+		req := admissionRequestFor("Pod", pod)
+		req.Namespace = "kube-system"
+		response := handler.Handle(ctx, req)
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Patches).To(BeEmpty())
+	})
+
+	It("leaves pods the guard refuses alone", func(ctx SpecContext) {
+		handler := &podSizingHandler{sizer: sizer, decoder: admission.NewDecoder(scheme), guard: newAdmissionGuard("", "kube-system", true)}
+		response := handler.Handle(ctx, admissionRequestFor("Pod", pod))
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Patches).To(BeEmpty())
+		Expect(response.Result.Message).To(ContainSubstring(sizing.EnabledLabel))
+	})
+
+	It("leaves ephemeral containers updates alone", func(ctx SpecContext) {
+		handler := &podSizingHandler{sizer: sizer, decoder: admission.NewDecoder(scheme)}
+		req := admissionRequestFor("Pod", pod)
+		req.Operation = admissionv1.Update
+		req.SubResource = "ephemeralcontainers"
+		response := handler.Handle(ctx, req)
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Patches).To(BeEmpty())
+	})
+
+	It("only sizes pods being created", func(ctx SpecContext) {
+		handler := &podSizingHandler{sizer: sizer, decoder: admission.NewDecoder(scheme)}
+		req := admissionRequestFor("Pod", pod)
+		req.Operation = admissionv1.Update
+		response := handler.Handle(ctx, req)
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Patches).To(BeEmpty())
+	})
+
+	It("leaves pods without sizing settings alone", func(ctx SpecContext) {
+		pod.Annotations = nil
+		fixtures.PinToNode(pod, "unknown")
+		handler := &podSizingHandler{sizer: sizer, decoder: admission.NewDecoder(scheme)}
+		response := handler.Handle(ctx, admissionRequestFor("Pod", pod))
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Patches).To(BeEmpty())
+	})
+
+	It("marks pods whose node is unknown to be sized once bound", func(ctx SpecContext) {
+		fixtures.PinToNode(pod, "unknown")
+		handler := &podSizingHandler{sizer: sizer, decoder: admission.NewDecoder(scheme), sizeOnceBound: true}
+		response := handler.Handle(ctx, admissionRequestFor("Pod", pod))
+		Expect(response.Allowed).To(BeTrue())
+		Expect(paths(response)).To(Equal([]string{pendingSizingPath}))
+	})
+
+	It("marks pods listing several candidate nodes to be sized once bound", func(ctx SpecContext) {
+		fixtures.PinToNode(pod, "node-a")
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchFields[0].Values = []string{"node-a", "node-b"}
+		bound := sizing.New(fake.NewClientBuilder().WithObjects(node).Build(), sizing.Options{Candidates: sizing.CandidatesBound})
+		handler := &podSizingHandler{sizer: bound, decoder: admission.NewDecoder(scheme)}
+		response := handler.Handle(ctx, admissionRequestFor("Pod", pod))
+		Expect(response.Allowed).To(BeTrue())
+		Expect(paths(response)).To(Equal([]string{pendingSizingPath}))
+	})
+
+	It("answers pods that cannot be sized according to the failure mode", func(ctx SpecContext) {
+		fixtures.PinToNode(pod, "unknown")
+		handler := &podSizingHandler{sizer: sizer, decoder: admission.NewDecoder(scheme)}
+		response := handler.Handle(ctx, admissionRequestFor("Pod", pod))
+		Expect(response.Allowed).To(BeFalse())
+		Expect(response.Result.Code).To(BeEquivalentTo(503))
+		Expect(response.Result.Reason).To(BeEquivalentTo(sizing.FailureNodeNotFound))
+
+		handler.failureMode = failureModeAllow
+		response = handler.Handle(ctx, admissionRequestFor("Pod", pod))
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Warnings).To(ConsistOf(HavePrefix("pod was not sized (NodeNotFound): cannot find data for node")))
+
+		handler.failureMode = failureModeDeny
+		response = handler.Handle(ctx, admissionRequestFor("Pod", pod))
+		Expect(response.Allowed).To(BeFalse())
+		Expect(response.Result.Code).To(BeEquivalentTo(403))
+		Expect(response.Result.Reason).To(BeEquivalentTo(sizing.FailureNodeNotFound))
+	})
+
+	It("tells failures apart by reason", func(ctx SpecContext) {
+		before := testutil.ToFloat64(sizingFailuresTotal.WithLabelValues("AnnotationInvalid"))
+		pod.Annotations[sizing.ExposeBudgetAnnotation] = "maybe"
+		handler := &podSizingHandler{sizer: sizer, decoder: admission.NewDecoder(scheme)}
+		response := handler.Handle(ctx, admissionRequestFor("Pod", pod))
+		Expect(response.Allowed).To(BeFalse())
+		Expect(response.Result.Code).To(BeEquivalentTo(422))
+		Expect(response.Result.Reason).To(BeEquivalentTo(sizing.FailureAnnotationInvalid))
+		Expect(testutil.ToFloat64(sizingFailuresTotal.WithLabelValues("AnnotationInvalid"))).To(Equal(before + 1))
+	})
+
+	Describe("side effects", func() {
+		var (
+			recorder *record.FakeRecorder
+			handler  *podSizingHandler
+		)
+		BeforeEach(func() {
+			recorder = record.NewFakeRecorder(10)
+			handler = &podSizingHandler{sizer: sizer, decoder: admission.NewDecoder(scheme), events: newSizingEvents(recorder, true)}
+			pod.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "agent", Controller: ptr.To(true)}}
+		})
+
+		It("records events", func(ctx SpecContext) {
+			handler.Handle(ctx, admissionRequestFor("Pod", pod))
+			Expect(recorder.Events).To(Receive(HavePrefix("Normal NodeSpecificSizing Sized for node node-a")))
+			Expect(handler.events.pending).To(HaveLen(1))
+		})
+
+		It("records dry runs as such", func(ctx SpecContext) {
+			handler.dryRun = true
+			handler.Handle(ctx, admissionRequestFor("Pod", pod))
+			Expect(recorder.Events).To(Receive(HavePrefix("Normal NodeSpecificSizing Dry run, would have sized for node node-a")))
+		})
+
+		It("has none on dry-run requests", func(ctx SpecContext) {
+			req := admissionRequestFor("Pod", pod)
+			req.DryRun = ptr.To(true)
+			handler.Handle(ctx, req)
+			Expect(recorder.Events).To(BeEmpty())
+			Expect(handler.events.pending).To(BeEmpty())
+
+			req = admissionRequestFor("Pod", fixtures.PinToNode(pod, "node-b"))
+			req.DryRun = ptr.To(true)
+			Expect(handler.Handle(ctx, req).Allowed).To(BeFalse())
+			Expect(recorder.Events).To(BeEmpty())
+		})
+	})
+})
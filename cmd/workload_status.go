@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+	"strings"
+	"time"
+)
+
+// workloadStatusInterval is how often workload statuses are recomputed from cached pods
+const workloadStatusInterval = time.Minute
+
+// workloadStatus is what sizing.WorkloadStatusAnnotation holds
+type workloadStatus struct {
+	// Pods counts the pods of the workload that opted into sizing
+	Pods int `json:"pods"`
+	// Sized counts those whose resources were set by the webhook
+	Sized int `json:"sized"`
+	// Clamped counts sized pods that some stage clamped, e.g. to node capacity
+	Clamped   int            `json:"clamped"`
+	ClampedBy []sizing.Stage `json:"clampedBy,omitempty"`
+}
+
+// workloadKey identifies a DaemonSet or Deployment
+type workloadKey struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+// workloadOf returns the DaemonSet or Deployment owning pod. Deployments are told from the pod-template-hash suffix of
+// the ReplicaSet in between, which the pod cache does not hold.
+func workloadOf(pod *corev1.Pod) (workloadKey, bool) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || !strings.HasPrefix(owner.APIVersion, "apps/") {
+		return workloadKey{}, false
+	}
+	switch owner.Kind {
+	case "DaemonSet":
+		return workloadKey{kind: owner.Kind, namespace: pod.Namespace, name: owner.Name}, true
+	case "ReplicaSet":
+		hash := pod.Labels["pod-template-hash"]
+		if name, ok := strings.CutSuffix(owner.Name, "-"+hash); ok && hash != "" {
+			return workloadKey{kind: "Deployment", namespace: pod.Namespace, name: name}, true
+		}
+	}
+	return workloadKey{}, false
+}
+
+// workloadStatuses summarizes the sizing of pods by workload
+func workloadStatuses(pods []corev1.Pod) map[workloadKey]workloadStatus {
+	statuses := make(map[workloadKey]workloadStatus)
+	for i := range pods {
+		pod := &pods[i]
+		key, ok := workloadOf(pod)
+		if !ok {
+			continue
+		}
+		status := statuses[key]
+		status.Pods++
+		if applied, err := sizing.AppliedResourcesFromAnnotations(pod.Annotations); err == nil && len(applied) > 0 {
+			status.Sized++
+			if clampedBy, err := sizing.ClampedByFromAnnotations(pod.Annotations); err == nil && len(clampedBy) > 0 {
+				status.Clamped++
+				for _, stage := range clampedBy {
+					if !slices.Contains(status.ClampedBy, stage) {
+						status.ClampedBy = append(status.ClampedBy, stage)
+					}
+				}
+			}
+		}
+		statuses[key] = status
+	}
+	for key, status := range statuses {
+		slices.Sort(status.ClampedBy)
+		statuses[key] = status
+	}
+	return statuses
+}
+
+// workloadStatusReporter writes sizing.WorkloadStatusAnnotation on the DaemonSets and Deployments owning sized pods, so
+// that operators can tell how a workload was sized without looking at each of its pods. Workload statuses are owned
+// by their controller, hence the annotation rather than a condition.
+type workloadStatusReporter struct {
+	podReader client.Reader
+	writer    client.Writer
+	// written holds the last status written to every workload, which is only patched again when it changes
+	written map[workloadKey]workloadStatus
+}
+
+func newWorkloadStatusReporter(podReader client.Reader, writer client.Writer) *workloadStatusReporter {
+	return &workloadStatusReporter{podReader: podReader, writer: writer, written: make(map[workloadKey]workloadStatus)}
+}
+
+func (wsr *workloadStatusReporter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := wsr.report(ctx); err != nil {
+			zap.L().Warn("Could not report workload sizing", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// report patches the workloads whose status changed since it was last written
+func (wsr *workloadStatusReporter) report(ctx context.Context) error {
+	var pods corev1.PodList
+	if err := wsr.podReader.List(ctx, &pods); err != nil {
+		return fmt.Errorf("could not list pods: %w", err)
+	}
+	statuses := workloadStatuses(pods.Items)
+	for key, status := range statuses {
+		if written, ok := wsr.written[key]; ok && written.Pods == status.Pods && written.Sized == status.Sized &&
+			written.Clamped == status.Clamped && slices.Equal(written.ClampedBy, status.ClampedBy) {
+			continue
+		}
+		if err := wsr.patch(ctx, key, status); err != nil {
+			zap.L().Warn("Could not annotate workload", zap.String("kind", key.kind), zap.String("namespace", key.namespace),
+				zap.String("name", key.name), zap.Error(err))
+			continue
+		}
+		wsr.written[key] = status
+	}
+	// Workloads without pods left are not patched, they may be gone altogether
+	for key := range wsr.written {
+		if _, ok := statuses[key]; !ok {
+			delete(wsr.written, key)
+		}
+	}
+	return nil
+}
+
+func (wsr *workloadStatusReporter) patch(ctx context.Context, key workloadKey, status workloadStatus) error {
+	value, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{"annotations": map[string]string{sizing.WorkloadStatusAnnotation: string(value)}},
+	})
+	if err != nil {
+		return err
+	}
+	workload := &metav1.PartialObjectMetadata{}
+	workload.SetGroupVersionKind(appsv1.SchemeGroupVersion.WithKind(key.kind))
+	workload.SetNamespace(key.namespace)
+	workload.SetName(key.name)
+	return client.IgnoreNotFound(wsr.writer.Patch(ctx, workload, client.RawPatch(types.MergePatchType, patch), client.FieldOwner(fieldManager)))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Reporting workload status", Label("WorkloadStatus"), func() {
+	ownedPod := func(name, kind, owner string, sized bool, clampedBy string) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "default",
+			Name:            name,
+			Labels:          map[string]string{"pod-template-hash": "5d8f7c"},
+			Annotations:     map[string]string{},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: kind, Name: owner, Controller: ptr.To(true)}},
+		}}
+		if sized {
+			pod.Annotations[sizing.AppliedResourcesAnnotation] = `{"a":{"requests":{"memory":"200M"}}}`
+		}
+		if clampedBy != "" {
+			pod.Annotations[sizing.StatusAnnotation] = `{"node":"node-a","clampedBy":["` + clampedBy + `"]}`
+		}
+		return pod
+	}
+
+	It("summarizes pods by DaemonSet and Deployment", func() {
+		statuses := workloadStatuses([]corev1.Pod{
+			*ownedPod("web-5d8f7c-a", "ReplicaSet", "web-5d8f7c", true, "node-cap"),
+			*ownedPod("web-5d8f7c-b", "ReplicaSet", "web-5d8f7c", true, "pod-min-max"),
+			*ownedPod("web-5d8f7c-c", "ReplicaSet", "web-5d8f7c", false, ""),
+			*ownedPod("agent-a", "DaemonSet", "agent", true, ""),
+			*ownedPod("db-0", "StatefulSet", "db", true, ""),
+		})
+		Expect(statuses).To(HaveLen(2))
+		Expect(statuses).To(HaveKeyWithValue(workloadKey{kind: "Deployment", namespace: "default", name: "web"},
+			workloadStatus{Pods: 3, Sized: 2, Clamped: 2, ClampedBy: []sizing.Stage{"node-cap", "pod-min-max"}}))
+		Expect(statuses).To(HaveKeyWithValue(workloadKey{kind: "DaemonSet", namespace: "default", name: "agent"},
+			workloadStatus{Pods: 1, Sized: 1}))
+	})
+
+	It("annotates workloads, only when their summary changes", func(ctx SpecContext) {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		daemonSet := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "agent"}}
+		pod := ownedPod("agent-a", "DaemonSet", "agent", true, "node-cap")
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(daemonSet, pod).Build()
+		reporter := newWorkloadStatusReporter(fakeClient, fakeClient)
+
+		Expect(reporter.report(ctx)).To(Succeed())
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(daemonSet), daemonSet)).To(Succeed())
+		Expect(daemonSet.Annotations).To(HaveKeyWithValue(sizing.WorkloadStatusAnnotation,
+			`{"pods":1,"sized":1,"clamped":1,"clampedBy":["node-cap"]}`))
+
+		// Left as is, the annotation is not written again
+		delete(daemonSet.Annotations, sizing.WorkloadStatusAnnotation)
+		Expect(fakeClient.Update(ctx, daemonSet)).To(Succeed())
+		Expect(reporter.report(ctx)).To(Succeed())
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(daemonSet), daemonSet)).To(Succeed())
+		Expect(daemonSet.Annotations).NotTo(HaveKey(sizing.WorkloadStatusAnnotation))
+	})
+
+	It("skips workloads that are gone", func(ctx SpecContext) {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		pod := ownedPod("web-5d8f7c-a", "ReplicaSet", "web-5d8f7c", true, "")
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pod).Build()
+		Expect(newWorkloadStatusReporter(fakeClient, fakeClient).report(ctx)).To(Succeed())
+	})
+})
@@ -0,0 +1,10 @@
+// Package deploy holds the manifests installing the webhook with kustomize, for knss manifests to render them without
+// it
+package deploy
+
+import "embed"
+
+// Files holds kustomization.yaml and every manifest it lists
+//
+//go:embed *.yaml crd/*.yaml
+var Files embed.FS
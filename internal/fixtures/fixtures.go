@@ -0,0 +1,53 @@
+// Package fixtures holds the pod and node fixtures shared by the tests of the webhook and of the sizing package
+package fixtures
+
+import (
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func NodeWithCapacity(cpu, memory string) *corev1.Node {
+	return &corev1.Node{
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse(cpu),
+				corev1.ResourceMemory: resource.MustParse(memory),
+			},
+		},
+	}
+}
+
+func PodWithContainers(containers ...corev1.Container) *corev1.Pod {
+	return &corev1.Pod{Spec: corev1.PodSpec{Containers: containers}}
+}
+
+func ContainerWithResources(name string, requests, limits corev1.ResourceList) corev1.Container {
+	return corev1.Container{Name: name, Resources: corev1.ResourceRequirements{Requests: requests, Limits: limits}}
+}
+
+// PinToNode sets the exact affinity the DaemonSet controller uses to pin pods to a node
+func PinToNode(pod *corev1.Pod, nodeName string) *corev1.Pod {
+	pod.Spec.Affinity = &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchFields: []corev1.NodeSelectorRequirement{{
+						Key:      "metadata.name",
+						Operator: corev1.NodeSelectorOpIn,
+						Values:   []string{nodeName},
+					}},
+				}},
+			},
+		},
+	}
+	return pod
+}
+
+// BoundValue fails the spec when the property is not bound
+func BoundValue(props *rps.ResourceProperties, prop rps.ResourceProperty, res corev1.ResourceName) float64 {
+	value, ok := props.GetValue(prop, res)
+	ExpectWithOffset(1, ok).To(BeTrue(), "%s.%s should be bound", prop, res)
+	return value
+}
@@ -0,0 +1,22 @@
+// Package v1alpha1 contains the API of node-specific-sizing.manomano.tech, which lets cluster operators configure
+// sizing with policies rather than with pod annotations alone.
+//
+// +kubebuilder:object:generate=true
+// +groupName=node-specific-sizing.manomano.tech
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/scheme"
+)
+
+var (
+	// GroupVersion is group version used to register these objects
+	GroupVersion = schema.GroupVersion{Group: "node-specific-sizing.manomano.tech", Version: "v1alpha1"}
+
+	// SchemeBuilder is used to add go types to the GroupVersionKind scheme
+	SchemeBuilder = &scheme.Builder{GroupVersion: GroupVersion}
+
+	// AddToScheme adds the types in this group-version to the given scheme.
+	AddToScheme = SchemeBuilder.AddToScheme
+)
@@ -0,0 +1,268 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// StatusVerbosity tells how much of a sizing decision is written in the status annotation
+// +kubebuilder:validation:Enum=None;Summary;Full
+type StatusVerbosity string
+
+const (
+	// StatusVerbosityNone writes no annotation at all
+	StatusVerbosityNone StatusVerbosity = "None"
+	// StatusVerbositySummary writes a JSON report of the node and the original and final resources of containers
+	StatusVerbositySummary StatusVerbosity = "Summary"
+	// StatusVerbosityFull adds the decision trace to the report
+	StatusVerbosityFull StatusVerbosity = "Full"
+)
+
+// StatusAnnotationSpec configures the annotation recording what sizing did to a pod
+type StatusAnnotationSpec struct {
+	// Key of the annotation. Defaults to node-specific-sizing.manomano.tech/status
+	// +optional
+	Key string `json:"key,omitempty"`
+
+	// Verbosity of the annotation. None also stops the webhook from writing any other annotation.
+	// +kubebuilder:default=Summary
+	// +optional
+	Verbosity StatusVerbosity `json:"verbosity,omitempty"`
+}
+
+// DriftAction tells what to do with running pods whose sizing is outdated
+// +kubebuilder:validation:Enum=Report;Resize;Evict
+type DriftAction string
+
+const (
+	// DriftActionReport records an Event on the pod and counts it, leaving it as is
+	DriftActionReport DriftAction = "Report"
+	// DriftActionResize also resizes the pod in place, through the resize subresource of Kubernetes 1.33 and later
+	DriftActionResize DriftAction = "Resize"
+	// DriftActionEvict also evicts the pod, for its controller to create it again, sized anew. Evictions honor
+	// PodDisruptionBudgets.
+	DriftActionEvict DriftAction = "Evict"
+)
+
+// EnforcementAction tells what to do with pods whose annotations, or the defaults of their namespace, loosen a setting
+// their policy enforces
+// +kubebuilder:validation:Enum=Override;Deny
+type EnforcementAction string
+
+const (
+	// EnforcementOverride sizes the pod with the enforced value instead, returning an admission warning
+	EnforcementOverride EnforcementAction = "Override"
+	// EnforcementDeny refuses the pod
+	EnforcementDeny EnforcementAction = "Deny"
+)
+
+// EnforcedSettings are settings namespace defaults and pod annotations may only tighten, so that application teams
+// can tune sizing themselves within what the cluster allows
+type EnforcedSettings struct {
+	// Settings by annotation name, e.g. maximum-memory: 8Gi, the node-specific-sizing.manomano.tech/ prefix being
+	// optional. Only fractions, minimums and maximums may be enforced: annotations may lower fractions and maximums,
+	// and raise minimums. They also apply to pods that do not set them, like other settings of the policy.
+	Settings map[string]string `json:"settings"`
+
+	// Action taken on pods loosening an enforced setting
+	// +kubebuilder:default=Override
+	// +optional
+	Action EnforcementAction `json:"action,omitempty"`
+}
+
+// TaintSelector matches the taints of a node
+type TaintSelector struct {
+	// Key of the taint
+	Key string `json:"key"`
+
+	// Value of the taint. Any value matches when empty.
+	// +optional
+	Value string `json:"value,omitempty"`
+
+	// Effect of the taint. Any effect matches when empty.
+	// +kubebuilder:validation:Enum=NoSchedule;PreferNoSchedule;NoExecute
+	// +optional
+	Effect corev1.TaintEffect `json:"effect,omitempty"`
+}
+
+// Fractions of the node given to a pod, as decimal strings in ]0, 1]. Limit fractions may be above 1 when the policy
+// allows overcommit. They mean the same as the corresponding annotations, which take precedence over them.
+type Fractions struct {
+	// +kubebuilder:validation:Pattern=`^(0?\.[0-9]+|1(\.0*)?)$`
+	// +optional
+	RequestCPU string `json:"requestCpu,omitempty"`
+
+	// +kubebuilder:validation:Pattern=`^([0-9]+(\.[0-9]*)?|\.[0-9]+)$`
+	// +optional
+	LimitCPU string `json:"limitCpu,omitempty"`
+
+	// +kubebuilder:validation:Pattern=`^(0?\.[0-9]+|1(\.0*)?)$`
+	// +optional
+	RequestMemory string `json:"requestMemory,omitempty"`
+
+	// +kubebuilder:validation:Pattern=`^([0-9]+(\.[0-9]*)?|\.[0-9]+)$`
+	// +optional
+	LimitMemory string `json:"limitMemory,omitempty"`
+}
+
+// FractionSet gives fractions to pods on the nodes it selects. A node must match both its label selector and every
+// one of its taint selectors.
+type FractionSet struct {
+	// NodeSelector selects nodes by label. An empty selector selects all nodes.
+	// +optional
+	NodeSelector metav1.LabelSelector `json:"nodeSelector,omitempty"`
+
+	// NodeTaints selects nodes carrying every one of these taints, for node pools distinguished only by their taints.
+	// +optional
+	NodeTaints []TaintSelector `json:"nodeTaints,omitempty"`
+
+	Fractions Fractions `json:"fractions"`
+}
+
+// Rounding gives the steps sized requests and limits are rounded down to, as quantities, e.g. 1m for CPU or 1Mi for
+// memory. Values are then written in the format of the step, binary for 1Mi. They mean the same as the corresponding
+// annotations, which take precedence over them.
+type Rounding struct {
+	// +optional
+	CPU string `json:"cpu,omitempty"`
+
+	// +optional
+	Memory string `json:"memory,omitempty"`
+}
+
+// SizeTable gives pods fixed requests depending on a label of their node, e.g. its instance type. Nodes missing from
+// the table are sized from fractions.
+type SizeTable struct {
+	// NodeLabel is the node label sizes are keyed by, e.g. node.kubernetes.io/instance-type
+	NodeLabel string `json:"nodeLabel"`
+
+	// Sizes holds pod requests by value of the node label
+	Sizes map[string]corev1.ResourceList `json:"sizes"`
+}
+
+// OwnerSelector matches the controller of a pod, e.g. its DaemonSet
+type OwnerSelector struct {
+	// APIGroup of the owner, e.g. apps. Any group matches when empty.
+	// +optional
+	APIGroup string `json:"apiGroup,omitempty"`
+
+	// Kind of the owner, e.g. DaemonSet. Any kind matches when empty.
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// Names of the owner. Any name matches when empty.
+	// +optional
+	Names []string `json:"names,omitempty"`
+}
+
+// OperatingSystem adjusts sizing on the nodes of an operating system, for policies selecting the DaemonSets of every
+// operating system of mixed clusters
+type OperatingSystem struct {
+	// Name of the operating system, as the kubernetes.io/os label of nodes gives it
+	// +kubebuilder:validation:Enum=linux;windows
+	Name corev1.OSName `json:"name"`
+
+	// Exclude leaves pods on these nodes as they are
+	// +optional
+	Exclude bool `json:"exclude,omitempty"`
+
+	// ExcludedResources are left as they are on these nodes, e.g. memory. Hugepages are never sized on Windows nodes,
+	// which have none.
+	// +optional
+	ExcludedResources []corev1.ResourceName `json:"excludedResources,omitempty"`
+
+	// Settings apply on these nodes by annotation name, e.g. request-memory-basis: allocatable, the
+	// node-specific-sizing.manomano.tech/ prefix being optional. They take precedence over the other settings of the
+	// policy, namespace defaults and pod annotations taking precedence over them.
+	// +optional
+	Settings map[string]string `json:"settings,omitempty"`
+}
+
+// SizingPolicySpec defines how pods it selects are sized
+type SizingPolicySpec struct {
+	// PodSelector selects the pods this policy applies to. An empty selector selects all sized pods.
+	// +optional
+	PodSelector metav1.LabelSelector `json:"podSelector,omitempty"`
+
+	// Owners restricts the policy to pods controlled by one of these owners, on top of the pod selector, for pods
+	// lacking distinctive labels. Pods of any owner, or none, match when empty.
+	// +optional
+	Owners []OwnerSelector `json:"owners,omitempty"`
+
+	// NodeSelector restricts the policy to pods on the nodes it selects, which are otherwise left as they are, whatever
+	// their annotations. An empty selector selects all nodes.
+	// +optional
+	NodeSelector metav1.LabelSelector `json:"nodeSelector,omitempty"`
+
+	// NodeTaints restricts the policy to pods on nodes carrying every one of these taints, on top of the node selector.
+	// +optional
+	NodeTaints []TaintSelector `json:"nodeTaints,omitempty"`
+
+	// StatusAnnotation configures the status annotation of pods
+	// +optional
+	StatusAnnotation StatusAnnotationSpec `json:"statusAnnotation,omitempty"`
+
+	// AllowOvercommit lets limit fractions go above 1, e.g. 1.5 for limits of 150% of the node. Limits are then not
+	// capped to node capacity either. Request fractions stay at most 1.
+	// +optional
+	AllowOvercommit bool `json:"allowOvercommit,omitempty"`
+
+	// Enforced settings cannot be loosened by namespace defaults or pod annotations, which otherwise take precedence
+	// over the policy
+	// +optional
+	Enforced *EnforcedSettings `json:"enforced,omitempty"`
+
+	// FractionSets configure fractions depending on the node a pod lands on. The first set selecting the node applies.
+	// +optional
+	FractionSets []FractionSet `json:"fractionSets,omitempty"`
+
+	// SizeTable gives pods fixed requests by node label, taking precedence over fractions. The corresponding
+	// annotations take precedence over it.
+	// +optional
+	SizeTable *SizeTable `json:"sizeTable,omitempty"`
+
+	// Rounding configures how sized values are rounded. They are only rounded down to what the API server stores
+	// when unset.
+	// +optional
+	Rounding Rounding `json:"rounding,omitempty"`
+
+	// FallbackRequests are the pod requests of pods whose node cannot be resolved or read, rather than failing
+	// admission. They are spread between containers like the sizes of a size table.
+	// +optional
+	FallbackRequests corev1.ResourceList `json:"fallbackRequests,omitempty"`
+
+	// OnDrift tells what to do with running pods whose sizing is outdated, e.g. after their node was replaced
+	// by a larger one. Only applies when the webhook reconciles pods, see --reconcile-interval.
+	// +kubebuilder:default=Report
+	// +optional
+	OnDrift DriftAction `json:"onDrift,omitempty"`
+
+	// OperatingSystems adjust sizing by operating system of the node, at most one entry applying to each
+	// +optional
+	OperatingSystems []OperatingSystem `json:"operatingSystems,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// SizingPolicy configures sizing for the pods it selects. When several policies select a pod, the first one by name
+// applies.
+type SizingPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec SizingPolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// SizingPolicyList contains a list of SizingPolicy
+type SizingPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SizingPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SizingPolicy{}, &SizingPolicyList{})
+}
@@ -0,0 +1,74 @@
+package resource_properties_test
+
+import (
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"testing"
+)
+
+// Benchmarks run with go test -bench=. -benchmem -run=^$ ./pkg/resource_properties/
+
+// realisticAnnotations are those of a DaemonSet pod setting most of what sizing reads, among unrelated annotations
+var realisticAnnotations = map[string]string{
+	"node-specific-sizing.manomano.tech/request-cpu-fraction":    "0.05",
+	"node-specific-sizing.manomano.tech/limit-cpu-fraction":      "0.1",
+	"node-specific-sizing.manomano.tech/request-memory-fraction": "1/40",
+	"node-specific-sizing.manomano.tech/limit-memory-fraction":   "0.05",
+	"node-specific-sizing.manomano.tech/minimum-cpu":             "50m",
+	"node-specific-sizing.manomano.tech/maximum-cpu":             "2",
+	"node-specific-sizing.manomano.tech/minimum-memory":          "128Mi",
+	"node-specific-sizing.manomano.tech/maximum-memory":          "4Gi",
+	"node-specific-sizing.manomano.tech/rounding-cpu":            "10m",
+	"node-specific-sizing.manomano.tech/rounding-memory":         "1Mi",
+	"kubectl.kubernetes.io/default-container":                    "app",
+	"prometheus.io/scrape":                                       "true",
+	"prometheus.io/port":                                         "9090",
+}
+
+func BenchmarkNewFromAnnotations(b *testing.B) {
+	b.ReportAllocs()
+	for range b.N {
+		if err, _ := rps.NewFromAnnotations(realisticAnnotations); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkOperators(b *testing.B) {
+	err, settings := rps.NewFromAnnotations(realisticAnnotations)
+	if err != nil {
+		b.Fatal(err)
+	}
+	requirements := rps.New()
+	requirements.AddResourceRequirements(&corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m"), corev1.ResourceMemory: resource.MustParse("256Mi")},
+		Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("512Mi")},
+	})
+
+	b.Run("add", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			_ = requirements.Add(requirements)
+		}
+	})
+	b.Run("mul", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			_ = requirements.Mul(settings)
+		}
+	})
+	b.Run("min-max", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			_ = requirements.Min(settings).Max(settings)
+		}
+	})
+	b.Run("quantity", func(b *testing.B) {
+		quantity := resource.MustParse("15842Mi")
+		b.ReportAllocs()
+		for range b.N {
+			_ = rps.QuantityRat(quantity)
+		}
+	})
+}
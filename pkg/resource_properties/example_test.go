@@ -0,0 +1,94 @@
+package resource_properties_test
+
+import (
+	"fmt"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Sizing a pod to a fraction of its node, within bounds
+func Example() {
+	err, settings := rps.NewFromAnnotations(map[string]string{
+		"node-specific-sizing.manomano.tech/request-memory-fraction": "0.1",
+		"node-specific-sizing.manomano.tech/maximum-memory":          "1Gi",
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	node := rps.New()
+	node.AddResourceRequirements(&corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("16Gi")},
+	})
+
+	budget := settings.Mul(node)
+	budget.ClampRequestsAndLimits(settings)
+	for binding := range budget.All() {
+		fmt.Println(binding.Property(), binding.ResourceName(), binding.HumanValue())
+	}
+	// Output: requests memory 1Gi
+}
+
+// Spreading a budget between containers in proportion to their original requests
+func ExampleResourceProperties_Div() {
+	containers := map[string]*rps.ResourceProperties{"app": rps.New(), "sidecar": rps.New()}
+	containers["app"].AddResourceRequirements(&corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("300m")},
+	})
+	containers["sidecar"].AddResourceRequirements(&corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+	})
+
+	total := rps.New()
+	for _, props := range containers {
+		total.AddInPlace(props)
+	}
+	budget := rps.New()
+	budget.BindPropertyFloat(rps.ResourceQuantity, rps.ResourceRequests, corev1.ResourceCPU, 2)
+
+	for _, name := range []string{"app", "sidecar"} {
+		for binding := range containers[name].Div(total).Mul(budget).All() {
+			fmt.Println(name, binding.HumanValue())
+		}
+	}
+	// Output:
+	// app 1500m
+	// sidecar 500m
+}
+
+// Rounding values down to steps, which also sets their suffix style
+func ExampleResourceProperties_Round() {
+	err, rounding := rps.NewFromAnnotations(map[string]string{
+		"node-specific-sizing.manomano.tech/rounding-memory": "1Mi",
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	props := rps.New()
+	props.BindPropertyFloat(rps.ResourceQuantity, rps.ResourceLimits, corev1.ResourceMemory, 841e6)
+	props.Round(rounding)
+	for binding := range props.All() {
+		fmt.Println(binding.HumanValue())
+	}
+	// Output: 802Mi
+}
+
+// Applying sized values to a container without going through a JSON patch
+func ExampleResourceProperties_ToResourceRequirements() {
+	err, settings := rps.NewFromAnnotations(map[string]string{
+		"node-specific-sizing.manomano.tech/request-memory-fraction": "0.05",
+	})
+	if err != nil {
+		panic(err)
+	}
+	node := rps.FromResourceRequirements(&corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("16Gi")},
+	})
+
+	ctn := corev1.Container{Name: "app"}
+	ctn.Resources = settings.Mul(node).ToResourceRequirements()
+	fmt.Println(ctn.Resources.Requests.Memory())
+	// Output: 819Mi
+}
@@ -0,0 +1,118 @@
+package resource_properties_test
+
+import (
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"math/big"
+	"testing"
+)
+
+// Fuzz targets run their seeds with go test, and explore further with go test -fuzz=<target>
+
+func FuzzNewFromAnnotations(f *testing.F) {
+	f.Add("0.1", "1/3", "100m", "1Gi", "250m")
+	f.Add("1", "0", "-1", "1e3", "0.5Ki")
+	f.Add("2/0", "abc", "", "9223372036854775807", "1e-3")
+	f.Fuzz(func(t *testing.T, cpuFraction, memoryFraction, minimumCPU, maximumMemory, roundingMemory string) {
+		err, props := rps.NewFromAnnotations(map[string]string{
+			"node-specific-sizing.manomano.tech/request-cpu-fraction":  cpuFraction,
+			"node-specific-sizing.manomano.tech/limit-memory-fraction": memoryFraction,
+			"node-specific-sizing.manomano.tech/minimum-cpu":           minimumCPU,
+			"node-specific-sizing.manomano.tech/maximum-memory":        maximumMemory,
+			"node-specific-sizing.manomano.tech/rounding-memory":       roundingMemory,
+		})
+		if err != nil {
+			return
+		}
+		_ = props.CheckBounds()
+		_ = props.String()
+		for binding := range props.All() {
+			_ = binding.HumanValue()
+		}
+	})
+}
+
+func FuzzBindPropertyString(f *testing.F) {
+	f.Add(true, "0.25")
+	f.Add(true, "3/4")
+	f.Add(false, "128Mi")
+	f.Add(false, "1.5e3m")
+	f.Fuzz(func(t *testing.T, fraction bool, value string) {
+		kind := rps.ResourceQuantity
+		if fraction {
+			kind = rps.ResourceFraction
+		}
+		props := rps.New()
+		if err := props.BindPropertyString(kind, rps.ResourceRequests, corev1.ResourceMemory, value); err != nil {
+			return
+		}
+		bound, ok := props.GetRat(rps.ResourceRequests, corev1.ResourceMemory)
+		if !ok {
+			t.Fatalf("%q parsed, but is not bound", value)
+		}
+		if fraction && (bound.Sign() <= 0 || bound.Cmp(big.NewRat(1, 1)) > 0) {
+			t.Fatalf("%q parsed as a fraction out of ]0, 1]: %s", value, bound)
+		}
+		if !fraction {
+			for binding := range props.All() {
+				if _, err := resource.ParseQuantity(binding.HumanValue()); err != nil {
+					t.Fatalf("%q renders as %q, which is not a quantity: %v", value, binding.HumanValue(), err)
+				}
+			}
+		}
+	})
+}
+
+// FuzzArithmetic combines properties parsed from quantities, as the sizing pipeline does with containers and nodes
+func FuzzArithmetic(f *testing.F) {
+	f.Add("100m", "128Mi", "4", "8Gi")
+	f.Add("0", "1", "1e-3", "0")
+	f.Fuzz(func(t *testing.T, cpu, memory, otherCPU, otherMemory string) {
+		props := func(cpu, memory string) *rps.ResourceProperties {
+			result := rps.New()
+			requests := corev1.ResourceList{}
+			for name, value := range map[corev1.ResourceName]string{corev1.ResourceCPU: cpu, corev1.ResourceMemory: memory} {
+				if qty, err := resource.ParseQuantity(value); err == nil {
+					requests[name] = qty
+				}
+			}
+			result.AddResourceRequirements(&corev1.ResourceRequirements{Requests: requests})
+			return result
+		}
+		ours, theirs := props(cpu, memory), props(otherCPU, otherMemory)
+		for _, result := range []*rps.ResourceProperties{ours.Mul(theirs), ours.Div(theirs), ours.Sub(theirs), ours.Min(theirs), ours.Max(theirs), ours.Add(theirs)} {
+			for binding := range result.All() {
+				_ = binding.HumanValue()
+			}
+		}
+		ours.AddInPlace(theirs)
+	})
+}
+
+// FuzzMultiplyQuantity checks products against their exact value: rounded down, by less than a milli-unit or, unless
+// with PrecisionMilli, a tenth of the value
+func FuzzMultiplyQuantity(f *testing.F) {
+	f.Add("1Gi", int64(1), int64(3), false)
+	f.Add("1", int64(999), int64(1000), true)
+	f.Add("4", int64(2499), int64(10), false)
+	f.Add("9Ei", int64(7), int64(3), true)
+	f.Fuzz(func(t *testing.T, value string, num, denom int64, milli bool) {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil || quantity.Sign() < 0 || num < 0 || denom <= 0 {
+			return
+		}
+		exact := new(big.Rat).Mul(rps.QuantityRat(quantity), big.NewRat(num, denom))
+		precision, maxError := rps.PrecisionMilli, big.NewRat(1, 1000)
+		if !milli {
+			precision = rps.PrecisionReadable
+			if tenth := new(big.Rat).Quo(exact, big.NewRat(10, 1)); tenth.Cmp(maxError) > 0 {
+				maxError = tenth
+			}
+		}
+		product := rps.MultiplyQuantity(quantity, big.NewRat(num, denom), precision)
+		if diff := new(big.Rat).Sub(exact, rps.QuantityRat(product)); diff.Sign() < 0 || diff.Cmp(maxError) >= 0 {
+			t.Fatalf("%s * %d/%d rendered as %s, off by %s from %s", value, num, denom, product.String(), diff.FloatString(3), exact.FloatString(3))
+		}
+	})
+}
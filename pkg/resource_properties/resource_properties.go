@@ -4,22 +4,42 @@
 // Unlike kube's APIs, requests and limits are programmatically the same, as well as other quantities, which
 // greatly reduces tedium when doing arithmetic on those.
 //
-// It departs from v1 resource handling by leaning heavily into floats, with the round-trip issues that come
-// with it, even though some mitigations are provided.
+// Values are held as exact rationals, so that arithmetic does not drift the way floats do. They are only rounded
+// when rendered, see HumanValue. Float accessors remain for convenience, at the cost of precision.
+//
+// # Stability
+//
+// This package is a public API, versioned with the module according to semantic versioning. Within a major version,
+// exported identifiers are neither removed nor changed in incompatible ways, and the built-in annotations listed by
+// SupportedAnnotations keep their meaning. Identifiers that are to go away are first marked with a "Deprecated:"
+// paragraph, and kept for at least two minor versions after that.
+//
+// What is not covered: the exact text of errors and of String, the iteration order of All, and rendering of quantities
+// by HumanValue beyond what it documents.
 package resource_properties
 
 import (
+	"cmp"
 	"fmt"
 	mapset "github.com/deckarep/golang-set/v2"
+	inf "gopkg.in/inf.v0"
 	"iter"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"maps"
 	"math"
+	"math/big"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 )
 
+// ResourceProperty tells what a value stands for: a request, a limit, or a setting such as a pod minimum
 type ResourceProperty string
+
+// ResourceKind tells whether a value is a fraction, e.g. of a node, or an absolute quantity
 type ResourceKind string
 
 const (
@@ -28,80 +48,280 @@ const (
 	ResourceLimits     ResourceProperty = "limits"
 	ResourcePodMinimum ResourceProperty = "pod-minimum"
 	ResourcePodMaximum ResourceProperty = "pod-maximum"
+	// ResourceRounding holds the step requests and limits are rounded down to, see Round
+	ResourceRounding ResourceProperty = "rounding"
 
 	ResourceFraction ResourceKind = "fraction"
 	ResourceQuantity ResourceKind = "quantity"
 )
 
-var allValidResourceProperties = []ResourceProperty{ResourceRequests, ResourceLimits, ResourcePodMinimum, ResourcePodMaximum}
+var allValidResourceProperties = []ResourceProperty{ResourceRequests, ResourceLimits, ResourcePodMinimum, ResourcePodMaximum, ResourceRounding}
 
+// ResourcePropertyBinding holds the value of a property for a resource, e.g. the CPU request
 type ResourcePropertyBinding struct {
 	resourceKind ResourceKind
 	resourceProp ResourceProperty
 	resourceName corev1.ResourceName
-	value        float64
+	// value is never nil on bound properties, and never shared between bindings
+	value *big.Rat
+	// format is the one HumanValue renders quantities in, when set. Bindings parsed from a quantity keep its format,
+	// and so do bindings derived from them, see Mul and Div.
+	format resource.Format
+	// rounded values are multiples of a rounding step, which HumanValue renders exactly
+	rounded bool
+	// basis is what a fraction applies to, node capacity when empty
+	basis Basis
 }
 
+// NewBinding returns a binding, for use with ResourceProperties.Bind
 func NewBinding(resourceKind ResourceKind, resourceProp ResourceProperty, resourceName corev1.ResourceName, value float64) *ResourcePropertyBinding {
 	return &ResourcePropertyBinding{
 		resourceKind: resourceKind,
 		resourceProp: resourceProp,
 		resourceName: resourceName,
-		value:        value,
+		value:        ratFromFloat(value),
+	}
+}
+
+// ratFromFloat converts a float exactly. Non-finite values, which no resource can hold, become zero.
+func ratFromFloat(value float64) *big.Rat {
+	result := new(big.Rat)
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return result
+	}
+	return result.SetFloat64(value)
+}
+
+// smallPowersOf10 caches the powers of 10 quantities commonly use as scales, from nano to exa. They are only ever used
+// as read-only operands.
+var smallPowersOf10 = func() [19]*big.Rat {
+	var result [19]*big.Rat
+	for n := range result {
+		result[n] = new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil))
+	}
+	return result
+}()
+
+// pow10 returns 10^n, which must not be modified
+func pow10(n int64) *big.Rat {
+	if n >= 0 && n < int64(len(smallPowersOf10)) {
+		return smallPowersOf10[n]
+	}
+	return new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(n), nil))
+}
+
+// QuantityRat converts a quantity to an exact rational
+func QuantityRat(qty resource.Quantity) *big.Rat {
+	dec := qty.AsDec()
+	result := new(big.Rat).SetInt(dec.UnscaledBig())
+	if scale := int64(dec.Scale()); scale > 0 {
+		return result.Quo(result, pow10(scale))
+	} else if scale < 0 {
+		return result.Mul(result, pow10(-scale))
 	}
+	return result
 }
 
+// ResourceName returns the resource the value is bound to
 func (rpb *ResourcePropertyBinding) ResourceName() corev1.ResourceName {
 	return rpb.resourceName
 }
 
+// Property returns the property the value is bound to
 func (rpb *ResourcePropertyBinding) Property() ResourceProperty {
 	return rpb.resourceProp
 }
 
+// Kind returns whether the value is a fraction or a quantity
+func (rpb *ResourcePropertyBinding) Kind() ResourceKind {
+	return rpb.resourceKind
+}
+
+// Value approximates the value as a float, prefer Rat for arithmetic
 func (rpb *ResourcePropertyBinding) Value() float64 {
-	return rpb.value
+	f, _ := rpb.value.Float64()
+	return f
 }
 
+// SetValue sets the value from a float, prefer SetRat for exact values
 func (rpb *ResourcePropertyBinding) SetValue(v float64) {
-	rpb.value = v
+	rpb.value = ratFromFloat(v)
+}
+
+// Rat returns a copy of the exact value
+func (rpb *ResourcePropertyBinding) Rat() *big.Rat {
+	return new(big.Rat).Set(rpb.value)
+}
+
+// SetRat sets the value, which is copied
+func (rpb *ResourcePropertyBinding) SetRat(v *big.Rat) {
+	rpb.value = new(big.Rat).Set(v)
+}
+
+// Basis returns what a fraction applies to, empty for node capacity
+func (rpb *ResourcePropertyBinding) Basis() Basis {
+	return rpb.basis
+}
+
+// SetBasis sets what a fraction applies to, empty for node capacity
+func (rpb *ResourcePropertyBinding) SetBasis(basis Basis) {
+	rpb.basis = basis
 }
 
 func (rpb *ResourcePropertyBinding) String() string {
-	return fmt.Sprintf("%s.%s=%f=%s (%s)", rpb.resourceProp, rpb.resourceName, rpb.value, rpb.HumanValue(), rpb.resourceKind)
+	return fmt.Sprintf("%s.%s=%f=%s (%s)", rpb.resourceProp, rpb.resourceName, rpb.Value(), rpb.HumanValue(), rpb.resourceKind)
 }
 
-func appropriateIntegerExponent(n float64, base float64) int {
-	if n == 0 {
-		return 0
-	}
-	log := math.Log(n) / math.Log(base)
-	truncatedLog := int(math.Trunc(log))
-	return (truncatedLog / 3) * 3 // (8 / 3) * 3 = 6, as everybody knows
+// floorRat rounds towards negative infinity. Rat denominators are always positive, for which Euclidean division floors.
+func floorRat(r *big.Rat) *big.Int {
+	return new(big.Int).Div(r.Num(), r.Denom())
 }
 
-// HumanValue converts from the internal float to a string that looks like
-// the usual suffixed representation, i.e. 2G or 200m
+// Precision tells how far quantities are rounded down when rendered, see FormatValue
+type Precision string
+
+const (
+	// PrecisionReadable rounds down to the largest unit a value holds at least 10 of, e.g. 1536M for 1536.9M, so that
+	// the error stays below a tenth of the value, or 1m for values below 10. Values rounded by Round are rendered
+	// exactly. HumanValue uses it.
+	PrecisionReadable Precision = "readable"
+	// PrecisionMilli only rounds down to milli-units, so that the error stays below 1m whatever the value
+	PrecisionMilli Precision = "milli"
+)
+
+// HumanValue converts from the internal rational to a string that looks like
+// the usual suffixed representation, i.e. 2G or 200m. Quantities are rounded down, which keeps the order of values:
+// a request at most equal to its limit is rendered at most equal to it. See PrecisionReadable for the maximum error.
+//
+// Quantities keep the suffix style of their format, e.g. 512Mi for a binding derived from a 1Gi quantity. Those
+// without one, e.g. derived from node capacity alone, are rendered with decimal suffixes.
 func (rpb *ResourcePropertyBinding) HumanValue() string {
+	return rpb.FormatValue(PrecisionReadable)
+}
+
+// FormatValue renders the value like HumanValue, rounded down with the given precision. Fractions are rendered as
+// decimals, whatever the precision.
+func (rpb *ResourcePropertyBinding) FormatValue(precision Precision) string {
 	if rpb.resourceKind == ResourceFraction {
-		return strconv.FormatFloat(rpb.value, 'f', -1, 64)
+		return strconv.FormatFloat(rpb.Value(), 'f', -1, 64)
 	}
+	return roundQuantity(rpb.value, cmp.Or(rpb.format, resource.DecimalSI), rpb.rounded || precision == PrecisionMilli).String()
+}
 
-	milliQty := rpb.value * 1000
-	if milliQty > 10_000 {
-		scale := appropriateIntegerExponent(rpb.value, 10.0) // we should be aware if we're not a power of 10 but a power of 2 instead, to preserve Mi/Gi suffixes
-		exp := math.Pow10(int(scale))
-		return resource.NewScaledQuantity(int64(math.Floor(rpb.value/exp)), resource.Scale(scale)).String()
-	} else {
-		return resource.NewMilliQuantity(int64(milliQty), resource.DecimalSI).String()
+// MultiplyQuantity returns quantity times multiplier, in the format of quantity and rounded down with the given
+// precision. Unlike going through floats, the product is exact before rounding, whatever the magnitude of quantity.
+func MultiplyQuantity(quantity resource.Quantity, multiplier *big.Rat, precision Precision) resource.Quantity {
+	product := new(big.Rat).Mul(QuantityRat(quantity), multiplier)
+	return *roundQuantity(product, quantity.Format, precision == PrecisionMilli)
+}
+
+// roundQuantity rounds value down to a quantity rendered with the suffixes of format, binary ones for BinarySI. Unless
+// exact, value is first rounded down to the largest unit it holds at least 10 of, e.g. Mi for 300M, down to milli-units.
+func roundQuantity(value *big.Rat, format resource.Format, exact bool) *resource.Quantity {
+	milli := big.NewRat(1, 1000)
+	unit := milli
+	if !exact {
+		base := big.NewRat(1000, 1)
+		if format == resource.BinarySI {
+			base = big.NewRat(1024, 1)
+		}
+		for next := big.NewRat(1, 1); value.Cmp(new(big.Rat).Mul(next, big.NewRat(10, 1))) >= 0; next = new(big.Rat).Mul(next, base) {
+			unit = next
+		}
+	}
+	floored := new(big.Rat).Mul(new(big.Rat).SetInt(floorRat(new(big.Rat).Quo(value, unit))), unit)
+	if !floored.IsInt() {
+		return newQuantity(floorRat(new(big.Rat).Quo(floored, milli)), true, format)
+	}
+	return newQuantity(floorRat(floored), false, format)
+}
+
+// newQuantity returns n units, or milli-units, going through an arbitrary precision decimal only when n does not fit in
+// an int64, as quantities built that way do not pick the largest suffix
+func newQuantity(n *big.Int, milli bool, format resource.Format) *resource.Quantity {
+	switch {
+	case !n.IsInt64():
+		scale := inf.Scale(0)
+		if milli {
+			scale = 3
+		}
+		return resource.NewDecimalQuantity(*inf.NewDecBig(n, scale), format)
+	case milli:
+		return resource.NewMilliQuantity(n.Int64(), format)
+	default:
+		return resource.NewQuantity(n.Int64(), format)
 	}
 }
 
+// Quantity returns the value as rendered by HumanValue, parsed back to a quantity whose format follows its suffix
+func (rpb *ResourcePropertyBinding) Quantity() resource.Quantity {
+	return resource.MustParse(rpb.HumanValue())
+}
+
+// PropertyJsonPath points to the value within a JSONPatch of a pod, for the container at the given index
 func (rpb *ResourcePropertyBinding) PropertyJsonPath(containerIndex int) string {
 	return fmt.Sprintf("/spec/containers/%d/resources/%s/%s", containerIndex, string(rpb.resourceProp), rpb.resourceName)
 }
 
-// We could technically allow other packages to register or modify the supported annotations. Should we? File an issue!
+// AllowOvercommitAnnotation, set to "true", lets limit fractions go above 1, e.g. 1.5 for limits of 150% of the node,
+// on nodes that are heavily overcommitted anyway. Request fractions stay at most 1.
+const AllowOvercommitAnnotation = "node-specific-sizing.manomano.tech/allow-overcommit"
+
+// Basis tells what a fraction applies to on a node: its capacity, its allocatable resources, the numeric value of one
+// of its labels, or its capacity of another resource, e.g. an extended resource
+type Basis string
+
+const (
+	BasisCapacity    Basis = "capacity"
+	BasisAllocatable Basis = "allocatable"
+
+	basisLabelPrefix    = "label:"
+	basisResourcePrefix = "resource:"
+)
+
+// ParseBasis reads capacity, allocatable, label:<node label key>, e.g. label:node.example.com/nvme-bytes, or
+// resource:<resource name>, e.g. resource:example.com/nvme
+func ParseBasis(value string) (Basis, error) {
+	basis := Basis(strings.TrimSpace(value))
+	if basis == BasisCapacity || basis == BasisAllocatable {
+		return basis, nil
+	}
+	name, ok := basis.Label()
+	if resourceName, isResource := basis.Resource(); isResource {
+		name, ok = string(resourceName), true
+	}
+	if !ok || len(validation.IsQualifiedName(name)) > 0 {
+		return "", fmt.Errorf("%s is not a valid basis: expected capacity, allocatable, label:<node label> or resource:<resource name>", value)
+	}
+	return basis, nil
+}
+
+// Label returns the key of the node label the basis reads, if it reads one
+func (b Basis) Label() (string, bool) {
+	return strings.CutPrefix(string(b), basisLabelPrefix)
+}
+
+// Resource returns the node resource whose capacity the basis reads, if it reads another resource than the one sized
+func (b Basis) Resource() (corev1.ResourceName, bool) {
+	name, ok := strings.CutPrefix(string(b), basisResourcePrefix)
+	return corev1.ResourceName(name), ok
+}
+
+// BasisAnnotation returns the key of the annotation setting the basis of a fraction annotation, e.g.
+// node-specific-sizing.manomano.tech/request-memory-basis for request-memory-fraction
+func BasisAnnotation(fractionAnnotation string) string {
+	return strings.TrimSuffix(fractionAnnotation, "-fraction") + "-basis"
+}
+
+// IsBasisAnnotation tells whether key sets the basis of a supported fraction annotation
+func IsBasisAnnotation(key string) bool {
+	supportedAnnotationsLock.RLock()
+	defer supportedAnnotationsLock.RUnlock()
+	_, ok := basisAnnotations[key]
+	return ok
+}
+
+// supportedAnnotations is guarded by supportedAnnotationsLock, as RegisterAnnotation may add to it
 var supportedAnnotations = map[string]ResourcePropertyBinding{
 	"node-specific-sizing.manomano.tech/request-cpu-fraction":    {resourceKind: ResourceFraction, resourceProp: ResourceRequests, resourceName: corev1.ResourceCPU},
 	"node-specific-sizing.manomano.tech/request-memory-fraction": {resourceKind: ResourceFraction, resourceProp: ResourceRequests, resourceName: corev1.ResourceMemory},
@@ -111,33 +331,152 @@ var supportedAnnotations = map[string]ResourcePropertyBinding{
 	"node-specific-sizing.manomano.tech/minimum-memory":          {resourceKind: ResourceQuantity, resourceProp: ResourcePodMinimum, resourceName: corev1.ResourceMemory},
 	"node-specific-sizing.manomano.tech/maximum-cpu":             {resourceKind: ResourceQuantity, resourceProp: ResourcePodMaximum, resourceName: corev1.ResourceCPU},
 	"node-specific-sizing.manomano.tech/maximum-memory":          {resourceKind: ResourceQuantity, resourceProp: ResourcePodMaximum, resourceName: corev1.ResourceMemory},
+	"node-specific-sizing.manomano.tech/rounding-cpu":            {resourceKind: ResourceQuantity, resourceProp: ResourceRounding, resourceName: corev1.ResourceCPU},
+	"node-specific-sizing.manomano.tech/rounding-memory":         {resourceKind: ResourceQuantity, resourceProp: ResourceRounding, resourceName: corev1.ResourceMemory},
+	// Hugepages are only sized for the page sizes nodes commonly pre-allocate
+	"node-specific-sizing.manomano.tech/request-hugepages-2Mi-fraction": {resourceKind: ResourceFraction, resourceProp: ResourceRequests, resourceName: "hugepages-2Mi"},
+	"node-specific-sizing.manomano.tech/limit-hugepages-2Mi-fraction":   {resourceKind: ResourceFraction, resourceProp: ResourceLimits, resourceName: "hugepages-2Mi"},
+	"node-specific-sizing.manomano.tech/minimum-hugepages-2Mi":          {resourceKind: ResourceQuantity, resourceProp: ResourcePodMinimum, resourceName: "hugepages-2Mi"},
+	"node-specific-sizing.manomano.tech/maximum-hugepages-2Mi":          {resourceKind: ResourceQuantity, resourceProp: ResourcePodMaximum, resourceName: "hugepages-2Mi"},
+	"node-specific-sizing.manomano.tech/rounding-hugepages-2Mi":         {resourceKind: ResourceQuantity, resourceProp: ResourceRounding, resourceName: "hugepages-2Mi"},
+	"node-specific-sizing.manomano.tech/request-hugepages-1Gi-fraction": {resourceKind: ResourceFraction, resourceProp: ResourceRequests, resourceName: "hugepages-1Gi"},
+	"node-specific-sizing.manomano.tech/limit-hugepages-1Gi-fraction":   {resourceKind: ResourceFraction, resourceProp: ResourceLimits, resourceName: "hugepages-1Gi"},
+	"node-specific-sizing.manomano.tech/minimum-hugepages-1Gi":          {resourceKind: ResourceQuantity, resourceProp: ResourcePodMinimum, resourceName: "hugepages-1Gi"},
+	"node-specific-sizing.manomano.tech/maximum-hugepages-1Gi":          {resourceKind: ResourceQuantity, resourceProp: ResourcePodMaximum, resourceName: "hugepages-1Gi"},
+	"node-specific-sizing.manomano.tech/rounding-hugepages-1Gi":         {resourceKind: ResourceQuantity, resourceProp: ResourceRounding, resourceName: "hugepages-1Gi"},
+}
+
+// HugePageSize returns the page size of a hugepages resource, e.g. 2Mi for hugepages-2Mi
+func HugePageSize(name corev1.ResourceName) (resource.Quantity, bool) {
+	size, isHugePages := strings.CutPrefix(string(name), corev1.ResourceHugePagesPrefix)
+	if !isHugePages {
+		return resource.Quantity{}, false
+	}
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil || quantity.Sign() <= 0 {
+		return resource.Quantity{}, false
+	}
+	return quantity, true
 }
 
+// basisAnnotations maps the basis annotation of every supported fraction annotation to the latter, it is guarded by
+// supportedAnnotationsLock as well
+var basisAnnotations = func() map[string]string {
+	result := make(map[string]string)
+	for key, binding := range supportedAnnotations {
+		if binding.resourceKind == ResourceFraction {
+			result[BasisAnnotation(key)] = key
+		}
+	}
+	return result
+}()
+
+var supportedAnnotationsLock sync.RWMutex
+
+// RegisterAnnotation makes NewFromAnnotations read key into the property and resource of binding, parsed according to
+// its kind, e.g. to size custom resources:
+//
+//	rps.RegisterAnnotation("example.com/request-gpu-fraction",
+//		*rps.NewBinding(rps.ResourceFraction, rps.ResourceRequests, "nvidia.com/gpu", 0))
+//
+// The value of binding is ignored. Registering a key again replaces its binding, built-in annotations included.
+// It panics on properties that are not valid, as it is meant to be called on start, e.g. from an init function.
+func RegisterAnnotation(key string, binding ResourcePropertyBinding) {
+	validKind := binding.resourceKind == ResourceFraction || binding.resourceKind == ResourceQuantity
+	if key == "" || !validKind || !slices.Contains(allValidResourceProperties, binding.resourceProp) || binding.resourceName == "" {
+		panic(fmt.Sprintf("resource_properties: cannot register %q to %s %s", key, binding.resourceProp, binding.resourceName))
+	}
+	supportedAnnotationsLock.Lock()
+	defer supportedAnnotationsLock.Unlock()
+	supportedAnnotations[key] = ResourcePropertyBinding{resourceKind: binding.resourceKind, resourceProp: binding.resourceProp, resourceName: binding.resourceName}
+	delete(basisAnnotations, BasisAnnotation(key))
+	if binding.resourceKind == ResourceFraction {
+		basisAnnotations[BasisAnnotation(key)] = key
+	}
+}
+
+// ResourceProperties holds values by property and resource. Its zero value is not usable, see New.
 type ResourceProperties struct {
 	props map[ResourceProperty]map[corev1.ResourceName]*ResourcePropertyBinding
 }
 
+// New returns empty resource properties
 func New() *ResourceProperties {
-	result := &ResourceProperties{
-		props: make(map[ResourceProperty]map[corev1.ResourceName]*ResourcePropertyBinding),
+	// Level-2 maps are only allocated on first bind, see bindings: reading from a missing one is safe
+	return &ResourceProperties{
+		props: make(map[ResourceProperty]map[corev1.ResourceName]*ResourcePropertyBinding, len(allValidResourceProperties)),
 	}
+}
 
-	// Pre-allocate level-1 maps to avoid constantly checking for their presence
-	for _, prop := range allValidResourceProperties {
-		result.props[prop] = make(map[corev1.ResourceName]*ResourcePropertyBinding)
+// bindings returns the bindings of prop, allocating them when needed
+func (rp *ResourceProperties) bindings(prop ResourceProperty) map[corev1.ResourceName]*ResourcePropertyBinding {
+	byResource, ok := rp.props[prop]
+	if !ok {
+		byResource = make(map[corev1.ResourceName]*ResourcePropertyBinding)
+		rp.props[prop] = byResource
 	}
-	return result
+	return byResource
+}
+
+// SupportedAnnotations iterates over the keys of annotations NewFromAnnotations reads, registered ones, basis
+// annotations and AllowOvercommitAnnotation included
+func SupportedAnnotations() iter.Seq[string] {
+	supportedAnnotationsLock.RLock()
+	defer supportedAnnotationsLock.RUnlock()
+	keys := slices.AppendSeq(slices.Collect(maps.Keys(supportedAnnotations)), maps.Keys(basisAnnotations))
+	return slices.Values(append(keys, AllowOvercommitAnnotation))
 }
 
+// SupportedBinding returns the binding key is read into, without any value, e.g. to describe supported annotations
+func SupportedBinding(key string) (ResourcePropertyBinding, bool) {
+	supportedAnnotationsLock.RLock()
+	defer supportedAnnotationsLock.RUnlock()
+	binding, ok := supportedAnnotations[key]
+	return binding, ok
+}
+
+// NewFromAnnotations parses the supported annotations found in annotations, ignoring any other. Limit fractions may be
+// above 1 when AllowOvercommitAnnotation is set to true. Basis annotations set the basis of their fraction, see
+// BasisAnnotation, and are checked but ignored without it. Unlike most of Go, the error comes first, which the stability
+// guarantees of this package keep as is.
 func NewFromAnnotations(annotations map[string]string) (error, *ResourceProperties) {
 	result := New()
 
-	for supportedAnnotation, supportedBinding := range supportedAnnotations {
-		if value, ok := annotations[supportedAnnotation]; ok {
-			err := result.BindPropertyString(supportedBinding.resourceKind, supportedBinding.resourceProp, supportedBinding.resourceName, value)
+	allowOvercommit := false
+	if value, ok := annotations[AllowOvercommitAnnotation]; ok {
+		var err error
+		if allowOvercommit, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s is not a valid %s: expected true or false", value, AllowOvercommitAnnotation), nil
+		}
+	}
+
+	// Pods carry far fewer annotations than there are supported ones, so look the former up in the latter
+	supportedAnnotationsLock.RLock()
+	defer supportedAnnotationsLock.RUnlock()
+	var bases map[string]Basis
+	for annotation, value := range annotations {
+		if supportedBinding, ok := supportedAnnotations[annotation]; ok {
+			overcommit := allowOvercommit && supportedBinding.resourceProp == ResourceLimits
+			err := result.bindString(supportedBinding.resourceKind, supportedBinding.resourceProp, supportedBinding.resourceName, value, overcommit)
 			if err != nil {
 				return err, nil
 			}
+		} else if fractionAnnotation, ok := basisAnnotations[annotation]; ok {
+			basis, err := ParseBasis(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", annotation, err), nil
+			}
+			if bases == nil {
+				bases = make(map[string]Basis)
+			}
+			bases[fractionAnnotation] = basis
+		}
+	}
+	// Fractions may come after their basis
+	for fractionAnnotation, basis := range bases {
+		supportedBinding := supportedAnnotations[fractionAnnotation]
+		if binding, ok := result.props[supportedBinding.resourceProp][supportedBinding.resourceName]; ok {
+			binding.basis = basis
 		}
 	}
 
@@ -156,19 +495,28 @@ func (rp *ResourceProperties) String() string {
 // GetValue returns (value, true) of an existing binding, or (0, false) for an unbound prop
 func (rp *ResourceProperties) GetValue(prop ResourceProperty, res corev1.ResourceName) (float64, bool) {
 	if ourBinding, ok := rp.props[prop][res]; ok {
-		return ourBinding.value, true
+		return ourBinding.Value(), true
 	} else {
 		return 0, false
 	}
 }
 
+// GetRat returns (a copy of the exact value, true) of an existing binding, or (nil, false) for an unbound prop
+func (rp *ResourceProperties) GetRat(prop ResourceProperty, res corev1.ResourceName) (*big.Rat, bool) {
+	if ourBinding, ok := rp.props[prop][res]; ok {
+		return ourBinding.Rat(), true
+	} else {
+		return nil, false
+	}
+}
+
 // All iterates over all bindings
 func (rp *ResourceProperties) All() iter.Seq[*ResourcePropertyBinding] {
 	return func(yield func(binding *ResourcePropertyBinding) bool) {
 		for _, byProps := range rp.props {
 			for _, byResource := range byProps {
 				if cont := yield(byResource); !cont {
-					break
+					return
 				}
 			}
 		}
@@ -178,98 +526,163 @@ func (rp *ResourceProperties) All() iter.Seq[*ResourcePropertyBinding] {
 // Bind registers a new binding, potentially removing a pre-existing binding.
 // The pass-by-value is intentional.
 func (rp *ResourceProperties) Bind(bind ResourcePropertyBinding) {
-	rp.props[bind.resourceProp][bind.resourceName] = &bind
+	if bind.value == nil {
+		bind.value = new(big.Rat)
+	} else {
+		bind.value = new(big.Rat).Set(bind.value)
+	}
+	rp.bindings(bind.resourceProp)[bind.resourceName] = &bind
 }
 
 // BindPropertyFloat binds a given resource property to a float value
 func (rp *ResourceProperties) BindPropertyFloat(kind ResourceKind, prop ResourceProperty, res corev1.ResourceName, value float64) {
+	rp.BindPropertyRat(kind, prop, res, ratFromFloat(value))
+}
+
+// BindPropertyRat binds a given resource property to an exact value, which is copied
+func (rp *ResourceProperties) BindPropertyRat(kind ResourceKind, prop ResourceProperty, res corev1.ResourceName, value *big.Rat) {
 	if existing, ok := rp.props[prop][res]; ok {
-		existing.value = value
+		existing.value = new(big.Rat).Set(value)
 	} else {
-		rp.props[prop][res] = &ResourcePropertyBinding{kind, prop, res, value}
+		rp.bindings(prop)[res] = &ResourcePropertyBinding{resourceKind: kind, resourceProp: prop, resourceName: res, value: new(big.Rat).Set(value)}
 	}
 }
 
-func parseFraction(value string) (float64, error) {
-	result, err := strconv.ParseFloat(value, 64)
-
-	if err != nil {
-		return 0, err
+// parseFraction parses a fraction in ]0, 1], or any positive one when overcommit is allowed
+func parseFraction(value string, overcommit bool) (*big.Rat, error) {
+	result, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return nil, fmt.Errorf("not a number")
 	}
 
-	if result <= 0 {
+	if result.Sign() <= 0 {
 		// We forbid 0 included because it makes no sense as a request or limit
-		return 0, fmt.Errorf("%s is not a valid fraction: cannot be <= 0", value)
+		return nil, fmt.Errorf("%s is not a valid fraction: cannot be <= 0", value)
 	}
 
-	if result > 1 {
-		return 0, fmt.Errorf("%s is not a valid fraction: cannot be > 1", value)
+	if !overcommit && result.Cmp(big.NewRat(1, 1)) > 0 {
+		return nil, fmt.Errorf("%s is not a valid fraction: cannot be > 1", value)
 	}
 
 	return result, nil
 }
 
-func parseQuantity(value string) (float64, error) {
+func parseQuantity(value string) (*big.Rat, resource.Format, error) {
 	qty, err := resource.ParseQuantity(value)
 	if err != nil {
-		return 0, err
+		return nil, "", err
 	}
-	return qty.AsApproximateFloat64(), nil
+	return QuantityRat(qty), qty.Format, nil
 }
 
-// BindPropertyString binds a given resource property to a float value by parsing it from a string.
+// BindPropertyString binds a given resource property to an exact value by parsing it from a string.
 // The parsing is different whether the kind is a fraction or a quantity:
-//   - For fractions, a floating point number between 0 and 1 (excluded) is expected.
-//     I'm ~into the idea of support N/M rationals, but that might be purely a curiosity thing.
+//   - For fractions, a decimal number in ]0, 1] is expected. Being rationals internally, N/M is accepted as well.
 //   - For quantities, any number that Kubernetes would accept will do. That includes many quantities with SI suffixes, like 100m or 2G
 func (rp *ResourceProperties) BindPropertyString(kind ResourceKind, prop ResourceProperty, res corev1.ResourceName, value string) error {
+	return rp.bindString(kind, prop, res, value, false)
+}
+
+func (rp *ResourceProperties) bindString(kind ResourceKind, prop ResourceProperty, res corev1.ResourceName, value string, overcommit bool) error {
 	var err error
-	var parsedValue float64
+	var parsedValue *big.Rat
+	var format resource.Format
 
 	if kind == ResourceFraction {
-		parsedValue, err = parseFraction(value)
+		parsedValue, err = parseFraction(value, overcommit)
 	} else {
-		parsedValue, err = parseQuantity(value)
+		parsedValue, format, err = parseQuantity(value)
 	}
 
 	if err != nil {
 		return fmt.Errorf("%s cannot be parsed as a %s: %s", value, kind, err)
 	}
 
-	rp.BindPropertyFloat(kind, prop, res, parsedValue)
+	rp.BindPropertyRat(kind, prop, res, parsedValue)
+	rp.props[prop][res].format = format
 	return nil
 }
 
-// Add merges two sets of properties into the receiver, by adding properties values from the added props, creating new
-// bindings if needed.
-// NB: Unlike the Div and Mul operators, the Add operators works in-place.
-func (rp *ResourceProperties) Add(operand *ResourceProperties) {
+// Clone returns a deep copy of the receiver, which can then be modified without affecting it
+func (rp *ResourceProperties) Clone() *ResourceProperties {
+	result := New()
+	for binding := range rp.All() {
+		result.Bind(*binding)
+	}
+	return result
+}
+
+// Add produces new resource properties by adding the operand values to the receiver values. Props defined on one side
+// only are copied as is. Like the other operators, it leaves both sides untouched, see AddInPlace to sum into the
+// receiver instead.
+func (rp *ResourceProperties) Add(operand *ResourceProperties) *ResourceProperties {
+	result := rp.Clone()
+	result.AddInPlace(operand)
+	return result
+}
+
+// AddInPlace merges two sets of properties into the receiver, by adding properties values from the added props,
+// creating new bindings if needed. It saves a copy when summing many sets, e.g. the resources of every container.
+func (rp *ResourceProperties) AddInPlace(operand *ResourceProperties) {
 	for otherBinding := range operand.All() {
 		if ourBinding, ok := rp.props[otherBinding.resourceProp][otherBinding.resourceName]; ok {
-			ourBinding.value += otherBinding.value
+			ourBinding.value.Add(ourBinding.value, otherBinding.value)
 		} else {
-			otherBindingCopy := *otherBinding
-			rp.props[otherBinding.resourceProp][otherBinding.resourceName] = &otherBindingCopy
+			rp.Bind(*otherBinding)
 		}
 	}
 }
 
-// AddResourceRequirements merge a Kubernetes ResourceRequirements to the props
+// AddResourceRequirements merge a Kubernetes ResourceRequirements to the props, keeping the format of quantities
 func (rp *ResourceProperties) AddResourceRequirements(reqs *corev1.ResourceRequirements) {
 	for name, quantity := range reqs.Requests {
-		rp.BindPropertyFloat(ResourceQuantity, ResourceRequests, name, quantity.AsApproximateFloat64())
+		rp.BindPropertyRat(ResourceQuantity, ResourceRequests, name, QuantityRat(quantity))
+		rp.props[ResourceRequests][name].format = quantity.Format
 	}
 
 	for name, quantity := range reqs.Limits {
-		rp.BindPropertyFloat(ResourceQuantity, ResourceLimits, name, quantity.AsApproximateFloat64())
+		rp.BindPropertyRat(ResourceQuantity, ResourceLimits, name, QuantityRat(quantity))
+		rp.props[ResourceLimits][name].format = quantity.Format
 	}
 }
 
+// FromResourceRequirements returns the quantities of a Kubernetes ResourceRequirements as props, keeping their format
+func FromResourceRequirements(reqs *corev1.ResourceRequirements) *ResourceProperties {
+	result := New()
+	result.AddResourceRequirements(reqs)
+	return result
+}
+
+// ToResourceRequirements returns the quantities of the props as a Kubernetes ResourceRequirements, rendered like
+// HumanValue so that they match JSON patches, ready to be set on a container. Fractions and properties other than
+// requests and limits are left out, and so are lists without any quantity.
+func (rp *ResourceProperties) ToResourceRequirements() corev1.ResourceRequirements {
+	var result corev1.ResourceRequirements
+	for binding := range rp.All() {
+		var list *corev1.ResourceList
+		switch {
+		case binding.resourceKind != ResourceQuantity:
+			continue
+		case binding.resourceProp == ResourceRequests:
+			list = &result.Requests
+		case binding.resourceProp == ResourceLimits:
+			list = &result.Limits
+		default:
+			continue
+		}
+		if *list == nil {
+			*list = make(corev1.ResourceList)
+		}
+		(*list)[binding.resourceName] = binding.Quantity()
+	}
+	return result
+}
+
 // Mul produces new resource properties by multiplying the receiver values by the operand values
 // Props unset on either side of the operation are unset on the result rather than set to zero.
 //
 // The output kind depends on the input kind : multiplying two fractions produces another fraction,
-// while any other combination produces a quantity.
+// while any other combination produces a quantity. The output keeps the format of the receiver, or else the operand.
 func (rp *ResourceProperties) Mul(operand *ResourceProperties) *ResourceProperties {
 	result := New()
 	for ourBinding := range rp.All() {
@@ -278,7 +691,8 @@ func (rp *ResourceProperties) Mul(operand *ResourceProperties) *ResourceProperti
 			if ourBinding.resourceKind == ResourceFraction && otherBinding.resourceKind == ResourceFraction {
 				kind = ResourceFraction
 			}
-			result.BindPropertyFloat(kind, ourBinding.resourceProp, ourBinding.resourceName, ourBinding.value*otherBinding.value)
+			result.BindPropertyRat(kind, ourBinding.resourceProp, ourBinding.resourceName, new(big.Rat).Mul(ourBinding.value, otherBinding.value))
+			result.props[ourBinding.resourceProp][ourBinding.resourceName].format = cmp.Or(ourBinding.format, otherBinding.format)
 		}
 	}
 	return result
@@ -286,8 +700,8 @@ func (rp *ResourceProperties) Mul(operand *ResourceProperties) *ResourceProperti
 
 // Div produces new resource properties by dividing the receiver values by the operand values
 //
-// Only props defined on the receiver will be used. If no matching prop is defined on the operand,
-// this operation will panic, like a division by zero would.
+// Only props defined on the receiver will be used. Like with Mul, props the operand does not define are unset on the
+// result, and so are props the operand binds to zero, as there is nothing to divide by.
 //
 // If some props are defined on the operand but not on the receiver, then these props will be absent
 // from the result.
@@ -297,15 +711,67 @@ func (rp *ResourceProperties) Mul(operand *ResourceProperties) *ResourceProperti
 // - fraction / quantity => quantity (weird way to put things, consider using Mul instead)
 // - quantity / fraction => quantity (weird way to put things, consider using Mul instead)
 // - fraction / fraction => fraction
+//
+// The output keeps the format of the receiver, so that proportions of a quantity are rendered like it once multiplied.
 func (rp *ResourceProperties) Div(operand *ResourceProperties) *ResourceProperties {
 	result := New()
 	for ourBinding := range rp.All() {
-		otherBinding := operand.props[ourBinding.resourceProp][ourBinding.resourceName]
+		otherBinding, ok := operand.props[ourBinding.resourceProp][ourBinding.resourceName]
+		if !ok || otherBinding.value.Sign() == 0 {
+			continue
+		}
 		kind := ResourceQuantity
 		if ourBinding.resourceKind == otherBinding.resourceKind {
 			kind = ResourceFraction
 		}
-		result.BindPropertyFloat(kind, ourBinding.resourceProp, ourBinding.resourceName, ourBinding.value/otherBinding.value)
+		result.BindPropertyRat(kind, ourBinding.resourceProp, ourBinding.resourceName, new(big.Rat).Quo(ourBinding.value, otherBinding.value))
+		result.props[ourBinding.resourceProp][ourBinding.resourceName].format = ourBinding.format
+	}
+	return result
+}
+
+// Sub produces new resource properties by subtracting the operand values from the receiver values, e.g. daemon
+// overhead from node allocatable. Props the operand does not define are copied from the receiver as is, while props
+// only defined on the operand are absent from the result. Values may become negative, see Max to bound them.
+func (rp *ResourceProperties) Sub(operand *ResourceProperties) *ResourceProperties {
+	result := New()
+	for ourBinding := range rp.All() {
+		result.Bind(*ourBinding)
+		if otherBinding, ok := operand.props[ourBinding.resourceProp][ourBinding.resourceName]; ok {
+			difference := result.props[ourBinding.resourceProp][ourBinding.resourceName]
+			difference.value.Sub(difference.value, otherBinding.value)
+			difference.format = cmp.Or(difference.format, otherBinding.format)
+			difference.rounded = false
+		}
+	}
+	return result
+}
+
+// Min produces new resource properties holding, for every prop, the smallest of the receiver and operand values.
+// Props defined on one side only are copied as is, an unset prop does not bound anything.
+func (rp *ResourceProperties) Min(operand *ResourceProperties) *ResourceProperties {
+	return rp.pick(operand, func(ours, theirs *big.Rat) bool { return ours.Cmp(theirs) <= 0 })
+}
+
+// Max produces new resource properties holding, for every prop, the largest of the receiver and operand values, e.g.
+// to make sure a budget is at least some quantity. Props defined on one side only are copied as is, an unset prop
+// does not bound anything.
+func (rp *ResourceProperties) Max(operand *ResourceProperties) *ResourceProperties {
+	return rp.pick(operand, func(ours, theirs *big.Rat) bool { return ours.Cmp(theirs) >= 0 })
+}
+
+// pick copies, for every prop, the receiver binding if keepOurs says so or the operand does not define it, or else
+// the operand binding
+func (rp *ResourceProperties) pick(operand *ResourceProperties, keepOurs func(ours, theirs *big.Rat) bool) *ResourceProperties {
+	result := New()
+	for otherBinding := range operand.All() {
+		result.Bind(*otherBinding)
+	}
+	for ourBinding := range rp.All() {
+		otherBinding, ok := operand.props[ourBinding.resourceProp][ourBinding.resourceName]
+		if !ok || keepOurs(ourBinding.value, otherBinding.value) {
+			result.Bind(*ourBinding)
+		}
 	}
 	return result
 }
@@ -324,24 +790,39 @@ func (rp *ResourceProperties) allResourceNames() iter.Seq[corev1.ResourceName] {
 	}
 }
 
-// ForceLimitAboveRequest goes over every bound property. If, for any given resourceName, a limit would be below the
-// request, it is mutated to be equal to the request instead.
+// LowerRequestsToLimits goes over every bound property. If, for any given resourceName, a request would be above the
+// limit, the request is lowered to the limit. Limits are left untouched.
 //
-// This is - not great - but it's a necessary evil when working with floats and their ever-perplexing rounding oddities.
-// We could rework our whole package to be able to work with rational numbers expressed as fractions to mitigate most of
-// it, but at some point, node resources will have to be divided.
-func (rp *ResourceProperties) ForceLimitAboveRequest() {
+// Values being exact, this only happens when the configured limit fraction is below the request one, or when clamping
+// brought a limit below its request.
+func (rp *ResourceProperties) LowerRequestsToLimits() {
 	for resourceName := range rp.allResourceNames() {
 		request, hasRequest := rp.props[ResourceRequests][resourceName]
 		limit, hasLimit := rp.props[ResourceLimits][resourceName]
 
-		if hasRequest && hasLimit && (request.Value() > limit.Value()) {
-			// XXX log warning, we shouldn't have to do this but because of float imprecision, we sometimes do
-			rp.BindPropertyFloat(request.resourceKind, ResourceRequests, resourceName, limit.Value())
+		if hasRequest && hasLimit && request.value.Cmp(limit.value) > 0 {
+			rp.BindPropertyRat(request.resourceKind, ResourceRequests, resourceName, limit.value)
 		}
 	}
 }
 
+// ForceLimitAboveRequest lowers requests above their limit to it, despite its name.
+//
+// Deprecated: use LowerRequestsToLimits, which tells what it does.
+func (rp *ResourceProperties) ForceLimitAboveRequest() {
+	rp.LowerRequestsToLimits()
+}
+
+// CheckBounds returns an error if, for any given resourceName, the minimum is above the maximum
+func (rp *ResourceProperties) CheckBounds() error {
+	for resourceName, minimum := range rp.props[ResourcePodMinimum] {
+		if maximum, ok := rp.props[ResourcePodMaximum][resourceName]; ok && minimum.value.Cmp(maximum.value) > 0 {
+			return fmt.Errorf("minimum %s (%s) cannot be above maximum %s (%s)", resourceName, minimum.HumanValue(), resourceName, maximum.HumanValue())
+		}
+	}
+	return nil
+}
+
 // ClampRequestsAndLimits goes over every bound property. If, for any given resourceName, a limit or a requests needs
 // to be clamped according to the matching minimum or maximum from userSettings, it will be.
 func (rp *ResourceProperties) ClampRequestsAndLimits(userSettings *ResourceProperties) {
@@ -352,13 +833,42 @@ func (rp *ResourceProperties) ClampRequestsAndLimits(userSettings *ResourcePrope
 
 		for _, prop := range []ResourceProperty{ResourceLimits, ResourceRequests} {
 			if bind, isBound := rp.props[prop][resourceName]; isBound {
-				if hasMinimum && bind.Value() < minimum.Value() {
-					bind.SetValue(minimum.Value())
+				if hasMinimum && bind.value.Cmp(minimum.value) < 0 {
+					bind.SetRat(minimum.value)
 				}
-				if hasMaximum && bind.Value() > maximum.Value() {
-					bind.SetValue(maximum.Value())
+				if hasMaximum && bind.value.Cmp(maximum.value) > 0 {
+					bind.SetRat(maximum.value)
 				}
 			}
 		}
 	}
 }
+
+// Round rounds requests and limits down to a multiple of the step bound as ResourceRounding in rounding, for their
+// resource, e.g. 1m for CPU or 1Mi for memory. Rounded values are then rendered in the format of the step, so that
+// memory rounded to 1Mi keeps binary suffixes. Resources without a positive step are left alone.
+//
+// Hugepages are always rounded down to a whole number of pages as well, see HugePageSize, as the kubelet refuses
+// fractions of a page.
+//
+// Rounding down keeps the order of values: a request at most equal to its limit stays at most equal to it.
+func (rp *ResourceProperties) Round(rounding *ResourceProperties) {
+	for _, prop := range []ResourceProperty{ResourceRequests, ResourceLimits} {
+		for resourceName, bind := range rp.props[prop] {
+			if step, ok := rounding.props[ResourceRounding][resourceName]; ok && step.value.Sign() > 0 {
+				bind.roundDown(step.value, step.format)
+			}
+			if pageSize, isHugePages := HugePageSize(resourceName); isHugePages {
+				bind.roundDown(QuantityRat(pageSize), resource.BinarySI)
+			}
+		}
+	}
+}
+
+// roundDown rounds the value down to a multiple of step, to be rendered in format
+func (rpb *ResourcePropertyBinding) roundDown(step *big.Rat, format resource.Format) {
+	steps := floorRat(new(big.Rat).Quo(rpb.value, step))
+	rpb.value = new(big.Rat).Mul(new(big.Rat).SetInt(steps), step)
+	rpb.format = format
+	rpb.rounded = true
+}
@@ -5,6 +5,9 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"math/big"
+	"slices"
 )
 
 var _ = Describe("Manipulating resource property bindings", Label("ResourcePropertyBinding"), func() {
@@ -17,3 +20,351 @@ var _ = Describe("Manipulating resource property bindings", Label("ResourcePrope
 		})
 	})
 })
+
+var _ = Describe("Checking bounds", Label("ResourceProperties"), func() {
+	It("accepts minimums below maximums", func() {
+		err, props := rps.NewFromAnnotations(map[string]string{
+			"node-specific-sizing.manomano.tech/minimum-memory": "1G",
+			"node-specific-sizing.manomano.tech/maximum-memory": "2G",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(props.CheckBounds()).To(Succeed())
+	})
+
+	It("rejects minimums above maximums", func() {
+		err, props := rps.NewFromAnnotations(map[string]string{
+			"node-specific-sizing.manomano.tech/minimum-memory": "4G",
+			"node-specific-sizing.manomano.tech/maximum-memory": "2G",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(props.CheckBounds()).To(MatchError("minimum memory (4G) cannot be above maximum memory (2G)"))
+	})
+})
+
+var _ = Describe("Doing arithmetic", Label("ResourceProperties"), func() {
+	node := rps.New()
+	node.AddResourceRequirements(&corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+		Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+	})
+
+	It("keeps exact values through fractions", func() {
+		err, fractions := rps.NewFromAnnotations(map[string]string{
+			"node-specific-sizing.manomano.tech/request-memory-fraction": "0.3",
+			"node-specific-sizing.manomano.tech/limit-memory-fraction":   "0.3",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		budget := fractions.Mul(node)
+
+		request, _ := budget.GetRat(rps.ResourceRequests, corev1.ResourceMemory)
+		limit, _ := budget.GetRat(rps.ResourceLimits, corev1.ResourceMemory)
+		Expect(request.Cmp(limit)).To(BeZero())
+		Expect(request.Cmp(big.NewRat(3*1024*1024*1024, 10))).To(BeZero())
+	})
+
+	It("accepts rational fractions", func() {
+		err, fractions := rps.NewFromAnnotations(map[string]string{
+			"node-specific-sizing.manomano.tech/request-memory-fraction": "1/3",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		sum := rps.New()
+		for range 3 {
+			sum.AddInPlace(fractions.Mul(node))
+		}
+		total, _ := sum.GetRat(rps.ResourceRequests, corev1.ResourceMemory)
+		Expect(total.Cmp(rps.QuantityRat(resource.MustParse("1Gi")))).To(BeZero())
+	})
+
+	It("accepts limit fractions above 1 only when overcommit is allowed", func() {
+		limit := map[string]string{"node-specific-sizing.manomano.tech/limit-cpu-fraction": "1.5"}
+		err, _ := rps.NewFromAnnotations(limit)
+		Expect(err).To(MatchError(ContainSubstring("cannot be > 1")))
+
+		limit[rps.AllowOvercommitAnnotation] = "true"
+		err, fractions := rps.NewFromAnnotations(limit)
+		Expect(err).NotTo(HaveOccurred())
+		value, _ := fractions.GetRat(rps.ResourceLimits, corev1.ResourceCPU)
+		Expect(value.Cmp(big.NewRat(3, 2))).To(BeZero())
+
+		err, _ = rps.NewFromAnnotations(map[string]string{
+			"node-specific-sizing.manomano.tech/request-cpu-fraction": "1.5",
+			rps.AllowOvercommitAnnotation:                             "true",
+		})
+		Expect(err).To(MatchError(ContainSubstring("cannot be > 1")))
+
+		err, _ = rps.NewFromAnnotations(map[string]string{rps.AllowOvercommitAnnotation: "sometimes"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("leaves out divisions by zero", func() {
+		zero := rps.New()
+		zero.BindPropertyFloat(rps.ResourceQuantity, rps.ResourceRequests, corev1.ResourceMemory, 0)
+		zero.BindPropertyFloat(rps.ResourceQuantity, rps.ResourceLimits, corev1.ResourceMemory, 1)
+		_, ok := node.Div(zero).GetRat(rps.ResourceRequests, corev1.ResourceMemory)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("leaves out props the operand does not define", func() {
+		cpuOnly := rps.New()
+		cpuOnly.BindPropertyFloat(rps.ResourceQuantity, rps.ResourceRequests, corev1.ResourceCPU, 2)
+		var result *rps.ResourceProperties
+		Expect(func() { result = node.Div(cpuOnly) }).NotTo(Panic())
+		_, ok := result.GetRat(rps.ResourceRequests, corev1.ResourceMemory)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("rounds to configured steps", func() {
+		err, rounding := rps.NewFromAnnotations(map[string]string{
+			"node-specific-sizing.manomano.tech/rounding-cpu":    "10m",
+			"node-specific-sizing.manomano.tech/rounding-memory": "1Mi",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		props := rps.New()
+		props.BindPropertyFloat(rps.ResourceQuantity, rps.ResourceRequests, corev1.ResourceCPU, 0.1234)
+		props.BindPropertyFloat(rps.ResourceQuantity, rps.ResourceLimits, corev1.ResourceMemory, 841e6)
+		props.Round(rounding)
+
+		rendered := make(map[rps.ResourceProperty]string)
+		for binding := range props.All() {
+			rendered[binding.Property()] = binding.HumanValue()
+		}
+		Expect(rendered).To(Equal(map[rps.ResourceProperty]string{
+			rps.ResourceRequests: "120m",
+			rps.ResourceLimits:   "802Mi",
+		}))
+	})
+
+	It("rounds hugepages to whole pages", func() {
+		pageSize, isHugePages := rps.HugePageSize("hugepages-2Mi")
+		Expect(isHugePages).To(BeTrue())
+		Expect(pageSize.String()).To(Equal("2Mi"))
+		_, isHugePages = rps.HugePageSize(corev1.ResourceMemory)
+		Expect(isHugePages).To(BeFalse())
+
+		props := rps.New()
+		props.BindPropertyFloat(rps.ResourceQuantity, rps.ResourceLimits, "hugepages-2Mi", 337.92*1024*1024)
+		props.Round(rps.New())
+		for binding := range props.All() {
+			Expect(binding.HumanValue()).To(Equal("336Mi"))
+		}
+	})
+
+	It("rounds down when rendering", func() {
+		third := rps.NewBinding(rps.ResourceQuantity, rps.ResourceRequests, corev1.ResourceCPU, 0)
+		third.SetRat(big.NewRat(1, 3))
+		Expect(third.HumanValue()).To(Equal("333m"))
+	})
+})
+
+var _ = Describe("Rendering quantities", Label("ResourceProperties"), func() {
+	sized := func(original string, fraction *big.Rat) string {
+		props := rps.New()
+		props.AddResourceRequirements(&corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse(original)},
+		})
+		ratio := rps.New()
+		ratio.BindPropertyRat(rps.ResourceFraction, rps.ResourceRequests, corev1.ResourceMemory, fraction)
+		for binding := range props.Mul(ratio).All() {
+			return binding.HumanValue()
+		}
+		return ""
+	}
+
+	It("keeps binary suffixes", func() {
+		Expect(sized("1Gi", big.NewRat(1, 2))).To(Equal("512Mi"))
+		Expect(sized("1Gi", big.NewRat(3, 2))).To(Equal("1536Mi"))
+		Expect(sized("300Mi", big.NewRat(1, 1))).To(Equal("300Mi"))
+	})
+
+	It("keeps decimal suffixes", func() {
+		Expect(sized("1G", big.NewRat(1, 2))).To(Equal("500M"))
+		Expect(sized("1G", big.NewRat(1234, 1000))).To(Equal("1234M"))
+	})
+
+	It("keeps significant digits of quantities without a format", func() {
+		Expect(rps.NewBinding(rps.ResourceQuantity, rps.ResourceRequests, corev1.ResourceMemory, 1.5e9).HumanValue()).To(Equal("1500M"))
+		Expect(rps.NewBinding(rps.ResourceQuantity, rps.ResourceRequests, corev1.ResourceCPU, 12.5).HumanValue()).To(Equal("12"))
+	})
+
+	It("only rounds down to milli-units with PrecisionMilli", func() {
+		binding := rps.NewBinding(rps.ResourceQuantity, rps.ResourceRequests, corev1.ResourceCPU, 12.5)
+		Expect(binding.FormatValue(rps.PrecisionMilli)).To(Equal("12500m"))
+		binding.SetRat(big.NewRat(2999999, 3))
+		Expect(binding.FormatValue(rps.PrecisionMilli)).To(Equal("999999666m"))
+		Expect(binding.FormatValue(rps.PrecisionReadable)).To(Equal("999k"))
+	})
+
+	It("multiplies quantities exactly before rounding", func() {
+		product := rps.MultiplyQuantity(resource.MustParse("3Gi"), big.NewRat(1, 3), rps.PrecisionReadable)
+		Expect(product.String()).To(Equal("1Gi"))
+		product = rps.MultiplyQuantity(resource.MustParse("1"), big.NewRat(999, 1000), rps.PrecisionMilli)
+		Expect(product.String()).To(Equal("999m"))
+		// Beyond what an int64 holds
+		product = rps.MultiplyQuantity(resource.MustParse("6E"), big.NewRat(3, 1), rps.PrecisionMilli)
+		expected, _ := new(big.Rat).SetString("18e18")
+		Expect(rps.QuantityRat(product).Cmp(expected)).To(BeZero())
+	})
+})
+
+var _ = Describe("Combining properties", Label("ResourceProperties"), func() {
+	props := func(cpu, memory string) *rps.ResourceProperties {
+		result := rps.New()
+		requests := corev1.ResourceList{}
+		if cpu != "" {
+			requests[corev1.ResourceCPU] = resource.MustParse(cpu)
+		}
+		if memory != "" {
+			requests[corev1.ResourceMemory] = resource.MustParse(memory)
+		}
+		result.AddResourceRequirements(&corev1.ResourceRequirements{Requests: requests})
+		return result
+	}
+	rendered := func(props *rps.ResourceProperties) map[corev1.ResourceName]string {
+		result := make(map[corev1.ResourceName]string)
+		for binding := range props.All() {
+			result[binding.ResourceName()] = binding.HumanValue()
+		}
+		return result
+	}
+
+	It("subtracts", func() {
+		allocatable := props("4", "16Gi")
+		budget := allocatable.Sub(props("500m", ""))
+		Expect(rendered(budget)).To(Equal(map[corev1.ResourceName]string{
+			corev1.ResourceCPU:    "3500m",
+			corev1.ResourceMemory: "16Gi",
+		}))
+		Expect(rendered(allocatable)).To(HaveKeyWithValue(corev1.ResourceCPU, "4"))
+		Expect(rendered(props("", "1Gi").Sub(props("1", "")))).NotTo(HaveKey(corev1.ResourceCPU))
+	})
+
+	It("bounds values", func() {
+		budget := props("100m", "4Gi")
+		bounds := props("250m", "")
+		Expect(rendered(budget.Max(bounds))).To(Equal(map[corev1.ResourceName]string{
+			corev1.ResourceCPU:    "250m",
+			corev1.ResourceMemory: "4Gi",
+		}))
+		Expect(rendered(budget.Min(bounds))).To(Equal(map[corev1.ResourceName]string{
+			corev1.ResourceCPU:    "100m",
+			corev1.ResourceMemory: "4Gi",
+		}))
+		Expect(rendered(bounds.Max(budget))).To(HaveKeyWithValue(corev1.ResourceMemory, "4Gi"))
+	})
+
+	It("adds without modifying either side", func() {
+		app, sidecar := props("300m", "1Gi"), props("100m", "")
+		Expect(rendered(app.Add(sidecar))).To(Equal(map[corev1.ResourceName]string{
+			corev1.ResourceCPU:    "400m",
+			corev1.ResourceMemory: "1Gi",
+		}))
+		Expect(rendered(app)).To(HaveKeyWithValue(corev1.ResourceCPU, "300m"))
+		Expect(rendered(sidecar)).NotTo(HaveKey(corev1.ResourceMemory))
+
+		app.AddInPlace(sidecar)
+		Expect(rendered(app)).To(HaveKeyWithValue(corev1.ResourceCPU, "400m"))
+	})
+
+	It("clones without sharing values", func() {
+		original := props("1", "512Mi")
+		clone := original.Clone()
+		clone.AddInPlace(props("1", "512Mi"))
+		Expect(rendered(original)).To(Equal(map[corev1.ResourceName]string{
+			corev1.ResourceCPU:    "1",
+			corev1.ResourceMemory: "512Mi",
+		}))
+		Expect(rendered(clone)).To(HaveKeyWithValue(corev1.ResourceMemory, "1Gi"))
+	})
+})
+
+var _ = Describe("Converting resource requirements", Label("ResourceProperties"), func() {
+	It("round-trips quantities, keeping their format", func() {
+		reqs := corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m"), corev1.ResourceMemory: resource.MustParse("512Mi")},
+			Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1G")},
+		}
+		converted := rps.FromResourceRequirements(&reqs).ToResourceRequirements()
+		Expect(converted.Requests).To(HaveLen(2))
+		Expect(converted.Limits).To(HaveLen(1))
+		Expect(converted.Requests[corev1.ResourceCPU].Equal(resource.MustParse("250m"))).To(BeTrue())
+		Expect(converted.Requests.Memory().String()).To(Equal("512Mi"))
+		Expect(converted.Limits.Memory().Format).To(Equal(resource.DecimalSI))
+		Expect(converted.Claims).To(BeNil())
+	})
+
+	It("renders computed values like HumanValue", func() {
+		props := rps.New()
+		props.BindPropertyFloat(rps.ResourceQuantity, rps.ResourceLimits, corev1.ResourceCPU, 1.5)
+		converted := props.ToResourceRequirements()
+		Expect(converted.Requests).To(BeNil())
+		Expect(converted.Limits.Cpu().MilliValue()).To(Equal(int64(1500)))
+	})
+
+	It("leaves fractions and pod bounds out", func() {
+		err, props := rps.NewFromAnnotations(map[string]string{
+			"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.5",
+			"node-specific-sizing.manomano.tech/minimum-memory":       "1Gi",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(props.ToResourceRequirements()).To(Equal(corev1.ResourceRequirements{}))
+	})
+})
+
+var _ = Describe("Registering annotations", Label("ResourceProperties"), func() {
+	const gpuFraction = "example.com/request-gpu-fraction"
+	const gpu corev1.ResourceName = "nvidia.com/gpu"
+
+	It("reads registered annotations", func() {
+		rps.RegisterAnnotation(gpuFraction, *rps.NewBinding(rps.ResourceFraction, rps.ResourceRequests, gpu, 0))
+		Expect(slices.Collect(rps.SupportedAnnotations())).To(ContainElement(gpuFraction))
+
+		err, props := rps.NewFromAnnotations(map[string]string{gpuFraction: "1/2"})
+		Expect(err).NotTo(HaveOccurred())
+		value, ok := props.GetValue(rps.ResourceRequests, gpu)
+		Expect(ok).To(BeTrue())
+		Expect(value).To(Equal(0.5))
+	})
+
+	It("refuses invalid bindings", func() {
+		Expect(func() {
+			rps.RegisterAnnotation("example.com/nothing", *rps.NewBinding(rps.ResourceQuantity, rps.ResourceInvalid, gpu, 0))
+		}).To(Panic())
+	})
+})
+
+var _ = Describe("Choosing the basis of fractions", Label("ResourceProperties"), func() {
+	basisOf := func(props *rps.ResourceProperties, prop rps.ResourceProperty, res corev1.ResourceName) rps.Basis {
+		for binding := range props.All() {
+			if binding.Property() == prop && binding.ResourceName() == res {
+				return binding.Basis()
+			}
+		}
+		return "unbound"
+	}
+
+	It("sets the basis of the matching fraction, whatever the order annotations come in", func() {
+		err, props := rps.NewFromAnnotations(map[string]string{
+			"node-specific-sizing.manomano.tech/request-memory-basis":    "label:node.example.com/nvme-bytes",
+			"node-specific-sizing.manomano.tech/request-memory-fraction": "0.1",
+			"node-specific-sizing.manomano.tech/limit-memory-fraction":   "0.2",
+			"node-specific-sizing.manomano.tech/request-cpu-basis":       "allocatable",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(basisOf(props, rps.ResourceRequests, corev1.ResourceMemory)).To(Equal(rps.Basis("label:node.example.com/nvme-bytes")))
+		Expect(basisOf(props, rps.ResourceLimits, corev1.ResourceMemory)).To(BeEmpty())
+		Expect(basisOf(props, rps.ResourceRequests, corev1.ResourceCPU)).To(Equal(rps.Basis("unbound")))
+		Expect(slices.Collect(rps.SupportedAnnotations())).To(ContainElement("node-specific-sizing.manomano.tech/limit-cpu-basis"))
+	})
+
+	It("refuses invalid bases", func() {
+		for _, value := range []string{"node", "label:", "resource:not a name"} {
+			err, _ := rps.NewFromAnnotations(map[string]string{"node-specific-sizing.manomano.tech/request-cpu-basis": value})
+			Expect(err).To(MatchError(ContainSubstring("is not a valid basis")), value)
+		}
+		basis, err := rps.ParseBasis("resource:example.com/nvme")
+		Expect(err).NotTo(HaveOccurred())
+		name, ok := basis.Resource()
+		Expect(ok).To(BeTrue())
+		Expect(name).To(Equal(corev1.ResourceName("example.com/nvme")))
+	})
+})
@@ -0,0 +1,147 @@
+package sizing
+
+import (
+	"fmt"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	"regexp"
+)
+
+const (
+	// quantityPattern is the one Kubernetes validates quantities with
+	quantityPattern = `^(\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))(([KMGTPE]i)|[numkMGTPE]|([eE](\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))))?$`
+	// fractionPattern accepts decimal numbers, with an exponent or as N/M, which parse as fractions
+	fractionPattern = `^(([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][-+]?[0-9]+)?|[0-9]+/[0-9]+)$`
+	// basisPattern accepts capacity, allocatable, label:<node label> and resource:<resource name>
+	basisPattern = `^(capacity|allocatable|label:.+|resource:.+)$`
+)
+
+// AnnotationProperty describes an annotation in a JSON Schema. Extensions tell how the webhook reads it.
+type AnnotationProperty struct {
+	Type             string   `json:"type"`
+	Description      string   `json:"description"`
+	Pattern          string   `json:"pattern,omitempty"`
+	Enum             []string `json:"enum,omitempty"`
+	ContentMediaType string   `json:"contentMediaType,omitempty"`
+	// ReadOnly annotations are written by the webhook
+	ReadOnly bool `json:"readOnly,omitempty"`
+	// Kind is fraction or quantity for settings read into resource properties
+	Kind     rps.ResourceKind     `json:"x-kind,omitempty"`
+	Property rps.ResourceProperty `json:"x-property,omitempty"`
+	Resource string               `json:"x-resource,omitempty"`
+}
+
+// AnnotationSchema is a JSON Schema of the annotations of a pod, or pod template, for policy engines and
+// documentation generators to check them before deploying. Other annotations are allowed.
+type AnnotationSchema struct {
+	Schema            string                        `json:"$schema"`
+	Title             string                        `json:"title"`
+	Type              string                        `json:"type"`
+	Properties        map[string]AnnotationProperty `json:"properties"`
+	PatternProperties map[string]AnnotationProperty `json:"patternProperties"`
+	// DependentRequired lists, by annotation, the annotations it goes together with
+	DependentRequired    map[string][]string `json:"dependentRequired"`
+	AdditionalProperties bool                `json:"additionalProperties"`
+}
+
+// NewAnnotationSchema describes every supported annotation, registered ones included, see rps.RegisterAnnotation.
+// Patterns only check the syntax of values, the validating webhook also checks e.g. that minimums are below maximums.
+func NewAnnotationSchema() AnnotationSchema {
+	text := func(description string) AnnotationProperty {
+		return AnnotationProperty{Type: "string", Description: description}
+	}
+	// Annotations the webhook writes are described too, for tools not to flag them
+	written := func(description string, mediaType string) AnnotationProperty {
+		return AnnotationProperty{Type: "string", Description: description, ContentMediaType: mediaType, ReadOnly: true}
+	}
+	properties := map[string]AnnotationProperty{
+		rps.AllowOvercommitAnnotation: {Type: "string", Pattern: `^(1|t|T|TRUE|true|True|0|f|F|FALSE|false|False)$`,
+			Description: "Lets limit fractions go above 1, limits then not being capped to node capacity either"},
+		ExcludeContainersAnnotation: text("Containers, comma-separated, that keep their original resources"),
+		distributionAnnotation: {Type: "string", Enum: []string{"proportional", "equal", "weighted", "primary"},
+			Description: "How the pod budget is spread between containers"},
+		containerWeightsAnnotation: {Type: "string", Pattern: `^\s*[^=,\s]+\s*=\s*[^=,\s]+\s*(,\s*[^=,\s]+\s*=\s*[^=,\s]+\s*)*,?\s*$`,
+			Description: "Weights of containers for the weighted distribution, e.g. app=3,sidecar=1"},
+		primaryContainerAnnotation: {Type: "string", Pattern: `\S`,
+			Description: "Container getting the pod budget for the primary distribution"},
+		sizeTableAnnotation: {Type: "string", ContentMediaType: "application/json",
+			Description: `Pod requests by value of a node label, as a JSON object, e.g. {"m5.large": {"cpu": "500m"}}`},
+		sizeTableLabelAnnotation: text("Node label the size table is keyed by, node.kubernetes.io/instance-type by default"),
+		ConfigAnnotation: {Type: "string", ContentMediaType: "application/yaml",
+			Description: "Every sizing setting as a single JSON or YAML document, instead of flat annotations"},
+		ResizePolicyAnnotation: {Type: "string", Pattern: `^\s*(cpu|memory)\s*=\s*(NotRequired|RestartContainer)\s*(,\s*(cpu|memory)\s*=\s*(NotRequired|RestartContainer)\s*)*$`,
+			Description: "Resize policies of sized containers of pods being created, e.g. cpu=NotRequired,memory=RestartContainer"},
+		TopologyAggregateAnnotation: {Type: "string", Enum: []string{"average", "minimum"},
+			Description: "Sizes pods for the average or minimum of the nodes of their topology domain rather than for their node"},
+		TopologyKeyAnnotation: text("Node label telling topology domains apart, topology.kubernetes.io/zone by default"),
+		ExposeBudgetAnnotation: {Type: "string", Pattern: `^(1|t|T|TRUE|true|True|0|f|F|FALSE|false|False)$`,
+			Description: "Writes the requests and limits the pod got in total in budget- annotations, for the Downward API"},
+		RuntimeEnvAnnotation: {Type: "string", Pattern: `^\s*(go|java)\s*(,\s*(go|java)\s*)*$`,
+			Description: "Runtimes, comma-separated, whose environment variables are set from final limits, e.g. GOMEMLIMIT for go"},
+		StatusAnnotation:            written("Report of what sizing did to the pod", "application/json"),
+		AppliedResourcesAnnotation:  written("Sized values by container, for drift detection", "application/json"),
+		OriginalResourcesAnnotation: written("Resources of sized containers before sizing, which sizing the pod again starts from", "application/json"),
+		PendingSizingAnnotation:     written("Marks pods admitted before their node was known, sized once bound", ""),
+		QuotaSkippedAnnotation:      written("Why containers kept their resources, resource quotas not leaving enough room", ""),
+		VPAConflictAnnotation:       written("VerticalPodAutoscaler also sizing the pod", ""),
+	}
+	for key, target := range sizeExpressionAnnotations {
+		properties[key] = AnnotationProperty{Type: "string", Property: target.property, Resource: string(target.resource),
+			Description: fmt.Sprintf("CEL expression computing the pod %s %s from the node", target.resource, target.property)}
+	}
+	for key := range rps.SupportedAnnotations() {
+		if key == rps.AllowOvercommitAnnotation {
+			continue
+		}
+		if rps.IsBasisAnnotation(key) {
+			properties[key] = AnnotationProperty{Type: "string", Pattern: basisPattern,
+				Description: "What the matching fraction applies to: capacity, allocatable, label:<node label> or resource:<resource name>"}
+			continue
+		}
+		binding, _ := rps.SupportedBinding(key)
+		properties[key] = settingProperty(binding)
+	}
+
+	clamp := AnnotationProperty{Type: "string", Pattern: quantityPattern, Kind: rps.ResourceQuantity,
+		Description: "Minimum or maximum of a single container, e.g. " + containerClampPrefix + "fluentd.maximum-memory"}
+	return AnnotationSchema{
+		Schema:     "https://json-schema.org/draft/2020-12/schema",
+		Title:      "Node-specific sizing annotations",
+		Type:       "object",
+		Properties: properties,
+		PatternProperties: map[string]AnnotationProperty{
+			"^" + regexp.QuoteMeta(containerClampPrefix) + `[a-z0-9]([-a-z0-9]*[a-z0-9])?\.(minimum|maximum)-.+$`: clamp,
+			"^" + regexp.QuoteMeta(budgetAnnotationPrefix) + `.+$`: {Type: "string", Pattern: quantityPattern, ReadOnly: true,
+				Description: "Requests, or limits for budget-limit-, of a sized resource the pod got in total, e.g. budget-cpu"},
+		},
+		DependentRequired: map[string][]string{
+			containerWeightsAnnotation: {distributionAnnotation},
+			primaryContainerAnnotation: {distributionAnnotation},
+			sizeTableLabelAnnotation:   {sizeTableAnnotation},
+			TopologyKeyAnnotation:      {TopologyAggregateAnnotation},
+		},
+		AdditionalProperties: true,
+	}
+}
+
+// settingProperty describes a setting read into resource properties
+func settingProperty(binding rps.ResourcePropertyBinding) AnnotationProperty {
+	property := AnnotationProperty{Type: "string", Kind: binding.Kind(), Property: binding.Property(), Resource: string(binding.ResourceName())}
+	if binding.Kind() == rps.ResourceFraction {
+		property.Pattern = fractionPattern
+		property.Description = fmt.Sprintf("Fraction of the node giving the pod %s %s, in ]0, 1]", binding.ResourceName(), binding.Property())
+		if binding.Property() == rps.ResourceLimits {
+			property.Description += ", or above 1 with allow-overcommit"
+		}
+		return property
+	}
+	property.Pattern = quantityPattern
+	descriptions := map[rps.ResourceProperty]string{
+		rps.ResourcePodMinimum: "Minimum of the sized pod %s",
+		rps.ResourcePodMaximum: "Maximum of the sized pod %s",
+		rps.ResourceRounding:   "Step the sized %s is rounded down to",
+		rps.ResourceRequests:   "Pod %s request",
+		rps.ResourceLimits:     "Pod %s limit",
+	}
+	property.Description = fmt.Sprintf(descriptions[binding.Property()], binding.ResourceName())
+	return property
+}
@@ -0,0 +1,94 @@
+package sizing
+
+import (
+	"encoding/json"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"regexp"
+	"slices"
+)
+
+var _ = Describe("Annotation schema", Label("AnnotationSchema"), func() {
+	schema := NewAnnotationSchema()
+
+	// valid tells whether value passes the pattern and enum of the annotation key
+	valid := func(key, value string) bool {
+		property, ok := schema.Properties[key]
+		Expect(ok).To(BeTrue(), key)
+		if property.Pattern != "" && !regexp.MustCompile(property.Pattern).MatchString(value) {
+			return false
+		}
+		return len(property.Enum) == 0 || slices.Contains(property.Enum, value)
+	}
+
+	It("describes every supported annotation", func() {
+		for key := range rps.SupportedAnnotations() {
+			Expect(schema.Properties).To(HaveKey(key))
+		}
+		Expect(schema.Properties["node-specific-sizing.manomano.tech/limit-memory-fraction"]).To(And(
+			HaveField("Kind", rps.ResourceFraction),
+			HaveField("Property", rps.ResourceLimits),
+			HaveField("Resource", "memory")))
+		Expect(schema.Properties[StatusAnnotation].ReadOnly).To(BeTrue())
+	})
+
+	It("accepts values the webhook accepts", func() {
+		for key, value := range map[string]string{
+			"node-specific-sizing.manomano.tech/request-cpu-fraction":  "0.25",
+			"node-specific-sizing.manomano.tech/limit-memory-fraction": "3/2",
+			"node-specific-sizing.manomano.tech/maximum-memory":        "1.5Gi",
+			"node-specific-sizing.manomano.tech/request-memory-basis":  "label:node.example.com/memory",
+			rps.AllowOvercommitAnnotation:                              "true",
+			containerWeightsAnnotation:                                 "app=3, sidecar=1",
+			ResizePolicyAnnotation:                                     "cpu=NotRequired,memory=RestartContainer",
+		} {
+			Expect(valid(key, value)).To(BeTrue(), "%s=%s", key, value)
+		}
+	})
+
+	It("rejects malformed values", func() {
+		for key, value := range map[string]string{
+			"node-specific-sizing.manomano.tech/request-cpu-fraction": "a tenth",
+			"node-specific-sizing.manomano.tech/maximum-memory":       "1.5GB",
+			"node-specific-sizing.manomano.tech/request-memory-basis": "everything",
+			distributionAnnotation: "random",
+			ResizePolicyAnnotation: "storage=NotRequired",
+		} {
+			Expect(valid(key, value)).To(BeFalse(), "%s=%s", key, value)
+		}
+	})
+
+	matching := func(key string) []string {
+		var patterns []string
+		for pattern := range schema.PatternProperties {
+			if regexp.MustCompile(pattern).MatchString(key) {
+				patterns = append(patterns, pattern)
+			}
+		}
+		return patterns
+	}
+
+	It("matches container minimums and maximums", func() {
+		Expect(matching(containerClampPrefix + "fluentd.maximum-memory")).To(HaveLen(1))
+		Expect(matching(containerClampPrefix + "fluentd.rounding-memory")).To(BeEmpty())
+	})
+
+	It("matches pod budgets", func() {
+		for _, key := range []string{BudgetAnnotation(corev1.ResourceCPU), BudgetLimitAnnotation(corev1.ResourceMemory)} {
+			patterns := matching(key)
+			Expect(patterns).To(HaveLen(1))
+			Expect(schema.PatternProperties[patterns[0]].ReadOnly).To(BeTrue())
+		}
+	})
+
+	It("is a JSON Schema", func() {
+		raw, err := json.Marshal(schema)
+		Expect(err).NotTo(HaveOccurred())
+		var document map[string]any
+		Expect(json.Unmarshal(raw, &document)).To(Succeed())
+		Expect(document).To(HaveKeyWithValue("$schema", "https://json-schema.org/draft/2020-12/schema"))
+		Expect(document).To(HaveKeyWithValue("dependentRequired", HaveKey(containerWeightsAnnotation)))
+	})
+})
@@ -0,0 +1,33 @@
+package sizing
+
+const (
+	// AnnotationPrefix is shared by every annotation and label the webhook reads or writes
+	AnnotationPrefix = "node-specific-sizing.manomano.tech/"
+
+	// EnabledLabel opts pods into sizing, see the MutatingWebhookConfiguration objectSelector
+	EnabledLabel = AnnotationPrefix + "enabled"
+
+	// StatusAnnotation is where the sizing report is written, unless a policy says otherwise
+	StatusAnnotation = AnnotationPrefix + "status"
+
+	// ExcludeContainersAnnotation lists containers, comma-separated, that keep their original resources
+	ExcludeContainersAnnotation = AnnotationPrefix + "exclude-containers"
+
+	// AppliedResourcesAnnotation records the resources we set, by container name, so that drift can be detected
+	AppliedResourcesAnnotation = AnnotationPrefix + "applied-resources"
+
+	// OriginalResourcesAnnotation records the resources sized containers had before sizing, by container name, so that
+	// sizing a pod again starts from them and operators may restore them
+	OriginalResourcesAnnotation = AnnotationPrefix + "original-resources"
+
+	// PendingSizingAnnotation marks pods admitted before their node was known, which are sized once bound to one
+	PendingSizingAnnotation = AnnotationPrefix + "pending-sizing"
+
+	// WorkloadStatusAnnotation summarizes the sizing of the pods of a DaemonSet or Deployment, on the workload itself
+	WorkloadStatusAnnotation = AnnotationPrefix + "workload-status"
+)
+
+// AnnotationJsonPath points to an annotation within a JSONPatch
+func AnnotationJsonPath(key string) string {
+	return "/metadata/annotations/" + jsonPointerEscaper.Replace(key)
+}
@@ -0,0 +1,89 @@
+package sizing
+
+import (
+	"encoding/json"
+	"fmt"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AppliedResources maps container names to the resources sizing set on them, as recorded in
+// AppliedResourcesAnnotation
+type AppliedResources map[string]corev1.ResourceRequirements
+
+func appliedResourcesOf(result *Result) AppliedResources {
+	applied := make(AppliedResources)
+	for patch := range result.Patches() {
+		reqs := applied[patch.ContainerName]
+		switch patch.Property {
+		case rps.ResourceRequests:
+			if reqs.Requests == nil {
+				reqs.Requests = make(corev1.ResourceList)
+			}
+			reqs.Requests[patch.Resource] = patch.New
+		case rps.ResourceLimits:
+			if reqs.Limits == nil {
+				reqs.Limits = make(corev1.ResourceList)
+			}
+			reqs.Limits[patch.Resource] = patch.New
+		}
+		applied[patch.ContainerName] = reqs
+	}
+	return applied
+}
+
+// AppliedResourcesFromAnnotations reads AppliedResourcesAnnotation, it returns nil for pods that were not sized
+func AppliedResourcesFromAnnotations(annotations map[string]string) (AppliedResources, error) {
+	value, ok := annotations[AppliedResourcesAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var applied AppliedResources
+	if err := json.Unmarshal([]byte(value), &applied); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", AppliedResourcesAnnotation, err)
+	}
+	return applied, nil
+}
+
+// OriginalResources maps container names to the resources they had before sizing, as recorded in
+// OriginalResourcesAnnotation
+type OriginalResources map[string]corev1.ResourceRequirements
+
+func originalResourcesOf(result *Result) OriginalResources {
+	applied := appliedResourcesOf(result)
+	originals := make(OriginalResources)
+	for _, ctn := range result.original {
+		if _, sized := applied[ctn.name]; sized {
+			originals[ctn.name] = ctn.resources
+		}
+	}
+	return originals
+}
+
+// OriginalResourcesFromAnnotations reads OriginalResourcesAnnotation, it returns nil for pods that were not sized
+func OriginalResourcesFromAnnotations(annotations map[string]string) (OriginalResources, error) {
+	value, ok := annotations[OriginalResourcesAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var originals OriginalResources
+	if err := json.Unmarshal([]byte(value), &originals); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", OriginalResourcesAnnotation, err)
+	}
+	return originals, nil
+}
+
+// withOriginalResources returns a copy of pod whose containers have their original resources back, or pod itself
+// when there is nothing to restore
+func withOriginalResources(pod *corev1.Pod, originals OriginalResources) *corev1.Pod {
+	if len(originals) == 0 {
+		return pod
+	}
+	restored := pod.DeepCopy()
+	for ctn := range LongRunningContainers(restored) {
+		if resources, ok := originals[ctn.Name]; ok {
+			ctn.Resources = *resources.DeepCopy()
+		}
+	}
+	return restored
+}
@@ -0,0 +1,61 @@
+package sizing
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Steps of sizing given a share of the time left, when the context has a deadline
+const (
+	// StepNodeLookup resolves the node of a pod and reads it, from the API server when missing from the cache
+	StepNodeLookup = "node lookup"
+	// StepPatching renders the JSONPatch of a sized pod
+	StepPatching = "patching"
+)
+
+// nodeLookupShare is the share of the time left the node lookup may take, for the remaining steps to run, or the
+// fallback requests to apply, when it is slow
+const nodeLookupShare = 0.5
+
+// BudgetExceededError is returned when a step of sizing runs out of its share of the deadline of the context, so that
+// callers answer with what was slow before the deadline itself is reached
+type BudgetExceededError struct {
+	Step string
+	// Budget is 0 for steps that were not started, the deadline having passed
+	Budget time.Duration
+	err    error
+}
+
+func (e *BudgetExceededError) Error() string {
+	if e.Budget == 0 {
+		return fmt.Sprintf("no time was left for %s: %s", e.Step, e.err)
+	}
+	return fmt.Sprintf("%s did not complete within its budget of %s: %s", e.Step, e.Budget.Round(time.Millisecond), e.err)
+}
+
+func (e *BudgetExceededError) Unwrap() error {
+	return e.err
+}
+
+// withStepBudget bounds a step to share of the time left before the deadline of ctx, which is returned as is without
+// one. The budget is 0 then.
+func withStepBudget(ctx context.Context, share float64) (context.Context, time.Duration, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return ctx, 0, func() {}
+	}
+	budget := time.Duration(float64(time.Until(deadline)) * share)
+	ctx, cancel := context.WithTimeout(ctx, budget)
+	return ctx, budget, cancel
+}
+
+// overBudget returns a *BudgetExceededError wrapping err when the step bounded by stepCtx ran out of time, err
+// otherwise
+func overBudget(stepCtx context.Context, step string, budget time.Duration, err error) error {
+	if err == nil || budget == 0 || stepCtx.Err() == nil {
+		return err
+	}
+	budgetsExceededTotal.WithLabelValues(step).Inc()
+	return &BudgetExceededError{Step: step, Budget: budget, err: err}
+}
@@ -0,0 +1,76 @@
+package sizing
+
+import (
+	"context"
+	"errors"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"time"
+)
+
+var _ = Describe("Step budgets", Label("Budget"), func() {
+	var sizer *Sizer
+	var pod *corev1.Pod
+
+	BeforeEach(func() {
+		// Nodes missing from the cache are read from an API server that hangs
+		hanging := fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		}).Build()
+		sizer = &Sizer{nodeReader: fake.NewClientBuilder().Build(), nodeAPIReader: hanging}
+		pod = fixtures.PinToNode(fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil)), "node-a")
+		pod.Annotations = map[string]string{"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.1"}
+	})
+
+	It("gives up on the node lookup halfway to the deadline", func(ctx SpecContext) {
+		ctx2, cancel := context.WithTimeout(ctx, 400*time.Millisecond)
+		defer cancel()
+		_, err := sizer.Size(ctx2, pod)
+		var budgetErr *BudgetExceededError
+		Expect(err).To(BeAssignableToTypeOf(&NodeUnavailableError{}))
+		Expect(errors.As(err, &budgetErr)).To(BeTrue())
+		Expect(budgetErr.Step).To(Equal(StepNodeLookup))
+		Expect(budgetErr.Budget).To(BeNumerically("~", 200*time.Millisecond, 20*time.Millisecond))
+		Expect(ctx2.Err()).NotTo(HaveOccurred(), "time is left to answer")
+	})
+
+	It("sizes with fallback requests when they are set", func(ctx SpecContext) {
+		sizer.fallback = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}
+		ctx2, cancel := context.WithTimeout(ctx, 400*time.Millisecond)
+		defer cancel()
+		result, err := sizer.Size(ctx2, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Warnings()).To(ContainElement(ContainSubstring("node lookup did not complete within its budget")))
+	})
+
+	It("does not bound lookups without a deadline", func(ctx SpecContext) {
+		lookupCtx, budget, cancel := withStepBudget(context.Background(), nodeLookupShare)
+		defer cancel()
+		Expect(budget).To(BeZero())
+		_, ok := lookupCtx.Deadline()
+		Expect(ok).To(BeFalse())
+	})
+
+	It("does not render patches once the deadline passed", func(ctx SpecContext) {
+		node := fixtures.NodeWithCapacity("4", "8G")
+		node.Name = "node-a"
+		sizer.nodeReader = fake.NewClientBuilder().WithObjects(node).Build()
+		expired, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+		defer cancel()
+		_, _, err := sizer.CreatePatch(expired, pod, false)
+		var budgetErr *BudgetExceededError
+		Expect(errors.As(err, &budgetErr)).To(BeTrue())
+		Expect(budgetErr.Step).To(Equal(StepPatching))
+		Expect(err).To(MatchError(ContainSubstring("no time was left for patching")))
+	})
+})
@@ -0,0 +1,121 @@
+package sizing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+)
+
+// CandidateChoice tells which node pods are sized for when their node affinity lists several nodes they may land on
+type CandidateChoice string
+
+const (
+	// CandidatesNone fails sizing, as when no node can be told
+	CandidatesNone CandidateChoice = ""
+	// CandidatesSmallest sizes for the smallest candidate, so that pods fit on any of them
+	CandidatesSmallest CandidateChoice = "smallest"
+	// CandidatesLargest sizes for the largest candidate
+	CandidatesLargest CandidateChoice = "largest"
+	// CandidatesMedian sizes for the median candidate, the smaller of both when there are as many above as below
+	CandidatesMedian CandidateChoice = "median"
+	// CandidatesBound leaves pods to be sized once bound to one of their candidates, see CandidatesPendingError
+	CandidatesBound CandidateChoice = "bound"
+)
+
+// CandidatesPendingError is returned with CandidatesBound for pods whose node affinity lists several candidate nodes,
+// which are to be admitted as they are and sized once the scheduler binds them to one
+type CandidatesPendingError struct {
+	Candidates []string
+}
+
+func (e *CandidatesPendingError) Error() string {
+	return fmt.Sprintf("pod may land on any of %d candidate nodes, it is sized once bound", len(e.Candidates))
+}
+
+// ParseCandidateChoice returns the CandidateChoice named value, an empty value being CandidatesNone
+func ParseCandidateChoice(value string) (CandidateChoice, error) {
+	choice := CandidateChoice(value)
+	if !slices.Contains([]CandidateChoice{CandidatesNone, CandidatesSmallest, CandidatesLargest, CandidatesMedian, CandidatesBound}, choice) {
+		return "", fmt.Errorf("unknown candidate choice %q, expected smallest, largest, median or bound", value)
+	}
+	return choice, nil
+}
+
+// candidateNodeNames returns the nodes the node affinity of a pod lists, by metadata.name fields or hostname label
+// values. Required terms are ORed, so every node they list is a candidate. Preferred terms are only read when no
+// required term lists any node.
+func candidateNodeNames(pod *corev1.Pod) []string {
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil {
+		return nil
+	}
+	affinity := pod.Spec.Affinity.NodeAffinity
+	var names []string
+	if affinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		for _, term := range affinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+			names = append(names, nodeNamesOfTerm(term)...)
+		}
+	}
+	if len(names) == 0 {
+		for _, preferred := range affinity.PreferredDuringSchedulingIgnoredDuringExecution {
+			names = append(names, nodeNamesOfTerm(preferred.Preference)...)
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+func nodeNamesOfTerm(term corev1.NodeSelectorTerm) []string {
+	var names []string
+	for _, field := range term.MatchFields {
+		if field.Key == "metadata.name" && field.Operator == corev1.NodeSelectorOpIn {
+			names = append(names, field.Values...)
+		}
+	}
+	for _, expr := range term.MatchExpressions {
+		if expr.Key == hostnameLabel && expr.Operator == corev1.NodeSelectorOpIn {
+			names = append(names, expr.Values...)
+		}
+	}
+	return names
+}
+
+// chooseCandidate reads the candidate nodes of a pod and picks one according to choice, ordering them by CPU, then
+// memory capacity. Candidates that cannot be read are left out.
+func chooseCandidate(ctx context.Context, nodeReader, apiReader client.Reader, pod *corev1.Pod, choice CandidateChoice) (*corev1.Node, error) {
+	names := candidateNodeNames(pod)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("node affinity lists no candidate node")
+	}
+	var nodes []*corev1.Node
+	var errs []error
+	for _, name := range names {
+		node, err := getNodeOrFallback(ctx, nodeReader, apiReader, name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	slices.SortStableFunc(nodes, func(a, b *corev1.Node) int {
+		if c := a.Status.Capacity.Cpu().Cmp(*b.Status.Capacity.Cpu()); c != 0 {
+			return c
+		}
+		return a.Status.Capacity.Memory().Cmp(*b.Status.Capacity.Memory())
+	})
+	switch choice {
+	case CandidatesSmallest:
+		return nodes[0], nil
+	case CandidatesLargest:
+		return nodes[len(nodes)-1], nil
+	case CandidatesMedian:
+		return nodes[(len(nodes)-1)/2], nil
+	}
+	return nil, fmt.Errorf("unknown candidate choice %q", choice)
+}
@@ -0,0 +1,79 @@
+package sizing
+
+import (
+	"errors"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Choosing between candidate nodes", Label("NodeResolver"), func() {
+	named := func(name, cpu, memory string) *corev1.Node {
+		node := fixtures.NodeWithCapacity(cpu, memory)
+		node.Name = name
+		return node
+	}
+	nodeReader := fake.NewClientBuilder().WithObjects(
+		named("small", "2", "8G"),
+		named("medium", "4", "8G"),
+		named("large", "4", "16G"),
+	).Build()
+
+	var pod *corev1.Pod
+	BeforeEach(func() {
+		pod = fixtures.PodWithContainers(fixtures.ContainerWithResources("app", corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil))
+		pod.Annotations = map[string]string{"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.5"}
+		pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{
+				{MatchFields: []corev1.NodeSelectorRequirement{{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{"large", "small"}}}},
+				{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: hostnameLabel, Operator: corev1.NodeSelectorOpIn, Values: []string{"medium", "gone"}}}},
+			}},
+		}}
+	})
+
+	It("lists nodes of every required term", func() {
+		Expect(candidateNodeNames(pod)).To(Equal([]string{"gone", "large", "medium", "small"}))
+	})
+
+	It("falls back to preferred terms", func() {
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = nil
+		pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = []corev1.PreferredSchedulingTerm{{
+			Weight:     1,
+			Preference: corev1.NodeSelectorTerm{MatchFields: []corev1.NodeSelectorRequirement{{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{"small"}}}},
+		}}
+		Expect(candidateNodeNames(pod)).To(Equal([]string{"small"}))
+	})
+
+	DescribeTable("sizes for the chosen candidate, by CPU then memory", func(ctx SpecContext, choice CandidateChoice, nodeName string) {
+		result, err := New(nodeReader, Options{Candidates: choice}).Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.NodeName()).To(Equal(nodeName))
+	},
+		Entry("smallest", CandidatesSmallest, "small"),
+		Entry("largest", CandidatesLargest, "large"),
+		Entry("median", CandidatesMedian, "medium"),
+	)
+
+	It("leaves pods to be sized once bound, fallback requests or not", func(ctx SpecContext) {
+		sizer := New(nodeReader, Options{Candidates: CandidatesBound, FallbackRequests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}})
+		_, err := sizer.Size(ctx, pod)
+		var pendingErr *CandidatesPendingError
+		Expect(errors.As(err, &pendingErr)).To(BeTrue())
+		Expect(pendingErr.Candidates).To(Equal([]string{"gone", "large", "medium", "small"}))
+
+		pod.Spec.NodeName = "medium"
+		result, err := sizer.Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.NodeName()).To(Equal("medium"))
+	})
+
+	It("fails without a choice", func(ctx SpecContext) {
+		_, err := New(nodeReader, Options{}).Size(ctx, pod)
+		Expect(err).To(MatchError(ContainSubstring("problem getting node name")))
+		_, err = ParseCandidateChoice("random")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,112 @@
+package sizing
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PodNodeNameField indexes pods by the node they are bound to
+const PodNodeNameField = "spec.nodeName"
+
+// IndexPodNodeName is the indexer of PodNodeNameField
+func IndexPodNodeName(obj client.Object) []string {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.NodeName == "" {
+		return nil
+	}
+	return []string{pod.Spec.NodeName}
+}
+
+// CommittedSource provides the resources other pods already request on a node
+type CommittedSource interface {
+	Committed(ctx context.Context, pod *corev1.Pod, nodeName string) (corev1.ResourceList, error)
+}
+
+// committedResources sums the requests of the pods bound to a node, from a pod reader indexed by PodNodeNameField
+type committedResources struct {
+	podReader client.Reader
+}
+
+var _ CommittedSource = &committedResources{}
+
+// NewCommittedResources returns a CommittedSource listing pods from podReader, which must index them by
+// PodNodeNameField, see IndexPodNodeName
+func NewCommittedResources(podReader client.Reader) CommittedSource {
+	return &committedResources{podReader: podReader}
+}
+
+func (c *committedResources) Committed(ctx context.Context, pod *corev1.Pod, nodeName string) (corev1.ResourceList, error) {
+	var pods corev1.PodList
+	if err := c.podReader.List(ctx, &pods, client.MatchingFields{PodNodeNameField: nodeName}); err != nil {
+		return nil, fmt.Errorf("problem listing pods of node %s: %w", nodeName, err)
+	}
+
+	owner := metav1.GetControllerOf(pod)
+	total := corev1.ResourceList{}
+	for i := range pods.Items {
+		other := &pods.Items[i]
+		if other.Status.Phase == corev1.PodSucceeded || other.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		// The pod likely replaces the one of its owner on the node, e.g. during a DaemonSet rollout
+		if otherOwner := metav1.GetControllerOf(other); owner != nil && otherOwner != nil && otherOwner.UID == owner.UID {
+			continue
+		}
+		addRequests(total, podRequests(other))
+	}
+	return total, nil
+}
+
+// podRequests returns what the scheduler accounts a pod for: the sum of its containers and native sidecars, or more if
+// one of its init containers requests more along with the sidecars started before it, plus its overhead
+func podRequests(pod *corev1.Pod) corev1.ResourceList {
+	result := corev1.ResourceList{}
+	for ctn := range LongRunningContainers(pod) {
+		addRequests(result, ctn.Resources.Requests)
+	}
+	sidecars := corev1.ResourceList{}
+	for i := range pod.Spec.InitContainers {
+		ctn := &pod.Spec.InitContainers[i]
+		if IsSidecar(ctn) {
+			addRequests(sidecars, ctn.Resources.Requests)
+			continue
+		}
+		peak := sidecars.DeepCopy()
+		addRequests(peak, ctn.Resources.Requests)
+		for name, quantity := range peak {
+			if current, ok := result[name]; !ok || quantity.Cmp(current) > 0 {
+				result[name] = quantity
+			}
+		}
+	}
+	addRequests(result, pod.Spec.Overhead)
+	return result
+}
+
+// addRequests sums requests into total
+func addRequests(total, requests corev1.ResourceList) {
+	for name, quantity := range requests {
+		sum := total[name]
+		sum.Add(quantity)
+		total[name] = sum
+	}
+}
+
+// freeCapacity returns what committed leaves of capacity, never below zero
+func freeCapacity(capacity, committed corev1.ResourceList) corev1.ResourceList {
+	result := capacity.DeepCopy()
+	for name, free := range result {
+		if quantity, ok := committed[name]; ok {
+			free.Sub(quantity)
+			if free.Sign() < 0 {
+				free = resource.Quantity{Format: free.Format}
+			}
+			result[name] = free
+		}
+	}
+	return result
+}
@@ -0,0 +1,87 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Deducting committed resources", Label("CommittedResources"), func() {
+	boundPod := func(name, nodeName, cpu string, owner types.UID) *corev1.Pod {
+		pod := fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)}, nil))
+		pod.Name = name
+		pod.Spec.NodeName = nodeName
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: string(owner), UID: owner, Controller: ptr.To(true)}}
+		return pod
+	}
+
+	node := fixtures.NodeWithCapacity("4", "8G")
+	node.Name = "node-a"
+	finished := boundPod("finished", "node-a", "2", "batch")
+	finished.Status.Phase = corev1.PodSucceeded
+	reader := fake.NewClientBuilder().
+		WithObjects(
+			node,
+			boundPod("web", "node-a", "1", "web"),
+			boundPod("previous-agent", "node-a", "500m", "agent"),
+			boundPod("elsewhere", "node-b", "2", "web"),
+			finished,
+		).
+		WithIndex(&corev1.Pod{}, PodNodeNameField, IndexPodNodeName).
+		Build()
+
+	It("sums the requests of running pods of the node, but not those the pod replaces", func(ctx SpecContext) {
+		committed, err := (&committedResources{podReader: reader}).Committed(ctx, boundPod("", "", "100m", "agent"), "node-a")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(committed.Cpu().String()).To(Equal("1"))
+	})
+
+	It("accounts for init containers and overhead", func() {
+		pod := boundPod("", "", "100m", "agent")
+		pod.Spec.InitContainers = []corev1.Container{fixtures.ContainerWithResources("init",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m")}, nil)}
+		pod.Spec.Overhead = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m")}
+		requests := podRequests(pod)
+		Expect(requests.Cpu().String()).To(Equal("250m"))
+	})
+
+	It("accounts for native sidecars like regular containers", func() {
+		pod := boundPod("", "", "100m", "agent")
+		always := corev1.ContainerRestartPolicyAlways
+		sidecar := fixtures.ContainerWithResources("proxy", corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil)
+		sidecar.RestartPolicy = &always
+		// The sidecar keeps running while the later init container runs
+		pod.Spec.InitContainers = []corev1.Container{sidecar, fixtures.ContainerWithResources("init",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("150m")}, nil)}
+		requests := podRequests(pod)
+		Expect(requests.Cpu().String()).To(Equal("250m"))
+
+		pod.Spec.InitContainers[1].Resources.Requests[corev1.ResourceCPU] = resource.MustParse("50m")
+		requests = podRequests(pod)
+		Expect(requests.Cpu().String()).To(Equal("200m"))
+	})
+
+	It("sizes against free capacity", func(ctx SpecContext) {
+		pod := fixtures.PinToNode(boundPod("agent", "", "100m", "agent"), "node-a")
+		pod.Annotations = map[string]string{"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.5"}
+		sizer := &Sizer{nodeReader: reader, committed: &committedResources{podReader: reader}}
+		result, err := sizer.Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.patches[0].New.String()).To(Equal("1500m"))
+		committed := result.report(false).Committed
+		Expect(committed.Cpu().String()).To(Equal("1"))
+	})
+
+	It("never leaves less than nothing", func() {
+		free := freeCapacity(corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")})
+		Expect(free.Cpu().IsZero()).To(BeTrue())
+	})
+})
@@ -0,0 +1,179 @@
+package sizing
+
+import (
+	"encoding/json"
+	"fmt"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	"maps"
+	"sigs.k8s.io/yaml"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ConfigAnnotation holds every sizing setting of a pod as a single JSON or YAML document, see podConfig. It is an
+// alternative to flat annotations, which it cannot be combined with for the same setting.
+const ConfigAnnotation = AnnotationPrefix + "config"
+
+// podConfig is the document of ConfigAnnotation, e.g.
+//
+//	fractions:
+//	  requests: {cpu: 0.1, memory: 0.2}
+//	  limits: {memory: 0.4}
+//	bases:
+//	  requests: {memory: allocatable}
+//	minimum: {cpu: 100m}
+//	distribution: weighted
+//	containerWeights: {app: 3}
+//	containers:
+//	  fluentd:
+//	    maximum: {memory: 500M}
+type podConfig struct {
+	Fractions struct {
+		Requests map[string]configValue `json:"requests,omitempty"`
+		Limits   map[string]configValue `json:"limits,omitempty"`
+	} `json:"fractions,omitempty"`
+	// Bases hold what fractions apply to, see rps.ParseBasis
+	Bases struct {
+		Requests map[string]configValue `json:"requests,omitempty"`
+		Limits   map[string]configValue `json:"limits,omitempty"`
+	} `json:"bases,omitempty"`
+	Minimum           map[string]configValue           `json:"minimum,omitempty"`
+	Maximum           map[string]configValue           `json:"maximum,omitempty"`
+	Rounding          map[string]configValue           `json:"rounding,omitempty"`
+	AllowOvercommit   *bool                            `json:"allowOvercommit,omitempty"`
+	ExcludeContainers []string                         `json:"excludeContainers,omitempty"`
+	Distribution      string                           `json:"distribution,omitempty"`
+	ContainerWeights  map[string]configValue           `json:"containerWeights,omitempty"`
+	PrimaryContainer  string                           `json:"primaryContainer,omitempty"`
+	Containers        map[string]containerClampsConfig `json:"containers,omitempty"`
+}
+
+// containerClampsConfig holds the minimums and maximums of a single container
+type containerClampsConfig struct {
+	Minimum map[string]configValue `json:"minimum,omitempty"`
+	Maximum map[string]configValue `json:"maximum,omitempty"`
+}
+
+// configValue is a fraction, quantity or weight, which YAML documents may hold as numbers as well as strings
+type configValue string
+
+func (v *configValue) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*v = configValue(value)
+		return nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return fmt.Errorf("expected a number or a string, got %s", data)
+	}
+	*v = configValue(number)
+	return nil
+}
+
+// withConfigAnnotation returns annotations with ConfigAnnotation expanded into the flat annotations it stands for.
+// It fails on documents that do not follow podConfig, and on settings also set by a flat annotation.
+func withConfigAnnotation(annotations map[string]string) (map[string]string, error) {
+	raw, ok := annotations[ConfigAnnotation]
+	if !ok {
+		return annotations, nil
+	}
+	var config podConfig
+	if err := yaml.UnmarshalStrict([]byte(raw), &config); err != nil {
+		return nil, fmt.Errorf("%s is not a valid sizing configuration: %w", ConfigAnnotation, err)
+	}
+	flat, err := config.annotations()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigAnnotation, err)
+	}
+
+	result := maps.Clone(annotations)
+	for _, key := range slices.Sorted(maps.Keys(flat)) {
+		if _, exists := annotations[key]; exists {
+			return nil, fmt.Errorf("%s is set both in %s and as an annotation", key, ConfigAnnotation)
+		}
+		result[key] = flat[key]
+	}
+	return result, nil
+}
+
+// annotations returns the flat annotations the configuration stands for
+func (c podConfig) annotations() (map[string]string, error) {
+	supported := slices.Collect(rps.SupportedAnnotations())
+	result := make(map[string]string)
+	if c.AllowOvercommit != nil {
+		result[rps.AllowOvercommitAnnotation] = strconv.FormatBool(*c.AllowOvercommit)
+	}
+	// Values are parsed one at a time, so that errors tell which setting of the document is at fault
+	check := func(path, key string, value configValue) error {
+		if !slices.Contains(supported, key) {
+			return fmt.Errorf("%s is not supported", path)
+		}
+		single := map[string]string{key: string(value)}
+		if overcommit, ok := result[rps.AllowOvercommitAnnotation]; ok {
+			single[rps.AllowOvercommitAnnotation] = overcommit
+		}
+		if err, _ := rps.NewFromAnnotations(single); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return nil
+	}
+	bind := func(path string, values map[string]configValue, key func(resource string) string) error {
+		for resource, value := range values {
+			if err := check(path+"."+resource, key(resource), value); err != nil {
+				return err
+			}
+			result[key(resource)] = string(value)
+		}
+		return nil
+	}
+	settings := []struct {
+		path   string
+		values map[string]configValue
+		key    func(resource string) string
+	}{
+		{"fractions.requests", c.Fractions.Requests, func(r string) string { return AnnotationPrefix + "request-" + r + "-fraction" }},
+		{"fractions.limits", c.Fractions.Limits, func(r string) string { return AnnotationPrefix + "limit-" + r + "-fraction" }},
+		{"bases.requests", c.Bases.Requests, func(r string) string { return AnnotationPrefix + "request-" + r + "-basis" }},
+		{"bases.limits", c.Bases.Limits, func(r string) string { return AnnotationPrefix + "limit-" + r + "-basis" }},
+		{"minimum", c.Minimum, func(r string) string { return AnnotationPrefix + "minimum-" + r }},
+		{"maximum", c.Maximum, func(r string) string { return AnnotationPrefix + "maximum-" + r }},
+		{"rounding", c.Rounding, func(r string) string { return AnnotationPrefix + "rounding-" + r }},
+	}
+	for _, setting := range settings {
+		if err := bind(setting.path, setting.values, setting.key); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(c.ExcludeContainers) > 0 {
+		result[ExcludeContainersAnnotation] = strings.Join(c.ExcludeContainers, ",")
+	}
+	if c.Distribution != "" {
+		result[distributionAnnotation] = c.Distribution
+	}
+	if len(c.ContainerWeights) > 0 {
+		var weights []string
+		for _, name := range slices.Sorted(maps.Keys(c.ContainerWeights)) {
+			weights = append(weights, name+"="+string(c.ContainerWeights[name]))
+		}
+		result[containerWeightsAnnotation] = strings.Join(weights, ",")
+	}
+	if c.PrimaryContainer != "" {
+		result[primaryContainerAnnotation] = c.PrimaryContainer
+	}
+
+	for name, clamps := range c.Containers {
+		for setting, values := range map[string]map[string]configValue{"minimum": clamps.Minimum, "maximum": clamps.Maximum} {
+			for resource, value := range values {
+				path := fmt.Sprintf("containers.%s.%s.%s", name, setting, resource)
+				if err := check(path, AnnotationPrefix+setting+"-"+resource, value); err != nil {
+					return nil, err
+				}
+				result[containerClampPrefix+name+"."+setting+"-"+resource] = string(value)
+			}
+		}
+	}
+	return result, nil
+}
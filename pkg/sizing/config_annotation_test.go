@@ -0,0 +1,75 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"slices"
+)
+
+var _ = Describe("Config annotation", Label("ConfigAnnotation"), func() {
+	It("stands for flat annotations", func() {
+		annotations, err := withConfigAnnotation(map[string]string{ConfigAnnotation: `
+fractions:
+  requests: {cpu: 0.1, memory: "0.2"}
+  limits: {memory: 0.4}
+minimum: {cpu: 100m}
+allowOvercommit: true
+excludeContainers: [istio-proxy, linkerd-proxy]
+distribution: weighted
+containerWeights: {sidecar: 1, app: 3}
+containers:
+  fluentd:
+    maximum: {memory: 500M}
+`})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(annotations).To(Equal(map[string]string{
+			ConfigAnnotation: annotations[ConfigAnnotation],
+			"node-specific-sizing.manomano.tech/request-cpu-fraction":             "0.1",
+			"node-specific-sizing.manomano.tech/request-memory-fraction":          "0.2",
+			"node-specific-sizing.manomano.tech/limit-memory-fraction":            "0.4",
+			"node-specific-sizing.manomano.tech/minimum-cpu":                      "100m",
+			"node-specific-sizing.manomano.tech/allow-overcommit":                 "true",
+			"node-specific-sizing.manomano.tech/exclude-containers":               "istio-proxy,linkerd-proxy",
+			"node-specific-sizing.manomano.tech/distribution":                     "weighted",
+			"node-specific-sizing.manomano.tech/container-weights":                "app=3,sidecar=1",
+			"node-specific-sizing.manomano.tech/container.fluentd.maximum-memory": "500M",
+		}))
+	})
+
+	It("sizes pods from JSON documents", func(ctx SpecContext) {
+		node := fixtures.NodeWithCapacity("4", "8G")
+		node.Name = "node-a"
+		pod := fixtures.PinToNode(fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil)), "node-a")
+		pod.Annotations = map[string]string{ConfigAnnotation: `{"fractions": {"requests": {"cpu": "0.1"}}}`}
+
+		result, err := (&Sizer{nodeReader: fake.NewClientBuilder().WithObjects(node).Build()}).Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Unconfigured()).To(BeFalse())
+		Expect(slices.Collect(result.Patches())).To(ContainElement(HaveField("New", resource.MustParse("400m"))))
+	})
+
+	It("rejects malformed documents with the setting at fault", func() {
+		for document, message := range map[string]string{
+			`fraction: {requests: {cpu: 0.1}}`:         `unknown field "fraction"`,
+			`fractions: {requests: {gpu: 0.1}}`:        "fractions.requests.gpu is not supported",
+			`fractions: {requests: {cpu: [0.1]}}`:      "expected a number or a string",
+			`containers: {app: {maximum: {disk: 1G}}}`: "containers.app.maximum.disk is not supported",
+			`minimum: {cpu: lots}`:                     "minimum.cpu: lots cannot be parsed",
+			`[`:                                        "is not a valid sizing configuration",
+		} {
+			Expect(ValidateAnnotations(map[string]string{ConfigAnnotation: document})).To(MatchError(ContainSubstring(message)), document)
+		}
+	})
+
+	It("refuses settings also set as flat annotations", func() {
+		Expect(ValidateAnnotations(map[string]string{
+			ConfigAnnotation: `minimum: {cpu: 100m}`,
+			"node-specific-sizing.manomano.tech/minimum-cpu": "200m",
+		})).To(MatchError(ContainSubstring("minimum-cpu is set both in")))
+	})
+})
@@ -0,0 +1,82 @@
+package sizing
+
+import (
+	"fmt"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	"slices"
+	"strings"
+)
+
+// containerClampPrefix starts annotations holding the minimums and maximums of a single container, e.g.
+// node-specific-sizing.manomano.tech/container.fluentd.maximum-memory
+const containerClampPrefix = AnnotationPrefix + "container."
+
+// containerClampsFromAnnotations returns, by container name, the minimums and maximums annotations set on single
+// containers. Settings read the same as their pod counterparts, e.g. maximum-memory.
+func containerClampsFromAnnotations(annotations map[string]string) (map[string]*rps.ResourceProperties, error) {
+	supported := slices.Collect(rps.SupportedAnnotations())
+	settings := make(map[string]map[string]string)
+	for key, value := range annotations {
+		rest, ok := strings.CutPrefix(key, containerClampPrefix)
+		if !ok {
+			continue
+		}
+		// Container names are DNS labels, without dots
+		name, setting, ok := strings.Cut(rest, ".")
+		isClamp := strings.HasPrefix(setting, "minimum-") || strings.HasPrefix(setting, "maximum-")
+		if !ok || name == "" || !isClamp || !slices.Contains(supported, AnnotationPrefix+setting) {
+			return nil, fmt.Errorf("%s is not a container minimum or maximum, expected e.g. %sNAME.maximum-memory", key, containerClampPrefix)
+		}
+		if settings[name] == nil {
+			settings[name] = make(map[string]string)
+		}
+		settings[name][AnnotationPrefix+setting] = value
+	}
+
+	clamps := make(map[string]*rps.ResourceProperties, len(settings))
+	for name, containerSettings := range settings {
+		err, props := rps.NewFromAnnotations(containerSettings)
+		if err != nil {
+			return nil, fmt.Errorf("container %s: %w", name, err)
+		}
+		if err := props.CheckBounds(); err != nil {
+			return nil, fmt.Errorf("container %s: %w", name, err)
+		}
+		clamps[name] = props
+	}
+	return clamps, nil
+}
+
+// mergeContainerClamps combines container clamps, keeping the largest minimums and the smallest maximums
+func mergeContainerClamps(left, right map[string]*rps.ResourceProperties) map[string]*rps.ResourceProperties {
+	if len(left) == 0 {
+		return right
+	}
+	if len(right) == 0 {
+		return left
+	}
+	result := make(map[string]*rps.ResourceProperties)
+	for name, clamps := range left {
+		result[name] = clamps
+	}
+	for name, clamps := range right {
+		existing, ok := result[name]
+		if !ok {
+			result[name] = clamps
+			continue
+		}
+		merged := rps.New()
+		for binding := range existing.Max(clamps).All() {
+			if binding.Property() == rps.ResourcePodMinimum {
+				merged.Bind(*binding)
+			}
+		}
+		for binding := range existing.Min(clamps).All() {
+			if binding.Property() == rps.ResourcePodMaximum {
+				merged.Bind(*binding)
+			}
+		}
+		result[name] = merged
+	}
+	return result
+}
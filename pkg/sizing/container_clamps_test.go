@@ -0,0 +1,70 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var _ = Describe("Container minimums and maximums", Label("ContainerClamps"), func() {
+	pod := fixtures.PodWithContainers(
+		fixtures.ContainerWithResources("app", corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("100M")}, nil),
+		fixtures.ContainerWithResources("fluentd", corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("100M")}, nil),
+	)
+
+	It("bounds containers separately", func() {
+		annotations := map[string]string{
+			"node-specific-sizing.manomano.tech/request-memory-fraction":          "0.5",
+			"node-specific-sizing.manomano.tech/container.fluentd.maximum-memory": "500M",
+			"node-specific-sizing.manomano.tech/container.app.minimum-memory":     "1.2G",
+		}
+		clamps, err := containerClampsFromAnnotations(annotations)
+		Expect(err).NotTo(HaveOccurred())
+		err, userSettings := rps.NewFromAnnotations(annotations)
+		Expect(err).NotTo(HaveOccurred())
+
+		containers, trace := runSizingPipeline(sizingInput{
+			userSettings:    userSettings,
+			node:            fixtures.NodeWithCapacity("2", "4G"),
+			pod:             pod,
+			containerClamps: clamps,
+		})
+		Expect(trace.Adjusted(stageContainerMinMax)).To(BeTrue())
+		Expect(fixtures.BoundValue(containers["app"], rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("~", 1.2e9))
+		Expect(fixtures.BoundValue(containers["fluentd"], rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("~", 0.5e9))
+	})
+
+	It("combines with usage floors, keeping the largest minimums and smallest maximums", func() {
+		annotated := rps.New()
+		annotated.BindPropertyFloat(rps.ResourceQuantity, rps.ResourcePodMinimum, corev1.ResourceMemory, 100)
+		annotated.BindPropertyFloat(rps.ResourceQuantity, rps.ResourcePodMaximum, corev1.ResourceMemory, 500)
+		floors := rps.New()
+		floors.BindPropertyFloat(rps.ResourceQuantity, rps.ResourcePodMinimum, corev1.ResourceMemory, 200)
+
+		merged := mergeContainerClamps(
+			map[string]*rps.ResourceProperties{"app": annotated},
+			map[string]*rps.ResourceProperties{"app": floors, "fluentd": floors},
+		)
+		Expect(fixtures.BoundValue(merged["app"], rps.ResourcePodMinimum, corev1.ResourceMemory)).To(BeNumerically("==", 200))
+		Expect(fixtures.BoundValue(merged["app"], rps.ResourcePodMaximum, corev1.ResourceMemory)).To(BeNumerically("==", 500))
+		Expect(merged["fluentd"]).To(BeIdenticalTo(floors))
+	})
+
+	It("rejects malformed settings", func() {
+		for _, annotations := range []map[string]string{
+			{"node-specific-sizing.manomano.tech/container.app.request-cpu-fraction": "0.1"},
+			{"node-specific-sizing.manomano.tech/container.app.maximum-gpu": "1"},
+			{"node-specific-sizing.manomano.tech/container.maximum-memory": "1G"},
+			{"node-specific-sizing.manomano.tech/container.app.maximum-memory": "lots"},
+			{
+				"node-specific-sizing.manomano.tech/container.app.minimum-memory": "2G",
+				"node-specific-sizing.manomano.tech/container.app.maximum-memory": "1G",
+			},
+		} {
+			Expect(ValidateAnnotations(annotations)).To(HaveOccurred(), "%v", annotations)
+		}
+	})
+})
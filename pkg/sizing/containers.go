@@ -0,0 +1,66 @@
+package sizing
+
+import (
+	"fmt"
+	"iter"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// podContainer is a container sizing gives a share of the pod budget to, along with where it sits in the pod spec
+type podContainer struct {
+	*corev1.Container
+	// index is the position of the container in its list, init containers for sidecars or regular containers
+	index int
+	// sidecar tells a native sidecar, found among init containers
+	sidecar bool
+}
+
+// IsSidecar tells whether an init container is a native sidecar: restarted whenever it exits, it keeps running along
+// with regular containers and counts towards pod resources like them.
+func IsSidecar(ctn *corev1.Container) bool {
+	return ctn.RestartPolicy != nil && *ctn.RestartPolicy == corev1.ContainerRestartPolicyAlways
+}
+
+// longRunningContainers lists native sidecars, in init order, then regular containers: everything that runs for the
+// lifetime of the pod and shares its budget. Other init containers run one after the other before them, and keep
+// their resources.
+func longRunningContainers(pod *corev1.Pod) []podContainer {
+	var result []podContainer
+	for i := range pod.Spec.InitContainers {
+		if IsSidecar(&pod.Spec.InitContainers[i]) {
+			result = append(result, podContainer{Container: &pod.Spec.InitContainers[i], index: i, sidecar: true})
+		}
+	}
+	for i := range pod.Spec.Containers {
+		result = append(result, podContainer{Container: &pod.Spec.Containers[i], index: i})
+	}
+	return result
+}
+
+// LongRunningContainers iterates over the containers sizing applies to, native sidecars first, then regular
+// containers
+func LongRunningContainers(pod *corev1.Pod) iter.Seq[*corev1.Container] {
+	return func(yield func(*corev1.Container) bool) {
+		for _, ctn := range longRunningContainers(pod) {
+			if !yield(ctn.Container) {
+				return
+			}
+		}
+	}
+}
+
+// containerIn returns the container of pod found at the same place as ctn
+func (ctn podContainer) containerIn(pod *corev1.Pod) *corev1.Container {
+	if ctn.sidecar {
+		return &pod.Spec.InitContainers[ctn.index]
+	}
+	return &pod.Spec.Containers[ctn.index]
+}
+
+// containerPath points to a container within the pod, sidecars being found under /spec/initContainers
+func containerPath(sidecar bool, index int) string {
+	if sidecar {
+		return fmt.Sprintf("/spec/initContainers/%d", index)
+	}
+	return fmt.Sprintf("/spec/containers/%d", index)
+}
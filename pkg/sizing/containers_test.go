@@ -0,0 +1,86 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Sizing native sidecars", Label("Sidecars"), func() {
+	node := fixtures.NodeWithCapacity("4", "8G")
+	node.Name = "node-a"
+	sizer := &Sizer{nodeReader: fake.NewClientBuilder().WithObjects(node).Build()}
+
+	var pod *corev1.Pod
+	BeforeEach(func() {
+		always := corev1.ContainerRestartPolicyAlways
+		sidecar := fixtures.ContainerWithResources("proxy", corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil)
+		sidecar.RestartPolicy = &always
+		pod = fixtures.PinToNode(fixtures.PodWithContainers(
+			fixtures.ContainerWithResources("app", corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("300m")}, nil),
+		), "node-a")
+		pod.Spec.InitContainers = []corev1.Container{
+			fixtures.ContainerWithResources("migrate", corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}, nil),
+			sidecar,
+		}
+		pod.ObjectMeta = metav1.ObjectMeta{Annotations: map[string]string{
+			"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.1",
+		}}
+	})
+
+	It("tells sidecars apart from other init containers", func() {
+		var names []string
+		for ctn := range LongRunningContainers(pod) {
+			names = append(names, ctn.Name)
+		}
+		Expect(names).To(Equal([]string{"proxy", "app"}))
+	})
+
+	It("gives sidecars a share of the pod budget along with regular containers", func() {
+		containers, _ := runPipelineFor(pod.Annotations, node, pod)
+		Expect(containers).To(HaveKey("proxy"))
+		Expect(containers).NotTo(HaveKey("migrate"))
+		proxy, _ := containers["proxy"].GetValue(rps.ResourceRequests, corev1.ResourceCPU)
+		app, _ := containers["app"].GetValue(rps.ResourceRequests, corev1.ResourceCPU)
+		Expect(proxy).To(BeNumerically("~", 0.1))
+		Expect(app).To(BeNumerically("~", 0.3))
+	})
+
+	It("patches sidecars under init containers", func(ctx SpecContext) {
+		result, patch, err := sizer.CreatePatch(ctx, pod, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		var patches []ResourcePatch
+		for resourcePatch := range result.Patches() {
+			patches = append(patches, resourcePatch)
+		}
+		Expect(patches).To(HaveLen(2))
+		Expect(patches[0].ContainerName).To(Equal("proxy"))
+		Expect(patches[0].Sidecar).To(BeTrue())
+		Expect(patches[0].JsonPath()).To(Equal("/spec/initContainers/1/resources/requests/cpu"))
+		Expect(patches[1].JsonPath()).To(Equal("/spec/containers/0/resources/requests/cpu"))
+
+		var paths []string
+		for _, op := range patch {
+			paths = append(paths, op.Path)
+		}
+		Expect(paths).To(ContainElement("/spec/initContainers/1/resources/requests/cpu"))
+		Expect(paths).NotTo(ContainElement(HavePrefix("/spec/initContainers/0")))
+	})
+
+	It("restores the original resources of sidecars when sizing again", func(ctx SpecContext) {
+		result, err := sizer.Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		originals := originalResourcesOf(result)
+		Expect(originals).To(HaveKey("proxy"))
+
+		pod.Spec.InitContainers[1].Resources.Requests[corev1.ResourceCPU] = resource.MustParse("5")
+		restored := withOriginalResources(pod, originals)
+		Expect(restored.Spec.InitContainers[1].Resources.Requests.Cpu().String()).To(Equal("100m"))
+	})
+})
@@ -0,0 +1,145 @@
+package sizing
+
+import (
+	"fmt"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	"math/big"
+	"strings"
+)
+
+const (
+	// distributionAnnotation picks how the pod budget is spread between containers, see distributionStrategy
+	distributionAnnotation = AnnotationPrefix + "distribution"
+
+	// containerWeightsAnnotation gives containers their weight for the weighted distribution, e.g. "app=3,sidecar=1"
+	containerWeightsAnnotation = AnnotationPrefix + "container-weights"
+
+	// primaryContainerAnnotation names the container that gets the pod budget for the primary distribution
+	primaryContainerAnnotation = AnnotationPrefix + "primary-container"
+)
+
+// distributionStrategy tells how the pod budget is spread between containers
+type distributionStrategy string
+
+const (
+	// distributionProportional spreads the pod budget proportionally to the original resources of containers
+	distributionProportional distributionStrategy = "proportional"
+	// distributionEqual splits the pod budget evenly between containers
+	distributionEqual distributionStrategy = "equal"
+	// distributionWeighted spreads the pod budget proportionally to the weights of containerWeightsAnnotation.
+	// Containers left out of it weigh 1.
+	distributionWeighted distributionStrategy = "weighted"
+	// distributionPrimary gives the pod budget to a single container, what other containers keep deducted from it
+	distributionPrimary distributionStrategy = "primary"
+)
+
+// distribution is how a pod spreads its budget between containers
+type distribution struct {
+	strategy distributionStrategy
+	// weights holds, by container name, the weights of the weighted distribution
+	weights map[string]*big.Rat
+	// primary names the container of the primary distribution
+	primary string
+}
+
+// distributionFromAnnotations returns the distribution of a pod, proportional unless annotations say otherwise
+func distributionFromAnnotations(annotations map[string]string) (distribution, error) {
+	result := distribution{strategy: distributionStrategy(annotations[distributionAnnotation])}
+	if result.strategy == "" {
+		result.strategy = distributionProportional
+	}
+
+	_, hasWeights := annotations[containerWeightsAnnotation]
+	if hasWeights != (result.strategy == distributionWeighted) {
+		return distribution{}, fmt.Errorf("%s goes together with the weighted distribution", containerWeightsAnnotation)
+	}
+	_, hasPrimary := annotations[primaryContainerAnnotation]
+	if hasPrimary != (result.strategy == distributionPrimary) {
+		return distribution{}, fmt.Errorf("%s goes together with the primary distribution", primaryContainerAnnotation)
+	}
+
+	switch result.strategy {
+	case distributionProportional, distributionEqual:
+	case distributionWeighted:
+		weights, err := parseContainerWeights(annotations[containerWeightsAnnotation])
+		if err != nil {
+			return distribution{}, err
+		}
+		result.weights = weights
+	case distributionPrimary:
+		result.primary = strings.TrimSpace(annotations[primaryContainerAnnotation])
+		if result.primary == "" {
+			return distribution{}, fmt.Errorf("%s cannot be empty", primaryContainerAnnotation)
+		}
+	default:
+		return distribution{}, fmt.Errorf("unknown %s %q, expected one of proportional, equal, weighted or primary",
+			distributionAnnotation, result.strategy)
+	}
+	return result, nil
+}
+
+// parseContainerWeights parses comma-separated name=weight pairs, weights being positive numbers
+func parseContainerWeights(value string) (map[string]*big.Rat, error) {
+	weights := make(map[string]*big.Rat)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, raw, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s: %q is not a name=weight pair", containerWeightsAnnotation, pair)
+		}
+		weight, ok := new(big.Rat).SetString(strings.TrimSpace(raw))
+		if !ok || weight.Sign() <= 0 {
+			return nil, fmt.Errorf("%s: weight of container %s must be a positive number", containerWeightsAnnotation, name)
+		}
+		weights[name] = weight
+	}
+	if len(weights) == 0 {
+		return nil, fmt.Errorf("%s cannot be empty", containerWeightsAnnotation)
+	}
+	return weights, nil
+}
+
+// byWeight tells whether containers get shares of the pod budget by weight rather than by their original resources
+func (d distribution) byWeight() bool {
+	return d.strategy != "" && d.strategy != distributionProportional
+}
+
+// weightOf returns the weight of a container, or nil when the pod budget is spread proportionally
+func (d distribution) weightOf(name string) *big.Rat {
+	switch d.strategy {
+	case distributionWeighted:
+		if weight, ok := d.weights[name]; ok {
+			return weight
+		}
+		return big.NewRat(1, 1)
+	case distributionEqual, distributionPrimary:
+		// Other containers than the primary one are excluded when the primary distribution is used
+		return big.NewRat(1, 1)
+	}
+	return nil
+}
+
+// computeWeightedResourceRequirements gives every container its weight over the sum of weights as the proportion of
+// every property of the pod budget, whatever its original resources
+func computeWeightedResourceRequirements(names []string, dist distribution, podResourceBudget *rps.ResourceProperties) map[string]*rps.ResourceProperties {
+	total := new(big.Rat)
+	for _, name := range names {
+		total.Add(total, dist.weightOf(name))
+	}
+	result := make(map[string]*rps.ResourceProperties)
+	if total.Sign() == 0 {
+		return result
+	}
+	for _, name := range names {
+		share := new(big.Rat).Quo(dist.weightOf(name), total)
+		proportions := rps.New()
+		for binding := range podResourceBudget.All() {
+			proportions.BindPropertyRat(rps.ResourceFraction, binding.Property(), binding.ResourceName(), share)
+		}
+		result[name] = proportions
+	}
+	return result
+}
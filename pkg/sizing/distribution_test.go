@@ -0,0 +1,79 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Distribution strategies", Label("Distribution"), func() {
+	pod := fixtures.PodWithContainers(
+		fixtures.ContainerWithResources("app", corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("100M")}, nil),
+		fixtures.ContainerWithResources("sidecar", corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("300M")}, nil),
+	)
+	node := fixtures.NodeWithCapacity("2", "4G")
+
+	distribute := func(annotations map[string]string) map[string]*rps.ResourceProperties {
+		annotations["node-specific-sizing.manomano.tech/request-memory-fraction"] = "0.5"
+		dist, err := distributionFromAnnotations(annotations)
+		Expect(err).NotTo(HaveOccurred())
+		err, userSettings := rps.NewFromAnnotations(annotations)
+		Expect(err).NotTo(HaveOccurred())
+		containers, _ := runSizingPipeline(sizingInput{userSettings: userSettings, node: node, pod: pod, distribution: dist})
+		return containers
+	}
+
+	It("spreads proportionally to original resources by default", func() {
+		containers := distribute(map[string]string{})
+		Expect(fixtures.BoundValue(containers["app"], rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("~", 0.5e9))
+		Expect(fixtures.BoundValue(containers["sidecar"], rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("~", 1.5e9))
+	})
+
+	It("splits evenly", func() {
+		containers := distribute(map[string]string{distributionAnnotation: "equal"})
+		Expect(fixtures.BoundValue(containers["app"], rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("~", 1e9))
+		Expect(fixtures.BoundValue(containers["sidecar"], rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("~", 1e9))
+	})
+
+	It("spreads by weight, containers left out weighing 1", func() {
+		containers := distribute(map[string]string{distributionAnnotation: "weighted", containerWeightsAnnotation: "app=3"})
+		Expect(fixtures.BoundValue(containers["app"], rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("~", 1.5e9))
+		Expect(fixtures.BoundValue(containers["sidecar"], rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("~", 0.5e9))
+	})
+
+	It("gives the primary container what sidecars leave", func() {
+		containers := distribute(map[string]string{distributionAnnotation: "primary", primaryContainerAnnotation: "app"})
+		Expect(containers).NotTo(HaveKey("sidecar"))
+		Expect(fixtures.BoundValue(containers["app"], rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("~", 1.7e9))
+	})
+
+	It("refuses primary containers the pod does not size", func(ctx SpecContext) {
+		named := node.DeepCopy()
+		named.Name = "node-a"
+		sized := fixtures.PinToNode(pod.DeepCopy(), "node-a")
+		sized.Annotations = map[string]string{
+			"node-specific-sizing.manomano.tech/request-memory-fraction": "0.5",
+			distributionAnnotation:     "primary",
+			primaryContainerAnnotation: "missing",
+		}
+		_, err := (&Sizer{nodeReader: fake.NewClientBuilder().WithObjects(named).Build()}).Size(ctx, sized)
+		Expect(err).To(MatchError(ContainSubstring("primary container missing")))
+	})
+
+	It("rejects malformed settings", func() {
+		for _, annotations := range []map[string]string{
+			{distributionAnnotation: "random"},
+			{distributionAnnotation: "weighted"},
+			{distributionAnnotation: "weighted", containerWeightsAnnotation: "app=0"},
+			{distributionAnnotation: "weighted", containerWeightsAnnotation: "app"},
+			{distributionAnnotation: "primary"},
+			{containerWeightsAnnotation: "app=1"},
+		} {
+			Expect(ValidateAnnotations(annotations)).To(HaveOccurred(), "%v", annotations)
+		}
+	})
+})
@@ -0,0 +1,135 @@
+package sizing
+
+import (
+	"fmt"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/apis/v1alpha1"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// settingViolation is a setting a pod or its namespace loosens, compared to what their policy enforces
+type settingViolation struct {
+	Key    string `json:"key"`
+	Source string `json:"source"`
+	Value  string `json:"value"`
+	// Enforced is empty for basis annotations, which change what an enforced fraction means
+	Enforced string `json:"enforced,omitempty"`
+	Policy   string `json:"policy"`
+}
+
+func (sv settingViolation) String() string {
+	if sv.Enforced == "" {
+		return fmt.Sprintf("%s=%q set by %s changes the basis of a fraction enforced by policy/%s", sv.Key, sv.Value, sv.Source, sv.Policy)
+	}
+	return fmt.Sprintf("%s=%q set by %s is looser than %q enforced by policy/%s", sv.Key, sv.Value, sv.Source, sv.Enforced, sv.Policy)
+}
+
+// EnforcedSettingsError is returned for pods loosening settings their policy enforces with v1alpha1.EnforcementDeny
+type EnforcedSettingsError struct {
+	violations []settingViolation
+}
+
+func (e *EnforcedSettingsError) Error() string {
+	var messages []string
+	for _, violation := range e.violations {
+		messages = append(messages, violation.String())
+	}
+	return "sizing settings loosen enforced ones: " + strings.Join(messages, "; ")
+}
+
+// enforcedAnnotations returns the settings policy enforces, keyed by annotation, or nil when it enforces none
+func enforcedAnnotations(policy *v1alpha1.SizingPolicy) (map[string]string, error) {
+	if policy == nil || policy.Spec.Enforced == nil {
+		return nil, nil
+	}
+	result := make(map[string]string, len(policy.Spec.Enforced.Settings))
+	for name, value := range policy.Spec.Enforced.Settings {
+		key := name
+		if !strings.Contains(key, "/") {
+			key = AnnotationPrefix + name
+		}
+		if _, err := parseEnforceable(key, value, true); err != nil {
+			return nil, fmt.Errorf("sizing policy %s enforces an invalid setting: %w", policy.Name, err)
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+// parseEnforceable parses a single fraction, minimum or maximum setting
+func parseEnforceable(key, value string, allowOvercommit bool) (*rps.ResourcePropertyBinding, error) {
+	annotations := map[string]string{key: value, rps.AllowOvercommitAnnotation: strconv.FormatBool(allowOvercommit)}
+	err, props := rps.NewFromAnnotations(annotations)
+	if err != nil {
+		return nil, err
+	}
+	for binding := range props.All() {
+		if binding.Property() == rps.ResourceRounding {
+			break
+		}
+		return binding, nil
+	}
+	return nil, fmt.Errorf("%s cannot be enforced, only fractions, minimums and maximums can", key)
+}
+
+// loosens tells whether value is looser than enforced: below it for minimums, above it otherwise. Values that do not
+// parse are left for parsing settings to report.
+func loosens(key, value, enforced string, allowOvercommit bool) bool {
+	binding, err := parseEnforceable(key, value, allowOvercommit)
+	if err != nil {
+		return false
+	}
+	enforcedBinding, err := parseEnforceable(key, enforced, true)
+	if err != nil {
+		return false
+	}
+	comparison := binding.Rat().Cmp(enforcedBinding.Rat())
+	if binding.Property() == rps.ResourcePodMinimum {
+		return comparison < 0
+	}
+	return comparison > 0
+}
+
+// withEnforced adds enforced settings to those of a policy, which cannot loosen them either
+func withEnforced(policyAnnotations, enforced map[string]string) map[string]string {
+	allowOvercommit, _ := strconv.ParseBool(policyAnnotations[rps.AllowOvercommitAnnotation])
+	for key, value := range enforced {
+		if own, ok := policyAnnotations[key]; !ok || loosens(key, own, value, allowOvercommit) {
+			policyAnnotations[key] = value
+		}
+	}
+	return policyAnnotations
+}
+
+// enforce replaces the merged settings that sources taking precedence over the policy loosen with enforced ones, and
+// returns what they loosened. Basis annotations of enforced fractions are dropped, as they change what fractions mean.
+func enforce(merged map[string]string, sources []settingsSource, enforced map[string]string, policyName string) []settingViolation {
+	// Sources come by decreasing precedence, those before the policy are the pod and its namespace
+	overriding := sources[:slices.IndexFunc(sources, func(source settingsSource) bool { return source.name == "policy/"+policyName })]
+	winner := func(key string) (string, bool) {
+		for _, source := range overriding {
+			if _, ok := source.annotations[key]; ok {
+				return source.name, true
+			}
+		}
+		return "", false
+	}
+
+	allowOvercommit, _ := strconv.ParseBool(merged[rps.AllowOvercommitAnnotation])
+	var violations []settingViolation
+	for _, key := range slices.Sorted(maps.Keys(enforced)) {
+		if source, ok := winner(key); ok && loosens(key, merged[key], enforced[key], allowOvercommit) {
+			violations = append(violations, settingViolation{Key: key, Source: source, Value: merged[key], Enforced: enforced[key], Policy: policyName})
+			merged[key] = enforced[key]
+		}
+		basisKey := rps.BasisAnnotation(key)
+		if source, ok := winner(basisKey); ok && rps.IsBasisAnnotation(basisKey) {
+			violations = append(violations, settingViolation{Key: basisKey, Source: source, Value: merged[basisKey], Policy: policyName})
+			delete(merged, basisKey)
+		}
+	}
+	return violations
+}
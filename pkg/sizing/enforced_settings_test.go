@@ -0,0 +1,103 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/apis/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Enforced settings", Label("EnforcedSettings"), func() {
+	const cpuFraction = "node-specific-sizing.manomano.tech/request-cpu-fraction"
+
+	node := fixtures.NodeWithCapacity("4", "8G")
+	node.Name = "node-a"
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}
+
+	var pod *corev1.Pod
+	var policy *v1alpha1.SizingPolicy
+	BeforeEach(func() {
+		pod = fixtures.PinToNode(fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil)), "node-a")
+		pod.Namespace = "team-a"
+		pod.Annotations = map[string]string{}
+		policy = &v1alpha1.SizingPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "tenants"},
+			Spec: v1alpha1.SizingPolicySpec{Enforced: &v1alpha1.EnforcedSettings{
+				Settings: map[string]string{"request-cpu-fraction": "0.1"},
+			}},
+		}
+	})
+
+	size := func(ctx SpecContext, namespace *corev1.Namespace) (*Result, error) {
+		reader := fake.NewClientBuilder().WithObjects(node, namespace).Build()
+		sizer := &Sizer{nodeReader: reader, namespaceReader: reader, policyReader: policyReaderWith(policy), conflicts: ConflictsDeny}
+		return sizer.Size(ctx, pod)
+	}
+
+	It("applies to pods that do not set them", func(ctx SpecContext) {
+		result, err := size(ctx, namespace)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.patches[0].New.String()).To(Equal("400m"))
+	})
+
+	It("lets pods tighten them without conflicting", func(ctx SpecContext) {
+		pod.Annotations[cpuFraction] = "0.05"
+		result, err := size(ctx, namespace)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.patches[0].New.String()).To(Equal("200m"))
+		Expect(result.warnings).To(BeEmpty())
+	})
+
+	It("overrides looser namespace defaults with a warning", func(ctx SpecContext) {
+		loose := namespace.DeepCopy()
+		loose.Annotations = map[string]string{cpuFraction: "0.5"}
+		result, err := size(ctx, loose)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.patches[0].New.String()).To(Equal("400m"))
+		Expect(result.warnings).To(ConsistOf(cpuFraction +
+			`="0.5" set by namespace/team-a is looser than "0.1" enforced by policy/tenants, the enforced setting applies`))
+	})
+
+	It("drops bases changing what enforced fractions mean", func(ctx SpecContext) {
+		pod.Annotations["node-specific-sizing.manomano.tech/request-cpu-basis"] = "label:cpu-share"
+		result, err := size(ctx, namespace)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.patches[0].New.String()).To(Equal("400m"))
+		Expect(result.warnings).To(ConsistOf(ContainSubstring("changes the basis")))
+	})
+
+	It("denies pods loosening them when asked to", func(ctx SpecContext) {
+		policy.Spec.Enforced.Action = v1alpha1.EnforcementDeny
+		pod.Annotations[cpuFraction] = "0.2"
+		_, err := size(ctx, namespace)
+		var enforcedErr *EnforcedSettingsError
+		Expect(err).To(BeAssignableToTypeOf(enforcedErr))
+		Expect(err).To(MatchError(ContainSubstring("set by pod is looser")))
+	})
+
+	It("raises minimums, lowers maximums and fractions of the policy itself", func() {
+		policy.Spec.FractionSets = []v1alpha1.FractionSet{{Fractions: v1alpha1.Fractions{RequestCPU: "0.5"}}}
+		Expect(withEnforced(policyAnnotations(policy, &policy.Spec.FractionSets[0]), map[string]string{cpuFraction: "0.1"})).
+			To(HaveKeyWithValue(cpuFraction, "0.1"))
+		Expect(loosens("node-specific-sizing.manomano.tech/minimum-memory", "1Gi", "2Gi", false)).To(BeTrue())
+		Expect(loosens("node-specific-sizing.manomano.tech/maximum-memory", "1Gi", "2Gi", false)).To(BeFalse())
+	})
+
+	It("only enforces fractions, minimums and maximums", func() {
+		for _, settings := range []map[string]string{
+			{"rounding-cpu": "100m"},
+			{"allow-overcommit": "true"},
+			{"maximum-memory": "lots"},
+			{"unknown": "1"},
+		} {
+			policy.Spec.Enforced.Settings = settings
+			_, err := enforcedAnnotations(policy)
+			Expect(err).To(HaveOccurred(), "%v", settings)
+		}
+	})
+})
@@ -0,0 +1,57 @@
+package sizing
+
+import (
+	"errors"
+)
+
+// FailureReason classifies why a pod could not be sized, for operators to alert on classes of failures rather than on
+// messages
+type FailureReason string
+
+const (
+	// FailureNodeNotFound is returned when the node of a pod cannot be resolved or read, see NodeUnavailableError
+	FailureNodeNotFound FailureReason = "NodeNotFound"
+	// FailureAnnotationInvalid is returned when sizing settings cannot be read, or conflict with each other or with
+	// enforced ones
+	FailureAnnotationInvalid FailureReason = "AnnotationInvalid"
+	// FailureBudgetComputeFailed is returned for any other failure to compute the pod budget, e.g. a SizingPolicy or
+	// ResourceQuota that cannot be read, or a step running out of its budget
+	FailureBudgetComputeFailed FailureReason = "BudgetComputeFailed"
+)
+
+// AnnotationError is returned when sizing settings of a pod, from its annotations, its namespace or its policy, cannot
+// be read
+type AnnotationError struct {
+	err error
+}
+
+func (e *AnnotationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *AnnotationError) Unwrap() error {
+	return e.err
+}
+
+// invalidAnnotations wraps err, if any, in an AnnotationError
+func invalidAnnotations(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &AnnotationError{err: err}
+}
+
+// FailureReasonOf tells why err, returned by the Sizer, prevented sizing
+func FailureReasonOf(err error) FailureReason {
+	var nodeErr *NodeUnavailableError
+	var annotationErr *AnnotationError
+	var conflictErr *SettingsConflictError
+	var enforcedErr *EnforcedSettingsError
+	switch {
+	case errors.As(err, &nodeErr):
+		return FailureNodeNotFound
+	case errors.As(err, &annotationErr), errors.As(err, &conflictErr), errors.As(err, &enforcedErr):
+		return FailureAnnotationInvalid
+	}
+	return FailureBudgetComputeFailed
+}
@@ -0,0 +1,49 @@
+package sizing
+
+import (
+	"errors"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Failure reasons", Label("Failures"), func() {
+	var sizer *Sizer
+	var pod *corev1.Pod
+
+	BeforeEach(func() {
+		node := fixtures.NodeWithCapacity("4", "8Gi")
+		node.Name = "node-a"
+		sizer = &Sizer{nodeReader: fake.NewClientBuilder().WithObjects(node).Build()}
+		pod = fixtures.PinToNode(fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil)), "node-a")
+		pod.Annotations = map[string]string{"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.1"}
+	})
+
+	It("tells nodes that cannot be read", func(ctx SpecContext) {
+		fixtures.PinToNode(pod, "node-gone")
+		_, err := sizer.Size(ctx, pod)
+		Expect(FailureReasonOf(err)).To(Equal(FailureNodeNotFound))
+	})
+
+	It("tells annotations that cannot be read, whatever setting they hold", func(ctx SpecContext) {
+		pod.Annotations["node-specific-sizing.manomano.tech/request-cpu-fraction"] = "lots"
+		_, err := sizer.Size(ctx, pod)
+		Expect(FailureReasonOf(err)).To(Equal(FailureAnnotationInvalid))
+		Expect(err).To(MatchError("problem parsing annotations: lots cannot be parsed as a fraction: not a number"))
+
+		pod.Annotations["node-specific-sizing.manomano.tech/request-cpu-fraction"] = "0.1"
+		pod.Annotations[RuntimeEnvAnnotation] = "cobol"
+		_, err = sizer.Size(ctx, pod)
+		Expect(FailureReasonOf(err)).To(Equal(FailureAnnotationInvalid))
+	})
+
+	It("tells any other failure to compute the budget", func() {
+		Expect(FailureReasonOf(errors.New("could not list resource quotas"))).To(Equal(FailureBudgetComputeFailed))
+		Expect(FailureReasonOf(&BudgetExceededError{Step: StepPatching, err: errors.New("deadline exceeded")})).
+			To(Equal(FailureBudgetComputeFailed))
+	})
+})
@@ -0,0 +1,67 @@
+package sizing
+
+import (
+	"context"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+	"testing"
+)
+
+// FuzzCreatePatch sizes arbitrary pods against arbitrary nodes. Sizing may fail, but never panic, and patches must
+// hold valid quantities.
+func FuzzCreatePatch(f *testing.F) {
+	f.Add("0.1", "0.5", "64Mi", "100m", "128Mi", "256Mi", "50m", "", "4", "8Gi")
+	f.Add("1", "1/3", "", "", "", "", "0", "sidecar", "0", "0")
+	f.Add("0.5", "", "2Gi", "1", "1Gi", "", "", "agent,sidecar", "1m", "1Ki")
+	f.Fuzz(func(t *testing.T, cpuFraction, memoryLimitFraction, minimumMemory, agentCPU, agentMemory, agentMemoryLimit, sidecarCPU, exclude, nodeCPU, nodeMemory string) {
+		node := &corev1.Node{Status: corev1.NodeStatus{Capacity: quantities(map[corev1.ResourceName]string{
+			corev1.ResourceCPU:    nodeCPU,
+			corev1.ResourceMemory: nodeMemory,
+		})}}
+		node.Name = "node-a"
+
+		pod := fixtures.PodWithContainers(
+			fixtures.ContainerWithResources("agent",
+				quantities(map[corev1.ResourceName]string{corev1.ResourceCPU: agentCPU, corev1.ResourceMemory: agentMemory}),
+				quantities(map[corev1.ResourceName]string{corev1.ResourceMemory: agentMemoryLimit})),
+			fixtures.ContainerWithResources("sidecar", quantities(map[corev1.ResourceName]string{corev1.ResourceCPU: sidecarCPU}), nil),
+		)
+		pod.Annotations = map[string]string{
+			"node-specific-sizing.manomano.tech/request-cpu-fraction":  cpuFraction,
+			"node-specific-sizing.manomano.tech/limit-memory-fraction": memoryLimitFraction,
+			"node-specific-sizing.manomano.tech/minimum-memory":        minimumMemory,
+			ExcludeContainersAnnotation:                                exclude,
+		}
+		fixtures.PinToNode(pod, node.Name)
+
+		sizer := New(fake.NewClientBuilder().WithObjects(node).Build(), Options{})
+		_, patch, err := sizer.CreatePatch(context.Background(), pod, false)
+		if err != nil {
+			return
+		}
+		for _, op := range patch {
+			if !strings.HasPrefix(op.Path, "/spec/containers/") || strings.HasSuffix(op.Path, "/resources") ||
+				strings.HasSuffix(op.Path, "/requests") || strings.HasSuffix(op.Path, "/limits") {
+				continue
+			}
+			value, _ := op.Value.(string)
+			if qty, err := resource.ParseQuantity(value); err != nil || qty.Sign() < 0 {
+				t.Fatalf("%s is set to %v, which is not a valid quantity", op.Path, op.Value)
+			}
+		}
+	})
+}
+
+// quantities parses the values that are quantities, leaving others out
+func quantities(values map[corev1.ResourceName]string) corev1.ResourceList {
+	result := corev1.ResourceList{}
+	for name, value := range values {
+		if qty, err := resource.ParseQuantity(value); err == nil {
+			result[name] = qty
+		}
+	}
+	return result
+}
@@ -0,0 +1,67 @@
+package sizing
+
+import (
+	"context"
+	"fmt"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// limitRangeWarnings tells which sized values the container LimitRanges of the namespace of a pod would reject. Values
+// sizing did not set are left to the LimitRanger admission plugin.
+func limitRangeWarnings(ctx context.Context, limitRangeReader client.Reader, namespace string, result *Result) ([]string, error) {
+	var limitRanges corev1.LimitRangeList
+	if err := limitRangeReader.List(ctx, &limitRanges, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("problem listing limit ranges of namespace %s: %w", namespace, err)
+	}
+	if len(limitRanges.Items) == 0 {
+		return nil, nil
+	}
+
+	finals := make(map[string]corev1.ResourceRequirements)
+	for _, report := range result.containerReports() {
+		finals[report.Name] = report.Final
+	}
+
+	var warnings []string
+	for _, limitRange := range limitRanges.Items {
+		for _, item := range limitRange.Spec.Limits {
+			if item.Type != corev1.LimitTypeContainer {
+				continue
+			}
+			warn := func(patch ResourcePatch, format string, args ...any) {
+				warnings = append(warnings, fmt.Sprintf("LimitRange %s/%s: container %s %s.%s ",
+					limitRange.Namespace, limitRange.Name, patch.ContainerName, patch.Property, patch.Resource)+fmt.Sprintf(format, args...))
+			}
+			// The ratio is checked once per container and resource, whether its request, its limit or both were sized
+			ratioChecked := make(map[string]bool)
+			for patch := range result.Patches() {
+				if maximum, ok := item.Max[patch.Resource]; ok && patch.New.Cmp(maximum) > 0 {
+					warn(patch, "%s is above the maximum of %s", patch.New.String(), maximum.String())
+				}
+				if minimum, ok := item.Min[patch.Resource]; ok && patch.New.Cmp(minimum) < 0 {
+					warn(patch, "%s is below the minimum of %s", patch.New.String(), minimum.String())
+				}
+
+				key := patch.ContainerName + "/" + string(patch.Resource)
+				ratio, hasRatio := item.MaxLimitRequestRatio[patch.Resource]
+				if !hasRatio || ratioChecked[key] {
+					continue
+				}
+				ratioChecked[key] = true
+				final := finals[patch.ContainerName]
+				request, hasRequest := final.Requests[patch.Resource]
+				limit, hasLimit := final.Limits[patch.Resource]
+				if !hasRequest || !hasLimit || request.Sign() <= 0 {
+					continue
+				}
+				actual := rps.QuantityRat(limit)
+				if actual.Quo(actual, rps.QuantityRat(request)).Cmp(rps.QuantityRat(ratio)) > 0 {
+					warn(patch, "makes the limit of %s more than %s times the request of %s", limit.String(), ratio.String(), request.String())
+				}
+			}
+		}
+	}
+	return warnings, nil
+}
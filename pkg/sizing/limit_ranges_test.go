@@ -0,0 +1,61 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Checking sizing against limit ranges", Label("LimitRanges"), func() {
+	node := fixtures.NodeWithCapacity("4", "8G")
+	node.Name = "node-a"
+	reader := fake.NewClientBuilder().
+		WithObjects(node, &corev1.LimitRange{
+			ObjectMeta: metav1.ObjectMeta{Name: "containers", Namespace: "team"},
+			Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{
+				{
+					Type:                 corev1.LimitTypeContainer,
+					Max:                  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+					MaxLimitRequestRatio: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2")},
+				},
+				{
+					Type: corev1.LimitTypePod,
+					Max:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+				},
+			}},
+		}).
+		Build()
+
+	sizedPod := func(annotations map[string]string) *corev1.Pod {
+		pod := fixtures.PinToNode(fixtures.PodWithContainers(fixtures.ContainerWithResources("agent",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("100M")},
+			corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("200M")})), "node-a")
+		pod.Namespace = "team"
+		pod.Annotations = annotations
+		return pod
+	}
+
+	It("warns about sized values container limit ranges would reject", func(ctx SpecContext) {
+		result, err := New(reader, Options{LimitRangeReader: reader}).Size(ctx, sizedPod(map[string]string{
+			"node-specific-sizing.manomano.tech/request-cpu-fraction":  "0.5",
+			"node-specific-sizing.manomano.tech/limit-memory-fraction": "0.1",
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Warnings()).To(ConsistOf(
+			"LimitRange team/containers: container agent requests.cpu 2 is above the maximum of 1",
+			"LimitRange team/containers: container agent limits.memory makes the limit of 800M more than 2 times the request of 100M",
+		))
+	})
+
+	It("stays quiet when sized values fit", func(ctx SpecContext) {
+		result, err := New(reader, Options{LimitRangeReader: reader}).Size(ctx, sizedPod(map[string]string{
+			"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.2",
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Warnings()).To(BeEmpty())
+	})
+})
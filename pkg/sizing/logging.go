@@ -0,0 +1,22 @@
+package sizing
+
+import (
+	"context"
+	"go.uber.org/zap"
+)
+
+type loggerKey struct{}
+
+// ContextWithLogger returns a context carrying logger, which sizing logs with instead of the global logger. Callers
+// give it the fields of the request being handled, so that logs of concurrent admissions can be told apart.
+func ContextWithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFromContext returns the logger ctx carries, the global logger when there is none
+func LoggerFromContext(ctx context.Context) *zap.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return logger
+	}
+	return zap.L()
+}
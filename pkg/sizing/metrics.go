@@ -0,0 +1,62 @@
+package sizing
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "node_specific_sizing"
+
+var nodeResolutionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "node_resolutions_total",
+	Help:      "Pods whose node was resolved, by the resolver that could tell, or none.",
+}, []string{"resolver"})
+
+var unconfiguredPodsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "unconfigured_pods_total",
+	Help:      "Pods left alone as no sizing settings apply to them.",
+})
+
+var nodeCacheMissesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "node_cache_misses_total",
+	Help:      "Nodes missing from the cache on admission, by outcome of reading them from the API server: found or not-found.",
+}, []string{"outcome"})
+
+var nodeFallbacksTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "node_fallbacks_total",
+	Help:      "Pods given fallback requests as their node could not be resolved or read.",
+})
+
+var resultCacheRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "result_cache_requests_total",
+	Help:      "Pods created from a template looked up in the result cache, by outcome: hit or miss.",
+}, []string{"outcome"})
+
+var nodeSnapshotsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "node_snapshots_total",
+	Help:      "Node capacities looked up in snapshots, by outcome: hit, or stale when parsed again as the snapshot lagged behind.",
+}, []string{"outcome"})
+
+var vpaConflictsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "vpa_conflicts_total",
+	Help:      "Pods also sized by a VerticalPodAutoscaler, by the mode they were handled with: warn, skip or override.",
+}, []string{"mode"})
+
+var budgetsExceededTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "budgets_exceeded_total",
+	Help:      "Sizing steps that ran out of their share of the admission deadline, by step: node lookup or patching.",
+}, []string{"step"})
+
+// Collectors lists the metrics of the package. Nothing is registered on import, binaries embedding a Sizer register
+// them wherever they see fit.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{nodeResolutionsTotal, unconfiguredPodsTotal, nodeCacheMissesTotal, nodeFallbacksTotal,
+		resultCacheRequestsTotal, nodeSnapshotsTotal, vpaConflictsTotal, budgetsExceededTotal}
+}
@@ -0,0 +1,218 @@
+package sizing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"net/http"
+	"strings"
+)
+
+// hostnameLabel is the well-known node label holding the node hostname, which matches the node name on most clusters
+const hostnameLabel = "kubernetes.io/hostname"
+
+// nodeResolver tells which node a pod is bound to, from a given part of the pod spec
+type nodeResolver interface {
+	// name identifies the resolver in flags and metrics
+	name() string
+	// resolve returns an error when the resolver cannot tell
+	resolve(ctx context.Context, pod *corev1.Pod) (string, error)
+}
+
+// NodeResolverChain tries resolvers in order, the first one that can tell wins
+type NodeResolverChain []nodeResolver
+
+// defaultNodeResolvers are all built-in resolvers, in the order they are tried unless configured otherwise
+var defaultNodeResolvers = NodeResolverChain{
+	affinityMatchFieldsResolver{},
+	affinityMatchExpressionsResolver{},
+	nodeNameResolver{},
+	nodeSelectorResolver{},
+}
+
+// Resolve returns the name of the node pod is bound to. Nil chains try defaultNodeResolvers.
+func (c NodeResolverChain) Resolve(ctx context.Context, pod *corev1.Pod) (string, error) {
+	if c == nil {
+		c = defaultNodeResolvers
+	}
+	var errs []error
+	for _, resolver := range c {
+		nodeName, err := resolver.resolve(ctx, pod)
+		if err == nil {
+			nodeResolutionsTotal.WithLabelValues(resolver.name()).Inc()
+			return nodeName, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", resolver.name(), err))
+	}
+	nodeResolutionsTotal.WithLabelValues("none").Inc()
+	return "", errors.Join(errs...)
+}
+
+// ParseNodeResolvers builds a chain out of comma-separated resolver names. The external resolver is only available
+// when given an URL.
+func ParseNodeResolvers(names string, externalURL string) (NodeResolverChain, error) {
+	available := make(map[string]nodeResolver)
+	for _, resolver := range defaultNodeResolvers {
+		available[resolver.name()] = resolver
+	}
+	if externalURL != "" {
+		external := &externalResolver{url: externalURL, client: http.DefaultClient}
+		available[external.name()] = external
+	}
+
+	var chain NodeResolverChain
+	for _, name := range strings.Split(names, ",") {
+		resolver, ok := available[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown or unavailable node resolver %q", name)
+		}
+		chain = append(chain, resolver)
+	}
+	return chain, nil
+}
+
+// affinityMatchFieldsResolver reads the exact affinity the DaemonSet controller pins pods to a node with
+type affinityMatchFieldsResolver struct{}
+
+func (affinityMatchFieldsResolver) name() string { return "affinity-match-fields" }
+
+func (affinityMatchFieldsResolver) resolve(_ context.Context, pod *corev1.Pod) (string, error) {
+	err, nodeName := nodeNameFromMatchFields(pod)
+	return nodeName, err
+}
+
+func nodeNameFromMatchFields(pod *corev1.Pod) (error, string) {
+	// We're matching the following exact shape and nothing else
+	//
+	// spec:
+	//  affinity:
+	//    nodeAffinity:
+	//      requiredDuringSchedulingIgnoredDuringExecution:
+	//        nodeSelectorTerms:
+	//        - matchFields:
+	//          - key: metadata.name
+	//            operator: In
+	//            values:
+	//            - k3d-knss-server-0
+
+	if pod.Spec.Affinity == nil {
+		return fmt.Errorf("pod does not have affinity"), ""
+	}
+
+	if pod.Spec.Affinity.NodeAffinity == nil {
+		return fmt.Errorf("pod does not have affinity.NodeAffinity"), ""
+	}
+
+	if pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return fmt.Errorf("pod does not have affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution"), ""
+	}
+
+	if len(pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms) == 0 {
+		return fmt.Errorf("pod has no terms affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms"), ""
+	}
+
+	for _, term := range pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		for _, mf := range term.MatchFields {
+			if mf.Key == "metadata.name" && mf.Operator == corev1.NodeSelectorOpIn {
+				if len(mf.Values) == 1 {
+					return nil, mf.Values[0]
+				} else {
+					return fmt.Errorf("pod lists %d nodes in its metadata.name field", len(mf.Values)), ""
+				}
+			}
+		}
+	}
+
+	return fmt.Errorf("no appropriate matchfield for node name extraction"), ""
+}
+
+// affinityMatchExpressionsResolver reads a required node affinity on the hostname label
+type affinityMatchExpressionsResolver struct{}
+
+func (affinityMatchExpressionsResolver) name() string { return "affinity-match-expressions" }
+
+func (affinityMatchExpressionsResolver) resolve(_ context.Context, pod *corev1.Pod) (string, error) {
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil ||
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return "", fmt.Errorf("pod does not have a required node affinity")
+	}
+
+	// Terms are ORed, so they can only tell the node when there's a single one
+	terms := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) != 1 {
+		return "", fmt.Errorf("pod has %d node selector terms, expected exactly one", len(terms))
+	}
+	for _, expr := range terms[0].MatchExpressions {
+		if expr.Key == hostnameLabel && expr.Operator == corev1.NodeSelectorOpIn && len(expr.Values) == 1 {
+			return expr.Values[0], nil
+		}
+	}
+	return "", fmt.Errorf("no match expression on %s with a single value", hostnameLabel)
+}
+
+// nodeNameResolver reads pods that are already bound, e.g. by bypassing the scheduler
+type nodeNameResolver struct{}
+
+func (nodeNameResolver) name() string { return "node-name" }
+
+func (nodeNameResolver) resolve(_ context.Context, pod *corev1.Pod) (string, error) {
+	if pod.Spec.NodeName == "" {
+		return "", fmt.Errorf("pod does not have spec.nodeName")
+	}
+	return pod.Spec.NodeName, nil
+}
+
+// nodeSelectorResolver reads a node selector on the hostname label
+type nodeSelectorResolver struct{}
+
+func (nodeSelectorResolver) name() string { return "node-selector" }
+
+func (nodeSelectorResolver) resolve(_ context.Context, pod *corev1.Pod) (string, error) {
+	if hostname, ok := pod.Spec.NodeSelector[hostnameLabel]; ok && hostname != "" {
+		return hostname, nil
+	}
+	return "", fmt.Errorf("pod does not have a node selector on %s", hostnameLabel)
+}
+
+// externalResolver asks an HTTP service, which receives the pod as JSON and answers {"nodeName": "..."}
+type externalResolver struct {
+	url    string
+	client *http.Client
+}
+
+func (*externalResolver) name() string { return "external" }
+
+func (er *externalResolver) resolve(ctx context.Context, pod *corev1.Pod) (string, error) {
+	body, err := json.Marshal(pod)
+	if err != nil {
+		return "", fmt.Errorf("could not encode pod: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, er.url, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := er.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not reach external resolver: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("external resolver answered %s", resp.Status)
+	}
+
+	var answer struct {
+		NodeName string `json:"nodeName"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return "", fmt.Errorf("could not decode external resolver answer: %w", err)
+	}
+	if answer.NodeName == "" {
+		return "", fmt.Errorf("external resolver could not tell")
+	}
+	return answer.NodeName, nil
+}
@@ -0,0 +1,78 @@
+package sizing
+
+import (
+	"encoding/json"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("Resolving nodes", Label("NodeResolver"), func() {
+	It("reads the DaemonSet affinity first", func(ctx SpecContext) {
+		pod := fixtures.PinToNode(fixtures.PodWithContainers(), "node-a")
+		pod.Spec.NodeSelector = map[string]string{hostnameLabel: "node-b"}
+		before := testutil.ToFloat64(nodeResolutionsTotal.WithLabelValues("affinity-match-fields"))
+		Expect(defaultNodeResolvers.Resolve(ctx, pod)).To(Equal("node-a"))
+		Expect(testutil.ToFloat64(nodeResolutionsTotal.WithLabelValues("affinity-match-fields"))).To(Equal(before + 1))
+	})
+
+	It("reads hostname match expressions", func(ctx SpecContext) {
+		pod := fixtures.PodWithContainers()
+		pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+				MatchExpressions: []corev1.NodeSelectorRequirement{{Key: hostnameLabel, Operator: corev1.NodeSelectorOpIn, Values: []string{"node-a"}}},
+			}}},
+		}}
+		Expect(defaultNodeResolvers.Resolve(ctx, pod)).To(Equal("node-a"))
+	})
+
+	It("reads bound pods and node selectors", func(ctx SpecContext) {
+		pod := fixtures.PodWithContainers()
+		pod.Spec.NodeSelector = map[string]string{hostnameLabel: "node-b"}
+		Expect(defaultNodeResolvers.Resolve(ctx, pod)).To(Equal("node-b"))
+		pod.Spec.NodeName = "node-a"
+		Expect(defaultNodeResolvers.Resolve(ctx, pod)).To(Equal("node-a"))
+	})
+
+	It("explains why no resolver could tell", func(ctx SpecContext) {
+		before := testutil.ToFloat64(nodeResolutionsTotal.WithLabelValues("none"))
+		_, err := defaultNodeResolvers.Resolve(ctx, fixtures.PodWithContainers())
+		Expect(err).To(MatchError(ContainSubstring("node-selector: pod does not have a node selector")))
+		Expect(testutil.ToFloat64(nodeResolutionsTotal.WithLabelValues("none"))).To(Equal(before + 1))
+	})
+
+	It("can be configured", func(ctx SpecContext) {
+		chain, err := ParseNodeResolvers("node-selector, node-name", "")
+		Expect(err).NotTo(HaveOccurred())
+		pod := fixtures.PodWithContainers()
+		pod.Spec.NodeName = "node-a"
+		pod.Spec.NodeSelector = map[string]string{hostnameLabel: "node-b"}
+		Expect(chain.Resolve(ctx, pod)).To(Equal("node-b"))
+
+		_, err = ParseNodeResolvers("external", "")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("can ask an external service", func(ctx SpecContext) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var pod corev1.Pod
+			Expect(json.NewDecoder(r.Body).Decode(&pod)).To(Succeed())
+			_ = json.NewEncoder(w).Encode(map[string]string{"nodeName": pod.Labels["node"]})
+		}))
+		DeferCleanup(server.Close)
+
+		chain, err := ParseNodeResolvers("external", server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		pod := fixtures.PodWithContainers()
+		pod.Labels = map[string]string{"node": "node-a"}
+		Expect(chain.Resolve(ctx, pod)).To(Equal("node-a"))
+
+		pod.Labels = nil
+		_, err = chain.Resolve(ctx, pod)
+		Expect(err).To(MatchError(ContainSubstring("external resolver could not tell")))
+	})
+})
@@ -0,0 +1,93 @@
+package sizing
+
+import (
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	corev1 "k8s.io/api/core/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	"math/big"
+	"sync"
+)
+
+// nodeCapacity holds the capacity of a node as exact values, which fractions are applied to
+type nodeCapacity map[corev1.ResourceName]*big.Rat
+
+func parseCapacity(capacity corev1.ResourceList) nodeCapacity {
+	parsed := make(nodeCapacity, len(capacity))
+	for name, quantity := range capacity {
+		parsed[name] = rps.QuantityRat(quantity)
+	}
+	return parsed
+}
+
+// NodeSnapshots keeps the capacity of every node parsed, as nodes are added or updated, so that admissions do not
+// parse it again. Register it as a handler of Node events. It is safe for concurrent use.
+type NodeSnapshots struct {
+	lock  sync.RWMutex
+	nodes map[string]nodeSnapshot
+}
+
+type nodeSnapshot struct {
+	resourceVersion string
+	capacity        nodeCapacity
+}
+
+var _ toolscache.ResourceEventHandler = &NodeSnapshots{}
+
+func NewNodeSnapshots() *NodeSnapshots {
+	return &NodeSnapshots{nodes: make(map[string]nodeSnapshot)}
+}
+
+func (s *NodeSnapshots) OnAdd(obj interface{}, _ bool) {
+	if node, ok := obj.(*corev1.Node); ok {
+		s.store(node)
+	}
+}
+
+func (s *NodeSnapshots) OnUpdate(_, newObj interface{}) {
+	if node, ok := newObj.(*corev1.Node); ok {
+		s.store(node)
+	}
+}
+
+func (s *NodeSnapshots) OnDelete(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if node, ok := obj.(*corev1.Node); ok {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		delete(s.nodes, node.Name)
+	}
+}
+
+// store takes a snapshot of node. Nodes without a capacity, e.g. those a cache transform trimmed, are left out so
+// that the capacity of nodes read in full from elsewhere is parsed rather than taken from them.
+func (s *NodeSnapshots) store(node *corev1.Node) {
+	if len(node.Status.Capacity) == 0 {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		delete(s.nodes, node.Name)
+		return
+	}
+	snapshot := nodeSnapshot{resourceVersion: node.ResourceVersion, capacity: parseCapacity(node.Status.Capacity)}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.nodes[node.Name] = snapshot
+}
+
+// capacity returns the parsed capacity of node, from its snapshot when taken from the same version. Events may lag
+// behind the cache sizing reads nodes from, the capacity is then parsed again. A nil NodeSnapshots always parses it.
+// Callers must not change what is returned.
+func (s *NodeSnapshots) capacity(node *corev1.Node) nodeCapacity {
+	if s != nil && node.ResourceVersion != "" {
+		s.lock.RLock()
+		snapshot, ok := s.nodes[node.Name]
+		s.lock.RUnlock()
+		if ok && snapshot.resourceVersion == node.ResourceVersion {
+			nodeSnapshotsTotal.WithLabelValues("hit").Inc()
+			return snapshot.capacity
+		}
+		nodeSnapshotsTotal.WithLabelValues("stale").Inc()
+	}
+	return parseCapacity(node.Status.Capacity)
+}
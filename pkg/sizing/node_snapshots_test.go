@@ -0,0 +1,70 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	toolscache "k8s.io/client-go/tools/cache"
+	"math/big"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Snapshotting nodes", Label("NodeSnapshots"), func() {
+	versioned := func(cpu, version string) *corev1.Node {
+		node := fixtures.NodeWithCapacity(cpu, "8G")
+		node.Name, node.ResourceVersion = "node-a", version
+		return node
+	}
+
+	It("serves capacities parsed as nodes are updated", func() {
+		snapshots := NewNodeSnapshots()
+		snapshots.OnAdd(versioned("4", "1"), true)
+		before := testutil.ToFloat64(nodeSnapshotsTotal.WithLabelValues("hit"))
+		Expect(snapshots.capacity(versioned("4", "1"))[corev1.ResourceCPU]).To(Equal(big.NewRat(4, 1)))
+		Expect(testutil.ToFloat64(nodeSnapshotsTotal.WithLabelValues("hit"))).To(Equal(before + 1))
+
+		snapshots.OnUpdate(versioned("4", "1"), versioned("8", "2"))
+		Expect(snapshots.capacity(versioned("8", "2"))[corev1.ResourceCPU]).To(Equal(big.NewRat(8, 1)))
+	})
+
+	It("parses capacities of nodes its snapshot lags behind", func() {
+		snapshots := NewNodeSnapshots()
+		snapshots.OnAdd(versioned("4", "1"), true)
+		Expect(snapshots.capacity(versioned("8", "2"))[corev1.ResourceCPU]).To(Equal(big.NewRat(8, 1)))
+
+		snapshots.OnDelete(toolscache.DeletedFinalStateUnknown{Obj: versioned("4", "1")})
+		Expect(snapshots.nodes).To(BeEmpty())
+		Expect((*NodeSnapshots)(nil).capacity(versioned("2", "1"))[corev1.ResourceCPU]).To(Equal(big.NewRat(2, 1)))
+	})
+
+	It("leaves out nodes without a capacity, as trimmed by a cache transform", func() {
+		snapshots := NewNodeSnapshots()
+		snapshots.OnAdd(versioned("4", "1"), true)
+		trimmed := &corev1.Node{}
+		trimmed.Name, trimmed.ResourceVersion = "node-a", "2"
+		snapshots.OnUpdate(versioned("4", "1"), trimmed)
+		Expect(snapshots.nodes).To(BeEmpty())
+		Expect(snapshots.capacity(versioned("4", "2"))[corev1.ResourceCPU]).To(Equal(big.NewRat(4, 1)))
+	})
+
+	It("sizes pods from snapshots", func(ctx SpecContext) {
+		reader := fake.NewClientBuilder().WithObjects(versioned("4", "")).Build()
+		var node corev1.Node
+		Expect(reader.Get(ctx, client.ObjectKey{Name: "node-a"}, &node)).To(Succeed())
+		snapshots := NewNodeSnapshots()
+		snapshots.OnAdd(&node, true)
+		before := testutil.ToFloat64(nodeSnapshotsTotal.WithLabelValues("hit"))
+		pod := fixtures.PinToNode(fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil)), "node-a")
+		pod.Annotations = map[string]string{"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.1"}
+
+		result, err := New(reader, Options{NodeSnapshots: snapshots}).Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.patches[0].New.String()).To(Equal("400m"))
+		Expect(testutil.ToFloat64(nodeSnapshotsTotal.WithLabelValues("hit"))).To(Equal(before + 1))
+	})
+})
@@ -0,0 +1,58 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/apis/v1alpha1"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	corev1 "k8s.io/api/core/v1"
+	"slices"
+	"strings"
+)
+
+// operatingSystemOf tells the operating system of the node pod is sized for, from its kubernetes.io/os label, or else
+// from the pod itself, e.g. for the empty node of fallback requests. It is empty when neither tells.
+func operatingSystemOf(pod *corev1.Pod, node *corev1.Node) corev1.OSName {
+	if os, ok := node.Labels[corev1.LabelOSStable]; ok {
+		return corev1.OSName(os)
+	}
+	if pod.Spec.OS != nil {
+		return pod.Spec.OS.Name
+	}
+	return corev1.OSName(pod.Spec.NodeSelector[corev1.LabelOSStable])
+}
+
+// osSettingsFor returns the settings policy has for the operating system os, or nil if there are none
+func osSettingsFor(policy *v1alpha1.SizingPolicy, os corev1.OSName) *v1alpha1.OperatingSystem {
+	if policy == nil || os == "" {
+		return nil
+	}
+	i := slices.IndexFunc(policy.Spec.OperatingSystems, func(settings v1alpha1.OperatingSystem) bool { return settings.Name == os })
+	if i < 0 {
+		return nil
+	}
+	return &policy.Spec.OperatingSystems[i]
+}
+
+// osAnnotations turns the settings of an operating system into the annotations they stand for
+func osAnnotations(settings *v1alpha1.OperatingSystem) map[string]string {
+	annotations := make(map[string]string)
+	if settings == nil {
+		return annotations
+	}
+	for name, value := range settings.Settings {
+		key := name
+		if !strings.Contains(key, "/") {
+			key = AnnotationPrefix + name
+		}
+		annotations[key] = value
+	}
+	return annotations
+}
+
+// osExcludes tells whether resource is left as it is on nodes of the operating system os: hugepages on Windows nodes,
+// which have none and whose pods may not request any, and the excluded resources of settings
+func osExcludes(os corev1.OSName, settings *v1alpha1.OperatingSystem, resource corev1.ResourceName) bool {
+	if _, isHugePages := rps.HugePageSize(resource); isHugePages && os == corev1.Windows {
+		return true
+	}
+	return settings != nil && slices.Contains(settings.ExcludedResources, resource)
+}
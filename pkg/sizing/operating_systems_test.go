@@ -0,0 +1,98 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/apis/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Operating systems", Label("OperatingSystems"), func() {
+	var node *corev1.Node
+	var pod *corev1.Pod
+	var policy *v1alpha1.SizingPolicy
+	BeforeEach(func() {
+		node = fixtures.NodeWithCapacity("4", "8Gi")
+		node.Name = "node-a"
+		node.Labels = map[string]string{corev1.LabelOSStable: "windows"}
+		pod = fixtures.PinToNode(fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil)), "node-a")
+		pod.Annotations = map[string]string{
+			"node-specific-sizing.manomano.tech/request-cpu-fraction":         "0.1",
+			"node-specific-sizing.manomano.tech/limit-hugepages-2Mi-fraction": "0.25",
+		}
+		policy = &v1alpha1.SizingPolicy{ObjectMeta: metav1.ObjectMeta{Name: "agents"}}
+	})
+
+	size := func(ctx SpecContext) (*Result, error) {
+		sizer := &Sizer{nodeReader: fake.NewClientBuilder().WithObjects(node).Build(), policyReader: policyReaderWith(policy)}
+		return sizer.Size(ctx, pod)
+	}
+	resources := func(result *Result) []corev1.ResourceName {
+		var names []corev1.ResourceName
+		for patch := range result.Patches() {
+			names = append(names, patch.Resource)
+		}
+		return names
+	}
+
+	It("never sizes hugepages on Windows nodes", func(ctx SpecContext) {
+		node.Status.Capacity["hugepages-2Mi"] = resource.MustParse("1Gi")
+		result, err := size(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resources(result)).To(ConsistOf(corev1.ResourceCPU))
+
+		node.Labels[corev1.LabelOSStable] = "linux"
+		result, err = size(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resources(result)).To(ContainElement(corev1.ResourceName("hugepages-2Mi")))
+	})
+
+	It("leaves pods alone on the operating systems their policy excludes", func(ctx SpecContext) {
+		policy.Spec.OperatingSystems = []v1alpha1.OperatingSystem{{Name: corev1.Linux, Exclude: true}, {Name: corev1.Windows}}
+		result, err := size(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Unconfigured()).To(BeFalse())
+
+		node.Labels[corev1.LabelOSStable] = "linux"
+		result, err = size(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Unconfigured()).To(BeTrue())
+	})
+
+	It("tells the operating system from the pod when the node has no label", func() {
+		pod.Spec.OS = &corev1.PodOS{Name: corev1.Windows}
+		Expect(operatingSystemOf(pod, &corev1.Node{})).To(Equal(corev1.Windows))
+		pod.Spec.OS = nil
+		pod.Spec.NodeSelector = map[string]string{corev1.LabelOSStable: "linux"}
+		Expect(operatingSystemOf(pod, &corev1.Node{})).To(Equal(corev1.Linux))
+	})
+
+	It("applies settings and exclusions of the operating system", func(ctx SpecContext) {
+		pod.Annotations = map[string]string{"node-specific-sizing.manomano.tech/request-memory-fraction": "0.25"}
+		policy.Spec.FractionSets = []v1alpha1.FractionSet{{Fractions: v1alpha1.Fractions{RequestCPU: "0.1"}}}
+		policy.Spec.OperatingSystems = []v1alpha1.OperatingSystem{{
+			Name:              corev1.Windows,
+			ExcludedResources: []corev1.ResourceName{corev1.ResourceMemory},
+			Settings:          map[string]string{"request-cpu-fraction": "0.2"},
+		}}
+		result, err := size(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.patches).To(HaveLen(1))
+		Expect(result.patches[0].Resource).To(Equal(corev1.ResourceCPU))
+		Expect(result.patches[0].New.String()).To(Equal("800m"))
+	})
+
+	It("does not resize Windows pods in place", func(ctx SpecContext) {
+		pod.Annotations[ResizePolicyAnnotation] = "cpu=NotRequired"
+		policy.Spec.OnDrift = v1alpha1.DriftActionResize
+		result, err := size(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.resizePolicies).To(BeEmpty())
+		Expect(result.DriftAction()).To(Equal(v1alpha1.DriftActionReport))
+	})
+})
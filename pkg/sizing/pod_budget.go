@@ -0,0 +1,74 @@
+package sizing
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"strconv"
+	"strings"
+)
+
+// ExposeBudgetAnnotation, set to "true", has the pod budget written in BudgetAnnotation and BudgetLimitAnnotation
+const ExposeBudgetAnnotation = AnnotationPrefix + "expose-budget"
+
+const (
+	budgetAnnotationPrefix      = AnnotationPrefix + "budget-"
+	budgetLimitAnnotationPrefix = AnnotationPrefix + "budget-limit-"
+)
+
+// exposeBudgetFromAnnotations reads ExposeBudgetAnnotation, false when unset
+func exposeBudgetFromAnnotations(annotations map[string]string) (bool, error) {
+	value, ok := annotations[ExposeBudgetAnnotation]
+	if !ok {
+		return false, nil
+	}
+	expose, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s is not a boolean: %q", ExposeBudgetAnnotation, value)
+	}
+	return expose, nil
+}
+
+// BudgetAnnotation returns the annotation holding the requests of a sized resource the pod got in total, e.g.
+// node-specific-sizing.manomano.tech/budget-cpu, for applications to read their budget through the Downward API and
+// tune thread pools or caches
+func BudgetAnnotation(name corev1.ResourceName) string {
+	return budgetAnnotationPrefix + string(name)
+}
+
+// BudgetLimitAnnotation returns the annotation holding the limits of a sized resource the pod got in total, e.g.
+// node-specific-sizing.manomano.tech/budget-limit-memory, only written when every sized container has one
+func BudgetLimitAnnotation(name corev1.ResourceName) string {
+	return budgetLimitAnnotationPrefix + string(name)
+}
+
+// budgetAnnotations totals the final resources of long-running containers, excluded ones included, for every resource
+// sizing patched. Resources whose name does not fit in an annotation key, e.g. example.com/gpu, are left out.
+func budgetAnnotations(result *Result) map[string]string {
+	sized := make(map[corev1.ResourceName]bool)
+	for patch := range result.Patches() {
+		sized[patch.Resource] = true
+	}
+	annotations := make(map[string]string)
+	reports := result.containerReports()
+	for name := range sized {
+		if strings.Contains(string(name), "/") {
+			continue
+		}
+		var requests, limits resource.Quantity
+		limited := true
+		for _, report := range reports {
+			if quantity, ok := report.Final.Requests[name]; ok {
+				requests.Add(quantity)
+			}
+			quantity, ok := report.Final.Limits[name]
+			limited = limited && ok
+			limits.Add(quantity)
+		}
+		annotations[BudgetAnnotation(name)] = requests.String()
+		if limited {
+			annotations[BudgetLimitAnnotation(name)] = limits.String()
+		}
+	}
+	return annotations
+}
@@ -0,0 +1,70 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gomodules.xyz/jsonpatch/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Exposing the pod budget", Label("PodBudget"), func() {
+	node := fixtures.NodeWithCapacity("4", "8Gi")
+	node.Name = "node-a"
+	sizer := &Sizer{nodeReader: fake.NewClientBuilder().WithObjects(node).Build()}
+
+	var pod *corev1.Pod
+	BeforeEach(func() {
+		resources := func(cpu string) corev1.ResourceList {
+			return corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse("100Mi")}
+		}
+		pod = fixtures.PinToNode(fixtures.PodWithContainers(
+			fixtures.ContainerWithResources("app", resources("300m"), corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("200Mi")}),
+			fixtures.ContainerWithResources("sidecar", resources("100m"), corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("200Mi")}),
+			fixtures.ContainerWithResources("proxy", resources("250m"), corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("100Mi")}),
+		), "node-a")
+		pod.Annotations = map[string]string{
+			"node-specific-sizing.manomano.tech/request-cpu-fraction":  "0.5",
+			"node-specific-sizing.manomano.tech/limit-memory-fraction": "0.25",
+			"node-specific-sizing.manomano.tech/exclude-containers":    "proxy",
+			"node-specific-sizing.manomano.tech/rounding-cpu":          "10m",
+			ExposeBudgetAnnotation:                                     "true",
+		}
+	})
+
+	budgets := func(patch []jsonpatch.JsonPatchOperation) map[string]any {
+		result := make(map[string]any)
+		for _, op := range patch {
+			for _, key := range []string{BudgetAnnotation(corev1.ResourceCPU), BudgetAnnotation(corev1.ResourceMemory),
+				BudgetLimitAnnotation(corev1.ResourceCPU), BudgetLimitAnnotation(corev1.ResourceMemory)} {
+				if op.Path == AnnotationJsonPath(key) {
+					result[key] = op.Value
+				}
+			}
+		}
+		return result
+	}
+
+	It("writes what the pod got in total of every sized resource, excluded containers included", func(ctx SpecContext) {
+		_, patch, err := sizer.CreatePatch(ctx, pod, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(budgets(patch)).To(Equal(map[string]any{
+			BudgetAnnotation(corev1.ResourceCPU):         "1990m",
+			BudgetAnnotation(corev1.ResourceMemory):      "300Mi",
+			BudgetLimitAnnotation(corev1.ResourceMemory): "2Gi",
+		}))
+	})
+
+	It("writes nothing unless asked to", func(ctx SpecContext) {
+		delete(pod.Annotations, ExposeBudgetAnnotation)
+		_, patch, err := sizer.CreatePatch(ctx, pod, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(budgets(patch)).To(BeEmpty())
+
+		pod.Annotations[ExposeBudgetAnnotation] = "yes"
+		_, _, err = sizer.CreatePatch(ctx, pod, false)
+		Expect(err).To(MatchError(ContainSubstring("is not a boolean")))
+	})
+})
@@ -0,0 +1,174 @@
+package sizing
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/apis/v1alpha1"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+)
+
+// resolvePolicy returns the policy that applies to a pod, or nil if there is none.
+// When several policies select the pod, the first one by name wins.
+func resolvePolicy(ctx context.Context, policyReader client.Reader, pod *corev1.Pod) (*v1alpha1.SizingPolicy, error) {
+	if policyReader == nil {
+		return nil, nil
+	}
+
+	var policies v1alpha1.SizingPolicyList
+	if err := policyReader.List(ctx, &policies); err != nil {
+		return nil, fmt.Errorf("problem fetching sizing policies: %w", err)
+	}
+
+	slices.SortFunc(policies.Items, func(a, b v1alpha1.SizingPolicy) int { return cmp.Compare(a.Name, b.Name) })
+
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.PodSelector)
+		if err != nil {
+			return nil, fmt.Errorf("sizing policy %s has an invalid pod selector: %w", policy.Name, err)
+		}
+		if selector.Matches(labels.Set(pod.Labels)) && ownedByAny(pod, policy.Spec.Owners) {
+			return policy, nil
+		}
+	}
+	return nil, nil
+}
+
+// ownedByAny returns whether the controller of pod matches any of the selectors, or whether there are none
+func ownedByAny(pod *corev1.Pod, selectors []v1alpha1.OwnerSelector) bool {
+	if len(selectors) == 0 {
+		return true
+	}
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return false
+	}
+	gv, err := schema.ParseGroupVersion(owner.APIVersion)
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(selectors, func(selector v1alpha1.OwnerSelector) bool {
+		return (selector.APIGroup == "" || selector.APIGroup == gv.Group) &&
+			(selector.Kind == "" || selector.Kind == owner.Kind) &&
+			(len(selector.Names) == 0 || slices.Contains(selector.Names, owner.Name))
+	})
+}
+
+// fractionSetFor returns the first fraction set of policy selecting node, or nil if there is none
+func fractionSetFor(policy *v1alpha1.SizingPolicy, node *corev1.Node) (*v1alpha1.FractionSet, error) {
+	if policy == nil {
+		return nil, nil
+	}
+	for i := range policy.Spec.FractionSets {
+		set := &policy.Spec.FractionSets[i]
+		selector, err := metav1.LabelSelectorAsSelector(&set.NodeSelector)
+		if err != nil {
+			return nil, fmt.Errorf("sizing policy %s has an invalid node selector: %w", policy.Name, err)
+		}
+		if selector.Matches(labels.Set(node.Labels)) && hasTaints(node, set.NodeTaints) {
+			return set, nil
+		}
+	}
+	return nil, nil
+}
+
+// policySelectsNode returns whether policy applies to pods on node, pods on other nodes being left alone
+func policySelectsNode(policy *v1alpha1.SizingPolicy, node *corev1.Node) (bool, error) {
+	if policy == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.NodeSelector)
+	if err != nil {
+		return false, fmt.Errorf("sizing policy %s has an invalid node selector: %w", policy.Name, err)
+	}
+	return selector.Matches(labels.Set(node.Labels)) && hasTaints(node, policy.Spec.NodeTaints), nil
+}
+
+// hasTaints returns whether node carries a taint matching every selector
+func hasTaints(node *corev1.Node, selectors []v1alpha1.TaintSelector) bool {
+	for _, selector := range selectors {
+		matches := slices.ContainsFunc(node.Spec.Taints, func(taint corev1.Taint) bool {
+			return taint.Key == selector.Key &&
+				(selector.Value == "" || taint.Value == selector.Value) &&
+				(selector.Effect == "" || taint.Effect == selector.Effect)
+		})
+		if !matches {
+			return false
+		}
+	}
+	return true
+}
+
+// fractionAnnotations turns a fraction set into the annotations it stands for
+func fractionAnnotations(set *v1alpha1.FractionSet) map[string]string {
+	annotations := make(map[string]string)
+	if set == nil {
+		return annotations
+	}
+	for key, value := range map[string]string{
+		AnnotationPrefix + "request-cpu-fraction":    set.Fractions.RequestCPU,
+		AnnotationPrefix + "limit-cpu-fraction":      set.Fractions.LimitCPU,
+		AnnotationPrefix + "request-memory-fraction": set.Fractions.RequestMemory,
+		AnnotationPrefix + "limit-memory-fraction":   set.Fractions.LimitMemory,
+	} {
+		if value != "" {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
+
+// policyAnnotations turns the settings of a policy, with the fraction set selecting the node if any, into the
+// annotations they stand for
+func policyAnnotations(policy *v1alpha1.SizingPolicy, set *v1alpha1.FractionSet) map[string]string {
+	annotations := fractionAnnotations(set)
+	for key, value := range map[string]string{
+		AnnotationPrefix + "rounding-cpu":    policy.Spec.Rounding.CPU,
+		AnnotationPrefix + "rounding-memory": policy.Spec.Rounding.Memory,
+	} {
+		if value != "" {
+			annotations[key] = value
+		}
+	}
+	if policy.Spec.AllowOvercommit {
+		annotations[rps.AllowOvercommitAnnotation] = "true"
+	}
+	return annotations
+}
+
+// statusSettings tells how to write the status annotation
+type statusSettings struct {
+	key       string
+	verbosity v1alpha1.StatusVerbosity
+}
+
+var defaultStatusSettings = statusSettings{key: StatusAnnotation, verbosity: v1alpha1.StatusVerbositySummary}
+
+// driftActionFor tells what to do with pods whose sizing is outdated, DriftActionReport unless policy says otherwise
+func driftActionFor(policy *v1alpha1.SizingPolicy) v1alpha1.DriftAction {
+	if policy == nil || policy.Spec.OnDrift == "" {
+		return v1alpha1.DriftActionReport
+	}
+	return policy.Spec.OnDrift
+}
+
+func statusSettingsFor(policy *v1alpha1.SizingPolicy) statusSettings {
+	settings := defaultStatusSettings
+	if policy == nil {
+		return settings
+	}
+	if policy.Spec.StatusAnnotation.Key != "" {
+		settings.key = policy.Spec.StatusAnnotation.Key
+	}
+	if policy.Spec.StatusAnnotation.Verbosity != "" {
+		settings.verbosity = policy.Spec.StatusAnnotation.Verbosity
+	}
+	return settings
+}
@@ -0,0 +1,193 @@
+package sizing
+
+import (
+	"encoding/json"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/apis/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"slices"
+)
+
+func policyReaderWith(policies ...client.Object) client.Reader {
+	scheme := runtime.NewScheme()
+	Expect(v1alpha1.AddToScheme(scheme)).To(Succeed())
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(policies...).Build()
+}
+
+var _ = Describe("Sizing policies", Label("Policy"), func() {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "agent"}}}
+
+	It("applies no policy when policies are unavailable", func(ctx SpecContext) {
+		Expect(resolvePolicy(ctx, nil, pod)).To(BeNil())
+		Expect(statusSettingsFor(nil)).To(Equal(defaultStatusSettings))
+	})
+
+	It("picks the first matching policy by name", func(ctx SpecContext) {
+		reader := policyReaderWith(
+			&v1alpha1.SizingPolicy{ObjectMeta: metav1.ObjectMeta{Name: "c-catch-all"}},
+			&v1alpha1.SizingPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "b-agents"},
+				Spec:       v1alpha1.SizingPolicySpec{PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "agent"}}},
+			},
+			&v1alpha1.SizingPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "a-other"},
+				Spec:       v1alpha1.SizingPolicySpec{PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "other"}}},
+			},
+		)
+
+		policy, err := resolvePolicy(ctx, reader, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policy.Name).To(Equal("b-agents"))
+	})
+
+	It("can be scoped to owners", func(ctx SpecContext) {
+		exporter := pod.DeepCopy()
+		exporter.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: "apps/v1",
+			Kind:       "DaemonSet",
+			Name:       "node-exporter",
+			Controller: ptr.To(true),
+		}}
+		reader := policyReaderWith(
+			&v1alpha1.SizingPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "a-exporters"},
+				Spec: v1alpha1.SizingPolicySpec{Owners: []v1alpha1.OwnerSelector{
+					{APIGroup: "apps", Kind: "DaemonSet", Names: []string{"node-exporter"}},
+				}},
+			},
+			&v1alpha1.SizingPolicy{ObjectMeta: metav1.ObjectMeta{Name: "b-catch-all"}},
+		)
+
+		policy, err := resolvePolicy(ctx, reader, exporter)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policy.Name).To(Equal("a-exporters"))
+
+		policy, err = resolvePolicy(ctx, reader, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policy.Name).To(Equal("b-catch-all"))
+
+		exporter.OwnerReferences[0].APIVersion = "example.com/v1"
+		Expect(ownedByAny(exporter, []v1alpha1.OwnerSelector{{APIGroup: "apps"}})).To(BeFalse())
+		Expect(ownedByAny(exporter, []v1alpha1.OwnerSelector{{Kind: "DaemonSet"}})).To(BeTrue())
+	})
+
+	Describe("fraction sets", func() {
+		dedicated := v1alpha1.FractionSet{
+			NodeTaints: []v1alpha1.TaintSelector{{Key: "dedicated", Value: "ingress"}},
+			Fractions:  v1alpha1.Fractions{RequestCPU: "0.5"},
+		}
+		large := v1alpha1.FractionSet{
+			NodeSelector: metav1.LabelSelector{MatchLabels: map[string]string{"size": "large"}},
+			Fractions:    v1alpha1.Fractions{RequestCPU: "0.1", RequestMemory: "0.2"},
+		}
+		policy := &v1alpha1.SizingPolicy{Spec: v1alpha1.SizingPolicySpec{FractionSets: []v1alpha1.FractionSet{dedicated, large}}}
+
+		node := func(labels map[string]string, taints ...corev1.Taint) *corev1.Node {
+			return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: labels}, Spec: corev1.NodeSpec{Taints: taints}}
+		}
+
+		It("selects nodes by taint", func() {
+			set, err := fractionSetFor(policy, node(map[string]string{"size": "large"},
+				corev1.Taint{Key: "dedicated", Value: "ingress", Effect: corev1.TaintEffectNoSchedule}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(set.Fractions.RequestCPU).To(Equal("0.5"))
+		})
+
+		It("selects nodes by label", func() {
+			set, err := fractionSetFor(policy, node(map[string]string{"size": "large"},
+				corev1.Taint{Key: "dedicated", Value: "batch", Effect: corev1.TaintEffectNoSchedule}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(set.Fractions.RequestCPU).To(Equal("0.1"))
+		})
+
+		It("matches taint effects when set", func() {
+			Expect(hasTaints(node(nil, corev1.Taint{Key: "dedicated", Effect: corev1.TaintEffectNoExecute}),
+				[]v1alpha1.TaintSelector{{Key: "dedicated", Effect: corev1.TaintEffectNoSchedule}})).To(BeFalse())
+		})
+
+		It("selects nothing on other nodes", func() {
+			set, err := fractionSetFor(policy, node(nil))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(set).To(BeNil())
+		})
+
+		It("stands for fraction annotations", func() {
+			Expect(fractionAnnotations(&large)).To(Equal(map[string]string{
+				"node-specific-sizing.manomano.tech/request-cpu-fraction":    "0.1",
+				"node-specific-sizing.manomano.tech/request-memory-fraction": "0.2",
+			}))
+		})
+	})
+
+	It("leaves pods alone on the nodes it does not select, whatever their annotations", func(ctx SpecContext) {
+		node := fixtures.NodeWithCapacity("4", "8Gi")
+		node.Name = "node-a"
+		sized := fixtures.PinToNode(fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil)), "node-a")
+		sized.Annotations = map[string]string{"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.1"}
+		policy := &v1alpha1.SizingPolicy{ObjectMeta: metav1.ObjectMeta{Name: "logging"}, Spec: v1alpha1.SizingPolicySpec{
+			NodeSelector: metav1.LabelSelector{MatchLabels: map[string]string{"node-role.kubernetes.io/logging": "true"}},
+			NodeTaints:   []v1alpha1.TaintSelector{{Key: "dedicated", Value: "logging"}},
+		}}
+		size := func() *Result {
+			sizer := &Sizer{nodeReader: fake.NewClientBuilder().WithObjects(node).Build(), policyReader: policyReaderWith(policy)}
+			result, err := sizer.Size(ctx, sized)
+			Expect(err).NotTo(HaveOccurred())
+			return result
+		}
+		Expect(size().Unconfigured()).To(BeTrue())
+
+		node.Labels = map[string]string{"node-role.kubernetes.io/logging": "true"}
+		Expect(size().Unconfigured()).To(BeTrue())
+
+		node.Spec.Taints = []corev1.Taint{{Key: "dedicated", Value: "logging", Effect: corev1.TaintEffectNoSchedule}}
+		Expect(slices.Collect(size().Patches())).To(ContainElement(HaveField("New", resource.MustParse("400m"))))
+	})
+
+	It("stands for rounding annotations", func() {
+		policy := &v1alpha1.SizingPolicy{Spec: v1alpha1.SizingPolicySpec{Rounding: v1alpha1.Rounding{Memory: "1Mi"}}}
+		Expect(policyAnnotations(policy, nil)).To(Equal(map[string]string{
+			"node-specific-sizing.manomano.tech/rounding-memory": "1Mi",
+		}))
+	})
+
+	It("stands for the overcommit annotation", func() {
+		policy := &v1alpha1.SizingPolicy{Spec: v1alpha1.SizingPolicySpec{AllowOvercommit: true}}
+		Expect(policyAnnotations(policy, nil)).To(Equal(map[string]string{
+			"node-specific-sizing.manomano.tech/allow-overcommit": "true",
+		}))
+	})
+
+	Describe("status annotation", func() {
+		result := &Result{nodeName: "node-a", trace: &decisionTrace{}}
+
+		It("can be turned off", func(ctx SpecContext) {
+			result.status = statusSettingsFor(&v1alpha1.SizingPolicy{Spec: v1alpha1.SizingPolicySpec{
+				StatusAnnotation: v1alpha1.StatusAnnotationSpec{Verbosity: v1alpha1.StatusVerbosityNone},
+			}})
+			Expect(renderAnnotations(ctx, result)).To(BeEmpty())
+		})
+
+		It("can use another key and hold the full decision", func(ctx SpecContext) {
+			result.status = statusSettingsFor(&v1alpha1.SizingPolicy{Spec: v1alpha1.SizingPolicySpec{
+				StatusAnnotation: v1alpha1.StatusAnnotationSpec{Key: "example.com/sizing", Verbosity: v1alpha1.StatusVerbosityFull},
+			}})
+			patch := renderAnnotations(ctx, result)
+			Expect(patch).To(HaveLen(3))
+			Expect(patch[2].Path).To(Equal("/metadata/annotations/example.com~1sizing"))
+
+			var status sizingReport
+			Expect(json.Unmarshal([]byte(patch[2].Value.(string)), &status)).To(Succeed())
+			Expect(status.Node).To(Equal("node-a"))
+			Expect(status.Trace).NotTo(BeNil())
+		})
+	})
+})
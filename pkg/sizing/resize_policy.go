@@ -0,0 +1,66 @@
+package sizing
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"slices"
+	"strings"
+)
+
+// ResizePolicyAnnotation sets the resize policy of sized containers, e.g. "cpu=NotRequired,memory=RestartContainer",
+// telling the kubelet whether they restart when resized in place. Resize policies cannot change once pods exist, only
+// pods being created get them.
+const ResizePolicyAnnotation = AnnotationPrefix + "resize-policy"
+
+// resizePoliciesFromAnnotations returns the resize policies of ResizePolicyAnnotation sorted by resource, or nil
+// without it
+func resizePoliciesFromAnnotations(annotations map[string]string) ([]corev1.ContainerResizePolicy, error) {
+	value, ok := annotations[ResizePolicyAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var policies []corev1.ContainerResizePolicy
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, policy, ok := strings.Cut(pair, "=")
+		resource := corev1.ResourceName(strings.TrimSpace(name))
+		restart := corev1.ResourceResizeRestartPolicy(strings.TrimSpace(policy))
+		if !ok {
+			return nil, fmt.Errorf("%s: %q is not a resource=policy pair", ResizePolicyAnnotation, pair)
+		}
+		if resource != corev1.ResourceCPU && resource != corev1.ResourceMemory {
+			return nil, fmt.Errorf("%s: unknown resource %q, expected cpu or memory", ResizePolicyAnnotation, resource)
+		}
+		if restart != corev1.NotRequired && restart != corev1.RestartContainer {
+			return nil, fmt.Errorf("%s: unknown policy %q for %s, expected NotRequired or RestartContainer",
+				ResizePolicyAnnotation, restart, resource)
+		}
+		if slices.ContainsFunc(policies, func(p corev1.ContainerResizePolicy) bool { return p.ResourceName == resource }) {
+			return nil, fmt.Errorf("%s: %s is given more than once", ResizePolicyAnnotation, resource)
+		}
+		policies = append(policies, corev1.ContainerResizePolicy{ResourceName: resource, RestartPolicy: restart})
+	}
+	if len(policies) == 0 {
+		return nil, fmt.Errorf("%s cannot be empty", ResizePolicyAnnotation)
+	}
+	slices.SortFunc(policies, func(a, b corev1.ContainerResizePolicy) int {
+		return strings.Compare(string(a.ResourceName), string(b.ResourceName))
+	})
+	return policies, nil
+}
+
+// withResizePolicies returns the resize policies of ctn with policies taking precedence, and whether they changed
+func withResizePolicies(ctn *corev1.Container, policies []corev1.ContainerResizePolicy) ([]corev1.ContainerResizePolicy, bool) {
+	result := slices.Clone(ctn.ResizePolicy)
+	for _, policy := range policies {
+		i := slices.IndexFunc(result, func(p corev1.ContainerResizePolicy) bool { return p.ResourceName == policy.ResourceName })
+		if i < 0 {
+			result = append(result, policy)
+		} else {
+			result[i] = policy
+		}
+	}
+	return result, !slices.Equal(result, ctn.ResizePolicy)
+}
@@ -0,0 +1,66 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gomodules.xyz/jsonpatch/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"slices"
+	"strings"
+)
+
+var _ = Describe("Container resize policies", Label("ResizePolicy"), func() {
+	node := fixtures.NodeWithCapacity("4", "8G")
+	node.Name = "node-a"
+	sizer := &Sizer{nodeReader: fake.NewClientBuilder().WithObjects(node).Build()}
+
+	var pod *corev1.Pod
+	BeforeEach(func() {
+		sized := fixtures.ContainerWithResources("app", corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("300m")}, nil)
+		sized.ResizePolicy = []corev1.ContainerResizePolicy{{ResourceName: corev1.ResourceCPU, RestartPolicy: corev1.RestartContainer}}
+		pod = fixtures.PinToNode(fixtures.PodWithContainers(sized, corev1.Container{Name: "excluded"}), "node-a")
+		pod.Annotations = map[string]string{
+			"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.1",
+			ExcludeContainersAnnotation:                               "excluded",
+			ResizePolicyAnnotation:                                    "memory=RestartContainer, cpu=NotRequired",
+		}
+	})
+
+	resizePolicyOps := func(patch []jsonpatch.JsonPatchOperation) []jsonpatch.JsonPatchOperation {
+		return slices.DeleteFunc(patch, func(op jsonpatch.JsonPatchOperation) bool { return !strings.HasSuffix(op.Path, "/resizePolicy") })
+	}
+
+	It("sets the resize policies of sized containers of pods being created", func(ctx SpecContext) {
+		_, patch, err := sizer.CreatePatch(ctx, pod, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resizePolicyOps(patch)).To(Equal([]jsonpatch.JsonPatchOperation{
+			jsonpatch.NewOperation("add", "/spec/containers/0/resizePolicy", []corev1.ContainerResizePolicy{
+				{ResourceName: corev1.ResourceCPU, RestartPolicy: corev1.NotRequired},
+				{ResourceName: corev1.ResourceMemory, RestartPolicy: corev1.RestartContainer},
+			}),
+		}))
+	})
+
+	It("leaves containers already having the policies alone", func(ctx SpecContext) {
+		pod.Annotations[ResizePolicyAnnotation] = "cpu=RestartContainer"
+		_, patch, err := sizer.CreatePatch(ctx, pod, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resizePolicyOps(patch)).To(BeEmpty())
+	})
+
+	It("leaves existing pods alone, their resize policies cannot change", func(ctx SpecContext) {
+		pod.UID = "existing"
+		_, patch, err := sizer.CreatePatch(ctx, pod, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resizePolicyOps(patch)).To(BeEmpty())
+	})
+
+	It("rejects malformed policies", func() {
+		for _, value := range []string{"", "cpu", "storage=NotRequired", "cpu=Sometimes", "cpu=NotRequired,cpu=RestartContainer"} {
+			Expect(ValidateAnnotations(map[string]string{ResizePolicyAnnotation: value})).To(HaveOccurred(), value)
+		}
+	})
+})
@@ -0,0 +1,307 @@
+package sizing
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/apis/v1alpha1"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	mapset "github.com/deckarep/golang-set/v2"
+	"gomodules.xyz/jsonpatch/v2"
+	"iter"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ResourcePatch is a single container resource change decided by the sizing engine.
+// It is the structured counterpart of a JSONPatch operation, meant to be consumed by anything that needs to know
+// what sizing did without parsing JSON paths.
+type ResourcePatch struct {
+	// ContainerIndex is the position of the container among init containers for sidecars, or else among containers
+	ContainerIndex int    `json:"containerIndex"`
+	ContainerName  string `json:"container"`
+	// Sidecar tells that the container is a native sidecar, see IsSidecar
+	Sidecar  bool                 `json:"sidecar,omitempty"`
+	Property rps.ResourceProperty `json:"property"`
+	Resource corev1.ResourceName  `json:"resource"`
+	// Old is nil when the container did not set this resource property
+	Old *resource.Quantity `json:"old,omitempty"`
+	New resource.Quantity  `json:"new"`
+}
+
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// JsonPath points to the patched value within the pod
+func (p ResourcePatch) JsonPath() string {
+	return fmt.Sprintf("%s/resources/%s/%s", containerPath(p.Sidecar, p.ContainerIndex), p.Property, jsonPointerEscaper.Replace(string(p.Resource)))
+}
+
+// JsonPatch renders the patch as a JSONPatch operation. Values the container did not set are added rather than
+// replaced, as replacing a missing member is an error.
+func (p ResourcePatch) JsonPatch() jsonpatch.JsonPatchOperation {
+	op := "replace"
+	if p.Old == nil {
+		op = "add"
+	}
+	return jsonpatch.NewOperation(op, p.JsonPath(), p.New.String())
+}
+
+// missingResourceObjects lists JSONPatch operations creating the objects that patches to a container expect to
+// find, but which the container does not have.
+func missingResourceObjects(path string, ctn *corev1.Container, props mapset.Set[rps.ResourceProperty]) []jsonpatch.JsonPatchOperation {
+	var ops []jsonpatch.JsonPatchOperation
+	resourcesPath := path + "/resources"
+
+	// ResourceRequirements is not a pointer, so we can't tell an absent stanza from an empty one.
+	// Adding an empty one when there's nothing in it is harmless either way.
+	if ctn.Resources.Requests == nil && ctn.Resources.Limits == nil && ctn.Resources.Claims == nil {
+		ops = append(ops, jsonpatch.NewOperation("add", resourcesPath, map[string]any{}))
+	}
+	if props.Contains(rps.ResourceRequests) && ctn.Resources.Requests == nil {
+		ops = append(ops, jsonpatch.NewOperation("add", resourcesPath+"/requests", map[string]any{}))
+	}
+	if props.Contains(rps.ResourceLimits) && ctn.Resources.Limits == nil {
+		ops = append(ops, jsonpatch.NewOperation("add", resourcesPath+"/limits", map[string]any{}))
+	}
+	return ops
+}
+
+// Result is everything the sizing engine decided for a pod, see Sizer.Size
+type Result struct {
+	nodeName string
+	// nodeCapacity is what fractions were applied to
+	nodeCapacity corev1.ResourceList
+	// committed holds what other pods request on the node, when deducted from its capacity
+	committed corev1.ResourceList
+	// original holds the resources of every container before sizing, in pod order
+	original []containerResources
+	patches  []ResourcePatch
+	trace    *decisionTrace
+	status   statusSettings
+	// driftAction tells what to do with the pod once running, should its sizing become outdated
+	driftAction v1alpha1.DriftAction
+	// warnings are returned to the client creating the pod
+	warnings []string
+	// quotaSkipped tells why containers keep their resources, when quotas do not leave enough room
+	quotaSkipped string
+	// resizePolicies are set on sized containers of pods being created, see ResizePolicyAnnotation
+	resizePolicies []corev1.ContainerResizePolicy
+	// exposeBudget writes the pod budget in annotations, see ExposeBudgetAnnotation
+	exposeBudget bool
+	// runtimes have environment variables set on sized containers of pods being created, see RuntimeEnvAnnotation
+	runtimes []containerRuntime
+	// vpaConflict describes the VerticalPodAutoscaler that also sizes the pod, if any
+	vpaConflict string
+	// dryRun results are reported, but not applied
+	dryRun bool
+	// unconfigured results are for pods no settings apply to, which were left alone
+	unconfigured bool
+}
+
+type containerResources struct {
+	name      string
+	resources corev1.ResourceRequirements
+}
+
+// containerReport tells what sizing did to a container
+type containerReport struct {
+	Name     string                      `json:"name"`
+	Original corev1.ResourceRequirements `json:"original"`
+	Final    corev1.ResourceRequirements `json:"final"`
+}
+
+// sizingReport is the status annotation payload. The trace is only included at full verbosity.
+type sizingReport struct {
+	Node         string              `json:"node"`
+	NodeCapacity corev1.ResourceList `json:"nodeCapacity"`
+	// Committed is what other pods request on the node, deducted from NodeCapacity
+	Committed  corev1.ResourceList `json:"committed,omitempty"`
+	Containers []containerReport   `json:"containers"`
+	// MinMaxClamped tells whether minimums or maximums changed the outcome
+	MinMaxClamped bool           `json:"minMaxClamped"`
+	ClampedBy     []Stage        `json:"clampedBy,omitempty"`
+	Trace         *decisionTrace `json:"trace,omitempty"`
+	// DryRun tells that final resources were computed, but not applied
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// containerReports tells, for every container in pod order, its resources before and after patches are applied
+func (sr *Result) containerReports() []containerReport {
+	var reports []containerReport
+	applied := appliedResourcesOf(sr)
+	for _, ctn := range sr.original {
+		final := *ctn.resources.DeepCopy()
+		for name, qty := range applied[ctn.name].Requests {
+			if final.Requests == nil {
+				final.Requests = make(corev1.ResourceList)
+			}
+			final.Requests[name] = qty
+		}
+		for name, qty := range applied[ctn.name].Limits {
+			if final.Limits == nil {
+				final.Limits = make(corev1.ResourceList)
+			}
+			final.Limits[name] = qty
+		}
+		reports = append(reports, containerReport{Name: ctn.name, Original: ctn.resources, Final: final})
+	}
+	return reports
+}
+
+// report builds the status annotation payload
+func (sr *Result) report(withTrace bool) sizingReport {
+	report := sizingReport{
+		Node:         sr.nodeName,
+		NodeCapacity: sr.nodeCapacity,
+		Committed:    sr.committed,
+		Containers:   sr.containerReports(),
+		DryRun:       sr.dryRun,
+	}
+
+	if sr.trace != nil {
+		report.ClampedBy = sr.trace.ClampedBy()
+		report.MinMaxClamped = sr.trace.Adjusted(stagePodMinMax) || sr.trace.Adjusted(stageContainerMinMax)
+		if withTrace {
+			report.Trace = sr.trace
+		}
+	}
+	return report
+}
+
+// ClampedByFromAnnotations returns the stages that clamped the resources of a pod, as recorded in StatusAnnotation.
+// Pods without it, e.g. whose policy sets another key or no status at all, are reported as not clamped.
+func ClampedByFromAnnotations(annotations map[string]string) ([]Stage, error) {
+	value, ok := annotations[StatusAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var report sizingReport
+	if err := json.Unmarshal([]byte(value), &report); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", StatusAnnotation, err)
+	}
+	return report.ClampedBy, nil
+}
+
+// NodeName is the node the pod was sized for
+func (sr *Result) NodeName() string {
+	return sr.nodeName
+}
+
+// Warnings are meant for the client creating the pod, e.g. conflicting settings
+func (sr *Result) Warnings() []string {
+	return sr.warnings
+}
+
+// Unconfigured tells whether no settings apply to the pod, which was then left alone without looking its node up
+func (sr *Result) Unconfigured() bool {
+	return sr.unconfigured
+}
+
+// VPAConflict describes the VerticalPodAutoscaler that also sizes the pod, or is empty when there is none or
+// VerticalPodAutoscalers are not looked up
+func (sr *Result) VPAConflict() string {
+	return sr.vpaConflict
+}
+
+// DriftAction tells what to do with the pod once running, should its sizing become outdated, as set by its policy
+func (sr *Result) DriftAction() v1alpha1.DriftAction {
+	return cmp.Or(sr.driftAction, v1alpha1.DriftActionReport)
+}
+
+// AppliedResources returns the resources the result sets, by container name, as recorded in
+// AppliedResourcesAnnotation
+func (sr *Result) AppliedResources() AppliedResources {
+	return appliedResourcesOf(sr)
+}
+
+// DryRun tells whether the result is only reported, leaving resources as they are
+func (sr *Result) DryRun() bool {
+	return sr.dryRun
+}
+
+// Patches iterates over resource patches, ordered by container, sidecars first, then property, then resource
+func (sr *Result) Patches() iter.Seq[ResourcePatch] {
+	return slices.Values(sr.patches)
+}
+
+func sortResourcePatches(patches []ResourcePatch) {
+	slices.SortFunc(patches, func(a, b ResourcePatch) int {
+		return cmp.Or(
+			cmp.Compare(listRank(a), listRank(b)),
+			cmp.Compare(a.ContainerIndex, b.ContainerIndex),
+			cmp.Compare(a.Property, b.Property),
+			cmp.Compare(a.Resource, b.Resource),
+		)
+	})
+}
+
+// listRank orders patches to sidecars before patches to regular containers, the way they come in the pod
+func listRank(p ResourcePatch) int {
+	if p.Sidecar {
+		return 0
+	}
+	return 1
+}
+
+// originalQuantity returns the quantity a container was set with for a given property, if any
+func originalQuantity(ctn *corev1.Container, prop rps.ResourceProperty, res corev1.ResourceName) *resource.Quantity {
+	var list corev1.ResourceList
+	switch prop {
+	case rps.ResourceRequests:
+		list = ctn.Resources.Requests
+	case rps.ResourceLimits:
+		list = ctn.Resources.Limits
+	}
+	if qty, ok := list[res]; ok {
+		return &qty
+	}
+	return nil
+}
+
+// Summary describes the node, the resulting pod budget and the stages that clamped it, in a single line
+func (sr *Result) Summary() string {
+	totals := make(map[rps.ResourceProperty]map[corev1.ResourceName]resource.Quantity)
+	for patch := range sr.Patches() {
+		if _, ok := totals[patch.Property]; !ok {
+			totals[patch.Property] = make(map[corev1.ResourceName]resource.Quantity)
+		}
+		total := totals[patch.Property][patch.Resource]
+		total.Add(patch.New)
+		totals[patch.Property][patch.Resource] = total
+	}
+
+	var budget []string
+	for _, prop := range slices.Sorted(maps.Keys(totals)) {
+		var values []string
+		for _, res := range slices.Sorted(maps.Keys(totals[prop])) {
+			qty := totals[prop][res]
+			values = append(values, fmt.Sprintf("%s=%s", res, qty.String()))
+		}
+		budget = append(budget, fmt.Sprintf("%s %s", prop, strings.Join(values, ",")))
+	}
+
+	message := fmt.Sprintf("Sized for node %s", sr.nodeName)
+	if sr.dryRun {
+		message = fmt.Sprintf("Dry run, would have sized for node %s", sr.nodeName)
+	}
+	if len(budget) > 0 {
+		message += fmt.Sprintf(", pod budget: %s", strings.Join(budget, " "))
+	}
+
+	var clamped []string
+	if sr.trace != nil {
+		for _, stage := range sr.trace.ClampedBy() {
+			clamped = append(clamped, string(stage))
+		}
+	}
+	if len(clamped) > 0 {
+		message += fmt.Sprintf(", clamped by: %s", strings.Join(clamped, ","))
+	}
+	if sr.vpaConflict != "" {
+		message += ", " + sr.vpaConflict
+	}
+	return message
+}
@@ -0,0 +1,41 @@
+package sizing
+
+import (
+	"encoding/json"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var _ = Describe("Summarizing results", Label("Result"), func() {
+	result := &Result{
+		nodeName: "node-a",
+		patches: []ResourcePatch{
+			{ContainerIndex: 0, ContainerName: "a", Property: rps.ResourceRequests, Resource: corev1.ResourceCPU, New: resource.MustParse("300m")},
+			{ContainerIndex: 1, ContainerName: "b", Property: rps.ResourceRequests, Resource: corev1.ResourceCPU, New: resource.MustParse("100m")},
+			{ContainerIndex: 1, ContainerName: "b", Property: rps.ResourceLimits, Resource: corev1.ResourceMemory, New: resource.MustParse("1Gi")},
+		},
+		trace: &decisionTrace{Steps: []traceStep{
+			{Stage: stagePodMinMax, Adjustments: []traceAdjustment{{Scope: podScope, Property: rps.ResourceRequests, Resource: corev1.ResourceCPU, After: 0.4}}},
+			{Stage: stageNodeCap},
+		}},
+	}
+
+	It("describes the node, the pod budget and clamping", func() {
+		Expect(result.Summary()).To(Equal("Sized for node node-a, pod budget: limits memory=1Gi requests cpu=400m, clamped by: pod-min-max"))
+	})
+
+	It("reads clamping back from the status annotation", func() {
+		report, err := json.Marshal(result.report(false))
+		Expect(err).NotTo(HaveOccurred())
+		clampedBy, err := ClampedByFromAnnotations(map[string]string{StatusAnnotation: string(report)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(clampedBy).To(Equal([]Stage{stagePodMinMax}))
+
+		clampedBy, err = ClampedByFromAnnotations(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(clampedBy).To(BeEmpty())
+	})
+})
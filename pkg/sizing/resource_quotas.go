@@ -0,0 +1,106 @@
+package sizing
+
+import (
+	"context"
+	"fmt"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+	"strings"
+)
+
+// QuotaMode tells what to do with pods whose sized resources would exceed what the ResourceQuotas of their namespace
+// leave, which the quota admission plugin would then reject
+type QuotaMode string
+
+const (
+	// QuotasIgnore sizes pods regardless of quotas
+	QuotasIgnore QuotaMode = "ignore"
+	// QuotasClamp scales sized containers down, keeping their proportions, to what quotas leave
+	QuotasClamp QuotaMode = "clamp"
+	// QuotasSkip leaves the resources of the pod as they are, and returns an admission warning
+	QuotasSkip QuotaMode = "skip"
+)
+
+// QuotaSkippedAnnotation tells why the resources of a pod were left as they are, with QuotasSkip
+const QuotaSkippedAnnotation = AnnotationPrefix + "quota-skipped"
+
+// ParseQuotaMode reads ignore, clamp or skip
+func ParseQuotaMode(value string) (QuotaMode, error) {
+	mode := QuotaMode(value)
+	if !slices.Contains([]QuotaMode{QuotasIgnore, QuotasClamp, QuotasSkip}, mode) {
+		return "", fmt.Errorf("unknown quota mode %q, expected one of ignore, clamp or skip", value)
+	}
+	return mode, nil
+}
+
+// quotaProperty maps a quota resource name, e.g. limits.cpu, to the property and resource it constrains. Bare names
+// of compute resources constrain requests.
+func quotaProperty(name corev1.ResourceName) (rps.ResourceProperty, corev1.ResourceName, bool) {
+	if res, ok := strings.CutPrefix(string(name), "requests."); ok {
+		return rps.ResourceRequests, corev1.ResourceName(res), true
+	}
+	if res, ok := strings.CutPrefix(string(name), "limits."); ok {
+		return rps.ResourceLimits, corev1.ResourceName(res), true
+	}
+	if slices.Contains([]corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourceEphemeralStorage}, name) {
+		return rps.ResourceRequests, name, true
+	}
+	return "", "", false
+}
+
+// quotaHeadroom returns, for every constrained property, the smallest of what the ResourceQuotas of a namespace leave
+// for a pod, its overhead deducted. It returns nil when no quota applies. Quotas restricted to scopes are not taken
+// into account, as telling whether they match the pod is left to the quota admission plugin.
+func quotaHeadroom(ctx context.Context, quotaReader client.Reader, pod *corev1.Pod) (*rps.ResourceProperties, error) {
+	var quotas corev1.ResourceQuotaList
+	if err := quotaReader.List(ctx, &quotas, client.InNamespace(pod.Namespace)); err != nil {
+		return nil, fmt.Errorf("problem listing resource quotas of namespace %s: %w", pod.Namespace, err)
+	}
+
+	var headroom *rps.ResourceProperties
+	for _, quota := range quotas.Items {
+		if len(quota.Spec.Scopes) > 0 || quota.Spec.ScopeSelector != nil {
+			continue
+		}
+		for name, hard := range quota.Status.Hard {
+			prop, res, ok := quotaProperty(name)
+			if !ok {
+				continue
+			}
+			remaining := rps.QuantityRat(hard)
+			if used, ok := quota.Status.Used[name]; ok {
+				remaining.Sub(remaining, rps.QuantityRat(used))
+			}
+			if overhead, ok := pod.Spec.Overhead[res]; ok {
+				remaining.Sub(remaining, rps.QuantityRat(overhead))
+			}
+			if remaining.Sign() < 0 {
+				remaining.SetInt64(0)
+			}
+			if headroom == nil {
+				headroom = rps.New()
+			}
+			if current, ok := headroom.GetRat(prop, res); !ok || remaining.Cmp(current) < 0 {
+				headroom.BindPropertyRat(rps.ResourceQuantity, prop, res, remaining)
+			}
+		}
+	}
+	return headroom, nil
+}
+
+// quotaSkippedMessage tells which sized totals quotas do not leave room for
+func quotaSkippedMessage(trace *decisionTrace) string {
+	var exceeded []string
+	for _, step := range trace.Steps {
+		if step.Stage != stageQuotaCap {
+			continue
+		}
+		for _, adjustment := range step.Adjustments {
+			exceeded = append(exceeded, fmt.Sprintf("%s.%s needs %s but %s is left",
+				adjustment.Property, adjustment.Resource, adjustment.quantity(*adjustment.Before), adjustment.quantity(adjustment.After)))
+		}
+	}
+	return "resources are left as is, resource quotas do not leave enough room: " + strings.Join(exceeded, ", ")
+}
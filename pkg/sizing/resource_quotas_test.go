@@ -0,0 +1,83 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Taking resource quotas into account", Label("ResourceQuotas"), func() {
+	quota := func(name string, hard, used corev1.ResourceList) *corev1.ResourceQuota {
+		return &corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team"},
+			Status:     corev1.ResourceQuotaStatus{Hard: hard, Used: used},
+		}
+	}
+
+	node := fixtures.NodeWithCapacity("4", "8G")
+	node.Name = "node-a"
+	scoped := quota("best-effort", corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("100m")}, nil)
+	scoped.Spec.Scopes = []corev1.ResourceQuotaScope{corev1.ResourceQuotaScopeBestEffort}
+	reader := fake.NewClientBuilder().
+		WithObjects(
+			node,
+			quota("compute",
+				corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("2"), corev1.ResourcePods: resource.MustParse("10")},
+				corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("1500m")}),
+			quota("memory", corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("16G")}, nil),
+			scoped,
+		).
+		Build()
+
+	sizedPod := func() *corev1.Pod {
+		pod := fixtures.PinToNode(fixtures.PodWithContainers(fixtures.ContainerWithResources("agent",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil)), "node-a")
+		pod.Namespace = "team"
+		pod.Annotations = map[string]string{"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.5"}
+		return pod
+	}
+
+	It("reads what unscoped quotas leave", func(ctx SpecContext) {
+		headroom, err := quotaHeadroom(ctx, reader, sizedPod())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fixtures.BoundValue(headroom, rps.ResourceRequests, corev1.ResourceCPU)).To(BeNumerically("~", 0.5))
+		Expect(fixtures.BoundValue(headroom, rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("~", 16e9))
+	})
+
+	It("sizes regardless of quotas by default", func(ctx SpecContext) {
+		result, err := New(reader, Options{QuotaReader: reader}).Size(ctx, sizedPod())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.patches[0].New.String()).To(Equal("2"))
+	})
+
+	It("clamps to what quotas leave", func(ctx SpecContext) {
+		result, err := New(reader, Options{QuotaReader: reader, Quotas: QuotasClamp}).Size(ctx, sizedPod())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.patches[0].New.String()).To(Equal("500m"))
+		Expect(result.trace.ClampedBy()).To(ContainElement(stageQuotaCap))
+	})
+
+	It("leaves resources as they are when skipping", func(ctx SpecContext) {
+		pod := sizedPod()
+		result, patch, err := New(reader, Options{QuotaReader: reader, Quotas: QuotasSkip}).CreatePatch(ctx, pod, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.patches).To(BeEmpty())
+		Expect(result.Warnings()).To(ConsistOf(ContainSubstring("requests.cpu needs 2 but 500m is left")))
+		Expect(patch).To(HaveLen(1))
+		Expect(patch[0].Path).To(Equal("/metadata/annotations/node-specific-sizing.manomano.tech~1quota-skipped"))
+	})
+
+	It("sizes pods that fit when skipping", func(ctx SpecContext) {
+		pod := sizedPod()
+		pod.Annotations["node-specific-sizing.manomano.tech/request-cpu-fraction"] = "0.1"
+		result, err := New(reader, Options{QuotaReader: reader, Quotas: QuotasSkip}).Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.patches[0].New.String()).To(Equal("400m"))
+		Expect(result.Warnings()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,116 @@
+package sizing
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/apis/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"slices"
+	"sync"
+)
+
+// Labels controllers set on their pods to the hash of the template they were created from
+var templateHashLabels = []string{"pod-template-hash", "controller-revision-hash"}
+
+// resultCache keeps the results of pods created from a template, so that pods of a rollout landing on the same node
+// are only sized once. Entries are keyed by everything sizing reads, node and policy resource versions included, so
+// that updating either makes their entries unreachable, until evicted as least recently used. It is safe for
+// concurrent use.
+type resultCache struct {
+	lock    sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type resultCacheEntry struct {
+	key    string
+	result *Result
+}
+
+func newResultCache(size int) *resultCache {
+	return &resultCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get returns a copy of the result kept for key, which callers may change
+func (c *resultCache) get(key string) (*Result, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		resultCacheRequestsTotal.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	resultCacheRequestsTotal.WithLabelValues("hit").Inc()
+	c.order.MoveToFront(element)
+	return copyResult(element.Value.(*resultCacheEntry).result), true
+}
+
+// put keeps a copy of result, evicting the least recently used one when full
+func (c *resultCache) put(key string, result *Result) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*resultCacheEntry).result = copyResult(result)
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&resultCacheEntry{key: key, result: copyResult(result)})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*resultCacheEntry).key)
+	}
+}
+
+// copyResult copies what is appended to once sized, the rest is only read
+func copyResult(result *Result) *Result {
+	copied := *result
+	copied.warnings = slices.Clone(result.warnings)
+	return &copied
+}
+
+// resultCacheKey returns the key of the result of a pod, false for pods not created from a template
+func resultCacheKey(pod *corev1.Pod, podAnnotations, defaults map[string]string, node *corev1.Node, policy *v1alpha1.SizingPolicy) (string, bool) {
+	var templateHash string
+	for _, label := range templateHashLabels {
+		if templateHash = pod.Labels[label]; templateHash != "" {
+			break
+		}
+	}
+	if templateHash == "" {
+		return "", false
+	}
+	key := struct {
+		Namespace    string
+		TemplateHash string
+		Annotations  map[string]string
+		Defaults     map[string]string
+		Containers   []corev1.Container
+		Overhead     corev1.ResourceList
+		Node         [2]string
+		Policy       [2]string
+	}{
+		Namespace:    pod.Namespace,
+		TemplateHash: templateHash,
+		Annotations:  podAnnotations,
+		Defaults:     defaults,
+		Overhead:     pod.Spec.Overhead,
+		Node:         [2]string{node.Name, node.ResourceVersion},
+	}
+	// Only names and resources are sized from, along with which init containers are sidecars
+	for _, ctn := range longRunningContainers(pod) {
+		key.Containers = append(key.Containers, corev1.Container{Name: ctn.Name, Resources: ctn.Resources, RestartPolicy: ctn.RestartPolicy})
+	}
+	if policy != nil {
+		key.Policy = [2]string{policy.Name, policy.ResourceVersion}
+	}
+	raw, err := json.Marshal(key)
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), true
+}
@@ -0,0 +1,88 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Caching results", Label("ResultCache"), func() {
+	var (
+		nodeClient client.Client
+		sizer      *Sizer
+		pod        *corev1.Pod
+	)
+	BeforeEach(func() {
+		node := fixtures.NodeWithCapacity("4", "8G")
+		node.Name = "node-a"
+		nodeClient = fake.NewClientBuilder().WithObjects(node).Build()
+		sizer = New(nodeClient, Options{ResultCacheSize: 2})
+		pod = fixtures.PinToNode(fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil)), "node-a")
+		pod.Labels = map[string]string{"controller-revision-hash": "agent-5d8f"}
+		pod.Annotations = map[string]string{"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.1"}
+	})
+
+	hits := func() float64 { return testutil.ToFloat64(resultCacheRequestsTotal.WithLabelValues("hit")) }
+
+	It("sizes pods of a template on a node once", func(ctx SpecContext) {
+		before := hits()
+		first, err := sizer.Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		second, err := sizer.Size(ctx, pod.DeepCopy())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hits()).To(Equal(before + 1))
+		Expect(second.patches).To(Equal(first.patches))
+		Expect(second).NotTo(BeIdenticalTo(first))
+	})
+
+	It("sizes again once the node changed", func(ctx SpecContext) {
+		_, err := sizer.Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		var node corev1.Node
+		Expect(nodeClient.Get(ctx, client.ObjectKey{Name: "node-a"}, &node)).To(Succeed())
+		node.Status.Capacity[corev1.ResourceCPU] = resource.MustParse("8")
+		Expect(nodeClient.Status().Update(ctx, &node)).To(Succeed())
+
+		before := hits()
+		result, err := sizer.Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hits()).To(Equal(before))
+		Expect(result.patches[0].New.String()).To(Equal("800m"))
+	})
+
+	It("sizes again when anything sized from changed", func(ctx SpecContext) {
+		_, err := sizer.Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		before := hits()
+		pod.Annotations["node-specific-sizing.manomano.tech/request-cpu-fraction"] = "0.2"
+		_, err = sizer.Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		pod.Spec.Containers[0].Resources.Requests[corev1.ResourceCPU] = resource.MustParse("200m")
+		_, err = sizer.Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hits()).To(Equal(before))
+	})
+
+	It("leaves pods without a template alone, and evicts the least recently used results", func(ctx SpecContext) {
+		delete(pod.Labels, "controller-revision-hash")
+		_, ok := resultCacheKey(pod, pod.Annotations, nil, &corev1.Node{}, nil)
+		Expect(ok).To(BeFalse())
+
+		cache := newResultCache(2)
+		cache.put("a", &Result{nodeName: "a"})
+		cache.put("b", &Result{nodeName: "b"})
+		_, _ = cache.get("a")
+		cache.put("c", &Result{nodeName: "c"})
+		_, ok = cache.get("b")
+		Expect(ok).To(BeFalse())
+		result, ok := cache.get("a")
+		Expect(ok).To(BeTrue())
+		Expect(result.nodeName).To(Equal("a"))
+	})
+})
@@ -0,0 +1,109 @@
+package sizing
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// RuntimeEnvAnnotation lists runtimes, comma-separated, whose environment variables are set on sized containers from
+// their final limits, for runtimes that do not read cgroup limits well:
+//   - go sets GOMAXPROCS to the CPU limit rounded up, and GOMEMLIMIT to 90% of the memory limit
+//   - java sets -Xmx to 75% of the memory limit in JAVA_TOOL_OPTIONS, replacing any -Xmx already there, unless that
+//     is under a mebibyte, the unit it is set in
+//
+// Environment variables cannot change once pods exist, only pods being created get them. Variables set from a source,
+// e.g. a ConfigMap, are left alone.
+const RuntimeEnvAnnotation = AnnotationPrefix + "runtime-env"
+
+// containerRuntime is a runtime whose environment variables RuntimeEnvAnnotation sets
+type containerRuntime string
+
+const (
+	runtimeGo   containerRuntime = "go"
+	runtimeJava containerRuntime = "java"
+)
+
+const (
+	// goMemLimitPercent of the memory limit leaves room to memory the Go runtime does not account for
+	goMemLimitPercent = 90
+	// javaHeapPercent of the memory limit leaves room to metaspace, thread stacks and direct buffers
+	javaHeapPercent = 75
+)
+
+var xmxOption = regexp.MustCompile(`(^|\s)-Xmx\S*`)
+
+// runtimesFromAnnotations returns the runtimes of RuntimeEnvAnnotation, sorted, or nil without it
+func runtimesFromAnnotations(annotations map[string]string) ([]containerRuntime, error) {
+	value, ok := annotations[RuntimeEnvAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var runtimes []containerRuntime
+	for _, name := range strings.Split(value, ",") {
+		runtime := containerRuntime(strings.TrimSpace(name))
+		switch {
+		case runtime == "":
+			continue
+		case runtime != runtimeGo && runtime != runtimeJava:
+			return nil, fmt.Errorf("%s: unknown runtime %q, expected go or java", RuntimeEnvAnnotation, runtime)
+		case !slices.Contains(runtimes, runtime):
+			runtimes = append(runtimes, runtime)
+		}
+	}
+	if len(runtimes) == 0 {
+		return nil, fmt.Errorf("%s cannot be empty", RuntimeEnvAnnotation)
+	}
+	slices.Sort(runtimes)
+	return runtimes, nil
+}
+
+// runtimeEnv returns the environment variables runtimes want for limits, those of limits missing being left out
+func runtimeEnv(runtimes []containerRuntime, limits corev1.ResourceList) map[string]func(current string) string {
+	env := make(map[string]func(string) string)
+	cpu, hasCPU := limits[corev1.ResourceCPU]
+	memory, hasMemory := limits[corev1.ResourceMemory]
+	for _, runtime := range runtimes {
+		switch runtime {
+		case runtimeGo:
+			if hasCPU {
+				procs := strconv.FormatInt(max(1, (cpu.MilliValue()+999)/1000), 10)
+				env["GOMAXPROCS"] = func(string) string { return procs }
+			}
+			if hasMemory {
+				limit := strconv.FormatInt(memory.Value()*goMemLimitPercent/100, 10)
+				env["GOMEMLIMIT"] = func(string) string { return limit }
+			}
+		case runtimeJava:
+			// -Xmx0m would fail the JVM, a heap under a mebibyte is left to it
+			if heap := memory.Value() * javaHeapPercent / 100 / (1 << 20); hasMemory && heap > 0 {
+				xmx := fmt.Sprintf("-Xmx%dm", heap)
+				env["JAVA_TOOL_OPTIONS"] = func(current string) string {
+					options := strings.TrimSpace(xmxOption.ReplaceAllString(current, ""))
+					return strings.TrimSpace(options + " " + xmx)
+				}
+			}
+		}
+	}
+	return env
+}
+
+// withRuntimeEnv returns the environment variables of ctn with those runtimes want for limits, and whether they changed
+func withRuntimeEnv(ctn *corev1.Container, runtimes []containerRuntime, limits corev1.ResourceList) ([]corev1.EnvVar, bool) {
+	result := slices.Clone(ctn.Env)
+	env := runtimeEnv(runtimes, limits)
+	for _, name := range slices.Sorted(maps.Keys(env)) {
+		i := slices.IndexFunc(result, func(v corev1.EnvVar) bool { return v.Name == name })
+		switch {
+		case i < 0:
+			result = append(result, corev1.EnvVar{Name: name, Value: env[name]("")})
+		case result[i].ValueFrom == nil:
+			result[i].Value = env[name](result[i].Value)
+		}
+	}
+	return result, !slices.EqualFunc(result, ctn.Env, func(a, b corev1.EnvVar) bool { return a.Name == b.Name && a.Value == b.Value })
+}
@@ -0,0 +1,96 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gomodules.xyz/jsonpatch/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"slices"
+	"strings"
+)
+
+var _ = Describe("Runtime environment variables", Label("RuntimeEnv"), func() {
+	node := fixtures.NodeWithCapacity("4", "8Gi")
+	node.Name = "node-a"
+	sizer := &Sizer{nodeReader: fake.NewClientBuilder().WithObjects(node).Build()}
+
+	limits := corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1500m"), corev1.ResourceMemory: resource.MustParse("1Gi")}
+
+	It("sets GOMAXPROCS and GOMEMLIMIT for go", func() {
+		env, changed := withRuntimeEnv(&corev1.Container{Env: []corev1.EnvVar{{Name: "GOMAXPROCS", Value: "8"}}}, []containerRuntime{runtimeGo}, limits)
+		Expect(changed).To(BeTrue())
+		Expect(env).To(Equal([]corev1.EnvVar{{Name: "GOMAXPROCS", Value: "2"}, {Name: "GOMEMLIMIT", Value: "966367641"}}))
+	})
+
+	It("replaces -Xmx in JAVA_TOOL_OPTIONS for java", func() {
+		ctn := &corev1.Container{Env: []corev1.EnvVar{{Name: "JAVA_TOOL_OPTIONS", Value: "-Xmx256m -Dfile.encoding=UTF-8"}}}
+		env, changed := withRuntimeEnv(ctn, []containerRuntime{runtimeJava}, limits)
+		Expect(changed).To(BeTrue())
+		Expect(env).To(Equal([]corev1.EnvVar{{Name: "JAVA_TOOL_OPTIONS", Value: "-Dfile.encoding=UTF-8 -Xmx768m"}}))
+
+		_, changed = withRuntimeEnv(&corev1.Container{Env: env}, []containerRuntime{runtimeJava}, limits)
+		Expect(changed).To(BeFalse())
+	})
+
+	It("leaves JAVA_TOOL_OPTIONS alone for limits too small to set a heap from", func() {
+		ctn := &corev1.Container{Env: []corev1.EnvVar{{Name: "JAVA_TOOL_OPTIONS", Value: "-Dfile.encoding=UTF-8"}}}
+		env, changed := withRuntimeEnv(ctn, []containerRuntime{runtimeJava}, corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Mi")})
+		Expect(changed).To(BeFalse())
+		Expect(env).To(Equal(ctn.Env))
+	})
+
+	It("leaves variables set from a source and missing limits alone", func() {
+		fromSource := corev1.EnvVar{Name: "GOMEMLIMIT", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{Key: "limit"}}}
+		env, changed := withRuntimeEnv(&corev1.Container{Env: []corev1.EnvVar{fromSource}}, []containerRuntime{runtimeGo},
+			corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")})
+		Expect(changed).To(BeFalse())
+		Expect(env).To(Equal([]corev1.EnvVar{fromSource}))
+	})
+
+	Describe("when sizing", func() {
+		var pod *corev1.Pod
+		BeforeEach(func() {
+			pod = fixtures.PinToNode(fixtures.PodWithContainers(
+				fixtures.ContainerWithResources("app", nil, corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("100Mi")}),
+			), "node-a")
+			pod.Annotations = map[string]string{
+				"node-specific-sizing.manomano.tech/limit-memory-fraction": "0.25",
+				RuntimeEnvAnnotation: "java, go",
+			}
+		})
+
+		envOps := func(patch []jsonpatch.JsonPatchOperation) []jsonpatch.JsonPatchOperation {
+			return slices.DeleteFunc(patch, func(op jsonpatch.JsonPatchOperation) bool { return !strings.HasSuffix(op.Path, "/env") })
+		}
+
+		It("sets variables from the final limits of sized containers of pods being created", func(ctx SpecContext) {
+			_, patch, err := sizer.CreatePatch(ctx, pod, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(envOps(patch)).To(Equal([]jsonpatch.JsonPatchOperation{
+				jsonpatch.NewOperation("add", "/spec/containers/0/env", []corev1.EnvVar{
+					{Name: "GOMEMLIMIT", Value: "1932735283"},
+					{Name: "JAVA_TOOL_OPTIONS", Value: "-Xmx1536m"},
+				}),
+			}))
+		})
+
+		It("leaves existing pods alone, their environment cannot change", func(ctx SpecContext) {
+			pod.UID = "existing"
+			_, patch, err := sizer.CreatePatch(ctx, pod, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(envOps(patch)).To(BeEmpty())
+		})
+	})
+
+	It("rejects unknown runtimes", func() {
+		for _, value := range []string{"", "rust", "go,,python"} {
+			Expect(ValidateAnnotations(map[string]string{RuntimeEnvAnnotation: value})).To(HaveOccurred(), value)
+		}
+		runtimes, err := runtimesFromAnnotations(map[string]string{RuntimeEnvAnnotation: "java, go,go"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runtimes).To(Equal([]containerRuntime{runtimeGo, runtimeJava}))
+	})
+})
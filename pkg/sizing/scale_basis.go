@@ -0,0 +1,59 @@
+package sizing
+
+import (
+	"fmt"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"math/big"
+)
+
+// basisOf returns what a fraction of binding applies to on node, see rps.Basis. Fractions of a resource the node lacks
+// are not sized, which is only an error when the basis was chosen explicitly. The returned value must not be modified.
+func basisOf(binding *rps.ResourcePropertyBinding, capacity nodeCapacity, node *corev1.Node) (*big.Rat, error) {
+	basis := binding.Basis()
+	if label, ok := basis.Label(); ok {
+		value, ok := node.Labels[label]
+		if !ok {
+			return nil, fmt.Errorf("node %s has no label %s", node.Name, label)
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil || quantity.Sign() <= 0 {
+			return nil, fmt.Errorf("label %s of node %s is not a positive quantity: %q", label, node.Name, value)
+		}
+		return rps.QuantityRat(quantity), nil
+	}
+	if name, ok := basis.Resource(); ok {
+		value, ok := capacity[name]
+		if !ok {
+			return nil, fmt.Errorf("node %s has no %s capacity", node.Name, name)
+		}
+		return value, nil
+	}
+	if basis == rps.BasisAllocatable {
+		quantity, ok := node.Status.Allocatable[binding.ResourceName()]
+		if !ok {
+			return nil, fmt.Errorf("node %s has no allocatable %s", node.Name, binding.ResourceName())
+		}
+		return rps.QuantityRat(quantity), nil
+	}
+	return capacity[binding.ResourceName()], nil
+}
+
+// basisWarnings describes fractions left unsized as their explicit basis is missing from node
+func basisWarnings(userSettings *rps.ResourceProperties, node *corev1.Node) []string {
+	var warnings []string
+	var capacity nodeCapacity
+	for binding := range userSettings.All() {
+		if binding.Basis() == "" || binding.Basis() == rps.BasisCapacity {
+			continue
+		}
+		if capacity == nil {
+			capacity = parseCapacity(node.Status.Capacity)
+		}
+		if _, err := basisOf(binding, capacity, node); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s.%s is not sized: %s", binding.Property(), binding.ResourceName(), err))
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,60 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Sizing from another basis than capacity", Label("ScaleBasis"), func() {
+	node := fixtures.NodeWithCapacity("4", "8G")
+	node.Name = "node-a"
+	node.Labels = map[string]string{"node.example.com/nvme-bytes": "50G", "node.example.com/broken": "lots"}
+	node.Status.Capacity["example.com/nvme"] = resource.MustParse("60G")
+	node.Status.Allocatable = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("6G")}
+	sizer := &Sizer{nodeReader: fake.NewClientBuilder().WithObjects(node).Build()}
+
+	sizedMemory := func(ctx SpecContext, basis string) (*Result, string) {
+		pod := fixtures.PinToNode(fixtures.PodWithContainers(
+			fixtures.ContainerWithResources("cache", corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1G")}, nil),
+		), "node-a")
+		pod.ObjectMeta = metav1.ObjectMeta{Annotations: map[string]string{
+			"node-specific-sizing.manomano.tech/request-memory-fraction": "0.1",
+			"node-specific-sizing.manomano.tech/request-memory-basis":    basis,
+		}}
+		result, err := sizer.Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		for patch := range result.Patches() {
+			if patch.Property == rps.ResourceRequests && patch.Resource == corev1.ResourceMemory {
+				return result, patch.New.String()
+			}
+		}
+		return result, ""
+	}
+
+	DescribeTable("applies fractions to their basis",
+		func(ctx SpecContext, basis, expected string) {
+			_, sized := sizedMemory(ctx, basis)
+			Expect(sized).To(Equal(expected))
+		},
+		Entry("capacity", "capacity", "800M"),
+		Entry("allocatable", "allocatable", "600M"),
+		Entry("a numeric label", "label:node.example.com/nvme-bytes", "5G"),
+		Entry("another resource", "resource:example.com/nvme", "6G"),
+	)
+
+	It("leaves fractions whose basis is missing unsized, with a warning", func(ctx SpecContext) {
+		result, sized := sizedMemory(ctx, "label:node.example.com/missing")
+		Expect(sized).To(BeEmpty())
+		Expect(result.Warnings()).To(ContainElement("requests.memory is not sized: node node-a has no label node.example.com/missing"))
+
+		result, sized = sizedMemory(ctx, "label:node.example.com/broken")
+		Expect(sized).To(BeEmpty())
+		Expect(result.Warnings()).To(ContainElement(ContainSubstring("is not a positive quantity")))
+	})
+})
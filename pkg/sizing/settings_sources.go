@@ -0,0 +1,170 @@
+package sizing
+
+import (
+	"context"
+	"fmt"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"maps"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+	"strings"
+)
+
+// ConflictMode tells what to do when several sources set the same sizing setting to different values
+type ConflictMode string
+
+const (
+	// ConflictsIgnore silently applies precedence
+	ConflictsIgnore ConflictMode = "ignore"
+	// ConflictsWarn applies precedence, but returns an admission warning and logs the conflict
+	ConflictsWarn ConflictMode = "warn"
+	// ConflictsDeny refuses to size, hence to admit, the pod
+	ConflictsDeny ConflictMode = "deny"
+)
+
+// ParseConflictMode reads ignore, warn or deny
+func ParseConflictMode(value string) (ConflictMode, error) {
+	mode := ConflictMode(value)
+	if !slices.Contains([]ConflictMode{ConflictsIgnore, ConflictsWarn, ConflictsDeny}, mode) {
+		return "", fmt.Errorf("unknown conflict mode %q, expected one of ignore, warn or deny", value)
+	}
+	return mode, nil
+}
+
+// settingsSource is a set of sizing annotations from a given origin
+type settingsSource struct {
+	name        string
+	annotations map[string]string
+}
+
+// sourcedValue is the value a source sets a setting to
+type sourcedValue struct {
+	Source string `json:"source"`
+	Value  string `json:"value"`
+}
+
+// settingConflict is a setting set to different values by several sources. The first value is the one that applies.
+type settingConflict struct {
+	Key    string         `json:"key"`
+	Values []sourcedValue `json:"values"`
+}
+
+func (sc settingConflict) String() string {
+	var values []string
+	for _, v := range sc.Values {
+		values = append(values, fmt.Sprintf("%s=%q", v.Source, v.Value))
+	}
+	return fmt.Sprintf("%s is set by %s, %s wins", sc.Key, strings.Join(values, ", "), sc.Values[0].Source)
+}
+
+// SettingsConflictError is returned when conflicts are denied
+type SettingsConflictError struct {
+	conflicts []settingConflict
+}
+
+func (e *SettingsConflictError) Error() string {
+	var messages []string
+	for _, conflict := range e.conflicts {
+		messages = append(messages, conflict.String())
+	}
+	return "conflicting sizing settings: " + strings.Join(messages, "; ")
+}
+
+// mergeSettings merges the sizing annotations of sources, given by decreasing precedence, and lists the settings
+// they disagree on
+func mergeSettings(sources []settingsSource) (map[string]string, []settingConflict) {
+	merged := make(map[string]string)
+	var conflicts []settingConflict
+	for _, key := range slices.Sorted(rps.SupportedAnnotations()) {
+		var values []sourcedValue
+		for _, source := range sources {
+			if value, ok := source.annotations[key]; ok {
+				values = append(values, sourcedValue{Source: source.name, Value: value})
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		merged[key] = values[0].Value
+		if slices.ContainsFunc(values, func(v sourcedValue) bool { return v.Value != values[0].Value }) {
+			conflicts = append(conflicts, settingConflict{Key: key, Values: values})
+		}
+	}
+	return merged, conflicts
+}
+
+// namespaceDefaults returns the sizing annotations of the namespace of a pod, which apply to all of its pods
+func namespaceDefaults(ctx context.Context, namespaceReader client.Reader, namespace string) (map[string]string, error) {
+	if namespaceReader == nil || namespace == "" {
+		return nil, nil
+	}
+	var ns corev1.Namespace
+	if err := namespaceReader.Get(ctx, client.ObjectKey{Name: namespace}, &ns); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("problem fetching namespace '%s': %w", namespace, err)
+	}
+	return ns.Annotations, nil
+}
+
+// prefixedDefaults keys defaults by full annotation name
+func prefixedDefaults(defaults map[string]string) map[string]string {
+	if defaults == nil {
+		return nil
+	}
+	result := make(map[string]string, len(defaults))
+	for name, value := range defaults {
+		result[AnnotationPrefix+name] = value
+	}
+	return result
+}
+
+// ValidateDefaults checks sizing settings given as Options.Defaults
+func ValidateDefaults(defaults map[string]string) error {
+	annotations := prefixedDefaults(defaults)
+	for key := range annotations {
+		if !slices.Contains(slices.Collect(rps.SupportedAnnotations()), key) {
+			return fmt.Errorf("unknown sizing setting %q", strings.TrimPrefix(key, AnnotationPrefix))
+		}
+	}
+	err, settings := rps.NewFromAnnotations(annotations)
+	if err != nil {
+		return err
+	}
+	return settings.CheckBounds()
+}
+
+// withAnnotationDomain returns annotations, those under domain being also read as if they were under AnnotationPrefix.
+// Annotations already under AnnotationPrefix take precedence.
+func withAnnotationDomain(annotations map[string]string, domain string) map[string]string {
+	if domain == "" || len(annotations) == 0 {
+		return annotations
+	}
+	result := maps.Clone(annotations)
+	for key, value := range annotations {
+		if name, ok := strings.CutPrefix(key, domain+"/"); ok {
+			if _, exists := annotations[AnnotationPrefix+name]; !exists {
+				result[AnnotationPrefix+name] = value
+			}
+		}
+	}
+	return result
+}
+
+// hasSizingSettings tells whether annotations hold anything sizing pods, rather than only tuning how they are sized
+func hasSizingSettings(annotations map[string]string) bool {
+	supported := slices.Collect(rps.SupportedAnnotations())
+	for key := range annotations {
+		if key == rps.AllowOvercommitAnnotation || rps.IsBasisAnnotation(key) {
+			continue
+		}
+		_, isExpression := sizeExpressionAnnotations[key]
+		if isExpression || key == sizeTableAnnotation || strings.HasPrefix(key, containerClampPrefix) || slices.Contains(supported, key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package sizing
+
+import (
+	"encoding/json"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Merging settings sources", Label("SettingsSources"), func() {
+	const cpuFraction = "node-specific-sizing.manomano.tech/request-cpu-fraction"
+	const memoryFraction = "node-specific-sizing.manomano.tech/request-memory-fraction"
+
+	It("applies precedence and reports disagreements", func() {
+		merged, conflicts := mergeSettings([]settingsSource{
+			{name: "pod", annotations: map[string]string{cpuFraction: "0.2", "unrelated": "x"}},
+			{name: "namespace/agents", annotations: map[string]string{cpuFraction: "0.1", memoryFraction: "0.3"}},
+			{name: "policy/default", annotations: map[string]string{cpuFraction: "0.2", memoryFraction: "0.3"}},
+		})
+		Expect(merged).To(Equal(map[string]string{cpuFraction: "0.2", memoryFraction: "0.3"}))
+		Expect(conflicts).To(HaveLen(1))
+		Expect(conflicts[0].String()).To(Equal(cpuFraction +
+			` is set by pod="0.2", namespace/agents="0.1", policy/default="0.2", pod wins`))
+	})
+
+	It("rejects unknown modes", func() {
+		_, err := ParseConflictMode("strict")
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("when sizing", func() {
+		node := fixtures.NodeWithCapacity("4", "8G")
+		node.Name = "node-a"
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "agents",
+			Annotations: map[string]string{cpuFraction: "0.1"},
+		}}
+		reader := fake.NewClientBuilder().WithObjects(node, namespace).Build()
+
+		// applyPatch patches pod the way the API server does, failing when an operation cannot be applied
+		applyPatch := func(ctx SpecContext, sizer *Sizer, pod *corev1.Pod) *corev1.Pod {
+			_, ops, err := sizer.CreatePatch(ctx, pod, false)
+			Expect(err).NotTo(HaveOccurred())
+			original, err := json.Marshal(pod)
+			Expect(err).NotTo(HaveOccurred())
+			raw, err := json.Marshal(ops)
+			Expect(err).NotTo(HaveOccurred())
+			patch, err := jsonpatch.DecodePatch(raw)
+			Expect(err).NotTo(HaveOccurred())
+			patched, err := patch.Apply(original)
+			Expect(err).NotTo(HaveOccurred())
+			var result corev1.Pod
+			Expect(json.Unmarshal(patched, &result)).To(Succeed())
+			return &result
+		}
+
+		var pod *corev1.Pod
+		BeforeEach(func() {
+			pod = fixtures.PinToNode(fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+				corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil)), "node-a")
+			pod.Namespace = "agents"
+			pod.Annotations = map[string]string{cpuFraction: "0.2"}
+		})
+
+		It("uses namespace defaults", func(ctx SpecContext) {
+			sizer := &Sizer{nodeReader: reader, namespaceReader: reader}
+			delete(pod.Annotations, cpuFraction)
+			result, err := sizer.Size(ctx, pod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.patches[0].New.String()).To(Equal("400m"))
+		})
+
+		It("annotates pods without annotations sized through namespace defaults", func(ctx SpecContext) {
+			sizer := New(reader, Options{NamespaceReader: reader})
+			pod.Annotations = nil
+			patched := applyPatch(ctx, sizer, pod)
+			Expect(patched.Spec.Containers[0].Resources.Requests.Cpu().String()).To(Equal("400m"))
+			Expect(patched.Annotations).To(HaveKey(AppliedResourcesAnnotation))
+		})
+
+		It("silently lets the pod win by default", func(ctx SpecContext) {
+			sizer := &Sizer{nodeReader: reader, namespaceReader: reader}
+			result, err := sizer.Size(ctx, pod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.patches[0].New.String()).To(Equal("800m"))
+			Expect(result.warnings).To(BeEmpty())
+		})
+
+		It("warns when asked to", func(ctx SpecContext) {
+			sizer := &Sizer{nodeReader: reader, namespaceReader: reader, conflicts: ConflictsWarn}
+			result, err := sizer.Size(ctx, pod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.warnings).To(ConsistOf(ContainSubstring("namespace/agents")))
+		})
+
+		It("denies in strict mode", func(ctx SpecContext) {
+			sizer := &Sizer{nodeReader: reader, namespaceReader: reader, conflicts: ConflictsDeny}
+			_, err := sizer.Size(ctx, pod)
+			var conflictErr *SettingsConflictError
+			Expect(err).To(BeAssignableToTypeOf(conflictErr))
+		})
+
+		It("applies its own defaults last", func(ctx SpecContext) {
+			sizer := New(reader, Options{Defaults: map[string]string{"request-cpu-fraction": "0.05"}})
+			result, err := sizer.Size(ctx, pod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.patches[0].New.String()).To(Equal("800m"))
+
+			delete(pod.Annotations, cpuFraction)
+			result, err = sizer.Size(ctx, pod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.patches[0].New.String()).To(Equal("200m"))
+		})
+
+		It("annotates pods without annotations sized through its own defaults", func(ctx SpecContext) {
+			sizer := New(reader, Options{Defaults: map[string]string{"request-cpu-fraction": "0.05"}})
+			pod.Annotations = nil
+			patched := applyPatch(ctx, sizer, pod)
+			Expect(patched.Spec.Containers[0].Resources.Requests.Cpu().String()).To(Equal("200m"))
+			Expect(patched.Annotations).To(HaveKey(AppliedResourcesAnnotation))
+		})
+
+		It("reads annotations of another domain", func(ctx SpecContext) {
+			sizer := New(reader, Options{AnnotationDomain: "sizing.example.com"})
+			pod.Annotations = map[string]string{"sizing.example.com/request-cpu-fraction": "0.25"}
+			result, err := sizer.Size(ctx, pod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.patches[0].New.String()).To(Equal("1"))
+		})
+	})
+
+	It("validates defaults", func() {
+		Expect(ValidateDefaults(map[string]string{"request-cpu-fraction": "0.1", "minimum-memory": "1Gi"})).To(Succeed())
+		Expect(ValidateDefaults(map[string]string{"request-cpu-fraction": "2"})).NotTo(Succeed())
+		Expect(ValidateDefaults(map[string]string{"request-gpu-fraction": "0.1"})).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,196 @@
+package sizing
+
+import (
+	"container/list"
+	"fmt"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	corev1 "k8s.io/api/core/v1"
+	"math"
+	"math/big"
+	"sync"
+)
+
+// sizeExpressionCostLimit bounds the evaluation of expressions, which run on every admission
+const sizeExpressionCostLimit = 1000
+
+type sizeExpressionTarget struct {
+	property rps.ResourceProperty
+	resource corev1.ResourceName
+}
+
+// sizeExpressionAnnotations hold CEL expressions computing the pod budget, e.g. "min(node.capacity.cpu * 0.1, 2.0)"
+var sizeExpressionAnnotations = map[string]sizeExpressionTarget{
+	AnnotationPrefix + "cpu-request-expr":    {rps.ResourceRequests, corev1.ResourceCPU},
+	AnnotationPrefix + "cpu-limit-expr":      {rps.ResourceLimits, corev1.ResourceCPU},
+	AnnotationPrefix + "memory-request-expr": {rps.ResourceRequests, corev1.ResourceMemory},
+	AnnotationPrefix + "memory-limit-expr":   {rps.ResourceLimits, corev1.ResourceMemory},
+}
+
+// sizeExpressionEnv exposes the node to expressions as a map: name, labels, and capacity and allocatable by resource,
+// in cores for CPU and bytes for memory
+var sizeExpressionEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("node", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Function("min", cel.Overload("min_double_double", []*cel.Type{cel.DoubleType, cel.DoubleType}, cel.DoubleType,
+			cel.BinaryBinding(func(a, b ref.Val) ref.Val {
+				return types.Double(math.Min(float64(a.(types.Double)), float64(b.(types.Double))))
+			}))),
+		cel.Function("max", cel.Overload("max_double_double", []*cel.Type{cel.DoubleType, cel.DoubleType}, cel.DoubleType,
+			cel.BinaryBinding(func(a, b ref.Val) ref.Val {
+				return types.Double(math.Max(float64(a.(types.Double)), float64(b.(types.Double))))
+			}))),
+	)
+})
+
+// sizeExpressionCacheSize bounds how many compiled expressions are kept, sources come from pod annotations
+const sizeExpressionCacheSize = 256
+
+// sizeExpressionPrograms caches compiled expressions by source, pods of a workload all carry the same ones
+var sizeExpressionPrograms = newProgramCache(sizeExpressionCacheSize)
+
+// programCache keeps compiled expressions, evicting the least recently used one when full. It is safe for
+// concurrent use.
+type programCache struct {
+	lock    sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type programCacheEntry struct {
+	expression string
+	program    cel.Program
+}
+
+func newProgramCache(size int) *programCache {
+	return &programCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *programCache) get(expression string) (cel.Program, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, ok := c.entries[expression]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*programCacheEntry).program, true
+}
+
+func (c *programCache) put(expression string, program cel.Program) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if element, ok := c.entries[expression]; ok {
+		element.Value.(*programCacheEntry).program = program
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[expression] = c.order.PushFront(&programCacheEntry{expression: expression, program: program})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*programCacheEntry).expression)
+	}
+}
+
+func compileSizeExpression(expression string) (cel.Program, error) {
+	if program, ok := sizeExpressionPrograms.get(expression); ok {
+		return program, nil
+	}
+	env, err := sizeExpressionEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expression)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	program, err := env.Program(ast, cel.CostLimit(sizeExpressionCostLimit))
+	if err != nil {
+		return nil, err
+	}
+	sizeExpressionPrograms.put(expression, program)
+	return program, nil
+}
+
+// validateSizeExpressions compiles the expressions found in annotations
+func validateSizeExpressions(annotations map[string]string) error {
+	for key := range sizeExpressionAnnotations {
+		if expression, ok := annotations[key]; ok {
+			if _, err := compileSizeExpression(expression); err != nil {
+				return fmt.Errorf("%s is not a valid expression: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// evaluateSizeExpressions computes the pod budget values the expressions found in annotations give on node, or nil
+// if there are none
+func evaluateSizeExpressions(annotations map[string]string, node *corev1.Node) (*rps.ResourceProperties, error) {
+	var sizes *rps.ResourceProperties
+	for key, target := range sizeExpressionAnnotations {
+		expression, ok := annotations[key]
+		if !ok {
+			continue
+		}
+		program, err := compileSizeExpression(expression)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a valid expression: %w", key, err)
+		}
+		out, _, err := program.Eval(map[string]any{"node": nodeActivation(node)})
+		if err != nil {
+			return nil, fmt.Errorf("could not evaluate %s: %w", key, err)
+		}
+		value, err := sizeFromValue(out)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		if sizes == nil {
+			sizes = rps.New()
+		}
+		sizes.BindPropertyRat(rps.ResourceQuantity, target.property, target.resource, value)
+	}
+	return sizes, nil
+}
+
+func sizeFromValue(value ref.Val) (*big.Rat, error) {
+	var f float64
+	switch v := value.(type) {
+	case types.Double:
+		f = float64(v)
+	case types.Int:
+		f = float64(v)
+	case types.Uint:
+		f = float64(v)
+	default:
+		return nil, fmt.Errorf("expected a number, got %s", value.Type().TypeName())
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) || f < 0 {
+		return nil, fmt.Errorf("expected a positive number, got %v", f)
+	}
+	return new(big.Rat).SetFloat64(f), nil
+}
+
+func nodeActivation(node *corev1.Node) map[string]any {
+	resources := func(list corev1.ResourceList) map[string]any {
+		result := make(map[string]any, len(list))
+		for name, quantity := range list {
+			result[string(name)] = quantity.AsApproximateFloat64()
+		}
+		return result
+	}
+	labels := make(map[string]any, len(node.Labels))
+	for key, value := range node.Labels {
+		labels[key] = value
+	}
+	return map[string]any{
+		"name":        node.Name,
+		"labels":      labels,
+		"capacity":    resources(node.Status.Capacity),
+		"allocatable": resources(node.Status.Allocatable),
+	}
+}
@@ -0,0 +1,67 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Size expressions", Label("SizeExpressions"), func() {
+	small := fixtures.NodeWithCapacity("4", "8G")
+	small.Name = "small"
+	large := fixtures.NodeWithCapacity("64", "256G")
+	large.Name = "large"
+	reader := fake.NewClientBuilder().WithObjects(small, large).Build()
+
+	var pod *corev1.Pod
+	BeforeEach(func() {
+		pod = fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil))
+		pod.Annotations = map[string]string{
+			"node-specific-sizing.manomano.tech/cpu-request-expr":     "min(node.capacity.cpu * 0.1, 2.0)",
+			"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.5",
+		}
+	})
+
+	It("computes the pod budget from the node, over fractions", func(ctx SpecContext) {
+		result, err := (&Sizer{nodeReader: reader}).Size(ctx, fixtures.PinToNode(pod, "small"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.patches[0].New.String()).To(Equal("400m"))
+
+		result, err = (&Sizer{nodeReader: reader}).Size(ctx, fixtures.PinToNode(pod, "large"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.patches[0].New.String()).To(Equal("2"))
+	})
+
+	It("fails sizing on results that are not sizes", func(ctx SpecContext) {
+		pod.Annotations["node-specific-sizing.manomano.tech/cpu-request-expr"] = "node.capacity.cpu * -1.0"
+		_, err := (&Sizer{nodeReader: reader}).Size(ctx, fixtures.PinToNode(pod, "small"))
+		Expect(err).To(MatchError(ContainSubstring("positive")))
+	})
+
+	It("rejects expressions that do not compile", func() {
+		pod.Annotations["node-specific-sizing.manomano.tech/memory-limit-expr"] = "node.capacity.memory *"
+		Expect(ValidateAnnotations(pod.Annotations)).To(MatchError(ContainSubstring("memory-limit-expr")))
+	})
+
+	It("keeps a bounded number of compiled expressions, evicting the least recently used", func() {
+		cache := newProgramCache(2)
+		for _, expression := range []string{"1.0", "2.0"} {
+			program, err := compileSizeExpression(expression)
+			Expect(err).NotTo(HaveOccurred())
+			cache.put(expression, program)
+		}
+		_, _ = cache.get("1.0")
+		program, err := compileSizeExpression("3.0")
+		Expect(err).NotTo(HaveOccurred())
+		cache.put("3.0", program)
+		Expect(cache.entries).To(HaveLen(2))
+		_, ok := cache.get("2.0")
+		Expect(ok).To(BeFalse())
+		_, ok = cache.get("1.0")
+		Expect(ok).To(BeTrue())
+	})
+})
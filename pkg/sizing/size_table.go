@@ -0,0 +1,68 @@
+package sizing
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/apis/v1alpha1"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// sizeTableAnnotation holds a JSON object of pod requests by node label value, e.g. {"m5.large": {"cpu": "250m"}}
+	sizeTableAnnotation = AnnotationPrefix + "size-table"
+
+	// sizeTableLabelAnnotation names the node label sizeTableAnnotation is keyed by
+	sizeTableLabelAnnotation = AnnotationPrefix + "size-table-label"
+)
+
+// sizeTable gives pods fixed requests depending on a label of their node, e.g. its instance type, for workloads that
+// want stepwise sizes per instance class rather than a fraction of every node.
+type sizeTable struct {
+	nodeLabel string
+	sizes     map[string]corev1.ResourceList
+}
+
+// sizeTableFromAnnotations returns the size table of a pod, or nil if it has none
+func sizeTableFromAnnotations(annotations map[string]string) (*sizeTable, error) {
+	raw, hasTable := annotations[sizeTableAnnotation]
+	nodeLabel, hasLabel := annotations[sizeTableLabelAnnotation]
+	if !hasTable && !hasLabel {
+		return nil, nil
+	}
+	if !hasTable || !hasLabel || nodeLabel == "" {
+		return nil, fmt.Errorf("%s and %s go together", sizeTableAnnotation, sizeTableLabelAnnotation)
+	}
+
+	table := &sizeTable{nodeLabel: nodeLabel}
+	if err := json.Unmarshal([]byte(raw), &table.sizes); err != nil {
+		return nil, fmt.Errorf("%s is not a JSON object of resources by label value: %w", sizeTableAnnotation, err)
+	}
+	return table, nil
+}
+
+// sizeTableFromPolicy returns the size table of a policy, or nil if it has none
+func sizeTableFromPolicy(policy *v1alpha1.SizingPolicy) *sizeTable {
+	if policy == nil || policy.Spec.SizeTable == nil {
+		return nil
+	}
+	return &sizeTable{nodeLabel: policy.Spec.SizeTable.NodeLabel, sizes: policy.Spec.SizeTable.Sizes}
+}
+
+// sizesFor returns the pod requests the table gives on node, or nil if the table has no entry for it
+func (t *sizeTable) sizesFor(node *corev1.Node) *rps.ResourceProperties {
+	if t == nil {
+		return nil
+	}
+	value, ok := node.Labels[t.nodeLabel]
+	if !ok {
+		return nil
+	}
+	requests, ok := t.sizes[value]
+	if !ok {
+		return nil
+	}
+	sizes := rps.New()
+	sizes.AddResourceRequirements(&corev1.ResourceRequirements{Requests: requests})
+	return sizes
+}
@@ -0,0 +1,65 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/apis/v1alpha1"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Size tables", Label("SizeTable"), func() {
+	const instanceType = "node.kubernetes.io/instance-type"
+
+	node := func(name, instance string) *corev1.Node {
+		result := fixtures.NodeWithCapacity("4", "8G")
+		result.Name = name
+		result.Labels = map[string]string{instanceType: instance}
+		return result
+	}
+	reader := fake.NewClientBuilder().WithObjects(node("small", "m5.large"), node("other", "c5.large")).Build()
+
+	var pod *corev1.Pod
+	BeforeEach(func() {
+		pod = fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil))
+		pod.Annotations = map[string]string{
+			sizeTableLabelAnnotation: instanceType,
+			sizeTableAnnotation:      `{"m5.large": {"cpu": "250m"}, "m5.4xlarge": {"cpu": "1"}}`,
+			"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.1",
+		}
+	})
+
+	It("takes precedence over fractions on listed nodes", func(ctx SpecContext) {
+		result, err := (&Sizer{nodeReader: reader}).Size(ctx, fixtures.PinToNode(pod, "small"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.patches[0].New.String()).To(Equal("250m"))
+	})
+
+	It("leaves other nodes to fractions", func(ctx SpecContext) {
+		result, err := (&Sizer{nodeReader: reader}).Size(ctx, fixtures.PinToNode(pod, "other"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.patches[0].New.String()).To(Equal("400m"))
+	})
+
+	It("can come from a policy", func() {
+		policy := &v1alpha1.SizingPolicy{Spec: v1alpha1.SizingPolicySpec{SizeTable: &v1alpha1.SizeTable{
+			NodeLabel: instanceType,
+			Sizes:     map[string]corev1.ResourceList{"c5.large": {corev1.ResourceMemory: resource.MustParse("1Gi")}},
+		}}}
+		sizes := sizeTableFromPolicy(policy).sizesFor(node("other", "c5.large"))
+		Expect(fixtures.BoundValue(sizes, rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("==", 1<<30))
+	})
+
+	It("rejects incomplete or malformed tables", func() {
+		delete(pod.Annotations, sizeTableLabelAnnotation)
+		Expect(ValidateAnnotations(pod.Annotations)).To(MatchError(ContainSubstring("go together")))
+
+		pod.Annotations[sizeTableLabelAnnotation] = instanceType
+		pod.Annotations[sizeTableAnnotation] = `{"m5.large": "250m"}`
+		Expect(ValidateAnnotations(pod.Annotations)).To(HaveOccurred())
+	})
+})
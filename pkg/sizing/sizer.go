@@ -0,0 +1,871 @@
+// Package sizing sizes pods according to the node they are bound to, from their sizing annotations, those of their
+// namespace and SizingPolicies. It is what the webhook runs on admission, available to other admission controllers
+// and tools that want to size pods the same way:
+//
+//	sizer := sizing.New(nodeReader, sizing.Options{})
+//	result, patch, err := sizer.CreatePatch(ctx, pod, false)
+//
+// Unlike resource_properties, this package is not yet covered by stability guarantees.
+package sizing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/apis/v1alpha1"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	mapset "github.com/deckarep/golang-set/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"gomodules.xyz/jsonpatch/v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"maps"
+	"math/big"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// computeProportionalResourceRequirements derives the relative requirements of every long-running container that is
+// not excluded from sizing, native sidecars included. Excluded containers do not count towards the totals.
+func computeProportionalResourceRequirements(pod *corev1.Pod, excluded mapset.Set[string]) map[string]*rps.ResourceProperties {
+	containerResources := make(map[string]*rps.ResourceProperties)
+	containerRequirements := make(map[string]*rps.ResourceProperties)
+
+	// Figure out totals first
+	totalAbsoluteResourcesRequirements := rps.New()
+
+	for _, ctn := range longRunningContainers(pod) {
+		if excluded.Contains(ctn.Name) {
+			continue
+		}
+		cr := rps.New()
+		cr.AddResourceRequirements(&ctn.Resources)
+		containerResources[ctn.Name] = cr
+
+		totalAbsoluteResourcesRequirements.AddInPlace(cr)
+	}
+
+	// Then derive proportions by container name
+	for name, cr := range containerResources {
+		containerRequirements[name] = cr.Div(totalAbsoluteResourcesRequirements)
+	}
+
+	return containerRequirements
+}
+
+// spreadUnrequested completes proportions with an even split of the pod budget properties no container has, or only
+// at zero, so that they are not silently left unsized. Containers lacking a property others have get none of it, and
+// containers requesting zero keep zero. Pods without any resources are left as they are, keeping their BestEffort QoS
+// class.
+func spreadUnrequested(proportions map[string]*rps.ResourceProperties, names []string, podResourceBudget *rps.ResourceProperties) map[string]*rps.ResourceProperties {
+	bestEffort := !slices.ContainsFunc(names, func(name string) bool {
+		return len(slices.Collect(proportions[name].All())) > 0
+	})
+	if bestEffort {
+		return proportions
+	}
+
+	unrequested := rps.New()
+	for binding := range podResourceBudget.All() {
+		requested := slices.ContainsFunc(names, func(name string) bool {
+			_, ok := proportions[name].GetRat(binding.Property(), binding.ResourceName())
+			return ok
+		})
+		if !requested {
+			unrequested.Bind(*binding)
+		}
+	}
+	if len(slices.Collect(unrequested.All())) == 0 {
+		return proportions
+	}
+
+	even := computeWeightedResourceRequirements(names, distribution{strategy: distributionEqual}, unrequested)
+	result := make(map[string]*rps.ResourceProperties, len(proportions))
+	for _, name := range names {
+		result[name] = proportions[name].Add(even[name])
+	}
+	return result
+}
+
+// computeExcludedResourceRequirements sums the requirements of long-running containers excluded from sizing
+func computeExcludedResourceRequirements(pod *corev1.Pod, excluded mapset.Set[string]) *rps.ResourceProperties {
+	result := rps.New()
+	for _, ctn := range longRunningContainers(pod) {
+		if excluded.Contains(ctn.Name) {
+			result.AddResourceRequirements(&ctn.Resources)
+		}
+	}
+	return result
+}
+
+// excludedContainers parses the comma-separated list of containers that keep their original resources
+func excludedContainers(annotations map[string]string) mapset.Set[string] {
+	result := mapset.NewThreadUnsafeSet[string]()
+	for _, name := range strings.Split(annotations[ExcludeContainersAnnotation], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			result.Add(name)
+		}
+	}
+	return result
+}
+
+// computePodResourceBudget applies user settings to their basis on the node, node capacity unless set otherwise
+func computePodResourceBudget(userSettings *rps.ResourceProperties, capacity nodeCapacity, node *corev1.Node) *rps.ResourceProperties {
+	podResourceBudget := rps.New()
+	for prop := range userSettings.All() {
+		// Missing bases are warned about before sizing, see basisWarnings
+		if basis, _ := basisOf(prop, capacity, node); basis != nil {
+			budget := new(big.Rat).Mul(basis, prop.Rat())
+			podResourceBudget.BindPropertyRat(rps.ResourceQuantity, prop.Property(), prop.ResourceName(), budget)
+		}
+	}
+	return podResourceBudget
+}
+
+func computePodContainerResourceBudget(
+	containersProportionalResourceRequirements map[string]*rps.ResourceProperties,
+	podResourceBudget *rps.ResourceProperties,
+) map[string]*rps.ResourceProperties {
+	result := make(map[string]*rps.ResourceProperties)
+	for containerName, proportionalResourceRequirements := range containersProportionalResourceRequirements {
+		result[containerName] = proportionalResourceRequirements.Mul(podResourceBudget)
+	}
+	return result
+}
+
+// getNode fetches a single node by name. The cache indexes nodes by name, so unlike listing, this does not copy
+// every node of the cluster on each admission.
+func getNode(ctx context.Context, nodeReader client.Reader, nodeName string) (*corev1.Node, error) {
+	var node corev1.Node
+	if err := nodeReader.Get(ctx, client.ObjectKey{Name: nodeName}, &node); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("cannot find data for node '%s'", nodeName)
+		}
+		return nil, fmt.Errorf("problem fetching node data: %w", err)
+	}
+	return &node, nil
+}
+
+const (
+	// nodeLookupAttempts bounds how many times nodes missing from the cache are read from the API server
+	nodeLookupAttempts = 3
+	// nodeLookupBackoff is the delay before the second attempt, doubled on every other one
+	nodeLookupBackoff = 50 * time.Millisecond
+)
+
+// getNodeOrFallback reads a node from nodeReader, then from apiReader when missing from it, e.g. nodes that just joined
+// and are not cached yet. API reads are retried a few times, admission requests having to complete quickly.
+func getNodeOrFallback(ctx context.Context, nodeReader, apiReader client.Reader, nodeName string) (*corev1.Node, error) {
+	node, err := getNode(ctx, nodeReader, nodeName)
+	if err == nil || apiReader == nil {
+		return node, err
+	}
+	backoff := nodeLookupBackoff
+	for attempt := 1; ; attempt++ {
+		if node, err = getNode(ctx, apiReader, nodeName); err == nil {
+			nodeCacheMissesTotal.WithLabelValues("found").Inc()
+			return node, nil
+		}
+		if attempt == nodeLookupAttempts {
+			nodeCacheMissesTotal.WithLabelValues("not-found").Inc()
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			nodeCacheMissesTotal.WithLabelValues("not-found").Inc()
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// NodeUnavailableError is returned when the node of a pod cannot be resolved or read, and there are no fallback
+// requests to size it with
+type NodeUnavailableError struct {
+	err error
+}
+
+func (e *NodeUnavailableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *NodeUnavailableError) Unwrap() error {
+	return e.err
+}
+
+// resolveNode tells which node a pod is bound to, and reads it, within its share of the deadline of ctx. Pods sized
+// for their topology domain get a node standing for it instead.
+func (s *Sizer) resolveNode(ctx context.Context, pod *corev1.Pod, topology *topologySettings) (string, *corev1.Node, error) {
+	lookupCtx, budget, cancel := withStepBudget(ctx, nodeLookupShare)
+	defer cancel()
+	lookup := s.lookupNode
+	if topology != nil {
+		lookup = func(ctx context.Context, pod *corev1.Pod) (string, *corev1.Node, error) {
+			return s.resolveTopology(ctx, pod, topology)
+		}
+	}
+	nodeName, node, err := lookup(lookupCtx, pod)
+	return nodeName, node, overBudget(lookupCtx, StepNodeLookup, budget, err)
+}
+
+func (s *Sizer) lookupNode(ctx context.Context, pod *corev1.Pod) (string, *corev1.Node, error) {
+	nodeName, err := s.nodeResolvers.Resolve(ctx, pod)
+	if err != nil && s.candidates == CandidatesBound {
+		if names := candidateNodeNames(pod); len(names) > 1 {
+			return "", nil, &CandidatesPendingError{Candidates: names}
+		}
+	} else if err != nil && s.candidates != CandidatesNone {
+		// Pods that may land on several nodes are sized for one of them
+		node, candidateErr := chooseCandidate(ctx, s.nodeReader, s.nodeAPIReader, pod, s.candidates)
+		if candidateErr == nil {
+			return node.Name, node, nil
+		}
+		err = errors.Join(err, fmt.Errorf("candidates: %w", candidateErr))
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("problem getting node name: %w", err)
+	}
+	node, err := getNodeOrFallback(ctx, s.nodeReader, s.nodeAPIReader, nodeName)
+	if err != nil {
+		return "", nil, err
+	}
+	return nodeName, node, nil
+}
+
+// fallbackRequests returns the pod requests used when the node of a pod is unavailable, those of its policy taking
+// precedence over those of the Sizer
+func fallbackRequests(policy *v1alpha1.SizingPolicy, defaults corev1.ResourceList) corev1.ResourceList {
+	if policy != nil && len(policy.Spec.FallbackRequests) > 0 {
+		return policy.Spec.FallbackRequests
+	}
+	return defaults
+}
+
+// ContainerFloorSource provides, by container name, minimums that apply to a single container
+type ContainerFloorSource interface {
+	Floors(pod *corev1.Pod, nodeName string) map[string]*rps.ResourceProperties
+}
+
+// ContainerFloorMatcher may be implemented by a ContainerFloorSource to tell, before the node of a pod is known,
+// whether it may have floors at all. Sources that do not implement it are assumed to have floors for every pod.
+type ContainerFloorMatcher interface {
+	MayHaveFloors(pod *corev1.Pod) bool
+}
+
+// mayHaveUsageFloors tells whether usage floors may apply to the pod, whatever its node
+func (s *Sizer) mayHaveUsageFloors(pod *corev1.Pod) bool {
+	if s.usageFloors == nil {
+		return false
+	}
+	matcher, ok := s.usageFloors.(ContainerFloorMatcher)
+	return !ok || matcher.MayHaveFloors(pod)
+}
+
+// Options holds the optional dependencies of a Sizer. The zero value sizes pods from their own annotations only.
+type Options struct {
+	// PolicyReader reads SizingPolicies, which are not used when nil
+	PolicyReader client.Reader
+	// NamespaceReader reads namespaces, whose annotations then hold defaults for their pods
+	NamespaceReader client.Reader
+	// UsageFloors keeps containers from being sized below some minimum, e.g. their observed usage
+	UsageFloors ContainerFloorSource
+	// Conflicts tells what to do with sources disagreeing on a setting, ConflictsIgnore when empty
+	Conflicts ConflictMode
+	// NodeAPIReader reads nodes missing from the node reader of the Sizer, usually straight from the API server, as
+	// nodes that just joined may not be cached yet. Missing nodes fail sizing when nil.
+	NodeAPIReader client.Reader
+	// NodeResolvers tell which node a pod is bound to, all built-in resolvers when nil
+	NodeResolvers NodeResolverChain
+	// Candidates tells which node pods are sized for when no resolver can tell a single one, but their node affinity
+	// lists several, CandidatesNone when empty
+	Candidates CandidateChoice
+	// FallbackRequests are the pod requests of pods whose node cannot be resolved or read, which then fail sizing
+	// when empty. The fallback requests of a SizingPolicy take precedence over them.
+	FallbackRequests corev1.ResourceList
+	// Committed makes pods sized against what other pods leave free on their node, see NewCommittedResources
+	Committed CommittedSource
+	// QuotaReader reads ResourceQuotas, which are taken into account according to Quotas
+	QuotaReader client.Reader
+	// Quotas tells what to do with pods that sizing would bring past their namespace quotas, QuotasIgnore when empty
+	// or without QuotaReader
+	Quotas QuotaMode
+	// LimitRangeReader reads LimitRanges, sized values they would reject are then returned as warnings
+	LimitRangeReader client.Reader
+	// VPAReader reads VerticalPodAutoscalers, which are taken into account according to VPAs
+	VPAReader client.Reader
+	// VPAs tells what to do with pods a VerticalPodAutoscaler also sizes, VPAIgnore when empty or without VPAReader
+	VPAs VPAMode
+	// Defaults hold sizing settings that apply to every pod, with the lowest precedence. They are keyed by annotation
+	// name without AnnotationPrefix, e.g. request-cpu-fraction.
+	Defaults map[string]string
+	// AnnotationDomain, when set, is read like the domain of AnnotationPrefix on pod and namespace annotations, e.g.
+	// sizing.example.com/request-cpu-fraction. Annotations the webhook writes keep AnnotationPrefix.
+	AnnotationDomain string
+	// DeductPodOverhead takes the overhead RuntimeClasses set on pods, e.g. for Kata or gVisor, out of the pod budget
+	DeductPodOverhead bool
+	// NodeSnapshots keeps node capacities parsed, rather than parsing them on every admission, optional
+	NodeSnapshots *NodeSnapshots
+	// ResultCacheSize is the number of results of pods created from a template kept for the next pods of the same
+	// template on the same node, 0 disabling the cache. Pods sized from what changes with every pod, committed
+	// resources, usage floors or quotas, are never cached.
+	ResultCacheSize int
+}
+
+// Sizer sizes pods according to the node they are bound to. It is safe for concurrent use.
+type Sizer struct {
+	nodeReader client.Reader
+	// nodeAPIReader is optional, it reads nodes missing from nodeReader
+	nodeAPIReader client.Reader
+	// policyReader is nil when policies are not available
+	policyReader client.Reader
+	// usageFloors is optional
+	usageFloors ContainerFloorSource
+	// namespaceReader is optional, namespaces hold defaults for their pods
+	namespaceReader client.Reader
+	conflicts       ConflictMode
+	// nodeResolvers tell which node a pod is bound to, defaultNodeResolvers when nil
+	nodeResolvers NodeResolverChain
+	candidates    CandidateChoice
+	// fallback holds the pod requests of pods whose node is unavailable, optional
+	fallback corev1.ResourceList
+	// committed is optional, pods are then sized against what other pods leave free on their node
+	committed CommittedSource
+	// quotaReader is optional, quotas are then taken into account according to quotas
+	quotaReader client.Reader
+	quotas      QuotaMode
+	// limitRangeReader is optional, sized values LimitRanges would reject are then warned about
+	limitRangeReader client.Reader
+	// vpaReader is optional, VerticalPodAutoscalers are then taken into account according to vpas
+	vpaReader client.Reader
+	vpas      VPAMode
+	// defaults are keyed by full annotation name
+	defaults          map[string]string
+	annotationDomain  string
+	deductPodOverhead bool
+	// results is optional
+	results *resultCache
+	// nodeSnapshots is optional
+	nodeSnapshots *NodeSnapshots
+}
+
+// New returns a Sizer reading nodes from nodeReader, which is best backed by a cache
+func New(nodeReader client.Reader, options Options) *Sizer {
+	var results *resultCache
+	if options.ResultCacheSize > 0 {
+		results = newResultCache(options.ResultCacheSize)
+	}
+	return &Sizer{
+		nodeReader:        nodeReader,
+		nodeAPIReader:     options.NodeAPIReader,
+		policyReader:      options.PolicyReader,
+		usageFloors:       options.UsageFloors,
+		namespaceReader:   options.NamespaceReader,
+		conflicts:         options.Conflicts,
+		nodeResolvers:     options.NodeResolvers,
+		candidates:        options.Candidates,
+		fallback:          options.FallbackRequests,
+		committed:         options.Committed,
+		quotaReader:       options.QuotaReader,
+		quotas:            options.Quotas,
+		limitRangeReader:  options.LimitRangeReader,
+		vpaReader:         options.VPAReader,
+		vpas:              options.VPAs,
+		defaults:          prefixedDefaults(options.Defaults),
+		annotationDomain:  options.AnnotationDomain,
+		deductPodOverhead: options.DeductPodOverhead,
+		results:           results,
+		nodeSnapshots:     options.NodeSnapshots,
+	}
+}
+
+// Size runs the sizing engine against a pod, without rendering anything. Settings conflicts the Sizer is configured to
+// deny are reported as a *SettingsConflictError, settings loosening those a policy enforces as an
+// *EnforcedSettingsError when it denies them.
+func (s *Sizer) Size(ctx context.Context, pod *corev1.Pod) (result *Result, err error) {
+	ctx, span := tracer.Start(ctx, "Size", trace.WithAttributes(
+		attribute.String("namespace", pod.Namespace),
+		attribute.Int("containers", len(pod.Spec.Containers))))
+	defer func() { endSpan(span, err) }()
+
+	result, err = s.size(ctx, pod)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.String("node", result.nodeName), attribute.Int("patches", len(result.patches)))
+	return result, nil
+}
+
+func (s *Sizer) size(ctx context.Context, pod *corev1.Pod) (*Result, error) {
+	logger := LoggerFromContext(ctx)
+	logger.Debug("Starting patch process")
+
+	policy, err := resolvePolicy(ctx, s.policyReader, pod)
+	if err != nil {
+		return nil, err
+	}
+
+	defaults, err := namespaceDefaults(ctx, s.namespaceReader, pod.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	podAnnotations, err := withConfigAnnotation(withAnnotationDomain(pod.Annotations, s.annotationDomain))
+	if err != nil {
+		return nil, invalidAnnotations(err)
+	}
+	// Pods nothing asks to size are left alone before looking their node up, broad webhook selectors catching many
+	if policy == nil && !s.mayHaveUsageFloors(pod) && !hasSizingSettings(podAnnotations) &&
+		!hasSizingSettings(withAnnotationDomain(defaults, s.annotationDomain)) && !hasSizingSettings(s.defaults) {
+		unconfiguredPodsTotal.Inc()
+		return &Result{unconfigured: true}, nil
+	}
+
+	var conflictingVPA string
+	if s.vpaReader != nil && s.vpas != VPAIgnore && s.vpas != "" {
+		if conflictingVPA, err = vpaConflict(ctx, s.vpaReader, pod); err != nil {
+			return nil, err
+		}
+		if conflictingVPA != "" {
+			logger.Debug("Pod is also sized by a VerticalPodAutoscaler", zap.String("conflict", conflictingVPA), zap.String("mode", string(s.vpas)))
+			vpaConflictsTotal.WithLabelValues(string(s.vpas)).Inc()
+		}
+	}
+
+	topology, err := topologyFromAnnotations(podAnnotations)
+	if err != nil {
+		return nil, invalidAnnotations(err)
+	}
+	var warnings []string
+	nodeName, node, err := s.resolveNode(ctx, pod, topology)
+	// Pods whose node is unavailable get fallback requests rather than being refused, so that e.g. a DaemonSet rollout
+	// is not blocked by a node briefly unreadable. They are sized as if for a node without capacity.
+	fallback := fallbackRequests(policy, s.fallback)
+	var pendingErr *CandidatesPendingError
+	if errors.As(err, &pendingErr) {
+		return nil, err
+	}
+	if err != nil {
+		if len(fallback) == 0 {
+			return nil, &NodeUnavailableError{err: err}
+		}
+		logger.Warn("Sizing with fallback requests", zap.Error(err))
+		nodeFallbacksTotal.Inc()
+		warnings = append(warnings, fmt.Sprintf("sized with fallback requests: %s", err))
+		nodeName, node = "", &corev1.Node{}
+	}
+	// Policies may leave pods alone on the nodes they do not select, or of an operating system, e.g. for DaemonSets of
+	// mixed clusters. Nodes of fallback requests have no labels nor taints.
+	selected, err := policySelectsNode(policy, node)
+	if err != nil {
+		return nil, err
+	}
+	nodeOS := operatingSystemOf(pod, node)
+	osSettings := osSettingsFor(policy, nodeOS)
+	if !selected || osSettings != nil && osSettings.Exclude {
+		unconfiguredPodsTotal.Inc()
+		return &Result{unconfigured: true}, nil
+	}
+
+	// Results only depend on the pod, its settings and its node then, cacheKey is empty otherwise. Nodes standing for a
+	// topology domain have no version to tell when the domain changed.
+	var cacheKey string
+	if s.results != nil && nodeName != "" && topology == nil && s.committed == nil && s.usageFloors == nil && conflictingVPA == "" &&
+		(s.quotaReader == nil || s.quotas == QuotasIgnore || s.quotas == "") {
+		if key, ok := resultCacheKey(pod, podAnnotations, defaults, node, policy); ok {
+			if result, ok := s.results.get(key); ok {
+				s.warnAboutLimitRanges(ctx, pod.Namespace, result)
+				return result, nil
+			}
+			cacheKey = key
+		}
+	}
+
+	var committed corev1.ResourceList
+	if s.committed != nil && nodeName != "" && topology == nil {
+		if committed, err = s.committed.Committed(ctx, pod, nodeName); err != nil {
+			return nil, err
+		}
+		node.Status.Capacity = freeCapacity(node.Status.Capacity, committed)
+		if node.Status.Allocatable != nil {
+			node.Status.Allocatable = freeCapacity(node.Status.Allocatable, committed)
+		}
+	}
+
+	// Free capacity changes with every pod, the pipeline parses it
+	var capacity nodeCapacity
+	if s.nodeSnapshots != nil && s.committed == nil && nodeName != "" && topology == nil {
+		capacity = s.nodeSnapshots.capacity(node)
+	}
+
+	fractionSet, err := fractionSetFor(policy, node)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pods sized before, e.g. when the webhook is called again, are sized from their original resources rather than from
+	// sized ones, so that sizing does not compound. Patches still apply to the resources the pod has.
+	current := pod
+	originals, err := OriginalResourcesFromAnnotations(pod.Annotations)
+	if err != nil {
+		return nil, invalidAnnotations(err)
+	}
+	pod = withOriginalResources(pod, originals)
+
+	// Pod annotations take precedence over namespace defaults, which take precedence over the policy, then over the
+	// defaults of the Sizer
+	sources := []settingsSource{{name: "pod", annotations: podAnnotations}}
+	if defaults != nil {
+		sources = append(sources, settingsSource{name: "namespace/" + pod.Namespace, annotations: withAnnotationDomain(defaults, s.annotationDomain)})
+	}
+	enforced, err := enforcedAnnotations(policy)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		settings := policyAnnotations(policy, fractionSet)
+		maps.Copy(settings, osAnnotations(osSettings))
+		sources = append(sources, settingsSource{name: "policy/" + policy.Name, annotations: withEnforced(settings, enforced)})
+	}
+	if s.defaults != nil {
+		sources = append(sources, settingsSource{name: "config", annotations: s.defaults})
+	}
+	annotations, conflicts := mergeSettings(sources)
+	if len(enforced) > 0 {
+		// Pods and namespaces may tighten enforced settings, which is what enforcing them is for
+		conflicts = slices.DeleteFunc(conflicts, func(conflict settingConflict) bool {
+			_, isEnforced := enforced[conflict.Key]
+			return isEnforced
+		})
+		if violations := enforce(annotations, sources, enforced, policy.Name); len(violations) > 0 {
+			if policy.Spec.Enforced.Action == v1alpha1.EnforcementDeny {
+				return nil, &EnforcedSettingsError{violations: violations}
+			}
+			logger.Warn("Sizing settings loosen enforced ones", zap.Any("violations", violations))
+			for _, violation := range violations {
+				warnings = append(warnings, violation.String()+", the enforced setting applies")
+			}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		switch s.conflicts {
+		case ConflictsDeny:
+			return nil, &SettingsConflictError{conflicts: conflicts}
+		case ConflictsWarn:
+			logger.Warn("Conflicting sizing settings", zap.Any("conflicts", conflicts))
+			for _, conflict := range conflicts {
+				warnings = append(warnings, conflict.String())
+			}
+		}
+	}
+
+	err, userSettings := rps.NewFromAnnotations(annotations)
+	if err != nil {
+		return nil, invalidAnnotations(fmt.Errorf("problem parsing annotations: %w", err))
+	}
+	// NewFromAnnotations refused invalid values already
+	allowOvercommit, _ := strconv.ParseBool(annotations[rps.AllowOvercommitAnnotation])
+	if nodeName != "" {
+		warnings = append(warnings, basisWarnings(userSettings, node)...)
+	}
+
+	// See sizingStages for the order in which the pod budget is derived from the node, clamped and spread
+	// between containers.
+	in := sizingInput{
+		userSettings:    userSettings,
+		node:            node,
+		capacity:        capacity,
+		pod:             pod,
+		excluded:        excludedContainers(podAnnotations),
+		deductOverhead:  s.deductPodOverhead,
+		allowOvercommit: allowOvercommit,
+	}
+	if in.distribution, err = distributionFromAnnotations(podAnnotations); err != nil {
+		return nil, invalidAnnotations(err)
+	}
+	if in.distribution.strategy == distributionPrimary {
+		if !slices.ContainsFunc(longRunningContainers(pod), func(ctn podContainer) bool { return ctn.Name == in.distribution.primary }) ||
+			in.excluded.Contains(in.distribution.primary) {
+			return nil, invalidAnnotations(fmt.Errorf("primary container %s is not a sized container of the pod", in.distribution.primary))
+		}
+	}
+	resizePolicies, err := resizePoliciesFromAnnotations(podAnnotations)
+	if err != nil {
+		return nil, invalidAnnotations(err)
+	}
+	exposeBudget, err := exposeBudgetFromAnnotations(podAnnotations)
+	if err != nil {
+		return nil, invalidAnnotations(err)
+	}
+	runtimes, err := runtimesFromAnnotations(podAnnotations)
+	if err != nil {
+		return nil, invalidAnnotations(err)
+	}
+	driftAction := driftActionFor(policy)
+	// Windows pods cannot be resized in place
+	if nodeOS == corev1.Windows {
+		resizePolicies = nil
+		if driftAction == v1alpha1.DriftActionResize {
+			driftAction = v1alpha1.DriftActionReport
+		}
+	}
+	table, err := sizeTableFromAnnotations(podAnnotations)
+	if err != nil {
+		return nil, invalidAnnotations(err)
+	}
+	if table == nil {
+		table = sizeTableFromPolicy(policy)
+	}
+	in.podSizes = table.sizesFor(node)
+	// Expressions take precedence over the table
+	var computed *rps.ResourceProperties
+	if nodeName != "" {
+		if computed, err = evaluateSizeExpressions(podAnnotations, node); err != nil {
+			return nil, invalidAnnotations(err)
+		}
+	} else {
+		computed = rps.New()
+		computed.AddResourceRequirements(&corev1.ResourceRequirements{Requests: fallback})
+	}
+	if computed != nil {
+		if in.podSizes == nil {
+			in.podSizes = rps.New()
+		}
+		for binding := range computed.All() {
+			in.podSizes.Bind(*binding)
+		}
+	}
+	if in.containerClamps, err = containerClampsFromAnnotations(podAnnotations); err != nil {
+		return nil, invalidAnnotations(err)
+	}
+	if s.usageFloors != nil {
+		in.containerClamps = mergeContainerClamps(in.containerClamps, s.usageFloors.Floors(pod, nodeName))
+	}
+	if s.quotaReader != nil && (s.quotas == QuotasClamp || s.quotas == QuotasSkip) {
+		if in.quotaHeadroom, err = quotaHeadroom(ctx, s.quotaReader, pod); err != nil {
+			return nil, err
+		}
+	}
+	containersResourceBudget, trace := runSizingPipeline(in)
+
+	logger.Debug("containersResourceBudget", zap.Any("cPCRB", containersResourceBudget), zap.Any("trace", trace))
+
+	result := &Result{
+		nodeName:       nodeName,
+		nodeCapacity:   node.Status.Capacity,
+		committed:      committed,
+		trace:          trace,
+		status:         statusSettingsFor(policy),
+		driftAction:    driftAction,
+		resizePolicies: resizePolicies,
+		exposeBudget:   exposeBudget,
+		runtimes:       runtimes,
+		warnings:       warnings,
+		original:       make([]containerResources, 0, len(pod.Spec.InitContainers)+len(pod.Spec.Containers)),
+	}
+	// Sized containers would not fit in what quotas leave, they keep their resources
+	if s.quotas == QuotasSkip && trace.Adjusted(stageQuotaCap) {
+		result.quotaSkipped = quotaSkippedMessage(trace)
+		result.warnings = append(result.warnings, result.quotaSkipped)
+		containersResourceBudget = nil
+	} else {
+		result.warnings = append(result.warnings, trace.Warnings()...)
+	}
+	if conflictingVPA != "" {
+		result.vpaConflict = conflictingVPA
+		switch s.vpas {
+		case VPASkip:
+			// The VerticalPodAutoscaler is left in charge of resources
+			result.warnings = append(result.warnings, conflictingVPA+", resources are left to it")
+			containersResourceBudget = nil
+		case VPAWarn:
+			result.warnings = append(result.warnings, conflictingVPA)
+		}
+	}
+	for _, ctn := range longRunningContainers(pod) {
+		result.original = append(result.original, containerResources{name: ctn.Name, resources: *ctn.Resources.DeepCopy()})
+		budget, sized := containersResourceBudget[ctn.Name]
+		if !sized {
+			continue
+		}
+		for binding := range budget.All() {
+			if osExcludes(nodeOS, osSettings, binding.ResourceName()) {
+				continue
+			}
+			result.patches = append(result.patches, ResourcePatch{
+				ContainerIndex: ctn.index,
+				ContainerName:  ctn.Name,
+				Sidecar:        ctn.sidecar,
+				Property:       binding.Property(),
+				Resource:       binding.ResourceName(),
+				Old:            originalQuantity(ctn.containerIn(current), binding.Property(), binding.ResourceName()),
+				New:            binding.Quantity(),
+			})
+		}
+	}
+	sortResourcePatches(result.patches)
+
+	// LimitRanges are not part of the key, they are checked on every pod
+	if cacheKey != "" {
+		s.results.put(cacheKey, result)
+	}
+	s.warnAboutLimitRanges(ctx, pod.Namespace, result)
+	return result, nil
+}
+
+// warnAboutLimitRanges adds warnings about sized values LimitRanges would reject
+func (s *Sizer) warnAboutLimitRanges(ctx context.Context, namespace string, result *Result) {
+	if s.limitRangeReader == nil {
+		return
+	}
+	// Warnings are not worth failing admission for
+	if limitWarnings, err := limitRangeWarnings(ctx, s.limitRangeReader, namespace, result); err == nil {
+		result.warnings = append(result.warnings, limitWarnings...)
+	} else {
+		LoggerFromContext(ctx).Warn("Could not check sizing against limit ranges", zap.Error(err))
+	}
+}
+
+// renderJSONPatch is the final step of the patch process, turning sizing decisions into what the apiserver expects
+func renderJSONPatch(ctx context.Context, pod *corev1.Pod, result *Result) []jsonpatch.JsonPatchOperation {
+	logger := LoggerFromContext(ctx)
+	var patch []jsonpatch.JsonPatchOperation
+
+	// Patches are ordered by container, so objects missing from a container can be added right before its first patch.
+	// Container names are unique within a pod, init containers included.
+	patchedProps := make(map[string]mapset.Set[rps.ResourceProperty], len(result.original))
+	for resourcePatch := range result.Patches() {
+		if _, ok := patchedProps[resourcePatch.ContainerName]; !ok {
+			patchedProps[resourcePatch.ContainerName] = mapset.NewThreadUnsafeSet[rps.ResourceProperty]()
+		}
+		patchedProps[resourcePatch.ContainerName].Add(resourcePatch.Property)
+	}
+
+	// Environment variables are set from final limits, of pods being created only
+	var finalLimits map[string]corev1.ResourceList
+	if len(result.runtimes) > 0 && pod.UID == "" {
+		finalLimits = make(map[string]corev1.ResourceList, len(result.original))
+		for _, report := range result.containerReports() {
+			finalLimits[report.Name] = report.Final.Limits
+		}
+	}
+
+	for resourcePatch := range result.Patches() {
+		// Dry runs leave resources alone, only the status annotation tells what would have been done
+		if result.dryRun {
+			break
+		}
+		if props, ok := patchedProps[resourcePatch.ContainerName]; ok {
+			ctn := podContainer{index: resourcePatch.ContainerIndex, sidecar: resourcePatch.Sidecar}.containerIn(pod)
+			path := containerPath(resourcePatch.Sidecar, resourcePatch.ContainerIndex)
+			patch = append(patch, missingResourceObjects(path, ctn, props)...)
+			// Pods being created have no UID yet, resize policies of existing ones cannot change
+			if policies, changed := withResizePolicies(ctn, result.resizePolicies); changed && pod.UID == "" {
+				patch = append(patch, jsonpatch.NewOperation("add", path+"/resizePolicy", policies))
+			}
+			if limits, ok := finalLimits[resourcePatch.ContainerName]; ok {
+				if env, changed := withRuntimeEnv(ctn, result.runtimes, limits); changed {
+					patch = append(patch, jsonpatch.NewOperation("add", path+"/env", env))
+				}
+			}
+			delete(patchedProps, resourcePatch.ContainerName)
+		}
+		patch = append(patch, resourcePatch.JsonPatch())
+	}
+
+	if len(result.patches) > 0 {
+		logger.Debug("concluding patch process", zap.Int("patches", len(patch)))
+		patch = append(patch, renderAnnotations(ctx, result)...)
+		// Pods asked for their budget, which is not a status report, whatever their status settings
+		if result.exposeBudget && !result.dryRun {
+			budgets := budgetAnnotations(result)
+			for _, key := range slices.Sorted(maps.Keys(budgets)) {
+				patch = append(patch, jsonpatch.NewOperation("add", AnnotationJsonPath(key), budgets[key]))
+			}
+		}
+	} else if result.quotaSkipped != "" {
+		logger.Debug("concluding patch process without resource patches, resource quotas do not leave enough room")
+		patch = append(patch, jsonpatch.NewOperation("add", AnnotationJsonPath(QuotaSkippedAnnotation), result.quotaSkipped))
+	} else {
+		logger.Debug("concluding patch process without creating a single patch")
+	}
+	if result.vpaConflict != "" {
+		patch = append(patch, jsonpatch.NewOperation("add", AnnotationJsonPath(VPAConflictAnnotation), result.vpaConflict))
+	}
+	// Pods sized through defaults may have no annotations at all, there is no map to add ours to
+	if pod.Annotations == nil {
+		patch = withAnnotationsMap(patch)
+	}
+
+	return patch
+}
+
+// withAnnotationsMap adds an empty annotations map before the first operation adding an annotation
+func withAnnotationsMap(patch []jsonpatch.JsonPatchOperation) []jsonpatch.JsonPatchOperation {
+	i := slices.IndexFunc(patch, func(op jsonpatch.JsonPatchOperation) bool {
+		return strings.HasPrefix(op.Path, AnnotationJsonPath(""))
+	})
+	if i < 0 {
+		return patch
+	}
+	return slices.Insert(patch, i, jsonpatch.NewOperation("add", "/metadata/annotations", map[string]string{}))
+}
+
+// renderAnnotations writes the annotations recording what sizing did, according to the status settings
+func renderAnnotations(ctx context.Context, result *Result) []jsonpatch.JsonPatchOperation {
+	logger := LoggerFromContext(ctx)
+	var patch []jsonpatch.JsonPatchOperation
+
+	if result.status.verbosity == v1alpha1.StatusVerbosityNone {
+		return nil
+	}
+	report, err := json.Marshal(result.report(result.status.verbosity == v1alpha1.StatusVerbosityFull))
+	if err != nil {
+		logger.Warn("Could not render status", zap.Error(err))
+	}
+
+	// Dry runs apply nothing, there is no drift to detect
+	if !result.dryRun {
+		if applied, err := json.Marshal(appliedResourcesOf(result)); err == nil {
+			patch = append(patch, jsonpatch.NewOperation("add", AnnotationJsonPath(AppliedResourcesAnnotation), string(applied)))
+		} else {
+			logger.Warn("Could not record applied resources, drift will go unnoticed", zap.Error(err))
+		}
+		if originals, err := json.Marshal(originalResourcesOf(result)); err == nil {
+			patch = append(patch, jsonpatch.NewOperation("add", AnnotationJsonPath(OriginalResourcesAnnotation), string(originals)))
+		} else {
+			logger.Warn("Could not record original resources", zap.Error(err))
+		}
+	}
+	if report != nil {
+		patch = append(patch, jsonpatch.NewOperation("add", AnnotationJsonPath(result.status.key), string(report)))
+	}
+
+	return patch
+}
+
+// CreatePatch sizes a pod and renders the JSONPatch for it, annotations recording what was done included. Dry runs only patch the status annotation.
+func (s *Sizer) CreatePatch(ctx context.Context, pod *corev1.Pod, dryRun bool) (result *Result, patch []jsonpatch.JsonPatchOperation, err error) {
+	ctx, span := tracer.Start(ctx, "CreatePatch", trace.WithAttributes(attribute.Bool("dryRun", dryRun)))
+	defer func() { endSpan(span, err) }()
+
+	result, err = s.Size(ctx, pod)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Rendering is not worth starting without time left to answer
+	if err := ctx.Err(); err != nil {
+		budgetsExceededTotal.WithLabelValues(StepPatching).Inc()
+		return nil, nil, &BudgetExceededError{Step: StepPatching, err: err}
+	}
+	result.dryRun = dryRun
+	patch = renderJSONPatch(ctx, pod, result)
+	span.SetAttributes(attribute.Int("operations", len(patch)))
+	return result, patch, nil
+}
@@ -0,0 +1,335 @@
+package sizing
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/apis/v1alpha1"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+var _ = Describe("Sizing a pod", Label("PodPatcher"), func() {
+	node := fixtures.NodeWithCapacity("4", "8G")
+	node.Name = "node-a"
+	sizer := &Sizer{nodeReader: fake.NewClientBuilder().WithObjects(node).Build()}
+
+	var pod *corev1.Pod
+	BeforeEach(func() {
+		pod = fixtures.PinToNode(fixtures.PodWithContainers(
+			fixtures.ContainerWithResources("a",
+				corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("100M")},
+				corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("200M")}),
+			fixtures.ContainerWithResources("b",
+				corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("300m"), corev1.ResourceMemory: resource.MustParse("300M")},
+				corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("600M")}),
+		), "node-a")
+		pod.ObjectMeta = metav1.ObjectMeta{Annotations: map[string]string{
+			"node-specific-sizing.manomano.tech/request-cpu-fraction":    "0.1",
+			"node-specific-sizing.manomano.tech/request-memory-fraction": "0.1",
+		}}
+	})
+
+	It("exposes typed patches in a stable order", func(ctx SpecContext) {
+		result, err := sizer.Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.nodeName).To(Equal("node-a"))
+
+		var patches []ResourcePatch
+		for patch := range result.Patches() {
+			patches = append(patches, patch)
+		}
+		Expect(patches).To(HaveLen(4))
+
+		Expect(patches[0].ContainerName).To(Equal("a"))
+		Expect(patches[0].Property).To(Equal(rps.ResourceRequests))
+		Expect(patches[0].Resource).To(Equal(corev1.ResourceCPU))
+		Expect(patches[0].Old.String()).To(Equal("100m"))
+		Expect(patches[0].New.String()).To(Equal("100m"))
+
+		Expect(patches[3].ContainerName).To(Equal("b"))
+		Expect(patches[3].Resource).To(Equal(corev1.ResourceMemory))
+		Expect(patches[3].New.String()).To(Equal("600M"))
+	})
+
+	It("renders JSONPatch as a final step", func(ctx SpecContext) {
+		_, patch, err := sizer.CreatePatch(ctx, pod, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patch).To(HaveLen(7))
+		Expect(patch[0].Operation).To(Equal("replace"))
+		Expect(patch[0].Path).To(Equal("/spec/containers/0/resources/requests/cpu"))
+		Expect(patch[4].Path).To(Equal("/metadata/annotations/node-specific-sizing.manomano.tech~1applied-resources"))
+		Expect(patch[5].Path).To(Equal("/metadata/annotations/node-specific-sizing.manomano.tech~1original-resources"))
+		Expect(patch[6].Path).To(Equal("/metadata/annotations/node-specific-sizing.manomano.tech~1status"))
+	})
+
+	It("reports what it did in the status annotation", func(ctx SpecContext) {
+		_, patch, err := sizer.CreatePatch(ctx, pod, false)
+		Expect(err).NotTo(HaveOccurred())
+
+		var report sizingReport
+		Expect(json.Unmarshal([]byte(patch[6].Value.(string)), &report)).To(Succeed())
+		Expect(report.Node).To(Equal("node-a"))
+		Expect(report.NodeCapacity.Cpu().String()).To(Equal("4"))
+		Expect(report.MinMaxClamped).To(BeFalse())
+		Expect(report.Trace).To(BeNil())
+		Expect(report.Containers).To(HaveLen(2))
+		Expect(report.Containers[1].Name).To(Equal("b"))
+		Expect(report.Containers[1].Original.Requests.Memory().String()).To(Equal("300M"))
+		Expect(report.Containers[1].Final.Requests.Memory().String()).To(Equal("600M"))
+		Expect(report.Containers[1].Final.Limits.Memory().String()).To(Equal("600M"))
+	})
+
+	It("sizes pods sized before from their original resources", func(ctx SpecContext) {
+		_, patch, err := sizer.CreatePatch(ctx, pod, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patch[5].Value).To(MatchJSON(`{
+			"a": {"requests": {"cpu": "100m", "memory": "100M"}, "limits": {"memory": "200M"}},
+			"b": {"requests": {"cpu": "300m", "memory": "300M"}, "limits": {"memory": "600M"}}
+		}`))
+
+		// As if the webhook was called again on the sized pod
+		pod.Annotations[OriginalResourcesAnnotation] = patch[5].Value.(string)
+		pod.Spec.Containers[1].Resources.Requests[corev1.ResourceMemory] = resource.MustParse("600M")
+		result, err := sizer.Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.patches[3].Old.String()).To(Equal("600M"))
+		Expect(result.patches[3].New.String()).To(Equal("600M"))
+		Expect(result.containerReports()[1].Original.Requests.Memory().String()).To(Equal("300M"))
+	})
+
+	It("reports min/max clamping", func(ctx SpecContext) {
+		pod.Annotations["node-specific-sizing.manomano.tech/maximum-cpu"] = "200m"
+		result, err := sizer.Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		report := result.report(false)
+		Expect(report.MinMaxClamped).To(BeTrue())
+		Expect(report.ClampedBy).To(ContainElement(stagePodMinMax))
+	})
+
+	It("deducts the pod overhead when asked to", func(ctx SpecContext) {
+		pod.Spec.Overhead = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m")}
+		result, err := sizer.Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.patches[0].New.String()).To(Equal("100m"))
+
+		overheadSizer := &Sizer{nodeReader: sizer.nodeReader, deductPodOverhead: true}
+		result, err = overheadSizer.Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		// 400m of pod budget, less 200m of overhead, spread 1:3 between containers
+		Expect(result.patches[0].New.String()).To(Equal("50m"))
+		Expect(result.patches[2].New.String()).To(Equal("150m"))
+	})
+
+	It("adds the resources stanza of containers that have none", func(ctx SpecContext) {
+		bare := fixtures.PodWithContainers(corev1.Container{Name: "bare"}, corev1.Container{
+			Name:      "limited",
+			Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}},
+		})
+		patch := renderJSONPatch(ctx, bare, &Result{status: defaultStatusSettings, patches: []ResourcePatch{
+			{ContainerIndex: 0, ContainerName: "bare", Property: rps.ResourceRequests, Resource: corev1.ResourceCPU, New: resource.MustParse("100m")},
+			{ContainerIndex: 1, ContainerName: "limited", Property: rps.ResourceRequests, Resource: corev1.ResourceCPU, New: resource.MustParse("100m")},
+		}})
+
+		var ops []string
+		for _, op := range patch {
+			ops = append(ops, op.Operation+" "+op.Path)
+		}
+		Expect(ops).To(Equal([]string{
+			"add /spec/containers/0/resources",
+			"add /spec/containers/0/resources/requests",
+			"add /spec/containers/0/resources/requests/cpu",
+			"add /spec/containers/1/resources/requests",
+			"add /spec/containers/1/resources/requests/cpu",
+			"add /metadata/annotations",
+			"add /metadata/annotations/node-specific-sizing.manomano.tech~1applied-resources",
+			"add /metadata/annotations/node-specific-sizing.manomano.tech~1original-resources",
+			"add /metadata/annotations/node-specific-sizing.manomano.tech~1status",
+		}))
+	})
+
+	It("fails when the node is unknown", func(ctx SpecContext) {
+		_, err := sizer.Size(ctx, fixtures.PinToNode(pod, "node-b"))
+		Expect(err).To(MatchError(ContainSubstring("cannot find data for node")))
+	})
+
+	It("reads nodes missing from the cache from the API server, a few times", func(ctx SpecContext) {
+		joined := fixtures.NodeWithCapacity("4", "8G")
+		joined.Name = "node-b"
+		attempts := 0
+		apiReader := fake.NewClientBuilder().WithObjects(joined).WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				if attempts++; attempts < 2 {
+					return apierrors.NewServiceUnavailable("etcd leader changed")
+				}
+				return c.Get(ctx, key, obj, opts...)
+			},
+		}).Build()
+		found := testutil.ToFloat64(nodeCacheMissesTotal.WithLabelValues("found"))
+		withFallback := &Sizer{nodeReader: sizer.nodeReader, nodeAPIReader: apiReader}
+		result, err := withFallback.Size(ctx, fixtures.PinToNode(pod, "node-b"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.NodeName()).To(Equal("node-b"))
+		Expect(attempts).To(Equal(2))
+		Expect(testutil.ToFloat64(nodeCacheMissesTotal.WithLabelValues("found"))).To(Equal(found + 1))
+
+		notFound := testutil.ToFloat64(nodeCacheMissesTotal.WithLabelValues("not-found"))
+		_, err = withFallback.Size(ctx, fixtures.PinToNode(pod, "node-c"))
+		Expect(err).To(MatchError(ContainSubstring("cannot find data for node")))
+		Expect(attempts).To(Equal(2 + nodeLookupAttempts))
+		Expect(testutil.ToFloat64(nodeCacheMissesTotal.WithLabelValues("not-found"))).To(Equal(notFound + 1))
+	})
+
+	It("gives fallback requests to pods whose node is unavailable", func(ctx SpecContext) {
+		before := testutil.ToFloat64(nodeFallbacksTotal)
+		withFallback := &Sizer{nodeReader: sizer.nodeReader, fallback: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}}
+		result, err := withFallback.Size(ctx, fixtures.PinToNode(pod, "node-b"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.NodeName()).To(BeEmpty())
+		Expect(result.Warnings()).To(ContainElement(HavePrefix("sized with fallback requests: cannot find data for node")))
+		Expect(slices.Collect(result.Patches())).To(ConsistOf(
+			HaveField("New", resource.MustParse("250m")),
+			HaveField("New", resource.MustParse("750m")),
+		))
+		Expect(testutil.ToFloat64(nodeFallbacksTotal)).To(Equal(before + 1))
+
+		policy := &v1alpha1.SizingPolicy{Spec: v1alpha1.SizingPolicySpec{
+			FallbackRequests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+		}}
+		Expect(fallbackRequests(policy, withFallback.fallback)).To(HaveKeyWithValue(corev1.ResourceCPU, resource.MustParse("2")))
+	})
+
+	It("leaves pods without sizing settings alone, without looking their node up", func(ctx SpecContext) {
+		before := testutil.ToFloat64(unconfiguredPodsTotal)
+		pod.Annotations = map[string]string{"node-specific-sizing.manomano.tech/allow-overcommit": "true"}
+		result, patch, err := sizer.CreatePatch(ctx, fixtures.PinToNode(pod, "node-b"), false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Unconfigured()).To(BeTrue())
+		Expect(patch).To(BeEmpty())
+		Expect(testutil.ToFloat64(unconfiguredPodsTotal)).To(Equal(before + 1))
+	})
+
+	It("leaves pods alone when usage floors cannot apply to them", func(ctx SpecContext) {
+		pod.Annotations = nil
+		floored := &Sizer{nodeReader: sizer.nodeReader, usageFloors: floorsMatching(false)}
+		result, err := floored.Size(ctx, fixtures.PinToNode(pod, "node-b"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Unconfigured()).To(BeTrue())
+
+		floored.usageFloors = floorsMatching(true)
+		_, err = floored.Size(ctx, fixtures.PinToNode(pod, "node-b"))
+		Expect(err).To(MatchError(ContainSubstring("cannot find data for node")))
+	})
+})
+
+// floorsMatching has no floors, but tells whether it may have some for any pod
+type floorsMatching bool
+
+func (f floorsMatching) Floors(*corev1.Pod, string) map[string]*rps.ResourceProperties { return nil }
+
+func (f floorsMatching) MayHaveFloors(*corev1.Pod) bool { return bool(f) }
+
+func largeClusterReader(nodeCount int) client.Reader {
+	builder := fake.NewClientBuilder()
+	for i := range nodeCount {
+		node := fixtures.NodeWithCapacity("16", "64Gi")
+		node.Name = "node-" + strconv.Itoa(i)
+		node.Labels = map[string]string{"node.kubernetes.io/instance-type": "m5.4xlarge"}
+		builder.WithObjects(node)
+	}
+	return builder.Build()
+}
+
+// listNode is how nodes used to be looked up, kept around for comparison
+func listNode(ctx context.Context, nodeReader client.Reader, nodeName string) (*corev1.Node, bool) {
+	var nodes corev1.NodeList
+	if err := nodeReader.List(ctx, &nodes); err != nil {
+		return nil, false
+	}
+	nodeByName := make(map[string]corev1.Node)
+	for _, node := range nodes.Items {
+		nodeByName[node.Name] = node
+	}
+	node, ok := nodeByName[nodeName]
+	return &node, ok
+}
+
+func BenchmarkNodeLookup(b *testing.B) {
+	ctx := context.Background()
+	nodeReader := largeClusterReader(5000)
+
+	b.Run("list", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, ok := listNode(ctx, nodeReader, "node-4242"); !ok {
+				b.Fatal("node not found")
+			}
+		}
+	})
+
+	b.Run("get", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := getNode(ctx, nodeReader, "node-4242"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// largePod is a DaemonSet pod with sidecars and most sizing settings set
+func largePod() *corev1.Pod {
+	var containers []corev1.Container
+	for i := range 8 {
+		containers = append(containers, fixtures.ContainerWithResources("container-"+strconv.Itoa(i),
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("128Mi")},
+			corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("512Mi")}))
+	}
+	pod := fixtures.PinToNode(fixtures.PodWithContainers(containers...), "node-42")
+	pod.Labels = map[string]string{"controller-revision-hash": "agent-5d8f"}
+	pod.Annotations = map[string]string{
+		"node-specific-sizing.manomano.tech/request-cpu-fraction":    "0.05",
+		"node-specific-sizing.manomano.tech/limit-cpu-fraction":      "0.1",
+		"node-specific-sizing.manomano.tech/request-memory-fraction": "1/40",
+		"node-specific-sizing.manomano.tech/limit-memory-fraction":   "0.05",
+		"node-specific-sizing.manomano.tech/minimum-cpu":             "50m",
+		"node-specific-sizing.manomano.tech/maximum-memory":          "8Gi",
+		"node-specific-sizing.manomano.tech/rounding-memory":         "1Mi",
+		"node-specific-sizing.manomano.tech/exclude-containers":      "container-7",
+	}
+	return pod
+}
+
+// Run with go test -bench=CreatePatch -benchmem -run=^$ ./pkg/sizing/
+func BenchmarkCreatePatch(b *testing.B) {
+	ctx := context.Background()
+	nodeReader := largeClusterReader(100)
+	pod := largePod()
+
+	for name, options := range map[string]Options{
+		"uncached": {},
+		"cached":   {ResultCacheSize: 16},
+	} {
+		b.Run(name, func(b *testing.B) {
+			sizer := New(nodeReader, options)
+			b.ReportAllocs()
+			for range b.N {
+				if _, _, err := sizer.CreatePatch(ctx, pod, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,532 @@
+package sizing
+
+import (
+	"cmp"
+	"fmt"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	mapset "github.com/deckarep/golang-set/v2"
+	corev1 "k8s.io/api/core/v1"
+	"math/big"
+	"slices"
+)
+
+// Stage names one step of the sizing pipeline.
+type Stage string
+
+const (
+	stageFractions          Stage = "fractions"
+	stageContainerOverrides Stage = "container-overrides"
+	stagePodMinMax          Stage = "pod-min-max"
+	stageDistribute         Stage = "distribute"
+	stageContainerMinMax    Stage = "container-min-max"
+	stageNodeCap            Stage = "node-cap"
+	stageQuotaCap           Stage = "quota-cap"
+	stageRenormalize        Stage = "renormalize"
+	stageRequestBelowLimit  Stage = "request-below-limit"
+	stageRound              Stage = "round"
+)
+
+// sizingStages is the documented order of operations, see the README. Features combine in this order and no other,
+// so reordering this list is a user-facing change.
+var sizingStages = []Stage{
+	stageFractions,
+	stageContainerOverrides,
+	stagePodMinMax,
+	stageDistribute,
+	stageContainerMinMax,
+	stageNodeCap,
+	stageQuotaCap,
+	stageRenormalize,
+	stageRequestBelowLimit,
+	stageRound,
+}
+
+// clampingStages are the stages that only ever adjust values when something had to be clamped
+var clampingStages = []Stage{
+	stagePodMinMax,
+	stageContainerMinMax,
+	stageNodeCap,
+	stageQuotaCap,
+	stageRenormalize,
+	stageRequestBelowLimit,
+}
+
+// warnedStages are the clamping stages whose adjustments are returned as admission warnings. renormalize is left out,
+// it only follows up on node-cap and quota-cap.
+var warnedStages = []Stage{
+	stagePodMinMax,
+	stageContainerMinMax,
+	stageNodeCap,
+	stageQuotaCap,
+	stageRequestBelowLimit,
+}
+
+const podScope = "pod"
+
+// traceAdjustment records a single value being bound or changed by a stage.
+// Before is nil when the stage created the binding.
+type traceAdjustment struct {
+	Scope    string               `json:"scope"`
+	Property rps.ResourceProperty `json:"property"`
+	Resource corev1.ResourceName  `json:"resource"`
+	Before   *float64             `json:"before,omitempty"`
+	After    float64              `json:"after"`
+}
+
+func (ta traceAdjustment) String() string {
+	if ta.Before == nil {
+		return fmt.Sprintf("%s %s.%s set to %s", ta.Scope, ta.Property, ta.Resource, ta.quantity(ta.After))
+	}
+	return fmt.Sprintf("%s %s.%s set to %s instead of %s", ta.Scope, ta.Property, ta.Resource, ta.quantity(ta.After), ta.quantity(*ta.Before))
+}
+
+// quantity renders a traced value the way the status annotation renders quantities
+func (ta traceAdjustment) quantity(value float64) string {
+	return rps.NewBinding(rps.ResourceQuantity, ta.Property, ta.Resource, value).HumanValue()
+}
+
+type traceStep struct {
+	Stage       Stage             `json:"stage"`
+	Adjustments []traceAdjustment `json:"adjustments,omitempty"`
+}
+
+// decisionTrace tells, stage by stage, how the final container resources came to be.
+type decisionTrace struct {
+	Steps []traceStep `json:"steps"`
+}
+
+// Adjusted returns whether the given stage changed any value.
+func (dt *decisionTrace) Adjusted(stage Stage) bool {
+	for _, step := range dt.Steps {
+		if step.Stage == stage && len(step.Adjustments) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ClampedBy lists the clamping stages that changed any value, in order.
+func (dt *decisionTrace) ClampedBy() []Stage {
+	var stages []Stage
+	for _, stage := range clampingStages {
+		if dt.Adjusted(stage) {
+			stages = append(stages, stage)
+		}
+	}
+	return stages
+}
+
+// Warnings describes every value changed by warnedStages, e.g. "pod-min-max: pod requests.memory set to 1G instead of
+// 800M"
+func (dt *decisionTrace) Warnings() []string {
+	var warnings []string
+	for _, step := range dt.Steps {
+		if !slices.Contains(warnedStages, step.Stage) {
+			continue
+		}
+		for _, adjustment := range step.Adjustments {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", step.Stage, adjustment))
+		}
+	}
+	return warnings
+}
+
+// sizingInput is everything the sizing pipeline works from
+type sizingInput struct {
+	userSettings *rps.ResourceProperties
+	node         *corev1.Node
+	// capacity is the parsed capacity of node, parsed from it when nil
+	capacity nodeCapacity
+	pod      *corev1.Pod
+	// excluded holds the names of containers that keep their original resources
+	excluded mapset.Set[string]
+	// containerClamps holds, by container name, minimums and maximums that apply to a single container
+	containerClamps map[string]*rps.ResourceProperties
+	// podSizes holds pod budget values looked up from a size table or computed by expressions, which take precedence
+	// over fractions
+	podSizes *rps.ResourceProperties
+	// quotaHeadroom holds what ResourceQuotas leave for the pod, nil when they are not taken into account
+	quotaHeadroom *rps.ResourceProperties
+	// deductOverhead takes the overhead of the pod, set from its RuntimeClass, out of the pod budget
+	deductOverhead bool
+	// allowOvercommit lets limits go past node capacity, see rps.AllowOvercommitAnnotation
+	allowOvercommit bool
+	// distribution tells how the pod budget is spread between containers, proportionally when left empty
+	distribution distribution
+}
+
+type sizingPipeline struct {
+	userSettings    *rps.ResourceProperties
+	podSizes        *rps.ResourceProperties
+	quotaHeadroom   *rps.ResourceProperties
+	allowOvercommit bool
+	node            *corev1.Node
+	capacity        nodeCapacity
+	proportions     map[string]*rps.ResourceProperties
+	distribution    distribution
+	containerClamps map[string]*rps.ResourceProperties
+	// containerNames lists sized containers, in pod order
+	containerNames []string
+	// excludedRequirements sums the requirements of containers that keep their original resources
+	excludedRequirements *rps.ResourceProperties
+	// originals holds, by container name, the resources containers had before sizing
+	originals map[string]*rps.ResourceProperties
+
+	podBudget *rps.ResourceProperties
+	// sizedBudget holds the pod budget as derived from fractions and sizes, before other stages bind more properties
+	sizedBudget *rps.ResourceProperties
+	containers  map[string]*rps.ResourceProperties
+	// podTargets holds, for properties that overflow the node, the total the containers must be brought back to
+	podTargets *rps.ResourceProperties
+
+	trace *decisionTrace
+}
+
+// runSizingPipeline derives the resources of every long-running container of a pod, native sidecars included and
+// excluded ones left out, following sizingStages.
+func runSizingPipeline(in sizingInput) (map[string]*rps.ResourceProperties, *decisionTrace) {
+	if in.excluded == nil {
+		in.excluded = mapset.NewThreadUnsafeSet[string]()
+	}
+	if in.distribution.strategy == distributionPrimary {
+		// Other containers keep what they have, like excluded ones
+		in.excluded = in.excluded.Clone()
+		for _, ctn := range longRunningContainers(in.pod) {
+			if ctn.Name != in.distribution.primary {
+				in.excluded.Add(ctn.Name)
+			}
+		}
+	}
+	p := &sizingPipeline{
+		userSettings:         in.userSettings,
+		podSizes:             in.podSizes,
+		quotaHeadroom:        in.quotaHeadroom,
+		allowOvercommit:      in.allowOvercommit,
+		node:                 in.node,
+		capacity:             in.capacity,
+		proportions:          computeProportionalResourceRequirements(in.pod, in.excluded),
+		distribution:         in.distribution,
+		containerClamps:      in.containerClamps,
+		excludedRequirements: computeExcludedResourceRequirements(in.pod, in.excluded),
+		podBudget:            rps.New(),
+		containers:           make(map[string]*rps.ResourceProperties),
+		podTargets:           rps.New(),
+		originals:            make(map[string]*rps.ResourceProperties),
+		trace:                &decisionTrace{},
+	}
+	if p.capacity == nil {
+		p.capacity = parseCapacity(in.node.Status.Capacity)
+	}
+	if in.deductOverhead && len(in.pod.Spec.Overhead) > 0 {
+		// The overhead counts against both requests and limits of the pod, like a container that is not sized
+		overhead := rps.New()
+		overhead.AddResourceRequirements(&corev1.ResourceRequirements{Requests: in.pod.Spec.Overhead, Limits: in.pod.Spec.Overhead})
+		p.excludedRequirements.AddInPlace(overhead)
+	}
+	for _, ctn := range longRunningContainers(in.pod) {
+		if !in.excluded.Contains(ctn.Name) {
+			p.containerNames = append(p.containerNames, ctn.Name)
+			p.originals[ctn.Name] = rps.New()
+			p.originals[ctn.Name].AddResourceRequirements(&ctn.Resources)
+		}
+	}
+
+	for _, stage := range sizingStages {
+		p.trace.Steps = append(p.trace.Steps, traceStep{Stage: stage, Adjustments: p.run(stage)})
+	}
+
+	return p.containers, p.trace
+}
+
+func (p *sizingPipeline) run(stage Stage) []traceAdjustment {
+	switch stage {
+	case stageFractions:
+		p.podBudget = computePodResourceBudget(p.userSettings, p.capacity, p.node)
+		if p.podSizes != nil {
+			for binding := range p.podSizes.All() {
+				p.podBudget.Bind(*binding)
+			}
+		}
+		p.sizedBudget = p.podBudget.Clone()
+		return diffProperties(podScope, rps.New(), p.podBudget)
+
+	case stageContainerOverrides:
+		return p.deductExcluded()
+
+	case stagePodMinMax:
+		before := p.podBudget.Clone()
+		p.podBudget.ClampRequestsAndLimits(p.userSettings)
+		return diffProperties(podScope, before, p.podBudget)
+
+	case stageDistribute:
+		proportions := spreadUnrequested(p.proportions, p.containerNames, p.distributable())
+		if p.distribution.byWeight() {
+			proportions = computeWeightedResourceRequirements(p.containerNames, p.distribution, p.distributable())
+		}
+		p.containers = computePodContainerResourceBudget(proportions, p.podBudget)
+		var adjustments []traceAdjustment
+		for _, name := range p.containerNames {
+			if budget, ok := p.containers[name]; ok {
+				adjustments = append(adjustments, diffProperties(name, rps.New(), budget)...)
+			}
+		}
+		return adjustments
+
+	case stageContainerMinMax:
+		var adjustments []traceAdjustment
+		for _, name := range p.containerNames {
+			budget, hasBudget := p.containers[name]
+			clamps, hasClamps := p.containerClamps[name]
+			if hasBudget && hasClamps {
+				before := budget.Clone()
+				budget.ClampRequestsAndLimits(clamps)
+				adjustments = append(adjustments, diffProperties(name, before, budget)...)
+			}
+		}
+		return adjustments
+
+	case stageNodeCap:
+		return p.capToNode()
+
+	case stageQuotaCap:
+		return p.capToQuota()
+
+	case stageRenormalize:
+		return p.renormalize()
+
+	case stageRequestBelowLimit:
+		var adjustments []traceAdjustment
+		for _, name := range p.containerNames {
+			if budget, ok := p.containers[name]; ok {
+				before := budget.Clone()
+				budget.LowerRequestsToLimits()
+				p.matchHugePages(name, budget)
+				p.boundByOriginal(name, budget)
+				adjustments = append(adjustments, diffProperties(name, before, budget)...)
+			}
+		}
+		return adjustments
+
+	case stageRound:
+		var adjustments []traceAdjustment
+		for _, name := range p.containerNames {
+			if budget, ok := p.containers[name]; ok {
+				before := budget.Clone()
+				budget.Round(p.userSettings)
+				adjustments = append(adjustments, diffProperties(name, before, budget)...)
+			}
+		}
+		return adjustments
+	}
+
+	return nil
+}
+
+// distributable returns the requests and limits of the pod budget that fractions or sizes set. Others, e.g. limits
+// only minimums bind, are only given to containers that have them.
+func (p *sizingPipeline) distributable() *rps.ResourceProperties {
+	result := rps.New()
+	for binding := range p.podBudget.All() {
+		if binding.Property() != rps.ResourceRequests && binding.Property() != rps.ResourceLimits {
+			continue
+		}
+		if _, ok := p.sizedBudget.GetRat(binding.Property(), binding.ResourceName()); ok {
+			result.Bind(*binding)
+		}
+	}
+	return result
+}
+
+// deductExcluded takes what excluded containers already require out of the pod budget, along with the pod overhead
+// when deducted, so that the pod as a whole still gets the configured fraction of the node.
+func (p *sizingPipeline) deductExcluded() []traceAdjustment {
+	before := p.podBudget.Clone()
+	for binding := range p.podBudget.All() {
+		if excluded, ok := p.excludedRequirements.GetRat(binding.Property(), binding.ResourceName()); ok {
+			remaining := excluded.Sub(binding.Rat(), excluded)
+			if remaining.Sign() < 0 {
+				remaining.SetInt64(0)
+			}
+			binding.SetRat(remaining)
+		}
+	}
+	return diffProperties(podScope, before, p.podBudget)
+}
+
+// matchHugePages keeps hugepages requests equal to their limits, as Kubernetes requires. The sized limit wins, or the
+// sized request when the container has a limit sizing left alone.
+func (p *sizingPipeline) matchHugePages(name string, budget *rps.ResourceProperties) {
+	original := p.originals[name]
+	// Bindings are collected first, as matching binds the other property
+	for _, binding := range slices.Collect(budget.All()) {
+		if _, isHugePages := rps.HugePageSize(binding.ResourceName()); !isHugePages {
+			continue
+		}
+		switch binding.Property() {
+		case rps.ResourceLimits:
+			budget.BindPropertyRat(rps.ResourceQuantity, rps.ResourceRequests, binding.ResourceName(), binding.Rat())
+		case rps.ResourceRequests:
+			_, sizedLimit := budget.GetRat(rps.ResourceLimits, binding.ResourceName())
+			if _, hasLimit := original.GetRat(rps.ResourceLimits, binding.ResourceName()); hasLimit && !sizedLimit {
+				budget.BindPropertyRat(rps.ResourceQuantity, rps.ResourceLimits, binding.ResourceName(), binding.Rat())
+			}
+		}
+	}
+}
+
+// boundByOriginal keeps a container valid when only one of its request and limit is sized for a resource, the other
+// being left as is: a sized limit is raised to the request the container keeps, and a sized request is lowered to the
+// limit it keeps.
+func (p *sizingPipeline) boundByOriginal(name string, budget *rps.ResourceProperties) {
+	original := p.originals[name]
+	for binding := range budget.All() {
+		switch binding.Property() {
+		case rps.ResourceLimits:
+			if _, sized := budget.GetRat(rps.ResourceRequests, binding.ResourceName()); sized {
+				continue
+			}
+			if request, ok := original.GetRat(rps.ResourceRequests, binding.ResourceName()); ok && request.Cmp(binding.Rat()) > 0 {
+				binding.SetRat(request)
+			}
+		case rps.ResourceRequests:
+			if _, sized := budget.GetRat(rps.ResourceLimits, binding.ResourceName()); sized {
+				continue
+			}
+			if limit, ok := original.GetRat(rps.ResourceLimits, binding.ResourceName()); ok && limit.Cmp(binding.Rat()) < 0 {
+				binding.SetRat(limit)
+			}
+		}
+	}
+}
+
+// containerTotals sums every container's bindings
+func (p *sizingPipeline) containerTotals() *rps.ResourceProperties {
+	totals := rps.New()
+	for _, name := range p.containerNames {
+		if budget, ok := p.containers[name]; ok {
+			totals.AddInPlace(budget)
+		}
+	}
+	return totals
+}
+
+// capToNode makes sure that no property summed over all containers exceeds the node capacity, which minimums
+// can otherwise cause on small nodes. It only records targets, renormalize is what brings containers back in line.
+func (p *sizingPipeline) capToNode() []traceAdjustment {
+	return p.capTotals(func(total *rps.ResourcePropertyBinding) (*big.Rat, bool) {
+		if p.allowOvercommit && total.Property() == rps.ResourceLimits {
+			return nil, false
+		}
+		nodeCapacity, ok := p.capacity[total.ResourceName()]
+		if !ok {
+			return nil, false
+		}
+		// Capacities may be shared with other admissions, capTotals lowers a copy of them
+		return new(big.Rat).Set(nodeCapacity), true
+	})
+}
+
+// capToQuota makes sure that no property summed over all containers exceeds what ResourceQuotas leave, lowering the
+// targets of capToNode if needed
+func (p *sizingPipeline) capToQuota() []traceAdjustment {
+	if p.quotaHeadroom == nil {
+		return nil
+	}
+	return p.capTotals(func(total *rps.ResourcePropertyBinding) (*big.Rat, bool) {
+		return p.quotaHeadroom.GetRat(total.Property(), total.ResourceName())
+	})
+}
+
+// capTotals records a target for every property whose sum over all containers exceeds the limit found for it, unless
+// a lower target was already recorded
+func (p *sizingPipeline) capTotals(limitOf func(total *rps.ResourcePropertyBinding) (*big.Rat, bool)) []traceAdjustment {
+	var adjustments []traceAdjustment
+	for total := range p.containerTotals().All() {
+		capacity, ok := limitOf(total)
+		if !ok {
+			continue
+		}
+		// Excluded containers take their share regardless
+		if excluded, ok := p.excludedRequirements.GetRat(total.Property(), total.ResourceName()); ok {
+			capacity.Sub(capacity, excluded)
+		}
+		if capacity.Sign() < 0 {
+			capacity.SetInt64(0)
+		}
+		if target, ok := p.podTargets.GetRat(total.Property(), total.ResourceName()); ok && target.Cmp(capacity) <= 0 {
+			continue
+		}
+		if total.Rat().Cmp(capacity) > 0 {
+			p.podTargets.BindPropertyRat(rps.ResourceQuantity, total.Property(), total.ResourceName(), capacity)
+			before := total.Value()
+			after, _ := capacity.Float64()
+			adjustments = append(adjustments, traceAdjustment{
+				Scope:    podScope,
+				Property: total.Property(),
+				Resource: total.ResourceName(),
+				Before:   &before,
+				After:    after,
+			})
+		}
+	}
+	sortAdjustments(adjustments)
+	return adjustments
+}
+
+// renormalize scales containers down, keeping their proportions, so that their sum matches the targets set by
+// the previous stages.
+func (p *sizingPipeline) renormalize() []traceAdjustment {
+	totals := p.containerTotals()
+
+	var adjustments []traceAdjustment
+	for _, name := range p.containerNames {
+		budget, ok := p.containers[name]
+		if !ok {
+			continue
+		}
+		before := budget.Clone()
+		for binding := range budget.All() {
+			target, hasTarget := p.podTargets.GetRat(binding.Property(), binding.ResourceName())
+			total, _ := totals.GetRat(binding.Property(), binding.ResourceName())
+			if hasTarget && total.Cmp(target) > 0 {
+				scaled := new(big.Rat).Mul(binding.Rat(), target)
+				binding.SetRat(scaled.Quo(scaled, total))
+			}
+		}
+		adjustments = append(adjustments, diffProperties(name, before, budget)...)
+	}
+	return adjustments
+}
+
+// diffProperties lists every binding of after that is absent from before or holds a different value
+func diffProperties(scope string, before, after *rps.ResourceProperties) []traceAdjustment {
+	var adjustments []traceAdjustment
+	for binding := range after.All() {
+		adjustment := traceAdjustment{
+			Scope:    scope,
+			Property: binding.Property(),
+			Resource: binding.ResourceName(),
+			After:    binding.Value(),
+		}
+		if previous, ok := before.GetRat(binding.Property(), binding.ResourceName()); ok {
+			if previous.Cmp(binding.Rat()) == 0 {
+				continue
+			}
+			previousValue, _ := previous.Float64()
+			adjustment.Before = &previousValue
+		}
+		adjustments = append(adjustments, adjustment)
+	}
+	sortAdjustments(adjustments)
+	return adjustments
+}
+
+// sortAdjustments keeps traces stable despite map iteration order
+func sortAdjustments(adjustments []traceAdjustment) {
+	slices.SortFunc(adjustments, func(a, b traceAdjustment) int {
+		return cmp.Or(
+			cmp.Compare(a.Property, b.Property),
+			cmp.Compare(a.Resource, b.Resource),
+		)
+	})
+}
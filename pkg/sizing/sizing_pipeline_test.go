@@ -0,0 +1,250 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func runPipelineFor(annotations map[string]string, node *corev1.Node, pod *corev1.Pod) (map[string]*rps.ResourceProperties, *decisionTrace) {
+	err, userSettings := rps.NewFromAnnotations(annotations)
+	Expect(err).NotTo(HaveOccurred())
+
+	return runSizingPipeline(sizingInput{
+		userSettings:    userSettings,
+		node:            node,
+		pod:             pod,
+		excluded:        excludedContainers(annotations),
+		allowOvercommit: annotations[rps.AllowOvercommitAnnotation] == "true",
+	})
+}
+
+var _ = Describe("Sizing pipeline", Label("SizingPipeline"), func() {
+	pod := fixtures.PodWithContainers(
+		fixtures.ContainerWithResources("a",
+			corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("100M")},
+			corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("200M")}),
+		fixtures.ContainerWithResources("b",
+			corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("300M")},
+			corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("600M")}),
+	)
+	// requestsOnly lets requests be sized without being bound by limits
+	requestsOnly := fixtures.PodWithContainers(
+		fixtures.ContainerWithResources("a", corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("100M")}, nil),
+		fixtures.ContainerWithResources("b", corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("300M")}, nil),
+	)
+
+	It("runs every stage in the documented order", func() {
+		_, trace := runPipelineFor(map[string]string{}, fixtures.NodeWithCapacity("2", "4G"), pod)
+
+		var stages []Stage
+		for _, step := range trace.Steps {
+			stages = append(stages, step.Stage)
+		}
+		Expect(stages).To(Equal([]Stage{
+			stageFractions,
+			stageContainerOverrides,
+			stagePodMinMax,
+			stageDistribute,
+			stageContainerMinMax,
+			stageNodeCap,
+			stageQuotaCap,
+			stageRenormalize,
+			stageRequestBelowLimit,
+			stageRound,
+		}))
+	})
+
+	It("spreads the node fraction between containers", func() {
+		containers, trace := runPipelineFor(map[string]string{
+			"node-specific-sizing.manomano.tech/request-memory-fraction": "0.1",
+		}, fixtures.NodeWithCapacity("2", "4G"), pod)
+
+		Expect(fixtures.BoundValue(containers["a"], rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("~", 100e6))
+		Expect(fixtures.BoundValue(containers["b"], rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("~", 300e6))
+		Expect(trace.Adjusted(stagePodMinMax)).To(BeFalse())
+	})
+
+	It("applies pod minimums before capping to the node", func() {
+		containers, trace := runPipelineFor(map[string]string{
+			"node-specific-sizing.manomano.tech/request-memory-fraction": "0.5",
+			"node-specific-sizing.manomano.tech/minimum-memory":          "8G",
+		}, fixtures.NodeWithCapacity("2", "4G"), requestsOnly)
+
+		Expect(trace.Adjusted(stagePodMinMax)).To(BeTrue())
+		Expect(trace.Adjusted(stageNodeCap)).To(BeTrue())
+		Expect(trace.Adjusted(stageRenormalize)).To(BeTrue())
+		Expect(trace.Warnings()).To(Equal([]string{
+			"pod-min-max: pod requests.memory set to 8G instead of 2G",
+			"node-cap: pod requests.memory set to 4G instead of 8G",
+		}))
+
+		a := fixtures.BoundValue(containers["a"], rps.ResourceRequests, corev1.ResourceMemory)
+		b := fixtures.BoundValue(containers["b"], rps.ResourceRequests, corev1.ResourceMemory)
+		Expect(a + b).To(BeNumerically("~", 4e9))
+		Expect(b / a).To(BeNumerically("~", 3))
+	})
+
+	It("lets limits go past the node when overcommit is allowed", func() {
+		annotations := map[string]string{
+			"node-specific-sizing.manomano.tech/request-memory-fraction": "0.5",
+			"node-specific-sizing.manomano.tech/limit-memory-fraction":   "1.5",
+			rps.AllowOvercommitAnnotation:                                "true",
+		}
+		containers, trace := runPipelineFor(annotations, fixtures.NodeWithCapacity("2", "4G"), pod)
+
+		Expect(trace.Adjusted(stageNodeCap)).To(BeFalse())
+		a := fixtures.BoundValue(containers["a"], rps.ResourceLimits, corev1.ResourceMemory)
+		b := fixtures.BoundValue(containers["b"], rps.ResourceLimits, corev1.ResourceMemory)
+		Expect(a + b).To(BeNumerically("~", 6e9))
+		Expect(fixtures.BoundValue(containers["a"], rps.ResourceRequests, corev1.ResourceMemory) +
+			fixtures.BoundValue(containers["b"], rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("~", 2e9))
+	})
+
+	Describe("containers without requests", func() {
+		mixed := fixtures.PodWithContainers(
+			fixtures.ContainerWithResources("a", corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil),
+			fixtures.ContainerWithResources("b", corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("0")}, nil),
+			fixtures.ContainerWithResources("c", nil, nil),
+		)
+
+		It("leaves them out of resources other containers request, zero requests staying zero", func() {
+			containers, _ := runPipelineFor(map[string]string{
+				"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.5",
+			}, fixtures.NodeWithCapacity("2", "4G"), mixed)
+
+			Expect(fixtures.BoundValue(containers["a"], rps.ResourceRequests, corev1.ResourceCPU)).To(BeNumerically("~", 1))
+			Expect(fixtures.BoundValue(containers["b"], rps.ResourceRequests, corev1.ResourceCPU)).To(BeZero())
+			_, ok := containers["c"].GetRat(rps.ResourceRequests, corev1.ResourceCPU)
+			Expect(ok).To(BeFalse())
+		})
+
+		It("splits evenly resources no container requests", func() {
+			containers, _ := runPipelineFor(map[string]string{
+				"node-specific-sizing.manomano.tech/request-memory-fraction": "0.75",
+			}, fixtures.NodeWithCapacity("2", "3G"), mixed)
+
+			for _, name := range []string{"a", "b", "c"} {
+				Expect(fixtures.BoundValue(containers[name], rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("~", 0.75e9))
+			}
+		})
+	})
+
+	It("keeps excluded containers out of the budget", func() {
+		containers, trace := runPipelineFor(map[string]string{
+			"node-specific-sizing.manomano.tech/request-memory-fraction": "0.1",
+			"node-specific-sizing.manomano.tech/exclude-containers":      "a, sidecar",
+		}, fixtures.NodeWithCapacity("2", "4G"), pod)
+
+		Expect(trace.Adjusted(stageContainerOverrides)).To(BeTrue())
+		Expect(containers).NotTo(HaveKey("a"))
+		Expect(fixtures.BoundValue(containers["b"], rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("~", 300e6))
+	})
+
+	It("clamps containers to their own minimums after distribution", func() {
+		err, userSettings := rps.NewFromAnnotations(map[string]string{
+			"node-specific-sizing.manomano.tech/request-memory-fraction": "0.1",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		floor := rps.New()
+		floor.BindPropertyFloat(rps.ResourceQuantity, rps.ResourcePodMinimum, corev1.ResourceMemory, 250e6)
+
+		containers, trace := runSizingPipeline(sizingInput{
+			userSettings:    userSettings,
+			node:            fixtures.NodeWithCapacity("2", "4G"),
+			pod:             requestsOnly,
+			containerClamps: map[string]*rps.ResourceProperties{"a": floor},
+		})
+
+		Expect(trace.Adjusted(stageContainerMinMax)).To(BeTrue())
+		Expect(fixtures.BoundValue(containers["a"], rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("~", 250e6))
+		Expect(fixtures.BoundValue(containers["b"], rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("~", 300e6))
+	})
+
+	It("forces limits above requests last", func() {
+		containers, trace := runPipelineFor(map[string]string{
+			"node-specific-sizing.manomano.tech/request-memory-fraction": "0.5",
+			"node-specific-sizing.manomano.tech/limit-memory-fraction":   "0.1",
+		}, fixtures.NodeWithCapacity("2", "4G"), pod)
+
+		Expect(trace.Adjusted(stageRequestBelowLimit)).To(BeTrue())
+		for _, budget := range containers {
+			request := fixtures.BoundValue(budget, rps.ResourceRequests, corev1.ResourceMemory)
+			Expect(request).To(BeNumerically("<=", fixtures.BoundValue(budget, rps.ResourceLimits, corev1.ResourceMemory)))
+		}
+	})
+
+	Context("sizing either requests or limits", func() {
+		It("leaves requests alone when only limits are configured", func() {
+			containers, _ := runPipelineFor(map[string]string{
+				"node-specific-sizing.manomano.tech/limit-memory-fraction": "0.5",
+			}, fixtures.NodeWithCapacity("2", "4G"), pod)
+
+			Expect(fixtures.BoundValue(containers["a"], rps.ResourceLimits, corev1.ResourceMemory)).To(BeNumerically("~", 500e6))
+			Expect(fixtures.BoundValue(containers["b"], rps.ResourceLimits, corev1.ResourceMemory)).To(BeNumerically("~", 1500e6))
+			for _, budget := range containers {
+				_, sized := budget.GetValue(rps.ResourceRequests, corev1.ResourceMemory)
+				Expect(sized).To(BeFalse())
+			}
+		})
+
+		It("raises limits to the requests containers keep", func() {
+			containers, trace := runPipelineFor(map[string]string{
+				"node-specific-sizing.manomano.tech/limit-memory-fraction": "0.05",
+			}, fixtures.NodeWithCapacity("2", "4G"), pod)
+
+			Expect(trace.Adjusted(stageRequestBelowLimit)).To(BeTrue())
+			Expect(fixtures.BoundValue(containers["a"], rps.ResourceLimits, corev1.ResourceMemory)).To(BeNumerically("~", 100e6))
+			Expect(fixtures.BoundValue(containers["b"], rps.ResourceLimits, corev1.ResourceMemory)).To(BeNumerically("~", 300e6))
+			_, sized := containers["a"].GetValue(rps.ResourceRequests, corev1.ResourceMemory)
+			Expect(sized).To(BeFalse())
+		})
+
+		It("lowers requests to the limits containers keep", func() {
+			containers, trace := runPipelineFor(map[string]string{
+				"node-specific-sizing.manomano.tech/request-memory-fraction": "0.5",
+			}, fixtures.NodeWithCapacity("2", "4G"), pod)
+
+			Expect(trace.Adjusted(stageRequestBelowLimit)).To(BeTrue())
+			Expect(fixtures.BoundValue(containers["a"], rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("~", 200e6))
+			Expect(fixtures.BoundValue(containers["b"], rps.ResourceRequests, corev1.ResourceMemory)).To(BeNumerically("~", 600e6))
+			_, sized := containers["a"].GetValue(rps.ResourceLimits, corev1.ResourceMemory)
+			Expect(sized).To(BeFalse())
+		})
+	})
+
+	It("sizes hugepages in whole pages, with requests equal to limits", func() {
+		node := fixtures.NodeWithCapacity("2", "4G")
+		node.Status.Capacity["hugepages-2Mi"] = resource.MustParse("1Gi")
+		hugePages := fixtures.PodWithContainers(fixtures.ContainerWithResources("a",
+			corev1.ResourceList{"hugepages-2Mi": resource.MustParse("100Mi")},
+			corev1.ResourceList{"hugepages-2Mi": resource.MustParse("100Mi")}))
+		containers, _ := runPipelineFor(map[string]string{
+			"node-specific-sizing.manomano.tech/limit-hugepages-2Mi-fraction": "0.33",
+		}, node, hugePages)
+
+		rendered := make(map[rps.ResourceProperty]string)
+		for binding := range containers["a"].All() {
+			rendered[binding.Property()] = binding.HumanValue()
+		}
+		Expect(rendered).To(Equal(map[rps.ResourceProperty]string{
+			rps.ResourceRequests: "336Mi",
+			rps.ResourceLimits:   "336Mi",
+		}))
+	})
+
+	It("rounds values last", func() {
+		containers, trace := runPipelineFor(map[string]string{
+			"node-specific-sizing.manomano.tech/request-memory-fraction": "0.3",
+			"node-specific-sizing.manomano.tech/rounding-memory":         "1Mi",
+		}, fixtures.NodeWithCapacity("2", "4G"), requestsOnly)
+
+		Expect(trace.Adjusted(stageRound)).To(BeTrue())
+		for binding := range containers["a"].All() {
+			Expect(binding.HumanValue()).To(Equal("286Mi"))
+		}
+	})
+})
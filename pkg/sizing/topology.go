@@ -0,0 +1,174 @@
+package sizing
+
+import (
+	"context"
+	"fmt"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"math/big"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+const (
+	// TopologyAggregateAnnotation sizes pods for the average or minimum of the nodes of their topology domain, e.g. their
+	// zone, rather than for their node, for pods spread across homogeneous nodes without being pinned to one
+	TopologyAggregateAnnotation = AnnotationPrefix + "topology-aggregate"
+	// TopologyKeyAnnotation is the node label telling topology domains apart, DefaultTopologyKey when unset
+	TopologyKeyAnnotation = AnnotationPrefix + "topology-key"
+	// DefaultTopologyKey groups nodes by zone
+	DefaultTopologyKey = corev1.LabelTopologyZone
+)
+
+// topologyAggregate tells how the nodes of a topology domain are aggregated
+type topologyAggregate string
+
+const (
+	aggregateAverage topologyAggregate = "average"
+	aggregateMinimum topologyAggregate = "minimum"
+)
+
+// topologySettings size pods for an aggregate of the nodes sharing the value of key with their node
+type topologySettings struct {
+	aggregate topologyAggregate
+	key       string
+}
+
+// topologyFromAnnotations reads TopologyAggregateAnnotation and TopologyKeyAnnotation, nil when pods are sized for their
+// node
+func topologyFromAnnotations(annotations map[string]string) (*topologySettings, error) {
+	aggregate, hasAggregate := annotations[TopologyAggregateAnnotation]
+	key, hasKey := annotations[TopologyKeyAnnotation]
+	if !hasAggregate {
+		if hasKey {
+			return nil, fmt.Errorf("%s requires %s", TopologyKeyAnnotation, TopologyAggregateAnnotation)
+		}
+		return nil, nil
+	}
+	settings := &topologySettings{aggregate: topologyAggregate(strings.TrimSpace(aggregate)), key: DefaultTopologyKey}
+	if settings.aggregate != aggregateAverage && settings.aggregate != aggregateMinimum {
+		return nil, fmt.Errorf("unknown topology aggregate %q, expected average or minimum", aggregate)
+	}
+	if hasKey {
+		settings.key = strings.TrimSpace(key)
+		if errs := validation.IsQualifiedName(settings.key); len(errs) > 0 {
+			return nil, fmt.Errorf("topology key %q is not a valid label key: %s", key, strings.Join(errs, ", "))
+		}
+	}
+	return settings, nil
+}
+
+// topologyDomain tells the topology domain of pod: the value of key on its node when it can be told, or else the one
+// its node selector or every required node affinity term pins key to
+func (s *Sizer) topologyDomain(ctx context.Context, pod *corev1.Pod, key string) (string, error) {
+	if nodeName, err := s.nodeResolvers.Resolve(ctx, pod); err == nil {
+		node, err := getNodeOrFallback(ctx, s.nodeReader, s.nodeAPIReader, nodeName)
+		if err != nil {
+			return "", err
+		}
+		value, ok := node.Labels[key]
+		if !ok {
+			return "", fmt.Errorf("node %s has no label %s", nodeName, key)
+		}
+		return value, nil
+	}
+	if value, ok := pod.Spec.NodeSelector[key]; ok {
+		return value, nil
+	}
+	var domain string
+	if affinity := pod.Spec.Affinity; affinity != nil && affinity.NodeAffinity != nil &&
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		// Terms are ORed, they must all pin the same domain
+		for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+			var value string
+			for _, expr := range term.MatchExpressions {
+				if expr.Key == key && expr.Operator == corev1.NodeSelectorOpIn && len(expr.Values) == 1 {
+					value = expr.Values[0]
+				}
+			}
+			if value == "" || (domain != "" && value != domain) {
+				return "", fmt.Errorf("pod node affinity does not pin a single %s", key)
+			}
+			domain = value
+		}
+	}
+	if domain == "" {
+		return "", fmt.Errorf("neither the node of the pod nor its node selector or affinity tell its %s", key)
+	}
+	return domain, nil
+}
+
+// resolveTopology reads the nodes of the topology domain of pod and returns a node standing for their aggregate,
+// named after the domain, e.g. topology.kubernetes.io/zone=eu-west-1a
+func (s *Sizer) resolveTopology(ctx context.Context, pod *corev1.Pod, topology *topologySettings) (string, *corev1.Node, error) {
+	domain, err := s.topologyDomain(ctx, pod, topology.key)
+	if err != nil {
+		return "", nil, fmt.Errorf("problem getting topology domain: %w", err)
+	}
+	var nodes corev1.NodeList
+	if err := s.nodeReader.List(ctx, &nodes, client.MatchingLabels{topology.key: domain}); err != nil {
+		return "", nil, fmt.Errorf("problem listing nodes of %s=%s: %w", topology.key, domain, err)
+	}
+	if len(nodes.Items) == 0 {
+		return "", nil, fmt.Errorf("cannot find any node of %s=%s", topology.key, domain)
+	}
+	node := aggregateNodes(nodes.Items, topology.aggregate)
+	node.Name = topology.key + "=" + domain
+	return node.Name, node, nil
+}
+
+// aggregateNodes returns a node whose capacity and allocatable resources are the average or minimum of those of nodes,
+// for the resources they all have, and whose labels are those they all share
+func aggregateNodes(nodes []corev1.Node, aggregate topologyAggregate) *corev1.Node {
+	capacities := make([]corev1.ResourceList, len(nodes))
+	allocatables := make([]corev1.ResourceList, len(nodes))
+	labels := make(map[string]string, len(nodes[0].Labels))
+	for name, value := range nodes[0].Labels {
+		labels[name] = value
+	}
+	for i, node := range nodes {
+		capacities[i], allocatables[i] = node.Status.Capacity, node.Status.Allocatable
+		for name, value := range labels {
+			if node.Labels[name] != value {
+				delete(labels, name)
+			}
+		}
+	}
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Labels: labels},
+		Status: corev1.NodeStatus{
+			Capacity:    aggregateResources(capacities, aggregate),
+			Allocatable: aggregateResources(allocatables, aggregate),
+		},
+	}
+}
+
+func aggregateResources(lists []corev1.ResourceList, aggregate topologyAggregate) corev1.ResourceList {
+	result := corev1.ResourceList{}
+	for name, first := range lists[0] {
+		aggregated := first.DeepCopy()
+		complete := true
+		for _, list := range lists[1:] {
+			quantity, ok := list[name]
+			if !ok {
+				complete = false
+				break
+			}
+			if aggregate == aggregateAverage {
+				aggregated.Add(quantity)
+			} else if quantity.Cmp(aggregated) < 0 {
+				aggregated = quantity.DeepCopy()
+			}
+		}
+		if !complete {
+			continue
+		}
+		if aggregate == aggregateAverage {
+			aggregated = rps.MultiplyQuantity(aggregated, big.NewRat(1, int64(len(lists))), rps.PrecisionMilli)
+		}
+		result[name] = aggregated
+	}
+	return result
+}
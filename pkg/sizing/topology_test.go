@@ -0,0 +1,107 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Sizing for a topology domain", Label("Topology"), func() {
+	inZone := func(name, zone, cpu, memory string) *corev1.Node {
+		node := fixtures.NodeWithCapacity(cpu, memory)
+		node.Name = name
+		node.Labels = map[string]string{corev1.LabelTopologyZone: zone, "node.example.com/pool": name}
+		return node
+	}
+	sizer := &Sizer{nodeReader: fake.NewClientBuilder().WithObjects(
+		inZone("a1", "eu-west-1a", "4", "8G"),
+		inZone("a2", "eu-west-1a", "8", "16G"),
+		inZone("b1", "eu-west-1b", "2", "4G"),
+	).Build()}
+
+	var pod *corev1.Pod
+	BeforeEach(func() {
+		pod = fixtures.PodWithContainers(fixtures.ContainerWithResources("app", corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil))
+		pod.Annotations = map[string]string{
+			"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.5",
+			TopologyAggregateAnnotation:                               "average",
+		}
+		pod.Spec.NodeSelector = map[string]string{corev1.LabelTopologyZone: "eu-west-1a"}
+	})
+
+	sizedCPU := func(result *Result) string {
+		for patch := range result.Patches() {
+			if patch.Property == rps.ResourceRequests && patch.Resource == corev1.ResourceCPU {
+				return patch.New.String()
+			}
+		}
+		return ""
+	}
+
+	DescribeTable("applies fractions to an aggregate of the nodes of the domain", func(ctx SpecContext, aggregate, expected string) {
+		pod.Annotations[TopologyAggregateAnnotation] = aggregate
+		result, err := sizer.Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.NodeName()).To(Equal("topology.kubernetes.io/zone=eu-west-1a"))
+		Expect(sizedCPU(result)).To(Equal(expected))
+	},
+		Entry("average", "average", "3"),
+		Entry("minimum", "minimum", "2"),
+	)
+
+	It("reads the domain from the node of the pod when known", func(ctx SpecContext) {
+		pod.Spec.NodeSelector = nil
+		result, err := sizer.Size(ctx, fixtures.PinToNode(pod, "b1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.NodeName()).To(Equal("topology.kubernetes.io/zone=eu-west-1b"))
+		Expect(sizedCPU(result)).To(Equal("1"))
+	})
+
+	It("reads the domain from a required node affinity", func(ctx SpecContext) {
+		pod.Spec.NodeSelector = nil
+		pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{
+				{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: corev1.LabelTopologyZone, Operator: corev1.NodeSelectorOpIn, Values: []string{"eu-west-1b"}}}},
+			}},
+		}}
+		result, err := sizer.Size(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.NodeName()).To(Equal("topology.kubernetes.io/zone=eu-west-1b"))
+
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions[0].Values = []string{"eu-west-1a", "eu-west-1b"}
+		_, err = sizer.Size(ctx, pod)
+		Expect(err).To(MatchError(ContainSubstring("does not pin a single topology.kubernetes.io/zone")))
+	})
+
+	It("fails for domains without nodes", func(ctx SpecContext) {
+		pod.Spec.NodeSelector[corev1.LabelTopologyZone] = "eu-west-1c"
+		_, err := sizer.Size(ctx, pod)
+		Expect(err).To(MatchError(ContainSubstring("cannot find any node of topology.kubernetes.io/zone=eu-west-1c")))
+	})
+
+	It("aggregates the resources all nodes have, and keeps the labels they share", func() {
+		a1, a2 := inZone("a1", "eu-west-1a", "4", "8G"), inZone("a2", "eu-west-1a", "7", "16G")
+		a1.Status.Capacity["example.com/gpu"] = resource.MustParse("1")
+		node := aggregateNodes([]corev1.Node{*a1, *a2}, aggregateAverage)
+		Expect(node.Labels).To(Equal(map[string]string{corev1.LabelTopologyZone: "eu-west-1a"}))
+		Expect(node.Status.Capacity).To(HaveLen(2))
+		Expect(node.Status.Capacity.Cpu().String()).To(Equal("5500m"))
+		Expect(node.Status.Capacity.Memory().String()).To(Equal("12G"))
+	})
+
+	It("validates its annotations", func() {
+		_, err := topologyFromAnnotations(map[string]string{TopologyKeyAnnotation: corev1.LabelTopologyRegion})
+		Expect(err).To(MatchError(ContainSubstring("requires")))
+		_, err = topologyFromAnnotations(map[string]string{TopologyAggregateAnnotation: "median"})
+		Expect(err).To(HaveOccurred())
+		_, err = topologyFromAnnotations(map[string]string{TopologyAggregateAnnotation: "minimum", TopologyKeyAnnotation: "not a label"})
+		Expect(err).To(HaveOccurred())
+		topology, err := topologyFromAnnotations(map[string]string{TopologyAggregateAnnotation: "minimum", TopologyKeyAnnotation: corev1.LabelTopologyRegion})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*topology).To(Equal(topologySettings{aggregate: aggregateMinimum, key: corev1.LabelTopologyRegion}))
+	})
+})
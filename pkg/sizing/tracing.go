@@ -0,0 +1,19 @@
+package sizing
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records spans through the global TracerProvider, which discards them unless the binary sets one up
+var tracer = otel.Tracer("github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/sizing")
+
+// endSpan ends span, marking it failed when err is set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
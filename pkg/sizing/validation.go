@@ -0,0 +1,47 @@
+package sizing
+
+import (
+	"fmt"
+	rps "github.com/ManoManoTech/kubernetes-node-specific-sizing/pkg/resource_properties"
+)
+
+// ValidateAnnotations checks the sizing annotations of a pod, or of a pod template, before any pod gets created.
+// Errors found then would otherwise only show when sizing, which denies pod creation.
+func ValidateAnnotations(annotations map[string]string) error {
+	annotations, err := withConfigAnnotation(annotations)
+	if err != nil {
+		return fmt.Errorf("invalid sizing annotations: %w", err)
+	}
+	err, props := rps.NewFromAnnotations(annotations)
+	if err != nil {
+		return fmt.Errorf("invalid sizing annotations: %w", err)
+	}
+	if err := props.CheckBounds(); err != nil {
+		return fmt.Errorf("invalid sizing annotations: %w", err)
+	}
+	if _, err := sizeTableFromAnnotations(annotations); err != nil {
+		return fmt.Errorf("invalid sizing annotations: %w", err)
+	}
+	if _, err := containerClampsFromAnnotations(annotations); err != nil {
+		return fmt.Errorf("invalid sizing annotations: %w", err)
+	}
+	if _, err := distributionFromAnnotations(annotations); err != nil {
+		return fmt.Errorf("invalid sizing annotations: %w", err)
+	}
+	if _, err := resizePoliciesFromAnnotations(annotations); err != nil {
+		return fmt.Errorf("invalid sizing annotations: %w", err)
+	}
+	if _, err := topologyFromAnnotations(annotations); err != nil {
+		return fmt.Errorf("invalid sizing annotations: %w", err)
+	}
+	if _, err := exposeBudgetFromAnnotations(annotations); err != nil {
+		return fmt.Errorf("invalid sizing annotations: %w", err)
+	}
+	if _, err := runtimesFromAnnotations(annotations); err != nil {
+		return fmt.Errorf("invalid sizing annotations: %w", err)
+	}
+	if err := validateSizeExpressions(annotations); err != nil {
+		return fmt.Errorf("invalid sizing annotations: %w", err)
+	}
+	return nil
+}
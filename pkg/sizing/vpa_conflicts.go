@@ -0,0 +1,105 @@
+package sizing
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+	"strings"
+)
+
+// VPAMode tells what to do with pods whose controller a VerticalPodAutoscaler also sizes, its admission controller
+// then fighting with the webhook over their resources
+type VPAMode string
+
+const (
+	// VPAIgnore sizes pods without looking VerticalPodAutoscalers up
+	VPAIgnore VPAMode = "ignore"
+	// VPAWarn sizes pods anyway, returns an admission warning and records the conflict in VPAConflictAnnotation
+	VPAWarn VPAMode = "warn"
+	// VPASkip leaves the resources of the pod to the VerticalPodAutoscaler, returns an admission warning and records
+	// the conflict in VPAConflictAnnotation
+	VPASkip VPAMode = "skip"
+	// VPAOverride sizes pods anyway, only recording the conflict in VPAConflictAnnotation. Sized resources take
+	// precedence as long as the webhook runs after the VPA admission controller, see the README.
+	VPAOverride VPAMode = "override"
+)
+
+// VPAConflictAnnotation names the VerticalPodAutoscaler that also sizes a pod, unless VPAIgnore is used
+const VPAConflictAnnotation = AnnotationPrefix + "vpa-conflict"
+
+// VerticalPodAutoscalerGVK is the kind of VerticalPodAutoscalers, which are read as unstructured objects as their API
+// is optional
+var VerticalPodAutoscalerGVK = schema.GroupVersionKind{Group: "autoscaling.k8s.io", Version: "v1", Kind: "VerticalPodAutoscaler"}
+
+// ParseVPAMode reads ignore, warn, skip or override
+func ParseVPAMode(value string) (VPAMode, error) {
+	mode := VPAMode(value)
+	if !slices.Contains([]VPAMode{VPAIgnore, VPAWarn, VPASkip, VPAOverride}, mode) {
+		return "", fmt.Errorf("unknown VPA mode %q, expected one of ignore, warn, skip or override", value)
+	}
+	return mode, nil
+}
+
+// vpaTarget is an object a VerticalPodAutoscaler may target
+type vpaTarget struct {
+	group string
+	kind  string
+	name  string
+}
+
+// vpaTargetsOf returns the objects a VerticalPodAutoscaler sizing pod may target: its controller, and the Deployment
+// owning it when it is a ReplicaSet, told from the pod-template-hash suffix of its name rather than read
+func vpaTargetsOf(pod *corev1.Pod) []vpaTarget {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return nil
+	}
+	gv, err := schema.ParseGroupVersion(owner.APIVersion)
+	if err != nil {
+		return nil
+	}
+	targets := []vpaTarget{{group: gv.Group, kind: owner.Kind, name: owner.Name}}
+	if hash := pod.Labels["pod-template-hash"]; gv.Group == "apps" && owner.Kind == "ReplicaSet" && hash != "" {
+		if name, ok := strings.CutSuffix(owner.Name, "-"+hash); ok {
+			targets = append(targets, vpaTarget{group: "apps", kind: "Deployment", name: name})
+		}
+	}
+	return targets
+}
+
+// vpaConflict describes the VerticalPodAutoscaler updating the resources of pod, or returns "" when there is none.
+// VerticalPodAutoscalers in Off mode only recommend, they do not conflict.
+func vpaConflict(ctx context.Context, vpaReader client.Reader, pod *corev1.Pod) (string, error) {
+	targets := vpaTargetsOf(pod)
+	if len(targets) == 0 {
+		return "", nil
+	}
+
+	var vpas unstructured.UnstructuredList
+	vpas.SetGroupVersionKind(VerticalPodAutoscalerGVK.GroupVersion().WithKind(VerticalPodAutoscalerGVK.Kind + "List"))
+	if err := vpaReader.List(ctx, &vpas, client.InNamespace(pod.Namespace)); err != nil {
+		return "", fmt.Errorf("problem listing vertical pod autoscalers of namespace %s: %w", pod.Namespace, err)
+	}
+	for _, vpa := range vpas.Items {
+		apiVersion, _, _ := unstructured.NestedString(vpa.Object, "spec", "targetRef", "apiVersion")
+		kind, _, _ := unstructured.NestedString(vpa.Object, "spec", "targetRef", "kind")
+		name, _, _ := unstructured.NestedString(vpa.Object, "spec", "targetRef", "name")
+		gv, err := schema.ParseGroupVersion(apiVersion)
+		if err != nil || !slices.Contains(targets, vpaTarget{group: gv.Group, kind: kind, name: name}) {
+			continue
+		}
+		updateMode, _, _ := unstructured.NestedString(vpa.Object, "spec", "updatePolicy", "updateMode")
+		if updateMode == "Off" {
+			continue
+		}
+		return fmt.Sprintf("VerticalPodAutoscaler %s also sizes %s %s in %s mode", vpa.GetName(), kind, name,
+			cmp.Or(updateMode, "Auto")), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,106 @@
+package sizing
+
+import (
+	"github.com/ManoManoTech/kubernetes-node-specific-sizing/internal/fixtures"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func verticalPodAutoscaler(name, targetKind, targetName, updateMode string) *unstructured.Unstructured {
+	vpa := &unstructured.Unstructured{Object: map[string]any{
+		"spec": map[string]any{
+			"targetRef": map[string]any{"apiVersion": "apps/v1", "kind": targetKind, "name": targetName},
+		},
+	}}
+	if updateMode != "" {
+		Expect(unstructured.SetNestedField(vpa.Object, updateMode, "spec", "updatePolicy", "updateMode")).To(Succeed())
+	}
+	vpa.SetGroupVersionKind(VerticalPodAutoscalerGVK)
+	vpa.SetNamespace("shop")
+	vpa.SetName(name)
+	return vpa
+}
+
+var _ = Describe("Detecting VerticalPodAutoscaler conflicts", Label("VPA"), func() {
+	node := fixtures.NodeWithCapacity("4", "8G")
+	node.Name = "node-a"
+
+	vpaReaderWith := func(objects ...client.Object) client.Reader {
+		mapper := meta.NewDefaultRESTMapper(nil)
+		mapper.Add(VerticalPodAutoscalerGVK, meta.RESTScopeNamespace)
+		return fake.NewClientBuilder().WithRESTMapper(mapper).WithObjects(objects...).Build()
+	}
+
+	var pod *corev1.Pod
+	BeforeEach(func() {
+		pod = fixtures.PinToNode(fixtures.PodWithContainers(
+			fixtures.ContainerWithResources("app", corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}, nil),
+		), "node-a")
+		pod.ObjectMeta = metav1.ObjectMeta{
+			Namespace: "shop",
+			Labels:    map[string]string{"pod-template-hash": "5d8f7c"},
+			Annotations: map[string]string{
+				"node-specific-sizing.manomano.tech/request-cpu-fraction": "0.1",
+			},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-5d8f7c", Controller: ptr.To(true)}},
+		}
+	})
+
+	It("finds VerticalPodAutoscalers targeting the Deployment of a pod", func(ctx SpecContext) {
+		conflict, err := vpaConflict(ctx, vpaReaderWith(verticalPodAutoscaler("web", "Deployment", "web", "")), pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conflict).To(Equal("VerticalPodAutoscaler web also sizes Deployment web in Auto mode"))
+	})
+
+	It("ignores VerticalPodAutoscalers that only recommend or target something else", func(ctx SpecContext) {
+		conflict, err := vpaConflict(ctx, vpaReaderWith(
+			verticalPodAutoscaler("web", "Deployment", "web", "Off"),
+			verticalPodAutoscaler("api", "Deployment", "api", "Auto"),
+		), pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conflict).To(BeEmpty())
+	})
+
+	It("leaves resources to the VerticalPodAutoscaler when skipping", func(ctx SpecContext) {
+		before := testutil.ToFloat64(vpaConflictsTotal.WithLabelValues(string(VPASkip)))
+		sizer := New(fake.NewClientBuilder().WithObjects(node).Build(), Options{
+			VPAReader: vpaReaderWith(verticalPodAutoscaler("web", "Deployment", "web", "Recreate")),
+			VPAs:      VPASkip,
+		})
+		result, patch, err := sizer.CreatePatch(ctx, pod, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.patches).To(BeEmpty())
+		Expect(result.Warnings()).To(ContainElement(ContainSubstring("resources are left to it")))
+		Expect(patch).To(HaveLen(1))
+		Expect(patch[0].Path).To(Equal(AnnotationJsonPath(VPAConflictAnnotation)))
+		Expect(testutil.ToFloat64(vpaConflictsTotal.WithLabelValues(string(VPASkip)))).To(Equal(before + 1))
+	})
+
+	It("sizes anyway when overriding, only recording the conflict", func(ctx SpecContext) {
+		sizer := New(fake.NewClientBuilder().WithObjects(node).Build(), Options{
+			VPAReader: vpaReaderWith(verticalPodAutoscaler("web", "Deployment", "web", "Auto")),
+			VPAs:      VPAOverride,
+		})
+		result, patch, err := sizer.CreatePatch(ctx, pod, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.patches).To(HaveLen(1))
+		Expect(result.Warnings()).To(BeEmpty())
+		Expect(result.VPAConflict()).NotTo(BeEmpty())
+		Expect(patch).To(ContainElement(HaveField("Path", AnnotationJsonPath(VPAConflictAnnotation))))
+	})
+
+	It("parses modes", func() {
+		Expect(ParseVPAMode("warn")).To(Equal(VPAWarn))
+		_, err := ParseVPAMode("fight")
+		Expect(err).To(MatchError(ContainSubstring("unknown VPA mode")))
+	})
+})